@@ -1,28 +1,50 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	logger "github.com/a-castellano/go-services/infra/logger"
-	rabbitmq "github.com/a-castellano/go-services/infra/rabbitmq"
 	redis "github.com/a-castellano/go-services/infra/redis"
 	memorydatabase "github.com/a-castellano/go-services/services/memorydatabase"
-	messagebroker "github.com/a-castellano/go-services/services/messagebroker"
 	slogconfig "github.com/a-castellano/go-types/slog"
 	app "github.com/a-castellano/home-ip-monitor/internal/app"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	asndb "github.com/a-castellano/home-ip-monitor/internal/infra/asndb"
+	authoritative "github.com/a-castellano/home-ip-monitor/internal/infra/authoritative"
+	breaker "github.com/a-castellano/home-ip-monitor/internal/infra/breaker"
 	config "github.com/a-castellano/home-ip-monitor/internal/infra/config"
+	doh "github.com/a-castellano/home-ip-monitor/internal/infra/doh"
+	dot "github.com/a-castellano/home-ip-monitor/internal/infra/dot"
+	echoip "github.com/a-castellano/home-ip-monitor/internal/infra/echoip"
+	enrich "github.com/a-castellano/home-ip-monitor/internal/infra/enrich"
+	gateway "github.com/a-castellano/home-ip-monitor/internal/infra/gateway"
 	ipinfodata "github.com/a-castellano/home-ip-monitor/internal/infra/ipinfodata"
+	netiface "github.com/a-castellano/home-ip-monitor/internal/infra/netiface"
 	notify "github.com/a-castellano/home-ip-monitor/internal/infra/notify"
 	nslookup "github.com/a-castellano/home-ip-monitor/internal/infra/nslookup"
+	quorum "github.com/a-castellano/home-ip-monitor/internal/infra/quorum"
+	retry "github.com/a-castellano/home-ip-monitor/internal/infra/retry"
 	storage "github.com/a-castellano/home-ip-monitor/internal/infra/storage"
+	stun "github.com/a-castellano/home-ip-monitor/internal/infra/stun"
+	systemd "github.com/a-castellano/home-ip-monitor/internal/infra/systemd"
 )
 
 func main() {
 
+	daemonMode := flag.Bool("daemon", false, "keep running and check the IP periodically instead of running once")
+	showHistory := flag.Bool("history", false, "print the recorded IP changes, newest first, and exit")
+	dryRun := flag.Bool("dry-run", false, "run the checks once and print what would be sent and stored, without notifying or writing Redis")
+	flag.Parse()
+
 	// First, initiate logger
 	logConfig, err := slogconfig.NewConfig()
 	if err != nil {
@@ -30,7 +52,12 @@ func main() {
 	}
 
 	appLogger := logger.NewLogger(logConfig)
-	ctx := logger.WithLogger(context.Background(), appLogger)
+
+	// SIGTERM/SIGINT cancel the context, so an in-flight run is interrupted and
+	// the daemon scheduler stops cleanly.
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	ctx := logger.WithLogger(signalCtx, appLogger)
 
 	// Now from anywhere else in your program, you can use this:
 	appLogger.DebugContext(ctx, "Loading config")
@@ -49,19 +76,27 @@ func main() {
 		Timeout: time.Second * 5,
 	}
 
-	appLogger.DebugContext(ctx, "Defining ipinfo requester")
-	requester := ipinfodata.IPInfoRequester{HttpClient: &httpClient}
-
-	appLogger.DebugContext(ctx, "Defining nslookup resolver")
-	nsLookup := nslookup.DNSLookup{DNSServer: appConfig.DNSServer}
+	// Providers that only detect addresses look up their organization here.
+	var enricher domain.IPInfoEnricher
+	switch appConfig.OrgLookup {
+	case "ipinfo":
+		enricher = ipinfodata.IPInfoRequester{HttpClient: &httpClient, Token: appConfig.IPInfoToken}
+	case "asndb":
+		appLogger.DebugContext(ctx, "Loading ASN database", "path", appConfig.ASNDatabase)
+		database, openErr := asndb.Open(appConfig.ASNDatabase)
+		if openErr != nil {
+			appLogger.ErrorContext(ctx, "Error loading ASN database", "error", openErr)
+			os.Exit(1)
+		}
+		enricher = database
+	}
 
-	appLogger.DebugContext(ctx, "Defining rabbitmq instance")
-	rabbitmqClient := rabbitmq.NewRabbitmqClient(appConfig.RabbitmqConfig)
-	appLogger.DebugContext(ctx, "Defining messagebroker instance")
-	messageBroker := messagebroker.MessageBroker{Client: rabbitmqClient}
+	appLogger.DebugContext(ctx, "Defining AMQP publisher")
+	publisher := &notify.AMQPPublisher{Config: appConfig.RabbitmqConfig}
+	defer publisher.Close()
 
-	appLogger.DebugContext(ctx, "Defining notifier instance")
-	notifier := notify.BrokerNotifier{Broker: messageBroker}
+	appLogger.DebugContext(ctx, "Defining notifier instance", "cloudEvents", appConfig.CloudEvents)
+	notifier := notify.BrokerNotifier{Format: appConfig.PayloadFormat, CloudEvents: appConfig.CloudEvents, Source: appConfig.CloudEventsSource, Publisher: publisher}
 
 	appLogger.DebugContext(ctx, "Defining redis instance")
 	redisClient := redis.NewRedisClient(appConfig.RedisConfig)
@@ -76,14 +111,154 @@ func main() {
 	memoryDatabase := memorydatabase.NewMemoryDatabase(&redisClient)
 
 	appLogger.DebugContext(ctx, "Defining store instance")
-	store := storage.Store{Database: memoryDatabase}
+	store := storage.Store{Database: memoryDatabase, HistoryMaxEntries: appConfig.History.MaxEntries, HistoryMaxAge: appConfig.History.MaxAge}
+
+	if *showHistory {
+		changes, historyErr := store.History(ctx)
+		if historyErr != nil {
+			appLogger.ErrorContext(ctx, "Error retrieving IP history", "error", historyErr)
+			os.Exit(1)
+		}
+		for _, change := range changes {
+			fmt.Printf("%s\t%s\t%s -> %s\t%s (AS%d)\n", change.Time.Format(time.RFC3339), change.Family, cmp.Or(change.OldIP, "none"), change.NewIP, cmp.Or(change.OrgName, "unknown"), change.ASN)
+		}
+		return
+	}
+
+	// Circuit breakers keep their state next to the stored IPs, except in
+	// dry-run mode where nothing is written.
+	var breakerStore domain.BreakerStore = &store
+	if *dryRun {
+		breakerStore = app.NewDryRunBreakerStore(&store, os.Stdout)
+	}
+
+	appLogger.DebugContext(ctx, "Defining public IP providers")
+	var members []quorum.Member
+	for _, providerName := range appConfig.Providers {
+		provider, providerErr := newProvider(providerName, appConfig, &httpClient, enricher, breakerStore)
+		if providerErr != nil {
+			appLogger.ErrorContext(ctx, "Error defining public IP provider", "provider", providerName, "error", providerErr)
+			os.Exit(1)
+		}
+		members = append(members, quorum.Member{Name: providerName, Provider: provider})
+	}
+
+	// A single provider is used as is, several ones must reach the quorum.
+	requester := members[0].Provider
+	if len(members) > 1 {
+		requester = quorum.Provider{Members: members, Quorum: appConfig.Quorum}
+	}
+
+	// Fallback providers are asked in order while the previous ones are out
+	// of service, e.g. while their circuit breakers are open.
+	if len(appConfig.FallbackProviders) > 0 {
+		fallback := breaker.Fallback{Providers: []domain.IPInfoProvider{requester}}
+		for _, providerName := range appConfig.FallbackProviders {
+			provider, providerErr := newProvider(providerName, appConfig, &httpClient, enricher, breakerStore)
+			if providerErr != nil {
+				appLogger.ErrorContext(ctx, "Error defining fallback public IP provider", "provider", providerName, "error", providerErr)
+				os.Exit(1)
+			}
+			fallback.Providers = append(fallback.Providers, provider)
+		}
+		requester = fallback
+	}
+
+	appLogger.DebugContext(ctx, "Defining domain resolver", "resolver", appConfig.DNSResolver)
+	var nsLookup domain.DNSResolver = retry.Resolver{Resolver: newResolver(appConfig), Policy: appConfig.Retry.Resolver}
+	if appConfig.Breaker.Threshold > 0 {
+		nsLookup = breaker.Resolver{Resolver: nsLookup, Breaker: breaker.Breaker{Name: "resolver", Store: breakerStore, Threshold: appConfig.Breaker.Threshold, Cooldown: appConfig.Breaker.Cooldown}}
+	}
+
+	monitorSettings := app.Settings{ISP: appConfig.ISP, DomainName: appConfig.DomainName, NotifyQueue: appConfig.NotifyQueue, UpdateQueue: appConfig.UpdateQueue, Families: appConfig.Families, ISPReminder: appConfig.ISPReminder}
 
-	monitorSettings := app.Settings{ISPName: appConfig.ISPName, DomainName: appConfig.DomainName, NotifyQueue: appConfig.NotifyQueue, UpdateQueue: appConfig.UpdateQueue}
+	// Every port is retried on transient errors, so a single blip does not
+	// lose the whole check.
+	retryStore := retry.Store{Store: &store, Policy: appConfig.Retry.Store}
+	retryNotifier := retry.Notifier{Notifier: &notifier, Policy: appConfig.Retry.Notifier}
+
+	monitor := app.NewMonitor(requester, nsLookup, retryStore, retryNotifier, monitorSettings)
+	if *dryRun {
+		if *daemonMode {
+			appLogger.ErrorContext(ctx, "Dry-run mode cannot be combined with daemon mode")
+			os.Exit(1)
+		}
+		monitor = app.NewDryRunMonitor(requester, nsLookup, retryStore, os.Stdout, monitorSettings)
+	}
+
+	if *daemonMode {
+		// Daemon mode: connections above stay open and the scheduler runs the
+		// monitor periodically until SIGTERM/SIGINT.
+		schedulerSettings := app.SchedulerSettings{Interval: appConfig.Daemon.Interval, Jitter: appConfig.Daemon.Jitter, RetryDelay: appConfig.Daemon.RetryDelay, MaxBackoff: appConfig.Daemon.MaxBackoff}
+		scheduler := app.NewScheduler(monitor, schedulerSettings)
+
+		appLogger.InfoContext(ctx, "Starting daemon mode", "interval", appConfig.Daemon.Interval)
+		if _, notifyErr := systemd.Notify(ctx, systemd.Ready); notifyErr != nil {
+			appLogger.ErrorContext(ctx, "Error notifying systemd that the daemon is ready", "error", notifyErr)
+		}
+		scheduler.Start(ctx)
+		if _, notifyErr := systemd.Notify(ctx, systemd.Stopping); notifyErr != nil {
+			appLogger.ErrorContext(ctx, "Error notifying systemd that the daemon is stopping", "error", notifyErr)
+		}
+
+		appLogger.InfoContext(ctx, "Daemon mode stopped")
+		return
+	}
 
-	monitor := app.NewMonitor(requester, nsLookup, &store, &notifier, monitorSettings)
 	// Start the monitoring process
 	if monitorErr := monitor.Run(ctx); monitorErr != nil {
 		appLogger.ErrorContext(ctx, "Error running monitor", "error", monitorErr)
 		os.Exit(1)
 	}
 }
+
+// newProvider builds the public IP provider called providerName. Its answers
+// are completed by the ASN database when that is the organization lookup, its
+// calls are retried and, unless disabled, guarded by a circuit breaker named
+// after it.
+func newProvider(providerName string, appConfig *config.Config, httpClient *http.Client, enricher domain.IPInfoEnricher, store domain.BreakerStore) (domain.IPInfoProvider, error) {
+
+	var provider domain.IPInfoProvider
+	switch providerName {
+	case "ipinfo":
+		provider = ipinfodata.IPInfoRequester{HttpClient: httpClient, Families: appConfig.Families, Token: appConfig.IPInfoToken}
+	case "stun":
+		provider = stun.Client{Servers: appConfig.STUNServers, Families: appConfig.Families}
+	case "dns":
+		provider = nslookup.MyIPLookup{Queries: appConfig.MyIPQueries, Families: appConfig.Families}
+	case "gateway":
+		provider = gateway.Client{HttpClient: httpClient, Gateway: appConfig.GatewayAddress}
+	case "interface":
+		provider = netiface.Provider{Interface: appConfig.WANInterface, Families: appConfig.Families, Enricher: enricher}
+	case "echoip":
+		provider = echoip.Requester{HttpClient: httpClient, Endpoints: appConfig.EchoEndpoints, Families: appConfig.Families, Enricher: enricher}
+	default:
+		return nil, fmt.Errorf("unknown public IP provider \"%s\"", providerName)
+	}
+
+	// The local ASN database is free to ask, so every provider reports the
+	// organization of its addresses.
+	if appConfig.OrgLookup == "asndb" {
+		provider = enrich.Provider{Provider: provider, Enricher: enricher}
+	}
+	provider = retry.Provider{Provider: provider, Policy: appConfig.Retry.Provider}
+	if appConfig.Breaker.Threshold > 0 {
+		provider = breaker.Provider{Provider: provider, Breaker: breaker.Breaker{Name: providerName, Store: store, Threshold: appConfig.Breaker.Threshold, Cooldown: appConfig.Breaker.Cooldown}}
+	}
+	return provider, nil
+}
+
+// newResolver returns the resolver of the domain records named by
+// DNS_RESOLVER.
+func newResolver(appConfig *config.Config) domain.DNSResolver {
+	switch appConfig.DNSResolver {
+	case "authoritative":
+		return authoritative.Resolver{Recursive: appConfig.DNSServer}
+	case "doh":
+		return doh.Resolver{HttpClient: doh.NewHTTPClient(appConfig.DoH.Bootstrap, time.Second*5), URL: appConfig.DoH.URL, Mode: appConfig.DoH.Mode}
+	case "dot":
+		return &dot.Resolver{Server: appConfig.DoT.Server, ServerName: appConfig.DoT.ServerName, Pins: appConfig.DoT.Pins}
+	default:
+		return nslookup.DNSLookup{DNSServer: appConfig.DNSServer}
+	}
+}
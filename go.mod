@@ -6,6 +6,7 @@ require (
 	github.com/a-castellano/go-services v0.0.8
 	github.com/a-castellano/go-types v0.0.8
 	github.com/go-redis/redismock/v9 v9.2.0
+	github.com/rabbitmq/amqp091-go v1.12.0
 	github.com/redis/go-redis/v9 v9.21.0
 	go.uber.org/mock v0.6.0
 )
@@ -13,7 +14,6 @@ require (
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
-	github.com/rabbitmq/amqp091-go v1.12.0 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
 	golang.org/x/mod v0.27.0 // indirect
 	golang.org/x/net v0.43.0 // indirect
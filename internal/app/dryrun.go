@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// NewDryRunMonitor builds a Monitor that goes through every rule like the one
+// returned by NewMonitor, reading the provider, the resolver and the store, but
+// never notifies nor writes the store. Its decisions and the messages it would
+// have sent are written to out instead.
+func NewDryRunMonitor(provider domain.IPInfoProvider, resolver domain.DNSResolver, storage domain.IPStore, out io.Writer, settings Settings) Monitor {
+	monitor := NewMonitor(provider, resolver, dryRunStore{IPStore: storage, out: out}, dryRunNotifier{out: out}, settings)
+	monitor.report = out
+	return monitor
+}
+
+// dryRunNotifier is the domain.Notifier of a dry-run Monitor: it prints each
+// event instead of sending it.
+type dryRunNotifier struct {
+	out io.Writer
+}
+
+func (notifier dryRunNotifier) Notify(ctx context.Context, queue string, event domain.Event) error {
+	_, writeErr := fmt.Fprintf(notifier.out, "Would send %s event to queue %s: %s\n", event.Type, queue, event.Message)
+	return writeErr
+}
+
+// dryRunStore is the domain.IPStore of a dry-run Monitor: reads go to the
+// wrapped store, writes are printed and discarded. Acknowledgements are
+// dropped silently, so messages pending in the outbox are reported but kept.
+type dryRunStore struct {
+	domain.IPStore
+	out io.Writer
+}
+
+func (store dryRunStore) SaveIP(ctx context.Context, change domain.IPChange, messages []domain.OutboxMessage) error {
+	_, writeErr := fmt.Fprintf(store.out, "Would store %s %s\n", familyLabel(change.Family), change.NewIP)
+	return writeErr
+}
+
+func (store dryRunStore) AckMessage(ctx context.Context, eventID string) error {
+	return nil
+}
+
+func (store dryRunStore) SaveAlertState(ctx context.Context, alert string, state domain.AlertState) error {
+	_, writeErr := fmt.Fprintf(store.out, "Would set %s alert to active=%t\n", alert, state.Active)
+	return writeErr
+}
+
+func (store dryRunStore) SavePropagation(ctx context.Context, family domain.IPFamily, propagation domain.Propagation) error {
+	_, writeErr := fmt.Fprintf(store.out, "Would store %s %s as propagating\n", familyLabel(family), propagation.Address)
+	return writeErr
+}
+
+// NewDryRunBreakerStore returns a domain.BreakerStore for the circuit breakers
+// used along a dry-run Monitor: states are read from storage, changes are
+// printed to out and discarded.
+func NewDryRunBreakerStore(storage domain.BreakerStore, out io.Writer) domain.BreakerStore {
+	return dryRunBreakerStore{BreakerStore: storage, out: out}
+}
+
+// dryRunBreakerStore is the domain.BreakerStore returned by
+// NewDryRunBreakerStore.
+type dryRunBreakerStore struct {
+	domain.BreakerStore
+	out io.Writer
+}
+
+func (store dryRunBreakerStore) SaveBreakerState(ctx context.Context, breaker string, state domain.BreakerState) error {
+	_, writeErr := fmt.Fprintf(store.out, "Would set %s circuit breaker to %s\n", breaker, state.Status)
+	return writeErr
+}
@@ -0,0 +1,94 @@
+//go:build integration_tests || unit_tests || app_tests || app_unit_tests
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// A dry run goes through the update rules and prints what it would send and
+// store, leaving the store untouched.
+func TestDryRunUpdate(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.2", IPv6: "2a0c:5a80::1", ASN: 57269, OrgName: "DIGI"}}
+
+	resolver := familyResolverMock{results: map[domain.IPFamily]string{domain.IPv6: "2a0c:5a80::1"}}
+
+	var changes []domain.IPChange
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1", domain.IPv6: "2a0c:5a80::1"}, alerts: map[string]domain.AlertState{}, changes: &changes}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	var out bytes.Buffer
+	monitor := NewDryRunMonitor(ipinfo, resolver, store, &out, settings)
+
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Fatalf("TestDryRunUpdate should not fail: %v", err)
+	}
+
+	expected := []string{
+		"Detected 1.1.1.2, 2a0c:5a80::1 on DIGI ISP (AS57269).",
+		"IPv4 must be updated from 1.1.1.1 to 1.1.1.2.",
+		"Would store IPv4 1.1.1.2",
+		"Would store IPv4 1.1.1.2 as propagating",
+		"Would send ip.changed event to queue notify: Home IPv4 has changed to 1.1.1.2.",
+		"Would send dns.update event to queue update: 1.1.1.2",
+		"IPv6 2a0c:5a80::1 is up to date.",
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("TestDryRunUpdate printed an unexpected report:\n%s", out.String())
+	}
+	if store.stored[domain.IPv4] != "1.1.1.1" || len(changes) != 0 {
+		t.Errorf("TestDryRunUpdate should not write the store, got %v and %+v", store.stored, changes)
+	}
+
+}
+
+// A dry run on a backup ISP prints the notification without recording the
+// alert, so the real run still notifies it.
+func TestDryRunDifferentISP(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "2.2.2.2", OrgName: "ORANGE"}}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{}, alerts: map[string]domain.AlertState{}}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	var out bytes.Buffer
+	monitor := NewDryRunMonitor(ipinfo, dnsResolverMock{}, store, &out, settings)
+	monitor.now = func() time.Time { return time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC) }
+
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Fatalf("TestDryRunDifferentISP should not fail: %v", err)
+	}
+
+	report := out.String()
+	if !strings.Contains(report, "ORANGE is not the main ISP DIGI, only notifying.") || !strings.Contains(report, "Would send isp.different event to queue notify: Read IP 2.2.2.2 belongs to ORANGE ISP") {
+		t.Errorf("TestDryRunDifferentISP printed an unexpected report:\n%s", report)
+	}
+	if _, found := store.alerts[differentISPAlert]; found {
+		t.Errorf("TestDryRunDifferentISP should not record the alert")
+	}
+
+}
+
+// Circuit breaker changes are printed instead of saved.
+func TestDryRunBreakerStore(t *testing.T) {
+
+	var out bytes.Buffer
+	store := NewDryRunBreakerStore(nil, &out)
+
+	if err := store.SaveBreakerState(context.Background(), "ipinfo", domain.BreakerState{Status: domain.BreakerOpen, Failures: 3}); err != nil {
+		t.Fatalf("TestDryRunBreakerStore should not fail: %v", err)
+	}
+	if out.String() != "Would set ipinfo circuit breaker to open\n" {
+		t.Errorf("TestDryRunBreakerStore printed an unexpected report:\n%s", out.String())
+	}
+
+}
@@ -1,23 +1,38 @@
 package app
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	logger "github.com/a-castellano/go-services/infra/logger"
 	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
 )
 
-// Settings holds the four business values the use case needs. It is a plain
-// value object so the application layer never sees infra wiring (Redis/RabbitMQ
+// Settings holds the business values the use case needs. It is a plain value
+// object so the application layer never sees infra wiring (Redis/RabbitMQ
 // configs live in infra/config and are mapped to this in the composition root).
 type Settings struct {
-	ISPName     string
+	ISP         domain.ISPMatcher // Criteria that recognise the main ISP
 	DomainName  string
 	NotifyQueue string
 	UpdateQueue string
+	Families    []domain.IPFamily // Address families to monitor (e.g. IPv4 and IPv6)
+	ISPReminder time.Duration     // Interval between reminders while on a backup ISP, zero disables them
 }
 
+// differentISPAlert is the name of the alert remembering that home is not
+// using the main ISP.
+const differentISPAlert = "differentISP"
+
+// behindCGNATAlert is the name of the alert remembering that home is behind a
+// carrier-grade NAT.
+const behindCGNATAlert = "behindCGNAT"
+
 // Monitor is the application use case. All its dependencies are domain ports
 // (interfaces), so it has zero knowledge of HTTP, Redis or RabbitMQ.
 type Monitor struct {
@@ -26,165 +41,594 @@ type Monitor struct {
 	store    domain.IPStore
 	notifier domain.Notifier
 	settings Settings
+	now      func() time.Time
+	report   io.Writer // Where a dry-run Monitor explains its decisions, nil otherwise
 }
 
 // NewMonitor builds a Monitor from its injected ports and settings. Since every
 // field is unexported, this constructor is the only way to create a Monitor.
 func NewMonitor(provider domain.IPInfoProvider, resolver domain.DNSResolver, storage domain.IPStore, notifier domain.Notifier, settings Settings) Monitor {
-	return Monitor{provider: provider, resolver: resolver, store: storage, notifier: notifier, settings: settings}
+	return Monitor{provider: provider, resolver: resolver, store: storage, notifier: notifier, settings: settings, now: time.Now}
 }
 
 // Run executes the monitoring flow:
 //
-//	Rule 1: read the current public IP and confirm it belongs to the expected ISP.
-//	        If it does not, notify (only) and stop without touching storage.
-//	Rule 2: compare the current IP with the stored one. If there is no stored IP
-//	        or it differs, an update is required.
+//	Rule 1: read the current public IPs and confirm they belong to the expected ISP.
+//	        If they do not, notify (only) and stop without touching the stored IPs.
+//	        The switch is notified once (plus optional reminders) and the return
+//	        to the main ISP is announced. When several providers are asked and
+//	        they disagree, notify and stop as well. An address behind a
+//	        carrier-grade NAT is notified once and, like private, documentation
+//	        and other non-public addresses, never published: only the other
+//	        families are monitored, and Run stops when none is left.
+//	Rule 2: for each monitored family, compare the current IP with the stored one.
+//	        If there is no stored IP or it differs, an update is required.
 //	Rule 3: if it looks unchanged locally, cross-check against the domain's DNS
-//	        record; a mismatch there also requires an update.
-//	Rule 4: on update, notify both queues and only then persist the new IP, so a
-//	        failed notification never leaves storage ahead of the notifications.
+//	        record of that family (A or AAAA); a mismatch there also requires an update.
+//	Rule 4: on update, persist the new IP together with the messages for both
+//	        queues in the store outbox, then deliver them. Messages left in the
+//	        outbox by a failed delivery are delivered first on the next run, so
+//	        a partial failure resumes where it stopped instead of re-sending
+//	        everything.
+//
+// Families are handled independently: an IPv6 answer is never compared with
+// the stored IPv4 address or with the A record.
 func (monitor Monitor) Run(ctx context.Context) error {
 
 	log := logger.FromContext(ctx).With("operation", "Monitor.Run")
 	log.DebugContext(ctx, "Starting monitor", "settings", monitor.settings)
 
+	// Rule 4: resume the delivery of a previous update first.
+	if dispatchErr := monitor.dispatch(ctx); dispatchErr != nil {
+		return dispatchErr
+	}
+
 	log.DebugContext(ctx, "Retrieving ipinfo data")
 
 	// Rule 1: fetch the current public IP info.
 	ipinfo, getIPInfoErr := monitor.provider.GetIPInfo(ctx)
-
 	if getIPInfoErr != nil {
 		log.ErrorContext(ctx, "Error retrieving ipinfo data", "error", getIPInfoErr)
+
+		// Providers answered but did not agree: report it, since nobody
+		// would notice otherwise, and do not act on any of the answers.
+		var disagreement *domain.DisagreementError
+		if errors.As(getIPInfoErr, &disagreement) {
+			if notifyErr := monitor.notifyDisagreement(ctx, disagreement); notifyErr != nil {
+				return notifyErr
+			}
+		}
+
+		// The home router itself reports a carrier-grade NAT address.
+		var cgnat *domain.CGNATError
+		if errors.As(getIPInfoErr, &cgnat) {
+			if notifyErr := monitor.notifyCGNAT(ctx, cgnat); notifyErr != nil {
+				return notifyErr
+			}
+		}
 		return getIPInfoErr
 	}
 
-	log.DebugContext(ctx, "Validating that ipinfo provider is the expected provider", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
+	// Providers only check that they read an address, not that it can be
+	// reached from the Internet. Each family is checked on its own: behind
+	// DS-Lite or a carrier-grade NAT the IPv4 address is unreachable, but the
+	// IPv6 one is still published.
+	var cgnat *domain.CGNATError
+	var invalidErrs []error
+	for _, family := range domain.Families {
+		invalidErr := ipinfo.ValidateAddress(family)
+		if invalidErr == nil {
+			continue
+		}
+		log.WarnContext(ctx, "Detected address cannot be published, dropping it", "family", family, "error", invalidErr)
+		monitor.decide("Not publishing %s: %v.", familyLabel(family), invalidErr)
+		errors.As(invalidErr, &cgnat)
+		invalidErrs = append(invalidErrs, invalidErr)
+		ipinfo.RemoveAddress(family)
+	}
+
+	// Home is behind a carrier-grade NAT: its IPv4 address is not reachable
+	// from outside, report it so the ISP can be asked for a public one.
+	if cgnat != nil {
+		if notifyErr := monitor.notifyCGNAT(ctx, cgnat); notifyErr != nil {
+			return notifyErr
+		}
+	} else if clearCGNATErr := monitor.clearCGNAT(ctx); clearCGNATErr != nil {
+		return clearCGNATErr
+	}
+
+	if len(invalidErrs) > 0 && len(ipinfo.Addresses()) == 0 {
+		invalidErr := errors.Join(invalidErrs...)
+		log.ErrorContext(ctx, "No detected address can be published", "error", invalidErr)
+		return invalidErr
+	}
+	monitor.decide("Detected %s on %s ISP (AS%d).", strings.Join(ipinfo.Addresses(), ", "), ipinfo.OrgName, ipinfo.ASN)
+
+	log.DebugContext(ctx, "Validating that ipinfo provider is the expected provider", "currentProvider", ipinfo.OrgName, "currentASN", ipinfo.ASN, "expectedProvider", monitor.settings.ISP.Name, "currentIPv4", ipinfo.IPv4, "currentIPv6", ipinfo.IPv6)
 
 	// Rule 1: the IP must belong to the expected ISP. If not, notify and stop:
 	// we do not update storage because this IP is not the home connection.
-	if !ipinfo.BelongsToISP(monitor.settings.ISPName) {
+	if !ipinfo.BelongsToISP(monitor.settings.ISP) {
+		monitor.decide("%s is not the main ISP %s, only notifying.", ipinfo.OrgName, monitor.settings.ISP.Name)
 		return monitor.notifyDifferentISP(ctx, ipinfo)
 	}
 
-	log.DebugContext(ctx, "Current provider is the expected provider, checking if IP has changed by retrieving the current stored IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
+	if mainISPErr := monitor.notifyMainISP(ctx, ipinfo); mainISPErr != nil {
+		return mainISPErr
+	}
 
-	// Rules 2 & 3: decide whether the stored IP needs updating.
-	updateIP, updateRequiredErr := monitor.updateRequired(ctx, ipinfo)
-	if updateRequiredErr != nil {
-		return updateRequiredErr
+	monitoredFamilies := 0
+	for _, family := range monitor.settings.Families {
+
+		currentIP := ipinfo.Address(family)
+		if currentIP == "" {
+			log.WarnContext(ctx, "No address detected for monitored family, skipping it", "family", family)
+			monitor.decide("No %s address detected, skipping it.", familyLabel(family))
+			continue
+		}
+		monitoredFamilies++
+
+		log.DebugContext(ctx, "Current provider is the expected provider, checking if IP has changed by retrieving the current stored IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "family", family, "currentIP", currentIP)
+
+		// Rules 2 & 3: decide whether the stored IP needs updating.
+		updateIP, previousIP, recordTTL, updateRequiredErr := monitor.updateRequired(ctx, ipinfo, family)
+		if updateRequiredErr != nil {
+			return updateRequiredErr
+		}
+
+		if !updateIP {
+			monitor.decide("%s %s is up to date.", familyLabel(family), currentIP)
+			continue
+		}
+
+		// Rule 4: persist the new IP with its messages, then deliver them.
+		monitor.decide("%s must be updated from %s to %s.", familyLabel(family), cmp.Or(previousIP, "none"), currentIP)
+		if applyUpdateErr := monitor.applyUpdate(ctx, ipinfo, family, previousIP, recordTTL); applyUpdateErr != nil {
+			return applyUpdateErr
+		}
 	}
 
-	// Rule 4: notify both queues, then persist (notify-before-persist order).
-	if updateIP {
-		return monitor.applyUpdate(ctx, ipinfo)
+	if monitoredFamilies == 0 {
+		noAddressErr := errors.New("no address has been detected for any monitored IP family")
+		log.ErrorContext(ctx, "Error processing ipinfo data", "error", noAddressErr, "families", monitor.settings.Families)
+		return noAddressErr
 	}
 
 	return nil
 }
 
 // notifyDifferentISP handles Rule 1's notify-only path: the current IP does not
-// belong to the expected ISP, so we notify and stop without touching storage.
+// belong to the expected ISP, so we notify and stop without touching the stored
+// IPs. The switch is notified once; while it lasts, a reminder is sent every
+// Settings.ISPReminder (if set). A switch to yet another backup ISP is notified
+// again.
 func (monitor Monitor) notifyDifferentISP(ctx context.Context, ipinfo domain.IPInfo) error {
 
 	log := logger.FromContext(ctx).With("operation", "Monitor.notifyDifferentISP")
-	log.DebugContext(ctx, "Current provider is not the expected provider, notifying only", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
+	log.DebugContext(ctx, "Current provider is not the expected provider, notifying only", "currentProvider", ipinfo.OrgName, "currentASN", ipinfo.ASN, "expectedProvider", monitor.settings.ISP.Name, "currentIPv4", ipinfo.IPv4, "currentIPv6", ipinfo.IPv6)
 
-	notifyMessage := []byte(fmt.Sprintf("Read IP %s belongs to %s ISP, it seems that home is not using main ISP %s.", ipinfo.IP, ipinfo.OrgName, monitor.settings.ISPName))
+	alertState, _, alertStateErr := monitor.store.AlertState(ctx, differentISPAlert)
+	if alertStateErr != nil {
+		log.ErrorContext(ctx, "Error retrieving different ISP alert state from store", "error", alertStateErr)
+		return alertStateErr
+	}
 
-	notifyError := monitor.notifier.Notify(ctx, monitor.settings.NotifyQueue, notifyMessage)
+	now := monitor.now()
+	var event domain.Event
+
+	switch {
+	case !alertState.Active || alertState.Detail != ipinfo.OrgName:
+		alertState = domain.AlertState{Active: true, Since: now, Detail: ipinfo.OrgName}
+		event = monitor.newEvent(domain.DifferentISPEvent, ipinfo, now)
+		event.Message = fmt.Sprintf("Read IP %s belongs to %s ISP, it seems that home is not using main ISP %s.", strings.Join(ipinfo.Addresses(), ", "), ipinfo.OrgName, monitor.settings.ISP.Name)
+	case monitor.settings.ISPReminder > 0 && now.Sub(alertState.LastNotified) >= monitor.settings.ISPReminder:
+		event = monitor.newEvent(domain.DifferentISPReminderEvent, ipinfo, now)
+		event.Message = fmt.Sprintf("Home is still not using main ISP %s, read IP %s has belonged to %s ISP for %s.", monitor.settings.ISP.Name, strings.Join(ipinfo.Addresses(), ", "), ipinfo.OrgName, now.Sub(alertState.Since).Round(time.Minute))
+	default:
+		log.DebugContext(ctx, "Different ISP has already been notified", "since", alertState.Since, "lastNotified", alertState.LastNotified)
+		monitor.decide("Backup ISP %s has already been notified, nothing to send.", alertState.Detail)
+		return nil
+	}
+
+	notifyError := monitor.notifier.Notify(ctx, monitor.settings.NotifyQueue, event)
 
 	if notifyError != nil {
 		log.ErrorContext(ctx, "Error notifying about ISP change", "error", notifyError)
 		return notifyError
 	}
 
+	alertState.LastNotified = now
+	if saveAlertStateErr := monitor.store.SaveAlertState(ctx, differentISPAlert, alertState); saveAlertStateErr != nil {
+		log.ErrorContext(ctx, "Error updating different ISP alert state in store", "error", saveAlertStateErr)
+		return saveAlertStateErr
+	}
+
 	return nil
 }
 
-// updateRequired implements Rules 2 & 3: it compares the current IP against the
-// stored one and, when they look unchanged locally, cross-checks the domain's
-// live DNS record. It returns whether an update is required (and any read error).
-func (monitor Monitor) updateRequired(ctx context.Context, ipinfo domain.IPInfo) (bool, error) {
+// notifyMainISP announces that home is back on the main ISP when a previous
+// switch to a backup ISP was notified, and clears that alert.
+func (monitor Monitor) notifyMainISP(ctx context.Context, ipinfo domain.IPInfo) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.notifyMainISP")
+
+	alertState, _, alertStateErr := monitor.store.AlertState(ctx, differentISPAlert)
+	if alertStateErr != nil {
+		log.ErrorContext(ctx, "Error retrieving different ISP alert state from store", "error", alertStateErr)
+		return alertStateErr
+	}
+	if !alertState.Active {
+		return nil
+	}
+
+	log.DebugContext(ctx, "Home is back on the main ISP, notifying recovery", "currentProvider", ipinfo.OrgName, "backupProvider", alertState.Detail, "since", alertState.Since)
+
+	now := monitor.now()
+	event := monitor.newEvent(domain.MainISPRecoveredEvent, ipinfo, now)
+	event.Message = fmt.Sprintf("Home is back on main ISP %s with IP %s after %s on %s ISP.", monitor.settings.ISP.Name, strings.Join(ipinfo.Addresses(), ", "), now.Sub(alertState.Since).Round(time.Minute), alertState.Detail)
+
+	notifyError := monitor.notifier.Notify(ctx, monitor.settings.NotifyQueue, event)
 
-	log := logger.FromContext(ctx).With("operation", "Monitor.updateRequired")
+	if notifyError != nil {
+		log.ErrorContext(ctx, "Error notifying about main ISP recovery", "error", notifyError)
+		return notifyError
+	}
+
+	if saveAlertStateErr := monitor.store.SaveAlertState(ctx, differentISPAlert, domain.AlertState{LastNotified: now, Detail: ipinfo.OrgName}); saveAlertStateErr != nil {
+		log.ErrorContext(ctx, "Error clearing different ISP alert state in store", "error", saveAlertStateErr)
+		return saveAlertStateErr
+	}
+
+	return nil
+}
+
+// notifyDisagreement reports that the public IP providers did not reach the
+// quorum. Storage and DNS are left untouched.
+func (monitor Monitor) notifyDisagreement(ctx context.Context, disagreement *domain.DisagreementError) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.notifyDisagreement")
+	log.DebugContext(ctx, "Public IP providers do not agree, notifying only", "family", disagreement.Family, "quorum", disagreement.Quorum, "answers", disagreement.Summary())
+
+	event := domain.NewEvent(domain.ProvidersDisagreeEvent, monitor.now())
+	event.Family = disagreement.Family
+	event.Domain = monitor.settings.DomainName
+	event.Message = fmt.Sprintf("Public IP providers do not agree on home %s (%d matching answers required): %s.", familyLabel(disagreement.Family), disagreement.Quorum, disagreement.Summary())
+
+	notifyError := monitor.notifier.Notify(ctx, monitor.settings.NotifyQueue, event)
+
+	if notifyError != nil {
+		log.ErrorContext(ctx, "Error notifying about providers disagreement", "error", notifyError)
+		return notifyError
+	}
+
+	return nil
+}
+
+// notifyCGNAT reports that the home router WAN address is in the shared
+// address space of a carrier-grade NAT. Storage and DNS are left untouched.
+// The move behind the carrier-grade NAT is notified once, and again only if
+// the address changes while it lasts.
+func (monitor Monitor) notifyCGNAT(ctx context.Context, cgnat *domain.CGNATError) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.notifyCGNAT")
+
+	alertState, _, alertStateErr := monitor.store.AlertState(ctx, behindCGNATAlert)
+	if alertStateErr != nil {
+		log.ErrorContext(ctx, "Error retrieving carrier-grade NAT alert state from store", "error", alertStateErr)
+		return alertStateErr
+	}
+	if alertState.Active && alertState.Detail == cgnat.Address {
+		log.DebugContext(ctx, "Carrier-grade NAT has already been notified", "address", cgnat.Address, "since", alertState.Since)
+		monitor.decide("Carrier-grade NAT address %s has already been notified, nothing to send.", cgnat.Address)
+		return nil
+	}
+
+	log.DebugContext(ctx, "Home router is behind a carrier-grade NAT, notifying only", "address", cgnat.Address)
+
+	now := monitor.now()
+	event := domain.NewEvent(domain.BehindCGNATEvent, now)
+	event.Family = domain.IPv4
+	event.NewIP = cgnat.Address
+	event.Domain = monitor.settings.DomainName
+	event.Message = fmt.Sprintf("Home router WAN address %s is in the carrier-grade NAT range 100.64.0.0/10, home is not reachable from the Internet.", cgnat.Address)
+
+	notifyError := monitor.notifier.Notify(ctx, monitor.settings.NotifyQueue, event)
+
+	if notifyError != nil {
+		log.ErrorContext(ctx, "Error notifying about carrier-grade NAT", "error", notifyError)
+		return notifyError
+	}
+
+	since := alertState.Since
+	if !alertState.Active {
+		since = now
+	}
+	alertState = domain.AlertState{Active: true, Since: since, LastNotified: now, Detail: cgnat.Address}
+	if saveAlertStateErr := monitor.store.SaveAlertState(ctx, behindCGNATAlert, alertState); saveAlertStateErr != nil {
+		log.ErrorContext(ctx, "Error updating carrier-grade NAT alert state in store", "error", saveAlertStateErr)
+		return saveAlertStateErr
+	}
+
+	return nil
+}
+
+// clearCGNAT forgets a notified carrier-grade NAT once a public address is
+// detected again, so moving back behind one is notified.
+func (monitor Monitor) clearCGNAT(ctx context.Context) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.clearCGNAT")
+
+	alertState, _, alertStateErr := monitor.store.AlertState(ctx, behindCGNATAlert)
+	if alertStateErr != nil {
+		log.ErrorContext(ctx, "Error retrieving carrier-grade NAT alert state from store", "error", alertStateErr)
+		return alertStateErr
+	}
+	if !alertState.Active {
+		return nil
+	}
+
+	log.InfoContext(ctx, "Home is no longer behind a carrier-grade NAT", "address", alertState.Detail, "since", alertState.Since)
+	if saveAlertStateErr := monitor.store.SaveAlertState(ctx, behindCGNATAlert, domain.AlertState{LastNotified: alertState.LastNotified, Detail: alertState.Detail}); saveAlertStateErr != nil {
+		log.ErrorContext(ctx, "Error clearing carrier-grade NAT alert state in store", "error", saveAlertStateErr)
+		return saveAlertStateErr
+	}
+
+	return nil
+}
+
+// updateRequired implements Rules 2 & 3 for one family: it compares the current
+// IP against the stored one and, when they look unchanged locally, cross-checks
+// the domain's live DNS records of that family: an update is also required
+// when the current IP is missing from them or stale records remain, unless the
+// previous update of the family is still propagating. It returns whether an
+// update is required, the address being replaced (the stored one, or the first
+// stale record when only DNS differs), the TTL of the records it replaces and
+// any read error.
+func (monitor Monitor) updateRequired(ctx context.Context, ipinfo domain.IPInfo, family domain.IPFamily) (bool, string, time.Duration, error) {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.updateRequired", "family", family)
+	currentIP := ipinfo.Address(family)
 
 	// Rule 2: compare the current IP against the stored one.
-	storedIP, ipFound, retrieveIPErr := monitor.store.StoredIP(ctx)
+	storedIP, ipFound, retrieveIPErr := monitor.store.StoredIP(ctx, family)
 
 	if retrieveIPErr != nil {
 		log.ErrorContext(ctx, "Error retrieving current stored IP from store", "error", retrieveIPErr)
-		return false, retrieveIPErr
+		return false, "", 0, retrieveIPErr
 	}
 
 	if !ipFound {
-		log.DebugContext(ctx, "There is no stored IP, update with current value", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
-		return true, nil
+		log.DebugContext(ctx, "There is no stored IP, update with current value", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP)
+		return true, "", monitor.recordTTL(ctx, family), nil
 	}
 
-	log.DebugContext(ctx, "There is already an IP stored, compare with current IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP, "storedIP", storedIP)
-	if storedIP != ipinfo.IP {
-		log.DebugContext(ctx, "IPs differ, stored IP must be updated", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP, "storedIP", storedIP)
-		return true, nil
+	log.DebugContext(ctx, "There is already an IP stored, compare with current IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "storedIP", storedIP)
+	if storedIP != currentIP {
+		log.DebugContext(ctx, "IPs differ, stored IP must be updated", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "storedIP", storedIP)
+		return true, storedIP, monitor.recordTTL(ctx, family), nil
 	}
-	log.DebugContext(ctx, "IPs are the same, stored IP will not be updated", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP, "storedIP", storedIP)
+	log.DebugContext(ctx, "IPs are the same, stored IP will not be updated", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "storedIP", storedIP)
 
 	// Rule 3: storage says it is unchanged, but cross-check against the
 	// domain's live DNS record in case storage drifted from reality.
-	log.DebugContext(ctx, "Stored IP matches, cross-checking against domain DNS resolution", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP, "domain", monitor.settings.DomainName)
-
-	retrievedIPFromDNS, dnsRetrievalErr := monitor.resolver.Resolve(ctx, monitor.settings.DomainName)
+	log.DebugContext(ctx, "Stored IP matches, cross-checking against domain DNS resolution", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "domain", monitor.settings.DomainName)
+
+	records, dnsRetrievalErr := monitor.resolver.Resolve(ctx, monitor.settings.DomainName, family)
+
+	// Some authoritative nameservers have not loaded the latest zone yet: a
+	// change is already on its way, asking for it again would duplicate it.
+	// They should catch up within the propagation window of the last update,
+	// or the zone refresh interval if longer; past it they are stuck and the
+	// lag is an error.
+	var lag *domain.NameserverLagError
+	if errors.As(dnsRetrievalErr, &lag) && len(lag.Lagging) > 0 {
+		propagation, propagationFound, propagationErr := monitor.store.Propagation(ctx, family)
+		if propagationErr != nil {
+			log.ErrorContext(ctx, "Error retrieving update propagation from store", "error", propagationErr)
+			return false, "", 0, propagationErr
+		}
+		propagation.TTL = max(propagation.TTL, lag.Refresh)
+		if !propagationFound || !propagation.Covers(currentIP, nil, monitor.now()) {
+			log.ErrorContext(ctx, "Authoritative nameservers lag behind past the last update propagation", "domain", monitor.settings.DomainName, "lagging", lag.Lagging, "answers", lag.Answers, "refresh", lag.Refresh, "updatedAt", propagation.Since)
+			monitor.decide("Nameservers %s of %s still lag behind after the last %s update.", strings.Join(lag.Lagging, ", "), monitor.settings.DomainName, familyLabel(family))
+			return false, "", 0, dnsRetrievalErr
+		}
+		log.WarnContext(ctx, "Authoritative nameservers lag behind, skipping DNS cross-check", "domain", monitor.settings.DomainName, "lagging", lag.Lagging, "answers", lag.Answers)
+		monitor.decide("Nameservers %s of %s lag behind, not cross-checking %s.", strings.Join(lag.Lagging, ", "), monitor.settings.DomainName, familyLabel(family))
+		return false, "", 0, nil
+	}
 
 	if dnsRetrievalErr != nil {
 		log.ErrorContext(ctx, "Error resolving domain IP", "error", dnsRetrievalErr, "domain", monitor.settings.DomainName)
-		return false, dnsRetrievalErr
+		return false, "", 0, dnsRetrievalErr
 	}
 
-	if retrievedIPFromDNS != ipinfo.IP {
-		log.DebugContext(ctx, "IP from domain DNS resolution differs from ipinfo IP, updating IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP, "domain", monitor.settings.DomainName, "retrievedIPFromDNS", retrievedIPFromDNS)
-		return true, nil
+	// The record set has drifted when the current IP is missing from it or
+	// when stale records remain next to it, as round-robin answers would
+	// then send part of the clients to an old address.
+	published := false
+	var stale []string
+	for _, record := range records {
+		if record.Address == currentIP {
+			published = true
+		} else {
+			stale = append(stale, record.Address)
+		}
 	}
 
-	log.DebugContext(ctx, "IP from domain DNS resolution matches ipinfo IP, update is not required", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP, "domain", monitor.settings.DomainName, "retrievedIPFromDNS", retrievedIPFromDNS)
-	return false, nil
-}
-
-// applyUpdate implements Rule 4: it notifies both queues and only then persists
-// the new IP, so a failed notification never leaves storage ahead of the
-// notifications.
-func (monitor Monitor) applyUpdate(ctx context.Context, ipinfo domain.IPInfo) error {
-
-	log := logger.FromContext(ctx).With("operation", "Monitor.applyUpdate")
+	recordTTL := longestTTL(records)
+
+	if !published || len(stale) > 0 {
+		// Right after an update, resolvers may still answer the previous
+		// records from their caches: another update would not help.
+		propagation, propagationFound, propagationErr := monitor.store.Propagation(ctx, family)
+		if propagationErr != nil {
+			log.ErrorContext(ctx, "Error retrieving update propagation from store", "error", propagationErr)
+			return false, "", 0, propagationErr
+		}
+		if propagationFound && propagation.Covers(currentIP, records, monitor.now()) {
+			log.InfoContext(ctx, "Domain DNS records differ from ipinfo IP but the last update is propagating", "currentIP", currentIP, "domain", monitor.settings.DomainName, "records", records, "updatedAt", propagation.Since, "ttl", max(propagation.TTL, recordTTL))
+			monitor.decide("%s %s is propagating to %s since %s.", familyLabel(family), currentIP, monitor.settings.DomainName, propagation.Since.Format(time.RFC3339))
+			return false, "", 0, nil
+		}
+
+		log.DebugContext(ctx, "Domain DNS records differ from ipinfo IP, updating IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "domain", monitor.settings.DomainName, "published", published, "staleRecords", stale)
+		if !published {
+			monitor.decide("%s %s is not published in %s.", familyLabel(family), currentIP, monitor.settings.DomainName)
+		}
+		if len(stale) > 0 {
+			monitor.decide("Stale %s records of %s remain: %s.", familyLabel(family), monitor.settings.DomainName, strings.Join(stale, ", "))
+			return true, stale[0], recordTTL, nil
+		}
+		return true, "", recordTTL, nil
+	}
 
-	log.DebugContext(ctx, "Notifying about IP change", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
-	notifyChangeMessage := fmt.Sprintf("Home IP has changed to %s.", ipinfo.IP)
+	log.DebugContext(ctx, "Domain DNS records match ipinfo IP, update is not required", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "domain", monitor.settings.DomainName, "records", records)
+	return false, "", 0, nil
+}
 
-	// Send notification message
-	encodedNotifyChangeMessage := []byte(notifyChangeMessage)
-	encodedIP := []byte(ipinfo.IP)
+// recordTTL returns how long resolvers may cache the domain's current records
+// of family, the longest of their TTLs. A Rule 2 update does not depend on the
+// records, so when they cannot be read it goes ahead with an unknown (zero)
+// TTL.
+func (monitor Monitor) recordTTL(ctx context.Context, family domain.IPFamily) time.Duration {
 
-	notifyChangeError := monitor.notifier.Notify(ctx, monitor.settings.NotifyQueue, encodedNotifyChangeMessage)
+	log := logger.FromContext(ctx).With("operation", "Monitor.recordTTL", "family", family)
 
-	if notifyChangeError != nil {
-		log.ErrorContext(ctx, "Error notifying about Home IP change", "error", notifyChangeError)
-		return notifyChangeError
+	records, dnsRetrievalErr := monitor.resolver.Resolve(ctx, monitor.settings.DomainName, family)
+	if dnsRetrievalErr != nil {
+		log.WarnContext(ctx, "Error resolving domain records, their TTL is unknown", "error", dnsRetrievalErr, "domain", monitor.settings.DomainName)
+		return 0
 	}
+	return longestTTL(records)
+}
 
-	log.DebugContext(ctx, "Notifying about IP change in DNS update queue", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
+// longestTTL returns the longest TTL of records, zero when there is none.
+func longestTTL(records []domain.DNSRecord) time.Duration {
+	var ttl time.Duration
+	for _, record := range records {
+		ttl = max(ttl, record.TTL)
+	}
+	return ttl
+}
 
-	notifyDNSError := monitor.notifier.Notify(ctx, monitor.settings.UpdateQueue, encodedIP)
-	if notifyDNSError != nil {
-		log.ErrorContext(ctx, "Error notifying DNS queue with IP to change", "error", notifyDNSError)
-		return notifyDNSError
+// applyUpdate implements Rule 4 for one family: it persists the new IP along
+// with the human notification and the DNS update message in the store outbox,
+// then delivers both. If a delivery fails, the remaining messages stay in the
+// outbox and the stored IP is already up to date, so the next run only resumes
+// the delivery. The update starts a propagation window of recordTTL, the TTL of
+// the records it replaces (zero when they could not be read), during which
+// Rule 3 does not ask for it again.
+func (monitor Monitor) applyUpdate(ctx context.Context, ipinfo domain.IPInfo, family domain.IPFamily, previousIP string, recordTTL time.Duration) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.applyUpdate", "family", family)
+	currentIP := ipinfo.Address(family)
+	now := monitor.now()
+
+	// Notification message
+	changedEvent := monitor.newFamilyEvent(domain.IPChangedEvent, ipinfo, family, previousIP, now)
+	changedEvent.Message = fmt.Sprintf("Home %s has changed to %s.", familyLabel(family), currentIP)
+
+	// Plain text consumers of the update queue expect the raw IP.
+	updateEvent := monitor.newFamilyEvent(domain.DNSUpdateEvent, ipinfo, family, previousIP, now)
+	updateEvent.Message = currentIP
+
+	messages := []domain.OutboxMessage{
+		{Queue: monitor.settings.NotifyQueue, Event: changedEvent},
+		{Queue: monitor.settings.UpdateQueue, Event: updateEvent},
 	}
 
-	log.DebugContext(ctx, "Updating stored IP", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISPName, "currentIP", ipinfo.IP)
+	log.DebugContext(ctx, "Updating stored IP and queueing notifications", "currentProvider", ipinfo.OrgName, "expectedProvider", monitor.settings.ISP.Name, "currentIP", currentIP, "previousIP", previousIP)
 
-	updateIPError := monitor.store.SaveIP(ctx, ipinfo.IP)
+	change := domain.IPChange{Family: family, OldIP: previousIP, NewIP: currentIP, OrgName: ipinfo.OrgName, ASN: ipinfo.ASN, Time: now}
+	updateIPError := monitor.store.SaveIP(ctx, change, messages)
 	if updateIPError != nil {
 		log.ErrorContext(ctx, "Error updating retrieved IP in store", "error", updateIPError)
 		return updateIPError
 	}
 
+	propagation := domain.Propagation{Address: currentIP, Since: now, TTL: recordTTL}
+	if savePropagationErr := monitor.store.SavePropagation(ctx, family, propagation); savePropagationErr != nil {
+		log.ErrorContext(ctx, "Error storing update propagation, the messages stay in the outbox", "error", savePropagationErr)
+		return savePropagationErr
+	}
+
+	return monitor.deliver(ctx, messages)
+}
+
+// dispatch delivers the messages left in the store outbox by a previous run.
+func (monitor Monitor) dispatch(ctx context.Context) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.dispatch")
+
+	messages, pendingErr := monitor.store.PendingMessages(ctx)
+	if pendingErr != nil {
+		log.ErrorContext(ctx, "Error retrieving pending messages from store", "error", pendingErr)
+		return pendingErr
+	}
+	if len(messages) > 0 {
+		log.InfoContext(ctx, "Resuming delivery of pending messages", "messages", len(messages))
+	}
+
+	return monitor.deliver(ctx, messages)
+}
+
+// deliver sends messages in order, acknowledging each one in the store outbox
+// once sent. It stops at the first failure so the order is kept; the failed
+// message and the following ones are delivered by the next dispatch.
+func (monitor Monitor) deliver(ctx context.Context, messages []domain.OutboxMessage) error {
+
+	log := logger.FromContext(ctx).With("operation", "Monitor.deliver")
+
+	for _, message := range messages {
+		log.DebugContext(ctx, "Delivering message", "queue", message.Queue, "event", message.Event.ID, "type", message.Event.Type)
+
+		if notifyErr := monitor.notifier.Notify(ctx, message.Queue, message.Event); notifyErr != nil {
+			log.ErrorContext(ctx, "Error delivering message, it stays in the outbox", "queue", message.Queue, "event", message.Event.ID, "error", notifyErr)
+			return notifyErr
+		}
+
+		if ackErr := monitor.store.AckMessage(ctx, message.Event.ID); ackErr != nil {
+			log.ErrorContext(ctx, "Error acknowledging delivered message", "queue", message.Queue, "event", message.Event.ID, "error", ackErr)
+			return ackErr
+		}
+	}
+
 	return nil
 }
+
+// newEvent returns an event of eventType about the addresses in ipinfo.
+func (monitor Monitor) newEvent(eventType domain.EventType, ipinfo domain.IPInfo, detectedAt time.Time) domain.Event {
+	event := domain.NewEvent(eventType, detectedAt)
+	event.Addresses = ipinfo.Addresses()
+	event.ISP = ipinfo.OrgName
+	event.ASN = ipinfo.ASN
+	event.Domain = monitor.settings.DomainName
+	event.Source = ipinfo.Source
+	return event
+}
+
+// newFamilyEvent returns an event of eventType about the family address in
+// ipinfo replacing previousIP.
+func (monitor Monitor) newFamilyEvent(eventType domain.EventType, ipinfo domain.IPInfo, family domain.IPFamily, previousIP string, detectedAt time.Time) domain.Event {
+	event := monitor.newEvent(eventType, ipinfo, detectedAt)
+	event.Family = family
+	event.OldIP = previousIP
+	event.NewIP = ipinfo.Address(family)
+	return event
+}
+
+// decide explains a decision of a dry-run Monitor; it does nothing otherwise.
+func (monitor Monitor) decide(format string, args ...any) {
+	if monitor.report != nil {
+		fmt.Fprintf(monitor.report, format+"\n", args...)
+	}
+}
+
+// familyLabel returns the human readable name of a family used in messages.
+func familyLabel(family domain.IPFamily) string {
+	if family == domain.IPv6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
@@ -5,7 +5,10 @@ package app
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
 )
@@ -24,14 +27,22 @@ func (mock ipInfoMock) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
 	return mock.ipInfoData, mock.err
 }
 
-// dnsResolverMock fakes domain.DNSResolver.
+// dnsResolverMock fakes domain.DNSResolver. It answers records when set, a
+// single record of result otherwise.
 type dnsResolverMock struct {
-	result string
-	err    error
+	result  string
+	records []domain.DNSRecord
+	err     error
 }
 
-func (mock dnsResolverMock) Resolve(ctx context.Context, domain string) (string, error) {
-	return mock.result, mock.err
+func (mock dnsResolverMock) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+	if mock.err != nil {
+		return nil, mock.err
+	}
+	if mock.records != nil {
+		return mock.records, nil
+	}
+	return []domain.DNSRecord{{Address: mock.result, Type: "A", TTL: 5 * time.Minute}}, nil
 }
 
 // ipStoreMock fakes domain.IPStore.
@@ -41,22 +52,48 @@ type ipStoreMock struct {
 	storeError    error
 
 	saveError error
+
+	propagation domain.Propagation // Returned by Propagation when its Address is set
 }
 
-func (mock ipStoreMock) StoredIP(ctx context.Context) (string, bool, error) {
+func (mock ipStoreMock) StoredIP(ctx context.Context, family domain.IPFamily) (string, bool, error) {
 	return mock.storedIPValue, mock.storeFound, mock.storeError
 }
 
-func (mock ipStoreMock) SaveIP(ctx context.Context, ip string) error {
+func (mock ipStoreMock) SaveIP(ctx context.Context, change domain.IPChange, messages []domain.OutboxMessage) error {
 	return mock.saveError
 }
 
+func (mock ipStoreMock) PendingMessages(ctx context.Context) ([]domain.OutboxMessage, error) {
+	return nil, nil
+}
+
+func (mock ipStoreMock) AckMessage(ctx context.Context, eventID string) error {
+	return nil
+}
+
+func (mock ipStoreMock) AlertState(ctx context.Context, alert string) (domain.AlertState, bool, error) {
+	return domain.AlertState{}, false, nil
+}
+
+func (mock ipStoreMock) SaveAlertState(ctx context.Context, alert string, state domain.AlertState) error {
+	return nil
+}
+
+func (mock ipStoreMock) Propagation(ctx context.Context, family domain.IPFamily) (domain.Propagation, bool, error) {
+	return mock.propagation, mock.propagation.Address != "", nil
+}
+
+func (mock ipStoreMock) SavePropagation(ctx context.Context, family domain.IPFamily, propagation domain.Propagation) error {
+	return nil
+}
+
 // notifierMock fakes domain.Notifier, returning the same result for every queue.
 type notifierMock struct {
 	err error
 }
 
-func (mock notifierMock) Notify(ctx context.Context, queue string, message []byte) error {
+func (mock notifierMock) Notify(ctx context.Context, queue string, event domain.Event) error {
 	return mock.err
 }
 
@@ -67,7 +104,7 @@ type complexNotifierMock struct {
 	err       error
 }
 
-func (mock complexNotifierMock) Notify(ctx context.Context, queue string, message []byte) error {
+func (mock complexNotifierMock) Notify(ctx context.Context, queue string, event domain.Event) error {
 	if queue == mock.failQueue {
 		return mock.err
 	}
@@ -78,7 +115,7 @@ func (mock complexNotifierMock) Notify(ctx context.Context, queue string, messag
 // nothing else.
 func TestGetIPInfoError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: errors.New("failed to fetch")}
 
@@ -88,7 +125,7 @@ func TestGetIPInfoError(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Example", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Example"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -106,7 +143,7 @@ func TestGetIPInfoError(t *testing.T) {
 // Here that single notification fails, so Run must return its error.
 func TestDifferentISPNotifyError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -116,7 +153,7 @@ func TestDifferentISPNotifyError(t *testing.T) {
 
 	notifier := notifierMock{err: errors.New("Fail")}
 
-	settings := Settings{ISPName: "Different", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Different"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -135,7 +172,7 @@ func TestDifferentISPNotifyError(t *testing.T) {
 // left untouched (this is the happy-path counterpart to TestDifferentISPNotifyError).
 func TestDifferentISP(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -145,7 +182,7 @@ func TestDifferentISP(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Different", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Different"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -163,7 +200,7 @@ func TestDifferentISP(t *testing.T) {
 // the store read error.
 func TestStoredIPReadError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -173,7 +210,7 @@ func TestStoredIPReadError(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -194,7 +231,7 @@ func TestStoredIPReadError(t *testing.T) {
 // genuine no-update case is TestStoredIPAndDNSMatchNoUpdate.
 func TestStoredIPMatchesButDNSDiffersTriggersUpdate(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -204,7 +241,7 @@ func TestStoredIPMatchesButDNSDiffersTriggersUpdate(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -223,7 +260,7 @@ func TestStoredIPMatchesButDNSDiffersTriggersUpdate(t *testing.T) {
 // and the save succeed, so Run returns no error.
 func TestStoredIPDiffersTriggersUpdate(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -233,7 +270,7 @@ func TestStoredIPDiffersTriggersUpdate(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -251,7 +288,7 @@ func TestStoredIPDiffersTriggersUpdate(t *testing.T) {
 // and the full notify + save path runs successfully.
 func TestNoStoredIPTriggersUpdate(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -261,7 +298,7 @@ func TestNoStoredIPTriggersUpdate(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -279,7 +316,7 @@ func TestNoStoredIPTriggersUpdate(t *testing.T) {
 // resolver fails. Run must propagate that resolver error.
 func TestStoredIPMatchesDNSResolveError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -289,7 +326,7 @@ func TestStoredIPMatchesDNSResolveError(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -307,7 +344,7 @@ func TestStoredIPMatchesDNSResolveError(t *testing.T) {
 // same IP, so nothing changed and Run finishes without notifying or saving.
 func TestStoredIPAndDNSMatchNoUpdate(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -317,7 +354,7 @@ func TestStoredIPAndDNSMatchNoUpdate(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -331,11 +368,170 @@ func TestStoredIPAndDNSMatchNoUpdate(t *testing.T) {
 
 }
 
+// Rule 3 with authoritative nameservers: when some of them still serve an
+// older zone, a change is already on its way, so Run neither fails nor asks
+// for another update, until the zone refresh interval after the last update
+// is over. Nameservers disagreeing on the same zone make it fail.
+func TestStoredIPMatchesNameserversLag(t *testing.T) {
+
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
+	ipinfo :=
+		ipInfoMock{ipInfoData: ipinfoData, err: nil}
+
+	lag := &domain.NameserverLagError{Domain: "test.windmaker.net", Answers: map[string]string{"ns1.windmaker.net": "1.1.1.1", "ns2.windmaker.net": "2.2.2.2"}, Lagging: []string{"ns2.windmaker.net"}, Refresh: time.Hour}
+	resolver := dnsResolverMock{result: "", err: lag}
+
+	// Any update would fail on its first save or notification.
+	updatedAt := time.Date(2026, 6, 18, 12, 0, 0, 0, time.UTC)
+	store := ipStoreMock{storedIPValue: "1.1.1.1", storeFound: true, storeError: nil, saveError: errors.New("Fail"), propagation: domain.Propagation{Address: "1.1.1.1", Since: updatedAt, TTL: 5 * time.Minute}}
+
+	notifier := notifierMock{err: errors.New("Fail")}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+
+	monitor.now = func() time.Time { return updatedAt.Add(30 * time.Minute) }
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Errorf("TestStoredIPMatchesNameserversLag should not fail while nameservers lag: %v", err)
+	}
+
+	monitor.now = func() time.Time { return updatedAt.Add(2 * time.Hour) }
+	if err := monitor.Run(context.Background()); !errors.Is(err, lag) {
+		t.Errorf("TestStoredIPMatchesNameserversLag should fail when nameservers lag past the zone refresh interval, got %v", err)
+	}
+
+	lag.Lagging = nil
+	if err := monitor.Run(context.Background()); !errors.Is(err, lag) {
+		t.Errorf("TestStoredIPMatchesNameserversLag should fail when nameservers disagree on the same zone, got %v", err)
+	}
+
+}
+
+// Rule 3 with several records: the record set has drifted when the home IP is
+// missing from it, even when it is empty, or stale records remain next to it,
+// whatever their order. The update replaces the first stale record.
+func TestStoredIPMatchesDNSRecordSet(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	for _, test := range []struct {
+		addresses  []string
+		update     bool
+		previousIP string
+	}{
+		{[]string{"1.1.1.1"}, false, ""},
+		{[]string{}, true, ""},
+		{[]string{"1.1.1.1", "1.1.1.9"}, true, "1.1.1.9"},
+		{[]string{"1.1.1.9", "1.1.1.1"}, true, "1.1.1.9"},
+		{[]string{"1.1.1.8", "1.1.1.9"}, true, "1.1.1.8"},
+	} {
+		records := []domain.DNSRecord{}
+		for _, address := range test.addresses {
+			records = append(records, domain.DNSRecord{Address: address, Type: "A", TTL: 5 * time.Minute})
+		}
+
+		var changes []domain.IPChange
+		var sent []string
+		store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, alerts: map[string]domain.AlertState{}, changes: &changes}
+		monitor := NewMonitor(ipinfo, dnsResolverMock{records: records}, store, recordingNotifierMock{sent: &sent}, settings)
+
+		if err := monitor.Run(context.Background()); err != nil {
+			t.Fatalf("TestStoredIPMatchesDNSRecordSet should not fail with records %v: %v", test.addresses, err)
+		}
+		if !test.update && (len(changes) != 0 || len(sent) != 0) {
+			t.Errorf("Records %v should not trigger an update, saved %+v and sent %v", test.addresses, changes, sent)
+		}
+		if test.update && (len(changes) != 1 || changes[0].OldIP != test.previousIP || len(sent) != 2 || sent[1] != "update: 1.1.1.1") {
+			t.Errorf("Records %v should trigger an update replacing %q, saved %+v and sent %v", test.addresses, test.previousIP, changes, sent)
+		}
+	}
+
+}
+
+// Rule 3 after an update: caches may still answer the previous record for as
+// long as its TTL, so a mismatch within that window is propagating and does
+// not ask for the update again. Once the window is over, it does.
+func TestPropagatingUpdateNotRepeated(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.2", OrgName: "Test"}}
+
+	// The record is still cached with the previous address.
+	resolver := dnsResolverMock{records: []domain.DNSRecord{{Address: "1.1.1.1", Type: "A", TTL: 5 * time.Minute}}}
+
+	var changes []domain.IPChange
+	propagations := map[domain.IPFamily]domain.Propagation{}
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, alerts: map[string]domain.AlertState{}, changes: &changes, propagations: propagations}
+
+	var sent []string
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+	monitor := NewMonitor(ipinfo, resolver, store, recordingNotifierMock{sent: &sent}, settings)
+	updatedAt := time.Date(2026, 6, 18, 12, 0, 0, 0, time.UTC)
+
+	for _, elapsed := range []time.Duration{0, time.Minute, 4 * time.Minute, 6 * time.Minute} {
+		monitor.now = func() time.Time { return updatedAt.Add(elapsed) }
+		if err := monitor.Run(context.Background()); err != nil {
+			t.Fatalf("TestPropagatingUpdateNotRepeated should not fail %s after the update: %v", elapsed, err)
+		}
+	}
+
+	if len(changes) != 2 || changes[1].OldIP != "1.1.1.1" || len(sent) != 4 {
+		t.Errorf("TestPropagatingUpdateNotRepeated should only update again once the TTL is over, saved %+v and sent %v", changes, sent)
+	}
+	if propagation := propagations[domain.IPv4]; propagation.Address != "1.1.1.2" || !propagation.Since.Equal(updatedAt.Add(6*time.Minute)) || propagation.TTL != 5*time.Minute {
+		t.Errorf("The second update should start a propagation of the record TTL, got %+v", propagation)
+	}
+
+}
+
+// A Rule 2 update reads the TTL of the records it replaces too: resolvers
+// count the cached record down, so a mismatch right after it is propagating
+// even when the answer only has a minute left.
+func TestStoredIPUpdatePropagating(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.2", OrgName: "Test"}}
+
+	var changes []domain.IPChange
+	propagations := map[domain.IPFamily]domain.Propagation{}
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, alerts: map[string]domain.AlertState{}, changes: &changes, propagations: propagations}
+
+	var sent []string
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+	monitor := NewMonitor(ipinfo, nil, store, recordingNotifierMock{sent: &sent}, settings)
+	var report strings.Builder
+	monitor.report = &report
+	updatedAt := time.Date(2026, 6, 18, 12, 0, 0, 0, time.UTC)
+
+	for _, step := range []struct {
+		elapsed time.Duration
+		ttl     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{4 * time.Minute, time.Minute},
+	} {
+		monitor.resolver = dnsResolverMock{records: []domain.DNSRecord{{Address: "1.1.1.1", Type: "A", TTL: step.ttl}}}
+		monitor.now = func() time.Time { return updatedAt.Add(step.elapsed) }
+		if err := monitor.Run(context.Background()); err != nil {
+			t.Fatalf("TestStoredIPUpdatePropagating should not fail %s after the update: %v", step.elapsed, err)
+		}
+	}
+
+	if propagation := propagations[domain.IPv4]; propagation.Address != "1.1.1.2" || propagation.TTL != 5*time.Minute {
+		t.Errorf("The stored IP update should start a propagation of the replaced record TTL, got %+v", propagation)
+	}
+	if len(changes) != 1 || len(sent) != 2 || !strings.Contains(report.String(), "IPv4 1.1.1.2 is propagating") {
+		t.Errorf("TestStoredIPUpdatePropagating should report the mismatch as propagating, saved %+v, sent %v and decided %q", changes, sent, report.String())
+	}
+
+}
+
 // Rule 4: an update is required (stored IP differs) but the first notification
-// (NotifyQueue) fails. Run must return its error before persisting.
+// (NotifyQueue) fails. Run must return its error.
 func TestUpdateNotifyChangeError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -345,7 +541,7 @@ func TestUpdateNotifyChangeError(t *testing.T) {
 
 	notifier := notifierMock{err: errors.New("Fail")}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -364,7 +560,7 @@ func TestUpdateNotifyChangeError(t *testing.T) {
 // "update" queue, so this isolates the second notification error.
 func TestUpdateNotifyUpdateQueueError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -374,7 +570,7 @@ func TestUpdateNotifyUpdateQueueError(t *testing.T) {
 
 	notifier := complexNotifierMock{err: errors.New("Fail"), failQueue: "update"}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -388,11 +584,11 @@ func TestUpdateNotifyUpdateQueueError(t *testing.T) {
 
 }
 
-// Rule 4: an update is required and both notifications succeed, but persisting
-// the new IP (SaveIP) fails. Run must return the save error.
+// Rule 4: an update is required but persisting the new IP and its messages
+// (SaveIP) fails. Run must return the save error.
 func TestUpdateSaveIPError(t *testing.T) {
 
-	ipinfoData := domain.IPInfo{IP: "1.1.1.1", OrgName: "Test"}
+	ipinfoData := domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}
 	ipinfo :=
 		ipInfoMock{ipInfoData: ipinfoData, err: nil}
 
@@ -402,7 +598,7 @@ func TestUpdateSaveIPError(t *testing.T) {
 
 	notifier := notifierMock{err: nil}
 
-	settings := Settings{ISPName: "Test", DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update"}
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
 
 	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
 
@@ -415,3 +611,506 @@ func TestUpdateSaveIPError(t *testing.T) {
 	}
 
 }
+
+// familyResolverMock fakes domain.DNSResolver answering per family (A or AAAA).
+type familyResolverMock struct {
+	results map[domain.IPFamily]string
+}
+
+func (mock familyResolverMock) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+	return []domain.DNSRecord{{Address: mock.results[family], TTL: 5 * time.Minute}}, nil
+}
+
+// familyStoreMock fakes domain.IPStore keeping one stored IP per family and
+// one state per alert. If changes is set, every saved change is recorded; if
+// outbox is set, saved messages are kept there until acknowledged; if
+// propagations is set, the propagation of each family is kept there.
+type familyStoreMock struct {
+	stored       map[domain.IPFamily]string
+	alerts       map[string]domain.AlertState
+	changes      *[]domain.IPChange
+	outbox       *[]domain.OutboxMessage
+	propagations map[domain.IPFamily]domain.Propagation
+}
+
+func (mock familyStoreMock) StoredIP(ctx context.Context, family domain.IPFamily) (string, bool, error) {
+	ip, found := mock.stored[family]
+	return ip, found, nil
+}
+
+func (mock familyStoreMock) SaveIP(ctx context.Context, change domain.IPChange, messages []domain.OutboxMessage) error {
+	mock.stored[change.Family] = change.NewIP
+	if mock.changes != nil {
+		*mock.changes = append(*mock.changes, change)
+	}
+	if mock.outbox != nil {
+		*mock.outbox = append(*mock.outbox, messages...)
+	}
+	return nil
+}
+
+func (mock familyStoreMock) PendingMessages(ctx context.Context) ([]domain.OutboxMessage, error) {
+	if mock.outbox == nil {
+		return nil, nil
+	}
+	return slices.Clone(*mock.outbox), nil
+}
+
+func (mock familyStoreMock) AckMessage(ctx context.Context, eventID string) error {
+	if mock.outbox != nil {
+		*mock.outbox = slices.DeleteFunc(*mock.outbox, func(message domain.OutboxMessage) bool { return message.Event.ID == eventID })
+	}
+	return nil
+}
+
+func (mock familyStoreMock) AlertState(ctx context.Context, alert string) (domain.AlertState, bool, error) {
+	state, found := mock.alerts[alert]
+	return state, found, nil
+}
+
+func (mock familyStoreMock) SaveAlertState(ctx context.Context, alert string, state domain.AlertState) error {
+	mock.alerts[alert] = state
+	return nil
+}
+
+func (mock familyStoreMock) Propagation(ctx context.Context, family domain.IPFamily) (domain.Propagation, bool, error) {
+	propagation, found := mock.propagations[family]
+	return propagation, found, nil
+}
+
+func (mock familyStoreMock) SavePropagation(ctx context.Context, family domain.IPFamily, propagation domain.Propagation) error {
+	if mock.propagations != nil {
+		mock.propagations[family] = propagation
+	}
+	return nil
+}
+
+// recordingNotifierMock fakes domain.Notifier and records every sent message
+// (and, if events is set, every event), so dual-stack tests can assert which
+// family triggered an update.
+type recordingNotifierMock struct {
+	sent   *[]string
+	events *[]domain.Event
+}
+
+func (mock recordingNotifierMock) Notify(ctx context.Context, queue string, event domain.Event) error {
+	*mock.sent = append(*mock.sent, queue+": "+event.Message)
+	if mock.events != nil {
+		*mock.events = append(*mock.events, event)
+	}
+	return nil
+}
+
+// Dual-stack: both families are stored and both DNS records (A and AAAA) match,
+// so nothing is sent even though the resolver holds an IPv6 answer. Before
+// families were tracked separately, an AAAA answer coming back first triggered
+// a false update.
+func TestDualStackNoUpdate(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.1", IPv6: "2a0c:5a80::1", OrgName: "Test"}}
+
+	resolver := familyResolverMock{results: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1", domain.IPv6: "2a0c:5a80::1"}}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1", domain.IPv6: "2a0c:5a80::1"}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Errorf("TestDualStackNoUpdate should not fail: %v", err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("TestDualStackNoUpdate should not send any message, but sent %v", sent)
+	}
+
+}
+
+// Dual-stack: only the IPv6 address changed, so only an IPv6 update is published
+// and stored; the IPv4 address is left alone.
+func TestDualStackOnlyIPv6Changed(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.1", IPv6: "2a0c:5a80::2", OrgName: "Test"}}
+
+	resolver := familyResolverMock{results: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1", domain.IPv6: "2a0c:5a80::1"}}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1", domain.IPv6: "2a0c:5a80::1"}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Errorf("TestDualStackOnlyIPv6Changed should not fail: %v", err)
+	}
+
+	expected := []string{"notify: Home IPv6 has changed to 2a0c:5a80::2.", "update: 2a0c:5a80::2"}
+	if len(sent) != len(expected) || sent[0] != expected[0] || sent[1] != expected[1] {
+		t.Errorf("TestDualStackOnlyIPv6Changed should send %v, but sent %v", expected, sent)
+	}
+	if store.stored[domain.IPv6] != "2a0c:5a80::2" || store.stored[domain.IPv4] != "1.1.1.1" {
+		t.Errorf("TestDualStackOnlyIPv6Changed should only update the stored IPv6 address, stored %v", store.stored)
+	}
+
+}
+
+// A monitored family without a detected address is skipped, but Run fails when
+// no monitored family has an address at all.
+func TestNoAddressForMonitoredFamilies(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}}
+
+	resolver := dnsResolverMock{result: "1.1.1.1", err: nil}
+
+	store := ipStoreMock{storedIPValue: "1.1.1.1", storeFound: true, storeError: nil, saveError: nil}
+
+	notifier := notifierMock{err: nil}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv6}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+
+	if err := monitor.Run(context.Background()); err == nil {
+		t.Errorf("TestNoAddressForMonitoredFamilies should fail because no IPv6 address was detected")
+	}
+
+}
+
+// When the providers do not reach the quorum, Run reports the disagreement on
+// the notify queue and fails without publishing an update.
+func TestProvidersDisagreementNotifies(t *testing.T) {
+
+	disagreement := &domain.DisagreementError{Family: domain.IPv4, Quorum: 2, Answers: []domain.ProviderAnswer{{Provider: "ipinfo", Address: "1.1.1.1"}, {Provider: "stun", Address: "2.2.2.2"}}}
+	ipinfo := ipInfoMock{err: disagreement}
+
+	resolver := dnsResolverMock{result: "1.1.1.1", err: nil}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+
+	if err := monitor.Run(context.Background()); !errors.Is(err, disagreement) {
+		t.Errorf("TestProvidersDisagreementNotifies should fail with the disagreement, got %v", err)
+	}
+
+	expected := "notify: Public IP providers do not agree on home IPv4 (2 matching answers required): ipinfo=1.1.1.1, stun=2.2.2.2."
+	if len(sent) != 1 || sent[0] != expected {
+		t.Errorf("TestProvidersDisagreementNotifies should send [%s], but sent %v", expected, sent)
+	}
+	if len(store.stored) != 0 {
+		t.Errorf("TestProvidersDisagreementNotifies should not store any IP, stored %v", store.stored)
+	}
+
+}
+
+// Being behind a carrier-grade NAT is notified once; it is notified again after
+// a public address was detected in between.
+func TestBehindCGNATNotifies(t *testing.T) {
+
+	cgnat := &domain.CGNATError{Address: "100.64.12.34"}
+	ipinfo := &ipInfoMock{err: cgnat}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{}, alerts: map[string]domain.AlertState{}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	monitor := NewMonitor(ipinfo, dnsResolverMock{result: "1.1.1.1"}, store, notifier, settings)
+
+	for range 2 {
+		if err := monitor.Run(context.Background()); !errors.Is(err, cgnat) {
+			t.Errorf("TestBehindCGNATNotifies should fail with the CGNATError, got %v", err)
+		}
+	}
+
+	expected := "notify: Home router WAN address 100.64.12.34 is in the carrier-grade NAT range 100.64.0.0/10, home is not reachable from the Internet."
+	if len(sent) != 1 || sent[0] != expected {
+		t.Errorf("TestBehindCGNATNotifies should send [%s] once, but sent %v", expected, sent)
+	}
+	if len(store.stored) != 0 {
+		t.Errorf("TestBehindCGNATNotifies should not store any IP, stored %v", store.stored)
+	}
+
+	// A provider reporting a shared address directly is handled the same way.
+	*ipinfo = ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.1", OrgName: "Test"}}
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Fatalf("TestBehindCGNATNotifies should not fail with a public address: %v", err)
+	}
+	sent = nil
+	*ipinfo = ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "100.64.12.34", OrgName: "Test"}}
+	var validateCGNAT *domain.CGNATError
+	if err := monitor.Run(context.Background()); !errors.As(err, &validateCGNAT) {
+		t.Errorf("TestBehindCGNATNotifies should fail with a CGNATError for a shared address, got %v", err)
+	}
+	if len(sent) != 1 || sent[0] != expected {
+		t.Errorf("TestBehindCGNATNotifies should send [%s] again after a public address, but sent %v", expected, sent)
+	}
+
+}
+
+// Behind DS-Lite or a carrier-grade NAT only the IPv4 address is unreachable:
+// it is notified and dropped, while the public IPv6 address is still updated.
+func TestBehindCGNATKeepsIPv6(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "100.64.12.34", IPv6: "2a0c:5a80::1", OrgName: "Test"}}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{}, alerts: map[string]domain.AlertState{}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	monitor := NewMonitor(ipinfo, dnsResolverMock{}, store, notifier, settings)
+
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Fatalf("TestBehindCGNATKeepsIPv6 should not fail with a public IPv6 address: %v", err)
+	}
+
+	expected := []string{
+		"notify: Home router WAN address 100.64.12.34 is in the carrier-grade NAT range 100.64.0.0/10, home is not reachable from the Internet.",
+		"notify: Home IPv6 has changed to 2a0c:5a80::1.",
+		"update: 2a0c:5a80::1",
+	}
+	if !slices.Equal(sent, expected) {
+		t.Errorf("TestBehindCGNATKeepsIPv6 should send %v, but sent %v", expected, sent)
+	}
+	if len(store.stored) != 1 || store.stored[domain.IPv6] != "2a0c:5a80::1" {
+		t.Errorf("TestBehindCGNATKeepsIPv6 should only store the IPv6 address, stored %v", store.stored)
+	}
+	if !store.alerts[behindCGNATAlert].Active {
+		t.Errorf("TestBehindCGNATKeepsIPv6 should remember the carrier-grade NAT alert")
+	}
+
+}
+
+// Private, documentation and other non-public addresses are rejected without
+// any notification nor update.
+func TestNonPublicAddressRejected(t *testing.T) {
+
+	for _, ipinfoData := range []domain.IPInfo{
+		{IPv4: "192.168.1.10", OrgName: "Test"},
+		{IPv6: "2001:db8::1", OrgName: "Test"},
+		{IPv4: "127.0.0.1", OrgName: "Test"},
+	} {
+		store := familyStoreMock{stored: map[domain.IPFamily]string{}, alerts: map[string]domain.AlertState{}}
+
+		var sent []string
+		notifier := recordingNotifierMock{sent: &sent}
+
+		settings := Settings{ISP: domain.ISPMatcher{Name: "Test"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+		monitor := NewMonitor(ipInfoMock{ipInfoData: ipinfoData}, dnsResolverMock{}, store, notifier, settings)
+
+		var nonPublic *domain.NonPublicAddressError
+		if err := monitor.Run(context.Background()); !errors.As(err, &nonPublic) {
+			t.Errorf("TestNonPublicAddressRejected should fail with a NonPublicAddressError for %+v, got %v", ipinfoData, err)
+		}
+		if len(sent) != 0 || len(store.stored) != 0 {
+			t.Errorf("TestNonPublicAddressRejected should neither notify nor store for %+v, sent %v and stored %v", ipinfoData, sent, store.stored)
+		}
+	}
+
+}
+
+// While home stays on a backup ISP the switch is notified once, then only a
+// reminder every ISPReminder; the stored IPs are never touched.
+func TestDifferentISPNotifiedOnceWithReminder(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "2.2.2.2", OrgName: "ORANGE"}}
+
+	resolver := dnsResolverMock{result: "1.1.1.1", err: nil}
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, alerts: map[string]domain.AlertState{}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}, ISPReminder: time.Hour}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+	start := time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC)
+
+	for _, elapsed := range []time.Duration{0, 2 * time.Minute, 30 * time.Minute, time.Hour, 90 * time.Minute} {
+		monitor.now = func() time.Time { return start.Add(elapsed) }
+		if err := monitor.Run(context.Background()); err != nil {
+			t.Fatalf("TestDifferentISPNotifiedOnceWithReminder should not fail: %v", err)
+		}
+	}
+
+	expected := []string{
+		"notify: Read IP 2.2.2.2 belongs to ORANGE ISP, it seems that home is not using main ISP DIGI.",
+		"notify: Home is still not using main ISP DIGI, read IP 2.2.2.2 has belonged to ORANGE ISP for 1h0m0s.",
+	}
+	if len(sent) != len(expected) || sent[0] != expected[0] || sent[1] != expected[1] {
+		t.Errorf("TestDifferentISPNotifiedOnceWithReminder should send %v, but sent %v", expected, sent)
+	}
+	if store.stored[domain.IPv4] != "1.1.1.1" {
+		t.Errorf("TestDifferentISPNotifiedOnceWithReminder should not update the stored IP, stored %v", store.stored)
+	}
+
+}
+
+// Without a reminder interval, a switch to another backup ISP is still
+// notified.
+func TestDifferentISPChangesBackupISP(t *testing.T) {
+
+	store := familyStoreMock{stored: map[domain.IPFamily]string{}, alerts: map[string]domain.AlertState{}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	for _, orgName := range []string{"ORANGE", "ORANGE", "VODAFONE"} {
+		ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "2.2.2.2", OrgName: orgName}}
+		monitor := NewMonitor(ipinfo, dnsResolverMock{}, store, notifier, settings)
+		if err := monitor.Run(context.Background()); err != nil {
+			t.Fatalf("TestDifferentISPChangesBackupISP should not fail: %v", err)
+		}
+	}
+
+	if len(sent) != 2 {
+		t.Errorf("TestDifferentISPChangesBackupISP should notify ORANGE and VODAFONE once each, but sent %v", sent)
+	}
+
+}
+
+// Coming back to the main ISP is announced once and the IP checks resume.
+func TestBackOnMainISP(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.1", OrgName: "DIGI"}}
+
+	resolver := dnsResolverMock{result: "1.1.1.1", err: nil}
+
+	since := time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC)
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, alerts: map[string]domain.AlertState{differentISPAlert: {Active: true, Since: since, LastNotified: since, Detail: "ORANGE"}}}
+
+	var sent []string
+	notifier := recordingNotifierMock{sent: &sent}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+	monitor.now = func() time.Time { return since.Add(3 * time.Hour) }
+
+	for range 2 {
+		if err := monitor.Run(context.Background()); err != nil {
+			t.Fatalf("TestBackOnMainISP should not fail: %v", err)
+		}
+	}
+
+	expected := "notify: Home is back on main ISP DIGI with IP 1.1.1.1 after 3h0m0s on ORANGE ISP."
+	if len(sent) != 1 || sent[0] != expected {
+		t.Errorf("TestBackOnMainISP should send [%s] once, but sent %v", expected, sent)
+	}
+	if store.alerts[differentISPAlert].Active {
+		t.Errorf("TestBackOnMainISP should clear the different ISP alert")
+	}
+
+}
+
+// Update events carry the structured view of the change: the replaced and new
+// addresses, the ISP, the domain and where the address was detected.
+func TestUpdateEvents(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.2", ASN: 57269, OrgName: "DIGI", Source: "ipinfo"}}
+
+	resolver := dnsResolverMock{result: "1.1.1.1", err: nil}
+
+	var changes []domain.IPChange
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, changes: &changes}
+
+	var sent []string
+	var events []domain.Event
+	notifier := recordingNotifierMock{sent: &sent, events: &events}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	monitor := NewMonitor(ipinfo, resolver, store, notifier, settings)
+	detectedAt := time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC)
+	monitor.now = func() time.Time { return detectedAt }
+
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Fatalf("TestUpdateEvents should not fail: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("TestUpdateEvents should send 2 events, but sent %v", sent)
+	}
+
+	expectedTypes := []domain.EventType{domain.IPChangedEvent, domain.DNSUpdateEvent}
+	for index, event := range events {
+		if event.Type != expectedTypes[index] || event.SchemaVersion != domain.EventSchemaVersion || event.ID == "" || !event.Time.Equal(detectedAt) {
+			t.Errorf("Event %d should be a %s event with schema, ID and time set, got %+v", index, expectedTypes[index], event)
+		}
+		if event.Family != domain.IPv4 || event.OldIP != "1.1.1.1" || event.NewIP != "1.1.1.2" || event.ISP != "DIGI" || event.ASN != 57269 || event.Domain != "test.windmaker.net" || event.Source != "ipinfo" {
+			t.Errorf("Event %d does not describe the change, got %+v", index, event)
+		}
+	}
+	if events[0].ID == events[1].ID {
+		t.Errorf("Each event should have its own ID")
+	}
+	if events[1].Message != "1.1.1.2" {
+		t.Errorf("The update event message should be the raw IP, got \"%s\"", events[1].Message)
+	}
+
+	expectedChange := domain.IPChange{Family: domain.IPv4, OldIP: "1.1.1.1", NewIP: "1.1.1.2", OrgName: "DIGI", ASN: 57269, Time: detectedAt}
+	if len(changes) != 1 || changes[0] != expectedChange {
+		t.Errorf("TestUpdateEvents should save the change to the history, got %+v", changes)
+	}
+
+}
+
+// Rule 4: the DNS update message fails after the human notification went out.
+// The next run must only deliver the DNS update, not repeat the notification
+// nor the update itself.
+func TestOutboxResumesPartialDelivery(t *testing.T) {
+
+	ipinfo := ipInfoMock{ipInfoData: domain.IPInfo{IPv4: "1.1.1.2", OrgName: "DIGI"}}
+
+	// The record is updated by the time the second run cross-checks it.
+	resolver := dnsResolverMock{result: "1.1.1.2", err: nil}
+
+	var outbox []domain.OutboxMessage
+	var changes []domain.IPChange
+	store := familyStoreMock{stored: map[domain.IPFamily]string{domain.IPv4: "1.1.1.1"}, alerts: map[string]domain.AlertState{}, changes: &changes, outbox: &outbox}
+
+	settings := Settings{ISP: domain.ISPMatcher{Name: "DIGI"}, DomainName: "test.windmaker.net", NotifyQueue: "notify", UpdateQueue: "update", Families: []domain.IPFamily{domain.IPv4}}
+
+	failingMonitor := NewMonitor(ipinfo, resolver, store, complexNotifierMock{failQueue: "update", err: errors.New("Fail")}, settings)
+	if err := failingMonitor.Run(context.Background()); err == nil {
+		t.Fatalf("TestOutboxResumesPartialDelivery should fail while the update queue fails")
+	}
+	if len(outbox) != 1 || outbox[0].Queue != "update" {
+		t.Fatalf("Only the DNS update message should be left in the outbox, got %+v", outbox)
+	}
+	pendingID := outbox[0].Event.ID
+
+	var sent []string
+	var events []domain.Event
+	monitor := NewMonitor(ipinfo, resolver, store, recordingNotifierMock{sent: &sent, events: &events}, settings)
+	if err := monitor.Run(context.Background()); err != nil {
+		t.Fatalf("TestOutboxResumesPartialDelivery should not fail once the queue is back: %v", err)
+	}
+
+	if len(sent) != 1 || sent[0] != "update: 1.1.1.2" || events[0].ID != pendingID {
+		t.Errorf("The resumed run should only deliver the pending DNS update with its original ID, but sent %v", sent)
+	}
+	if len(outbox) != 0 || len(changes) != 1 {
+		t.Errorf("The outbox should be empty and the change saved once, got %+v and %+v", outbox, changes)
+	}
+
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// Runner is a single monitoring pass. Monitor implements it; the scheduler
+// only depends on this interface so it can be exercised with fakes.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// SchedulerSettings holds the timing of the daemon mode.
+type SchedulerSettings struct {
+	Interval   time.Duration // Time between two runs while they succeed
+	Jitter     time.Duration // Upper bound of the random delay added to Interval and to backoffs
+	RetryDelay time.Duration // Delay before the immediate re-check that follows a failure
+	MaxBackoff time.Duration // Upper bound of the delay while runs keep failing, defaultMaxBackoff when unset
+}
+
+// defaultMaxBackoff bounds the delay while runs keep failing when MaxBackoff
+// is unset, so the backoff still grows without ever overflowing.
+const defaultMaxBackoff = 10 * time.Minute
+
+// Scheduler runs a Runner periodically until its context is cancelled. It is
+// the daemon counterpart of the systemd timer: connections opened by the
+// composition root are kept for the whole life of the process.
+type Scheduler struct {
+	runner   Runner
+	settings SchedulerSettings
+	random   func(n int64) int64
+}
+
+// NewScheduler builds a Scheduler for runner with the given settings.
+func NewScheduler(runner Runner, settings SchedulerSettings) Scheduler {
+	return Scheduler{runner: runner, settings: settings, random: rand.Int64N}
+}
+
+// Start runs the runner right away and then keeps running it:
+//
+//   - after a successful run it waits Interval plus a random jitter;
+//   - after the first failure it re-checks once after RetryDelay;
+//   - while runs keep failing it doubles the delay up to MaxBackoff;
+//   - when a provider was rate limited it waits at least its Retry-After.
+//
+// It returns once ctx is cancelled (SIGTERM/SIGINT in the daemon), after the
+// in-flight run, if any, has returned. Run errors are logged, never returned:
+// the next run is the recovery path.
+func (scheduler Scheduler) Start(ctx context.Context) {
+
+	log := logger.FromContext(ctx).With("operation", "Scheduler.Start")
+	log.InfoContext(ctx, "Starting scheduler", "settings", scheduler.settings)
+
+	failures := 0
+	for {
+		runErr := scheduler.runner.Run(ctx)
+		if runErr != nil {
+			failures++
+			log.ErrorContext(ctx, "Error running monitor", "error", runErr, "consecutiveFailures", failures)
+		} else {
+			failures = 0
+		}
+
+		delay := scheduler.nextDelay(failures, runErr)
+		log.DebugContext(ctx, "Waiting for next run", "delay", delay, "consecutiveFailures", failures)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.InfoContext(ctx, "Stopping scheduler", "reason", context.Cause(ctx))
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// nextDelay returns how long to wait before the next run given the number of
+// consecutive failures so far and the error of the last run. When a provider
+// was rate limited, the wait lasts at least as long as it asked for.
+func (scheduler Scheduler) nextDelay(failures int, runErr error) time.Duration {
+
+	var rateLimit *domain.RateLimitError
+	if errors.As(runErr, &rateLimit) && rateLimit.RetryAfter > 0 {
+		return max(scheduler.backoff(failures), rateLimit.RetryAfter+scheduler.jitter())
+	}
+	return scheduler.backoff(failures)
+}
+
+// backoff returns how long to wait before the next run given the number of
+// consecutive failures so far.
+func (scheduler Scheduler) backoff(failures int) time.Duration {
+
+	switch {
+	case failures == 0:
+		return scheduler.settings.Interval + scheduler.jitter()
+	case failures == 1:
+		return scheduler.settings.RetryDelay
+	}
+
+	// Keep failing: exponential backoff from the retry delay (at least one
+	// second, so a zero RetryDelay never turns into a busy loop).
+	maxBackoff := scheduler.settings.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	backoff := max(scheduler.settings.RetryDelay, time.Second)
+	for attempt := 1; attempt < failures && backoff < maxBackoff; attempt++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff) + scheduler.jitter()
+}
+
+// jitter returns a random duration in [0, Jitter].
+func (scheduler Scheduler) jitter() time.Duration {
+	if scheduler.settings.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(scheduler.random(int64(scheduler.settings.Jitter) + 1))
+}
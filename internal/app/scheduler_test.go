@@ -0,0 +1,134 @@
+//go:build integration_tests || unit_tests || app_tests || app_unit_tests
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// runnerMock fakes Runner. It returns the configured results in order (nil
+// once they are exhausted) and cancels the scheduler after stopAfter runs.
+type runnerMock struct {
+	results   []error
+	runs      *int
+	stopAfter int
+	cancel    context.CancelFunc
+}
+
+func (mock runnerMock) Run(ctx context.Context) error {
+	*mock.runs++
+	if *mock.runs >= mock.stopAfter {
+		mock.cancel()
+	}
+	if *mock.runs <= len(mock.results) {
+		return mock.results[*mock.runs-1]
+	}
+	return nil
+}
+
+// The scheduler keeps running after failures and stops cleanly once the
+// context is cancelled.
+func TestSchedulerRunsUntilCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	runner := runnerMock{results: []error{errors.New("Fail"), errors.New("Fail")}, runs: &runs, stopAfter: 4, cancel: cancel}
+
+	scheduler := NewScheduler(runner, SchedulerSettings{Interval: time.Millisecond, RetryDelay: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	done := make(chan struct{})
+	go func() {
+		scheduler.Start(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("TestSchedulerRunsUntilCancelled scheduler did not stop after cancellation")
+	}
+
+	if runs != 4 {
+		t.Errorf("TestSchedulerRunsUntilCancelled should run 4 times, it ran %d times", runs)
+	}
+
+}
+
+// Successful runs wait Interval plus jitter, the first failure re-checks after
+// RetryDelay and further failures back off exponentially up to MaxBackoff.
+func TestSchedulerNextDelay(t *testing.T) {
+
+	scheduler := NewScheduler(nil, SchedulerSettings{Interval: 2 * time.Minute, Jitter: 10 * time.Second, RetryDelay: 5 * time.Second, MaxBackoff: time.Minute})
+	scheduler.random = func(n int64) int64 { return n - 1 }
+
+	expected := map[int]time.Duration{
+		0: 2*time.Minute + 10*time.Second,
+		1: 5 * time.Second,
+		2: 10*time.Second + 10*time.Second,
+		3: 20*time.Second + 10*time.Second,
+		4: 40*time.Second + 10*time.Second,
+		5: time.Minute + 10*time.Second,
+		9: time.Minute + 10*time.Second,
+	}
+
+	for failures, delay := range expected {
+		if got := scheduler.nextDelay(failures, nil); got != delay {
+			t.Errorf("nextDelay(%d) should be %s but it was %s", failures, delay, got)
+		}
+	}
+
+}
+
+// Without MaxBackoff failures still back off exponentially, up to
+// defaultMaxBackoff.
+func TestSchedulerNextDelayDefaultMaxBackoff(t *testing.T) {
+
+	scheduler := NewScheduler(nil, SchedulerSettings{Interval: 2 * time.Minute, RetryDelay: 5 * time.Second})
+
+	expected := map[int]time.Duration{
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		6:  160 * time.Second,
+		8:  defaultMaxBackoff,
+		80: defaultMaxBackoff,
+	}
+
+	for failures, delay := range expected {
+		if got := scheduler.nextDelay(failures, nil); got != delay {
+			t.Errorf("nextDelay(%d) should be %s without MaxBackoff but it was %s", failures, delay, got)
+		}
+	}
+
+}
+
+// A rate limited provider is not asked again before its Retry-After, which
+// does not shorten a longer backoff either.
+func TestSchedulerNextDelayRateLimited(t *testing.T) {
+
+	scheduler := NewScheduler(nil, SchedulerSettings{Interval: 2 * time.Minute, Jitter: 10 * time.Second, RetryDelay: 5 * time.Second, MaxBackoff: 10 * time.Minute})
+	scheduler.random = func(n int64) int64 { return n - 1 }
+
+	rateLimited := fmt.Errorf("ipinfo: %w", &domain.RateLimitError{Provider: "ipinfo", RetryAfter: time.Hour})
+	if got := scheduler.nextDelay(1, rateLimited); got != time.Hour+10*time.Second {
+		t.Errorf("nextDelay should wait for the Retry-After, it was %s", got)
+	}
+
+	rateLimited = &domain.RateLimitError{Provider: "ipinfo", RetryAfter: 10 * time.Second}
+	if got := scheduler.nextDelay(3, rateLimited); got != 20*time.Second+10*time.Second {
+		t.Errorf("nextDelay should keep the backoff when it is longer than the Retry-After, it was %s", got)
+	}
+
+	rateLimited = &domain.RateLimitError{Provider: "ipinfo"}
+	if got := scheduler.nextDelay(1, rateLimited); got != 5*time.Second {
+		t.Errorf("nextDelay should back off as usual without Retry-After, it was %s", got)
+	}
+
+}
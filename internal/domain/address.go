@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// AddressScope tells where an address is reachable from. Only public
+// addresses can be published in the domain DNS records.
+type AddressScope string
+
+const (
+	PublicAddress        AddressScope = "public"
+	PrivateAddress       AddressScope = "private"       // RFC 1918 and RFC 4193 (ULA)
+	SharedAddress        AddressScope = "shared"        // RFC 6598, carrier-grade NAT
+	LoopbackAddress      AddressScope = "loopback"      // 127.0.0.0/8 and ::1
+	LinkLocalAddress     AddressScope = "link-local"    // 169.254.0.0/16 and fe80::/10
+	DocumentationAddress AddressScope = "documentation" // RFC 5737, RFC 3849 and RFC 9637
+	BogonAddress         AddressScope = "bogon"         // Any other special-purpose, multicast or unallocated address
+)
+
+// specialPrefixes maps the special-purpose ranges (RFC 6890) to their scope.
+var specialPrefixes = []struct {
+	prefix netip.Prefix
+	scope  AddressScope
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), BogonAddress},
+	{netip.MustParsePrefix("10.0.0.0/8"), PrivateAddress},
+	{sharedAddressSpace, SharedAddress},
+	{netip.MustParsePrefix("127.0.0.0/8"), LoopbackAddress},
+	{netip.MustParsePrefix("169.254.0.0/16"), LinkLocalAddress},
+	{netip.MustParsePrefix("172.16.0.0/12"), PrivateAddress},
+	{netip.MustParsePrefix("192.0.0.0/24"), BogonAddress},
+	{netip.MustParsePrefix("192.0.2.0/24"), DocumentationAddress},
+	{netip.MustParsePrefix("192.88.99.0/24"), BogonAddress},
+	{netip.MustParsePrefix("192.168.0.0/16"), PrivateAddress},
+	{netip.MustParsePrefix("198.18.0.0/15"), BogonAddress},
+	{netip.MustParsePrefix("198.51.100.0/24"), DocumentationAddress},
+	{netip.MustParsePrefix("203.0.113.0/24"), DocumentationAddress},
+	{netip.MustParsePrefix("224.0.0.0/3"), BogonAddress},
+	{netip.MustParsePrefix("::1/128"), LoopbackAddress},
+	{netip.MustParsePrefix("64:ff9b::/96"), BogonAddress},
+	{netip.MustParsePrefix("100::/64"), BogonAddress},
+	{netip.MustParsePrefix("2001::/23"), BogonAddress},
+	{netip.MustParsePrefix("2001:db8::/32"), DocumentationAddress},
+	{netip.MustParsePrefix("2002::/16"), BogonAddress},
+	{netip.MustParsePrefix("3fff::/20"), DocumentationAddress},
+	{netip.MustParsePrefix("fc00::/7"), PrivateAddress},
+	{netip.MustParsePrefix("fe80::/10"), LinkLocalAddress},
+}
+
+// globalUnicast is the only IPv6 range allocated to the registries.
+var globalUnicast = netip.MustParsePrefix("2000::/3")
+
+// ClassifyAddress parses ip and returns its scope. IPv4-mapped IPv6
+// addresses are classified as the IPv4 address they hold.
+func ClassifyAddress(ip string) (AddressScope, error) {
+	address, parseErr := netip.ParseAddr(ip)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	address = address.Unmap()
+
+	for _, special := range specialPrefixes {
+		if special.prefix.Contains(address) {
+			return special.scope, nil
+		}
+	}
+	if address.Is6() && !globalUnicast.Contains(address) {
+		return BogonAddress, nil
+	}
+	return PublicAddress, nil
+}
+
+// NonPublicAddressError is returned when a provider reported an address that
+// cannot be the public address of the home connection, such as a private or
+// documentation one. Publishing it would make home unreachable.
+type NonPublicAddressError struct {
+	Address string
+	Scope   AddressScope
+}
+
+func (err *NonPublicAddressError) Error() string {
+	return fmt.Sprintf("detected address %s is a %s address, not a public one", err.Address, err.Scope)
+}
+
+// ValidateAddress checks that the address of family in ipinfo, if any, is a
+// public address of that family. Each family is checked on its own, since
+// behind DS-Lite or a carrier-grade NAT only the IPv4 address is unreachable.
+// It returns a *CGNATError for an address in the shared address space of a
+// carrier-grade NAT and a *NonPublicAddressError for any other address that
+// is not public.
+func (ipinfo IPInfo) ValidateAddress(family IPFamily) error {
+	ip := ipinfo.Address(family)
+	if ip == "" {
+		return nil
+	}
+
+	addressFamily, familyErr := FamilyOf(ip)
+	if familyErr != nil {
+		return fmt.Errorf("detected address \"%s\" cannot be parsed: %w", ip, familyErr)
+	}
+	if addressFamily != family {
+		return fmt.Errorf("detected address %s is not an %s address", ip, family)
+	}
+
+	scope, _ := ClassifyAddress(ip)
+	switch scope {
+	case PublicAddress:
+		return nil
+	case SharedAddress:
+		return &CGNATError{Address: ip}
+	}
+	return &NonPublicAddressError{Address: ip, Scope: scope}
+}
@@ -0,0 +1,88 @@
+//go:build integration_tests || unit_tests || domain_tests || domain_unit_tests
+
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyAddress(t *testing.T) {
+	for ip, expected := range map[string]AddressScope{
+		"79.116.12.34":      PublicAddress,
+		"2a0c:5a80::1":      PublicAddress,
+		"::ffff:79.116.0.1": PublicAddress,
+		"10.1.2.3":          PrivateAddress,
+		"172.31.255.1":      PrivateAddress,
+		"192.168.1.1":       PrivateAddress,
+		"fd12:3456::1":      PrivateAddress,
+		"100.64.12.34":      SharedAddress,
+		"127.0.0.1":         LoopbackAddress,
+		"::1":               LoopbackAddress,
+		"169.254.1.1":       LinkLocalAddress,
+		"fe80::1":           LinkLocalAddress,
+		"192.0.2.1":         DocumentationAddress,
+		"198.51.100.1":      DocumentationAddress,
+		"203.0.113.1":       DocumentationAddress,
+		"2001:db8::1":       DocumentationAddress,
+		"0.0.0.0":           BogonAddress,
+		"198.18.0.1":        BogonAddress,
+		"224.0.0.1":         BogonAddress,
+		"255.255.255.255":   BogonAddress,
+		"::":                BogonAddress,
+		"2002:c000:201::1":  BogonAddress,
+		"ff02::1":           BogonAddress,
+		"4000::1":           BogonAddress,
+	} {
+		scope, err := ClassifyAddress(ip)
+		if err != nil || scope != expected {
+			t.Errorf("ClassifyAddress(\"%s\") should be %s, got %s (%v)", ip, expected, scope, err)
+		}
+	}
+
+	if _, err := ClassifyAddress("not an address"); err == nil {
+		t.Errorf("ClassifyAddress should fail with an invalid address")
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+
+	for _, family := range Families {
+		if err := (IPInfo{IPv4: "79.116.12.34", IPv6: "2a0c:5a80::1"}).ValidateAddress(family); err != nil {
+			t.Errorf("ValidateAddress should accept a public %s address: %v", family, err)
+		}
+		if err := (IPInfo{}).ValidateAddress(family); err != nil {
+			t.Errorf("ValidateAddress should accept an IPInfo without %s address: %v", family, err)
+		}
+	}
+
+	// Each family is checked on its own: a carrier-grade NAT IPv4 address
+	// does not make the public IPv6 one invalid.
+	var cgnat *CGNATError
+	dsLite := IPInfo{IPv4: "100.64.12.34", IPv6: "2a0c:5a80::1"}
+	if err := dsLite.ValidateAddress(IPv4); !errors.As(err, &cgnat) || cgnat.Address != "100.64.12.34" {
+		t.Errorf("ValidateAddress should return a CGNATError for a shared address, got %v", err)
+	}
+	if err := dsLite.ValidateAddress(IPv6); err != nil {
+		t.Errorf("ValidateAddress should accept the public IPv6 address next to a shared IPv4 one: %v", err)
+	}
+
+	var nonPublic *NonPublicAddressError
+	if err := (IPInfo{IPv4: "79.116.12.34", IPv6: "fd12:3456::1"}).ValidateAddress(IPv6); !errors.As(err, &nonPublic) || nonPublic.Scope != PrivateAddress {
+		t.Errorf("ValidateAddress should return a NonPublicAddressError for a ULA address, got %v", err)
+	}
+
+	for _, test := range []struct {
+		ipinfo IPInfo
+		family IPFamily
+	}{
+		{IPInfo{IPv4: "2a0c:5a80::1"}, IPv4},
+		{IPInfo{IPv6: "79.116.12.34"}, IPv6},
+		{IPInfo{IPv4: "79.116.12"}, IPv4},
+	} {
+		if err := test.ipinfo.ValidateAddress(test.family); err == nil {
+			t.Errorf("ValidateAddress should fail with the %s address of %+v", test.family, test.ipinfo)
+		}
+	}
+
+}
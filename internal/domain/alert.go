@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// AlertState is the remembered state of a condition that is reported once
+// rather than on every run, such as the home connection being on a backup ISP.
+// It is kept in the IPStore so it survives restarts of the oneshot service.
+type AlertState struct {
+	Active       bool      // Whether the condition is currently ongoing
+	Since        time.Time // When the condition started
+	LastNotified time.Time // When the condition was last notified
+	Detail       string    // What the condition was about (e.g. the backup ISP name)
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// BreakerStatus is the position of a circuit breaker.
+type BreakerStatus string
+
+const (
+	BreakerClosed   BreakerStatus = "closed"    // Calls go through
+	BreakerOpen     BreakerStatus = "open"      // Calls are refused until the cooldown is over
+	BreakerHalfOpen BreakerStatus = "half-open" // A trial call decides whether to close or reopen
+)
+
+// BreakerState is the remembered state of a circuit breaker guarding an
+// external service. It is kept in a BreakerStore so it survives restarts of
+// the oneshot service: a service found down by a run is not waited for again
+// by the next ones. The zero value is a closed breaker.
+type BreakerState struct {
+	Status   BreakerStatus
+	Failures int       // Consecutive failures while closed
+	OpenedAt time.Time // When the breaker last opened
+	TrialAt  time.Time // When the pending trial call of a half-open breaker started
+}
+
+// BreakerOpenError is returned instead of calling a service whose circuit
+// breaker is open.
+type BreakerOpenError struct {
+	Breaker string
+	RetryAt time.Time // When a trial call will be allowed
+}
+
+func (err *BreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open until %s", err.Breaker, err.RetryAt.Format(time.RFC3339))
+}
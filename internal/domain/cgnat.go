@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// sharedAddressSpace is the RFC 6598 range ISPs number the links of their
+// carrier-grade NAT from.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsCGNAT reports whether ip is in the RFC 6598 shared address space, which
+// on a router WAN interface means the ISP put home behind a carrier-grade NAT.
+func IsCGNAT(ip string) bool {
+	address, parseErr := netip.ParseAddr(ip)
+	return parseErr == nil && sharedAddressSpace.Contains(address.Unmap())
+}
+
+// CGNATError is returned by an IPInfoProvider that reads the WAN address of
+// the home router, or by IPInfo.Validate, when that address is in the shared
+// address space. The public address is then shared with other customers and
+// cannot be reached from outside, so the use case reports it instead of
+// publishing any address.
+type CGNATError struct {
+	Address string // WAN address of the router
+}
+
+func (err *CGNATError) Error() string {
+	return fmt.Sprintf("router WAN address %s is in the RFC 6598 shared address space, home is behind a carrier-grade NAT", err.Address)
+}
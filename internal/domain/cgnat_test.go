@@ -0,0 +1,21 @@
+//go:build integration_tests || unit_tests || domain_tests || domain_unit_tests
+
+package domain
+
+import "testing"
+
+func TestIsCGNAT(t *testing.T) {
+	for ip, expected := range map[string]bool{
+		"100.64.0.1":      true,
+		"100.127.255.254": true,
+		"100.128.0.1":     false,
+		"100.63.255.255":  false,
+		"192.168.1.1":     false,
+		"2001:db8::1":     false,
+		"not an address":  false,
+	} {
+		if IsCGNAT(ip) != expected {
+			t.Errorf("IsCGNAT(\"%s\") should be %t", ip, expected)
+		}
+	}
+}
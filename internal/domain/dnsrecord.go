@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// DNSRecord is an address record published for a domain. A DNSResolver
+// returns the whole record set of a family, so round-robin answers and stale
+// records left behind by a previous update can be told apart. The set is empty,
+// without error, when the domain has no record of the family or does not exist.
+type DNSRecord struct {
+	Address string
+	Type    string        // "A" or "AAAA"
+	TTL     time.Duration // Time resolvers may cache the record for
+}
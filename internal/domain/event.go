@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// EventSchemaVersion is the version of the Event schema. It is increased on
+// any change that is not backwards compatible for consumers.
+const EventSchemaVersion = 1
+
+// EventType identifies what an Event is about.
+type EventType string
+
+const (
+	IPChangedEvent            EventType = "ip.changed"             // The home IP of a family changed (notify queue)
+	DNSUpdateEvent            EventType = "dns.update"             // The home record of a family must be updated (update queue)
+	DifferentISPEvent         EventType = "isp.different"          // Home switched to a backup ISP
+	DifferentISPReminderEvent EventType = "isp.different.reminder" // Home is still on a backup ISP
+	MainISPRecoveredEvent     EventType = "isp.recovered"          // Home is back on the main ISP
+	ProvidersDisagreeEvent    EventType = "providers.disagreement" // Public IP providers did not reach the quorum
+	BehindCGNATEvent          EventType = "isp.cgnat"              // The home router got a carrier-grade NAT address
+)
+
+// Event is what the monitor publishes to the queues. Message is the human
+// readable (or, for DNS updates, raw IP) text kept for plain text consumers;
+// the remaining fields are the structured view of the same event.
+type Event struct {
+	SchemaVersion int
+	Type          EventType
+	ID            string    // Unique event ID, usable as an idempotency key
+	Time          time.Time // When the event was detected
+	Family        IPFamily  // Address family the event is about, if any
+	OldIP         string    // Previous address (stored or published), if known
+	NewIP         string    // Detected address of Family
+	Addresses     []string  // Every detected address, whatever the family
+	ISP           string    // Organization name the detected address belongs to
+	ASN           uint32    // Autonomous system number of the detected address
+	Domain        string    // Monitored domain
+	Source        string    // Provider(s) that detected the address
+	Message       string
+}
+
+// NewEvent returns an Event of eventType detected at detectedAt with a fresh
+// random ID and the current schema version.
+func NewEvent(eventType EventType, detectedAt time.Time) Event {
+	return Event{SchemaVersion: EventSchemaVersion, Type: eventType, ID: newEventID(), Time: detectedAt}
+}
+
+// newEventID returns a random (version 4) UUID.
+func newEventID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
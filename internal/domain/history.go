@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// IPChange is an entry of the IP history: the address of Family went from
+// OldIP (empty for the first one) to NewIP at Time, on the ISP OrgName.
+type IPChange struct {
+	Family  IPFamily
+	OldIP   string
+	NewIP   string
+	OrgName string
+	ASN     uint32
+	Time    time.Time
+}
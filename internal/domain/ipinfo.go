@@ -1,10 +1,106 @@
 package domain
 
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// IPFamily identifies an address family. The monitor tracks each family
+// separately, so an IPv6 answer is never compared against an IPv4 one.
+type IPFamily string
+
+const (
+	IPv4 IPFamily = "ipv4"
+	IPv6 IPFamily = "ipv6"
+)
+
+// Families lists every supported family in the order the monitor processes them.
+var Families = []IPFamily{IPv4, IPv6}
+
+// ParseIPFamily maps a (case-insensitive) family name to an IPFamily.
+func ParseIPFamily(value string) (IPFamily, error) {
+	switch IPFamily(strings.ToLower(strings.TrimSpace(value))) {
+	case IPv4:
+		return IPv4, nil
+	case IPv6:
+		return IPv6, nil
+	}
+	return "", fmt.Errorf("unknown IP family \"%s\"", value)
+}
+
+// FamilyOf returns the family of the given textual address.
+func FamilyOf(ip string) (IPFamily, error) {
+	addr, parseErr := netip.ParseAddr(ip)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	if addr.Unmap().Is4() {
+		return IPv4, nil
+	}
+	return IPv6, nil
+}
+
+// IPInfo holds the public addresses of the home connection, one per family,
+// and the organization (ISP) they belong to. An empty address means that
+// family was not detected; a zero ASN means the provider did not report it.
 type IPInfo struct {
-	IP      string
-	OrgName string
+	IPv4    string
+	IPv6    string
+	ASN     uint32 // Autonomous system number announcing the addresses (e.g. 3352)
+	OrgName string // Full organization name (e.g. "Telefonica de Espana")
+	Source  string // Provider(s) the addresses were detected by (e.g. "ipinfo")
+}
+
+// Address returns the address detected for the given family (empty if none).
+func (ipinfo IPInfo) Address(family IPFamily) string {
+	switch family {
+	case IPv4:
+		return ipinfo.IPv4
+	case IPv6:
+		return ipinfo.IPv6
+	}
+	return ""
+}
+
+// SetAddress stores ip in the field matching its family, so callers never
+// have to know which family a provider answer belongs to.
+func (ipinfo *IPInfo) SetAddress(ip string) error {
+	family, familyErr := FamilyOf(ip)
+	if familyErr != nil {
+		return familyErr
+	}
+	if family == IPv4 {
+		ipinfo.IPv4 = ip
+	} else {
+		ipinfo.IPv6 = ip
+	}
+	return nil
+}
+
+// RemoveAddress forgets the address detected for family.
+func (ipinfo *IPInfo) RemoveAddress(family IPFamily) {
+	switch family {
+	case IPv4:
+		ipinfo.IPv4 = ""
+	case IPv6:
+		ipinfo.IPv6 = ""
+	}
+}
+
+// Addresses returns every detected address, IPv4 first.
+func (ipinfo IPInfo) Addresses() []string {
+	var addresses []string
+	for _, family := range Families {
+		if address := ipinfo.Address(family); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
 }
 
-func (ipinfo IPInfo) BelongsToISP(isp string) bool {
-	return ipinfo.OrgName == isp
+// BelongsToISP reports whether the addresses belong to the ISP described by
+// the matcher (see ISPMatcher.Matches).
+func (ipinfo IPInfo) BelongsToISP(isp ISPMatcher) bool {
+	return isp.Matches(ipinfo)
 }
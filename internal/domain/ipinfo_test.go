@@ -7,10 +7,10 @@ import (
 )
 
 func TestBelongsToISP(t *testing.T) {
-	ipinfo := IPInfo{IP: "1.1.1.1", OrgName: "DIGI"}
+	ipinfo := IPInfo{IPv4: "1.1.1.1", OrgName: "DIGI"}
 
-	trueResult := ipinfo.BelongsToISP("DIGI")
-	falseResult := ipinfo.BelongsToISP("TELEFONICA")
+	trueResult := ipinfo.BelongsToISP(ISPMatcher{Name: "DIGI"})
+	falseResult := ipinfo.BelongsToISP(ISPMatcher{Name: "TELEFONICA"})
 
 	if trueResult == false {
 		t.Errorf("IP info with DIGI OrgName should return true when BelongsToISP is called ith \"DIGI\" ISP value")
@@ -20,3 +20,36 @@ func TestBelongsToISP(t *testing.T) {
 	}
 
 }
+
+func TestSetAddressByFamily(t *testing.T) {
+	var ipinfo IPInfo
+
+	if err := ipinfo.SetAddress("2001:db8::1"); err != nil {
+		t.Fatalf("SetAddress should accept an IPv6 address: %v", err)
+	}
+	if err := ipinfo.SetAddress("1.1.1.1"); err != nil {
+		t.Fatalf("SetAddress should accept an IPv4 address: %v", err)
+	}
+
+	if ipinfo.Address(IPv4) != "1.1.1.1" {
+		t.Errorf("IPv4 address should be \"1.1.1.1\" but it was \"%s\"", ipinfo.Address(IPv4))
+	}
+	if ipinfo.Address(IPv6) != "2001:db8::1" {
+		t.Errorf("IPv6 address should be \"2001:db8::1\" but it was \"%s\"", ipinfo.Address(IPv6))
+	}
+
+	if err := ipinfo.SetAddress("not an ip"); err == nil {
+		t.Errorf("SetAddress should fail with an invalid address")
+	}
+}
+
+func TestParseIPFamily(t *testing.T) {
+	family, err := ParseIPFamily(" IPv6 ")
+	if err != nil || family != IPv6 {
+		t.Errorf("ParseIPFamily(\" IPv6 \") should return ipv6, got \"%s\" (%v)", family, err)
+	}
+
+	if _, err := ParseIPFamily("ipx"); err == nil {
+		t.Errorf("ParseIPFamily should fail with an unknown family")
+	}
+}
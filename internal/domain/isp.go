@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ISPMatcher describes the expected (main) ISP. An IPInfo belongs to it when
+// any of the configured criteria matches, so a rebranded ISP can still be
+// recognised by its ASN or by the ranges it owns.
+type ISPMatcher struct {
+	Name       string         // Label used in notifications, also matched as an alias
+	ASNs       []uint32       // Autonomous system numbers of the ISP
+	Prefixes   []netip.Prefix // Address ranges owned by the ISP
+	Aliases    []string       // Other names of the ISP, matched case-insensitively
+	OrgPattern *regexp.Regexp // Pattern matched against the full organization name
+}
+
+// Matches reports whether ipinfo belongs to the ISP. It checks, in order, the
+// ASN, the owned prefixes, the aliases (Name included) and the organization
+// pattern. An alias matches when the organization name is the alias itself or
+// starts with it followed by a space, so "DIGI" matches "DIGI SPAIN TELECOM S.L.".
+func (matcher ISPMatcher) Matches(ipinfo IPInfo) bool {
+
+	if ipinfo.ASN != 0 {
+		for _, asn := range matcher.ASNs {
+			if asn == ipinfo.ASN {
+				return true
+			}
+		}
+	}
+
+	for _, address := range ipinfo.Addresses() {
+		addr, parseErr := netip.ParseAddr(address)
+		if parseErr != nil {
+			continue
+		}
+		for _, prefix := range matcher.Prefixes {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+	}
+
+	orgName := strings.ToLower(strings.TrimSpace(ipinfo.OrgName))
+	if orgName != "" {
+		for _, alias := range append([]string{matcher.Name}, matcher.Aliases...) {
+			alias = strings.ToLower(strings.TrimSpace(alias))
+			if alias == "" {
+				continue
+			}
+			if orgName == alias || strings.HasPrefix(orgName, alias+" ") {
+				return true
+			}
+		}
+
+		if matcher.OrgPattern != nil && matcher.OrgPattern.MatchString(ipinfo.OrgName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseASN parses an autonomous system number written either as "AS3352"
+// (case-insensitive prefix) or as a plain number.
+func ParseASN(value string) (uint32, error) {
+	trimmed := strings.TrimSpace(value)
+	if len(trimmed) > 2 && strings.EqualFold(trimmed[:2], "AS") {
+		trimmed = trimmed[2:]
+	}
+	asn, parseErr := strconv.ParseUint(trimmed, 10, 32)
+	if parseErr != nil || asn == 0 {
+		return 0, fmt.Errorf("invalid ASN \"%s\"", value)
+	}
+	return uint32(asn), nil
+}
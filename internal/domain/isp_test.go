@@ -0,0 +1,71 @@
+//go:build integration_tests || unit_tests || domain_tests || domain_unit_tests
+
+package domain
+
+import (
+	"net/netip"
+	"regexp"
+	"testing"
+)
+
+func TestISPMatcherByAlias(t *testing.T) {
+	ipinfo := IPInfo{IPv4: "79.12.12.12", ASN: 3352, OrgName: "Telefonica de Espana"}
+
+	if !(ISPMatcher{Name: "Movistar", Aliases: []string{"TELEFONICA"}}).Matches(ipinfo) {
+		t.Errorf("An alias should match the beginning of the organization name case-insensitively")
+	}
+	if !(ISPMatcher{Name: "telefonica de espana"}).Matches(ipinfo) {
+		t.Errorf("The ISP name should match the full organization name case-insensitively")
+	}
+	if (ISPMatcher{Name: "Tele"}).Matches(ipinfo) {
+		t.Errorf("An alias should not match a partial word of the organization name")
+	}
+}
+
+func TestISPMatcherByASN(t *testing.T) {
+	ipinfo := IPInfo{IPv4: "79.12.12.12", ASN: 3352, OrgName: "Movistar"}
+
+	if !(ISPMatcher{Name: "Telefonica", ASNs: []uint32{12479, 3352}}).Matches(ipinfo) {
+		t.Errorf("A rebranded ISP should still match by ASN")
+	}
+	if (ISPMatcher{Name: "Telefonica", ASNs: []uint32{12479}}).Matches(ipinfo) {
+		t.Errorf("A different ASN should not match")
+	}
+}
+
+func TestISPMatcherByPrefix(t *testing.T) {
+	ipinfo := IPInfo{IPv4: "79.12.12.12", IPv6: "2a0c:5a80::1"}
+	matcher := ISPMatcher{Name: "DIGI", Prefixes: []netip.Prefix{netip.MustParsePrefix("2a0c:5a80::/29")}}
+
+	if !matcher.Matches(ipinfo) {
+		t.Errorf("An owned IPv6 prefix should match even without organization data")
+	}
+	if matcher.Matches(IPInfo{IPv4: "79.12.12.12"}) {
+		t.Errorf("An address outside the owned prefixes should not match")
+	}
+}
+
+func TestISPMatcherByOrgPattern(t *testing.T) {
+	matcher := ISPMatcher{Name: "DIGI", OrgPattern: regexp.MustCompile(`(?i)digi\s+spain`)}
+
+	if !matcher.Matches(IPInfo{IPv4: "79.12.12.12", OrgName: "AS57269 Digi Spain Telecom S.L."}) {
+		t.Errorf("The organization pattern should match")
+	}
+	if matcher.Matches(IPInfo{IPv4: "79.12.12.12", OrgName: "Orange Espagne SA"}) {
+		t.Errorf("The organization pattern should not match another ISP")
+	}
+}
+
+func TestParseASN(t *testing.T) {
+	for _, value := range []string{"AS3352", "as3352", "3352", " AS3352 "} {
+		asn, err := ParseASN(value)
+		if err != nil || asn != 3352 {
+			t.Errorf("ParseASN(\"%s\") should return 3352, got %d (%v)", value, asn, err)
+		}
+	}
+	for _, value := range []string{"", "AS", "ASX", "0", "-1", "AS99999999999"} {
+		if _, err := ParseASN(value); err == nil {
+			t.Errorf("ParseASN(\"%s\") should fail", value)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NameserverLagError is returned by a DNSResolver that asks every
+// authoritative nameserver of the zone when they do not give the same answer.
+// Lagging lists the nameservers still serving an older zone serial than the
+// others: they have not loaded the latest change yet, so their answers are
+// expected to converge without another update. When Lagging is empty the
+// nameservers disagree on the same serial. Unreachable lists the nameservers
+// that could not be asked, left out of Answers.
+type NameserverLagError struct {
+	Domain      string
+	Answers     map[string]string // Comma separated addresses of each nameserver, empty when it has no record
+	Lagging     []string
+	Unreachable []string
+	Refresh     time.Duration // SOA refresh interval of the latest zone, within which lagging nameservers should catch up
+}
+
+func (err *NameserverLagError) Error() string {
+	if len(err.Lagging) > 0 {
+		return fmt.Sprintf("nameservers %s of %s lag behind the latest zone serial", strings.Join(err.Lagging, ", "), err.Domain)
+	}
+
+	var answers []string
+	for nameserver, answer := range err.Answers {
+		answers = append(answers, fmt.Sprintf("%s: %s", nameserver, cmp.Or(answer, "no record")))
+	}
+	slices.Sort(answers)
+	return fmt.Sprintf("authoritative nameservers of %s disagree on the same zone serial (%s)", err.Domain, strings.Join(answers, ", "))
+}
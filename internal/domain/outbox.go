@@ -0,0 +1,10 @@
+package domain
+
+// OutboxMessage is an event waiting in the IPStore outbox to be sent to Queue.
+// It is stored together with the IP change it announces and removed once
+// delivered, so a message may be delivered more than once after a failure;
+// Event.ID is the idempotency key consumers use to discard the duplicates.
+type OutboxMessage struct {
+	Queue string
+	Event Event
+}
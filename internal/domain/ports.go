@@ -5,13 +5,29 @@ import "context"
 type IPInfoProvider interface {
 	GetIPInfo(ctx context.Context) (IPInfo, error)
 }
+type IPInfoEnricher interface {
+	Enrich(ctx context.Context, ipinfo IPInfo) (IPInfo, error)
+}
 type DNSResolver interface {
-	Resolve(ctx context.Context, domain string) (string, error)
+	Resolve(ctx context.Context, domain string, family IPFamily) ([]DNSRecord, error)
 }
 type IPStore interface {
-	StoredIP(ctx context.Context) (ip string, found bool, err error)
-	SaveIP(ctx context.Context, ip string) error
+	StoredIP(ctx context.Context, family IPFamily) (ip string, found bool, err error)
+	SaveIP(ctx context.Context, change IPChange, messages []OutboxMessage) error
+	PendingMessages(ctx context.Context) ([]OutboxMessage, error)
+	AckMessage(ctx context.Context, eventID string) error
+	AlertState(ctx context.Context, alert string) (state AlertState, found bool, err error)
+	SaveAlertState(ctx context.Context, alert string, state AlertState) error
+	Propagation(ctx context.Context, family IPFamily) (propagation Propagation, found bool, err error)
+	SavePropagation(ctx context.Context, family IPFamily, propagation Propagation) error
+}
+type BreakerStore interface {
+	BreakerState(ctx context.Context, breaker string) (state BreakerState, found bool, err error)
+	SaveBreakerState(ctx context.Context, breaker string, state BreakerState) error
+}
+type IPHistory interface {
+	History(ctx context.Context) ([]IPChange, error)
 }
 type Notifier interface {
-	Notify(ctx context.Context, queue string, message []byte) error
+	Notify(ctx context.Context, queue string, event Event) error
 }
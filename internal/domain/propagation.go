@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+// Propagation is the window after an update of the domain records during
+// which resolvers may still answer the previous address from their caches. It
+// starts when Address was published, at Since, and lasts as long as the
+// records may be cached: TTL, the TTL of the records when the update was
+// made, or unknown (zero) when no DNS answer had been read then.
+type Propagation struct {
+	Address string
+	Since   time.Time
+	TTL     time.Duration
+}
+
+// Covers reports whether records, answered at now while Address is the
+// current one, may still be cached copies from before the update. The window
+// lasts the longest of TTL and the TTL of records.
+func (propagation Propagation) Covers(address string, records []DNSRecord, now time.Time) bool {
+	if propagation.Address != address {
+		return false
+	}
+	ttl := propagation.TTL
+	for _, record := range records {
+		ttl = max(ttl, record.TTL)
+	}
+	return now.Before(propagation.Since.Add(ttl))
+}
@@ -0,0 +1,33 @@
+//go:build integration_tests || unit_tests || domain_tests || domain_unit_tests
+
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPropagationCovers(t *testing.T) {
+
+	since := time.Date(2026, 6, 18, 12, 0, 0, 0, time.UTC)
+	propagation := Propagation{Address: "79.116.1.2", Since: since, TTL: 5 * time.Minute}
+	records := []DNSRecord{{Address: "79.116.1.1", Type: "A", TTL: 10 * time.Minute}}
+
+	for _, test := range []struct {
+		address string
+		records []DNSRecord
+		now     time.Time
+		covers  bool
+	}{
+		{"79.116.1.2", nil, since.Add(4 * time.Minute), true},
+		{"79.116.1.2", nil, since.Add(5 * time.Minute), false},
+		{"79.116.1.2", records, since.Add(9 * time.Minute), true},
+		{"79.116.1.2", records, since.Add(11 * time.Minute), false},
+		{"79.116.1.3", records, since.Add(time.Minute), false},
+	} {
+		if covers := propagation.Covers(test.address, test.records, test.now); covers != test.covers {
+			t.Errorf("Covers(%s, %+v) %s after the update should be %t", test.address, test.records, test.now.Sub(since), test.covers)
+		}
+	}
+
+}
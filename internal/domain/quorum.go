@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProviderAnswer is the address a single public IP provider reported for one
+// family. Address is empty when the provider failed or did not report one.
+type ProviderAnswer struct {
+	Provider string
+	Address  string
+}
+
+// DisagreementError is returned by an IPInfoProvider that asks several sources
+// when not enough of them agree on the address of a family. It is a distinct
+// error so the use case can report it instead of acting on an untrusted answer.
+type DisagreementError struct {
+	Family  IPFamily
+	Quorum  int
+	Answers []ProviderAnswer
+}
+
+func (err *DisagreementError) Error() string {
+	return fmt.Sprintf("public IP providers do not agree on the %s address, %d matching answers are required: %s", err.Family, err.Quorum, err.Summary())
+}
+
+// Summary lists every answer as provider=address, in the order the providers
+// were configured.
+func (err *DisagreementError) Summary() string {
+	answers := make([]string, 0, len(err.Answers))
+	for _, answer := range err.Answers {
+		address := answer.Address
+		if address == "" {
+			address = "none"
+		}
+		answers = append(answers, answer.Provider+"="+address)
+	}
+	return strings.Join(answers, ", ")
+}
@@ -0,0 +1,23 @@
+//go:build integration_tests || unit_tests || domain_tests || domain_unit_tests
+
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDisagreementError(t *testing.T) {
+	var err error = &DisagreementError{Family: IPv4, Quorum: 2, Answers: []ProviderAnswer{{Provider: "ipinfo", Address: "1.1.1.1"}, {Provider: "stun", Address: "2.2.2.2"}, {Provider: "dns"}}}
+
+	expected := "ipinfo=1.1.1.1, stun=2.2.2.2, dns=none"
+
+	var disagreement *DisagreementError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &disagreement) {
+		t.Fatalf("A wrapped DisagreementError should be found by errors.As")
+	}
+	if disagreement.Summary() != expected {
+		t.Errorf("Summary should be \"%s\" but it was \"%s\"", expected, disagreement.Summary())
+	}
+}
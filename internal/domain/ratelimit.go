@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitError is returned by an IPInfoProvider whose service refused the
+// request because the request quota is exhausted. It is not an outage: asking
+// again before RetryAfter only burns more quota, so the daemon waits at least
+// that long. A zero RetryAfter means the service did not tell.
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (err *RateLimitError) Error() string {
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("%s request quota is exhausted, retry after %s", err.Provider, err.RetryAfter)
+	}
+	return fmt.Sprintf("%s request quota is exhausted", err.Provider)
+}
+
+// UnavailableError is returned by an IPInfoProvider whose service could not be
+// reached or failed on its side (a transport error or a 5xx status code).
+type UnavailableError struct {
+	Provider string
+	Err      error
+}
+
+func (err *UnavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable: %v", err.Provider, err.Err)
+}
+
+func (err *UnavailableError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,107 @@
+package asndb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// record is the ASN and organization announcing an address.
+type record struct {
+	asn     uint32
+	orgName string
+}
+
+// table is a loaded database format.
+type table interface {
+	lookup(address netip.Addr) (record, bool, error)
+}
+
+// Database maps addresses to the ASN and organization announcing them, from a
+// local file, so no network call is needed. It implements
+// domain.IPInfoEnricher.
+//
+// Two formats are read: the iptoasn.com TSV dump (ip2asn-combined.tsv, plain
+// or gzipped) and MaxMind GeoLite2-ASN MMDB files. The format is detected from
+// the file contents. The whole file is loaded by Open and never reloaded, so
+// restart the monitor after updating it.
+type Database struct {
+	path  string
+	table table
+}
+
+// Open loads the database file at path.
+func Open(path string) (*Database, error) {
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, fmt.Errorf("ASN database cannot be read: %w", readErr)
+	}
+
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		reader, gzipErr := gzip.NewReader(bytes.NewReader(content))
+		if gzipErr != nil {
+			return nil, fmt.Errorf("ASN database %s cannot be decompressed: %w", path, gzipErr)
+		}
+		content, readErr = io.ReadAll(reader)
+		if readErr != nil {
+			return nil, fmt.Errorf("ASN database %s cannot be decompressed: %w", path, readErr)
+		}
+	}
+
+	var database table
+	var loadErr error
+	if bytes.Contains(content, metadataMarker) {
+		database, loadErr = newMMDB(content)
+	} else {
+		database, loadErr = newTSV(content)
+	}
+	if loadErr != nil {
+		return nil, fmt.Errorf("ASN database %s cannot be loaded: %w", path, loadErr)
+	}
+
+	return &Database{path: path, table: database}, nil
+}
+
+// Lookup returns the ASN and organization announcing address. found is false
+// when the address is not announced.
+func (database *Database) Lookup(address netip.Addr) (asn uint32, orgName string, found bool, err error) {
+	entry, found, lookupErr := database.table.lookup(address.Unmap())
+	return entry.asn, entry.orgName, found, lookupErr
+}
+
+// Enrich fills the ASN and OrgName of ipinfo with those of its first address
+// found in the database, IPv4 first.
+func (database *Database) Enrich(ctx context.Context, ipinfo domain.IPInfo) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "asndb.Enrich")
+
+	for _, ip := range ipinfo.Addresses() {
+		address, parseErr := netip.ParseAddr(ip)
+		if parseErr != nil {
+			return ipinfo, parseErr
+		}
+		asn, orgName, found, lookupErr := database.Lookup(address)
+		if lookupErr != nil {
+			log.ErrorContext(ctx, "Error looking up address in ASN database", "path", database.path, "ip", ip, "error", lookupErr)
+			return ipinfo, lookupErr
+		}
+		if !found {
+			log.DebugContext(ctx, "Address not found in ASN database", "path", database.path, "ip", ip)
+			continue
+		}
+		log.DebugContext(ctx, "Address found in ASN database", "ip", ip, "asn", asn, "orgName", orgName)
+		ipinfo.ASN = asn
+		ipinfo.OrgName = orgName
+		return ipinfo, nil
+	}
+
+	return ipinfo, fmt.Errorf("no address of %v is in the ASN database %s", ipinfo.Addresses(), database.path)
+}
@@ -0,0 +1,248 @@
+//go:build integration_tests || unit_tests || asndb_tests || asndb_unit_tests
+
+package asndb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+const tsvDump = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"10.0.0.0\t10.255.255.255\t0\tNone\tNot routed\n" +
+	"79.12.0.0\t79.12.255.255\t57269\tES\tDIGI SPAIN TELECOM S.L.\n" +
+	"2001:db8::\t2001:db8:ffff:ffff:ffff:ffff:ffff:ffff\t64500\tES\tEXAMPLE-NET\n"
+
+// writeDatabase writes content to a file of a temporary directory.
+func writeDatabase(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "asn.db")
+	if writeErr := os.WriteFile(path, content, 0o600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	return path
+}
+
+// mmdbPrefix is a network of a test MaxMind DB and the data record it points to.
+type mmdbPrefix struct {
+	prefix netip.Prefix
+	data   []byte
+}
+
+// mmdbControl encodes the control bytes of a value of fieldType and size,
+// sizes up to 284.
+func mmdbControl(fieldType byte, size int) []byte {
+	var control []byte
+	if fieldType > 7 {
+		control = []byte{0, fieldType - 7}
+	} else {
+		control = []byte{fieldType << 5}
+	}
+	if size >= 29 {
+		control[0] |= 29
+		return append(control, byte(size-29))
+	}
+	control[0] |= byte(size)
+	return control
+}
+
+func mmdbEncodeString(value string) []byte {
+	return append(mmdbControl(mmdbString, len(value)), value...)
+}
+
+func mmdbEncodeUint(fieldType byte, value uint32) []byte {
+	var payload []byte
+	for ; value > 0; value >>= 8 {
+		payload = append([]byte{byte(value)}, payload...)
+	}
+	return append(mmdbControl(fieldType, len(payload)), payload...)
+}
+
+// buildMMDB builds an IPv6 MaxMind DB with 24 bit records holding prefixes;
+// IPv4 prefixes are stored under ::/96.
+func buildMMDB(prefixes []mmdbPrefix) []byte {
+
+	// Records are node indexes, -1 when empty and -2-i for the data of prefixes[i].
+	nodes := [][2]int{{-1, -1}}
+	for index, entry := range prefixes {
+		raw := entry.prefix.Addr().As16()
+		bits := entry.prefix.Bits()
+		if entry.prefix.Addr().Is4() {
+			raw = [16]byte{}
+			v4 := entry.prefix.Addr().As4()
+			copy(raw[12:], v4[:])
+			bits += 96
+		}
+		node := 0
+		for bit := range bits {
+			side := int(raw[bit/8]>>(7-bit%8)) & 1
+			if bit == bits-1 {
+				nodes[node][side] = -2 - index
+				break
+			}
+			if nodes[node][side] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][side] = len(nodes) - 1
+			}
+			node = nodes[node][side]
+		}
+	}
+
+	var data []byte
+	offsets := make([]int, len(prefixes))
+	for index, entry := range prefixes {
+		offsets[index] = len(data)
+		data = append(data, entry.data...)
+	}
+
+	var content []byte
+	for _, node := range nodes {
+		for _, value := range node {
+			switch {
+			case value == -1:
+				value = len(nodes)
+			case value < -1:
+				value = len(nodes) + dataSectionSeparator + offsets[-2-value]
+			}
+			content = append(content, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	content = append(content, make([]byte, dataSectionSeparator)...)
+	content = append(content, data...)
+	content = append(content, metadataMarker...)
+	content = append(content, mmdbControl(mmdbMap, 4)...)
+	content = append(content, mmdbEncodeString("node_count")...)
+	content = append(content, mmdbEncodeUint(mmdbUint32, uint32(len(nodes)))...)
+	content = append(content, mmdbEncodeString("record_size")...)
+	content = append(content, mmdbEncodeUint(mmdbUint16, 24)...)
+	content = append(content, mmdbEncodeString("ip_version")...)
+	content = append(content, mmdbEncodeUint(mmdbUint16, 6)...)
+	content = append(content, mmdbEncodeString("database_type")...)
+	content = append(content, mmdbEncodeString("GeoLite2-ASN")...)
+	return content
+}
+
+func TestOpenTSV(t *testing.T) {
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(tsvDump))
+	writer.Close()
+
+	for _, content := range [][]byte{[]byte(tsvDump), compressed.Bytes()} {
+
+		database, openErr := Open(writeDatabase(t, content))
+		if openErr != nil {
+			t.Fatalf("TestOpenTSV should not fail: %v", openErr)
+		}
+
+		for _, test := range []struct {
+			address string
+			asn     uint32
+			orgName string
+			found   bool
+		}{
+			{"79.12.12.12", 57269, "DIGI SPAIN TELECOM S.L.", true},
+			{"::ffff:79.12.0.1", 57269, "DIGI SPAIN TELECOM S.L.", true},
+			{"1.0.0.1", 13335, "CLOUDFLARENET", true},
+			{"2001:db8::7", 64500, "EXAMPLE-NET", true},
+			{"10.1.1.1", 0, "", false},
+			{"0.0.0.1", 0, "", false},
+			{"79.13.0.1", 0, "", false},
+			{"2001:db9::1", 0, "", false},
+		} {
+			asn, orgName, found, lookupErr := database.Lookup(netip.MustParseAddr(test.address))
+			if lookupErr != nil || asn != test.asn || orgName != test.orgName || found != test.found {
+				t.Errorf("Lookup of %s should return %d %q %t, got %d %q %t (%v)", test.address, test.asn, test.orgName, test.found, asn, orgName, found, lookupErr)
+			}
+		}
+	}
+
+}
+
+func TestOpenMMDB(t *testing.T) {
+
+	digi := append(mmdbControl(mmdbMap, 2), mmdbEncodeString("autonomous_system_number")...)
+	digi = append(digi, mmdbEncodeUint(mmdbUint32, 57269)...)
+	digi = append(digi, mmdbEncodeString("autonomous_system_organization")...)
+	digi = append(digi, mmdbEncodeString("DIGI SPAIN TELECOM S.L.")...)
+
+	// The second record points back to the keys of the first one.
+	example := append(mmdbControl(mmdbMap, 2), mmdbControl(mmdbPointer, 0)...)
+	example = append(example, 1)
+	example = append(example, mmdbEncodeUint(mmdbUint32, 64500)...)
+	example = append(example, mmdbControl(mmdbPointer, 0)...)
+	example = append(example, byte(1+len(mmdbEncodeString("autonomous_system_number"))+len(mmdbEncodeUint(mmdbUint32, 57269))))
+	example = append(example, mmdbEncodeString("EXAMPLE-NET")...)
+
+	database, openErr := Open(writeDatabase(t, buildMMDB([]mmdbPrefix{
+		{netip.MustParsePrefix("79.12.0.0/16"), digi},
+		{netip.MustParsePrefix("2001:db8::/32"), example},
+	})))
+	if openErr != nil {
+		t.Fatalf("TestOpenMMDB should not fail: %v", openErr)
+	}
+
+	for _, test := range []struct {
+		address string
+		asn     uint32
+		orgName string
+		found   bool
+	}{
+		{"79.12.12.12", 57269, "DIGI SPAIN TELECOM S.L.", true},
+		{"2001:db8::7", 64500, "EXAMPLE-NET", true},
+		{"79.13.0.1", 0, "", false},
+		{"2001:db9::1", 0, "", false},
+	} {
+		asn, orgName, found, lookupErr := database.Lookup(netip.MustParseAddr(test.address))
+		if lookupErr != nil || asn != test.asn || orgName != test.orgName || found != test.found {
+			t.Errorf("Lookup of %s should return %d %q %t, got %d %q %t (%v)", test.address, test.asn, test.orgName, test.found, asn, orgName, found, lookupErr)
+		}
+	}
+
+}
+
+func TestOpenInvalid(t *testing.T) {
+
+	if _, openErr := Open(filepath.Join(t.TempDir(), "missing.tsv")); openErr == nil {
+		t.Errorf("Open should fail with a missing file")
+	}
+
+	for _, content := range []string{
+		"",
+		"79.12.0.0\t79.12.255.255\t57269\n",
+		"79.12.0.0\t2001:db8::\t57269\tES\tDIGI SPAIN TELECOM S.L.\n",
+		"10.0.0.0\t10.255.255.255\t0\tNone\tNot routed\n",
+		"\x1f\x8bnot gzip",
+		"\xab\xcd\xefMaxMind.com\xe0",
+	} {
+		if _, openErr := Open(writeDatabase(t, []byte(content))); openErr == nil {
+			t.Errorf("Open should fail with %q", content)
+		}
+	}
+
+}
+
+func TestEnrich(t *testing.T) {
+
+	database, openErr := Open(writeDatabase(t, []byte(tsvDump)))
+	if openErr != nil {
+		t.Fatalf("TestEnrich should not fail opening the database: %v", openErr)
+	}
+
+	ipinfo, enrichErr := database.Enrich(context.Background(), domain.IPInfo{IPv4: "10.1.1.1", IPv6: "2001:db8::7", Source: "interface"})
+	if enrichErr != nil || ipinfo != (domain.IPInfo{IPv4: "10.1.1.1", IPv6: "2001:db8::7", ASN: 64500, OrgName: "EXAMPLE-NET", Source: "interface"}) {
+		t.Errorf("Enrich should fill the organization of the first address found, got %+v (%v)", ipinfo, enrichErr)
+	}
+
+	if ipinfo, enrichErr := database.Enrich(context.Background(), domain.IPInfo{IPv4: "79.13.0.1"}); enrichErr == nil {
+		t.Errorf("Enrich should fail when no address is found, got %+v", ipinfo)
+	}
+
+}
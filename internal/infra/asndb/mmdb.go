@@ -0,0 +1,331 @@
+package asndb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// MaxMind DB data section field types.
+const (
+	mmdbExtended  = 0
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEndMarker = 13
+	mmdbBoolean   = 14
+	mmdbFloat     = 15
+)
+
+// dataSectionSeparator is the number of zero bytes between the search tree and
+// the data section.
+const dataSectionSeparator = 16
+
+// mmdbTable is a MaxMind DB file (https://maxmind.github.io/MaxMind-DB/): a
+// binary search tree over the address bits whose leaves point into a data
+// section of typed values.
+type mmdbTable struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // Node reached after the 96 zero bits of ::/96 in IPv6 trees
+}
+
+// newMMDB reads the metadata of a MaxMind DB file and checks its tree.
+func newMMDB(content []byte) (*mmdbTable, error) {
+
+	markerIndex := bytes.LastIndex(content, metadataMarker)
+	metadataStart := markerIndex + len(metadataMarker)
+	metadata, _, decodeErr := decoder{data: content[metadataStart:]}.decode(0)
+	if decodeErr != nil {
+		return nil, fmt.Errorf("metadata cannot be decoded: %w", decodeErr)
+	}
+	fields, isMap := metadata.(map[string]any)
+	if !isMap {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	table := &mmdbTable{
+		nodeCount:  uintField(fields, "node_count"),
+		recordSize: uintField(fields, "record_size"),
+		ipVersion:  uintField(fields, "ip_version"),
+	}
+	if table.recordSize != 24 && table.recordSize != 28 && table.recordSize != 32 {
+		return nil, fmt.Errorf("record size %d is not supported", table.recordSize)
+	}
+	if table.ipVersion != 4 && table.ipVersion != 6 {
+		return nil, fmt.Errorf("IP version %d is not supported", table.ipVersion)
+	}
+
+	treeSize := table.nodeCount * table.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(markerIndex) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	table.tree = content[:treeSize]
+	table.data = content[treeSize+dataSectionSeparator : markerIndex]
+
+	// IPv4 addresses live under ::/96 in IPv6 trees.
+	if table.ipVersion == 6 {
+		for bit := 0; bit < 96 && table.ipv4Start < table.nodeCount; bit++ {
+			table.ipv4Start = table.record(table.ipv4Start, 0)
+		}
+	}
+	return table, nil
+}
+
+// uintField returns the unsigned field name of fields, zero when missing.
+func uintField(fields map[string]any, name string) uint {
+	value, _ := fields[name].(uint64)
+	return uint(value)
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (table *mmdbTable) record(node uint, bit uint) uint {
+	offset := node * table.recordSize / 4
+	switch table.recordSize {
+	case 24:
+		offset += bit * 3
+		return uint(table.tree[offset])<<16 | uint(table.tree[offset+1])<<8 | uint(table.tree[offset+2])
+	case 28:
+		if bit == 0 {
+			return uint(table.tree[offset+3]&0xf0)<<20 | uint(table.tree[offset])<<16 | uint(table.tree[offset+1])<<8 | uint(table.tree[offset+2])
+		}
+		return uint(table.tree[offset+3]&0x0f)<<24 | uint(table.tree[offset+4])<<16 | uint(table.tree[offset+5])<<8 | uint(table.tree[offset+6])
+	}
+	offset += bit * 4
+	return uint(binary.BigEndian.Uint32(table.tree[offset : offset+4]))
+}
+
+// lookup walks the tree along the bits of address and decodes the
+// autonomous_system_number and autonomous_system_organization of the data
+// record it ends on.
+func (table *mmdbTable) lookup(address netip.Addr) (record, bool, error) {
+
+	raw := address.AsSlice()
+	node := uint(0)
+	if address.Is4() && table.ipVersion == 6 {
+		node = table.ipv4Start
+	} else if address.Is6() && table.ipVersion == 4 {
+		return record{}, false, nil
+	}
+
+	for bit := 0; bit < len(raw)*8 && node < table.nodeCount; bit++ {
+		node = table.record(node, uint(raw[bit/8]>>(7-bit%8))&1)
+	}
+	switch {
+	case node == table.nodeCount:
+		return record{}, false, nil
+	case node < table.nodeCount:
+		return record{}, false, errors.New("search tree is deeper than the address")
+	}
+
+	offset := node - table.nodeCount - dataSectionSeparator
+	value, _, decodeErr := decoder{data: table.data}.decode(offset)
+	if decodeErr != nil {
+		return record{}, false, decodeErr
+	}
+	fields, isMap := value.(map[string]any)
+	if !isMap {
+		return record{}, false, errors.New("data record is not a map")
+	}
+	asn := uintField(fields, "autonomous_system_number")
+	orgName, _ := fields["autonomous_system_organization"].(string)
+	if asn == 0 {
+		return record{}, false, nil
+	}
+	return record{asn: uint32(asn), orgName: orgName}, true, nil
+}
+
+// decoder decodes the values of a MaxMind DB data section. Unsigned integers
+// of any size are returned as uint64 (uint128 values larger than that are not
+// needed and fail), maps as map[string]any and arrays as []any.
+type decoder struct {
+	data []byte
+}
+
+// decode decodes the value at offset and returns it with the offset of the
+// next value.
+func (decoder decoder) decode(offset uint) (any, uint, error) {
+
+	fieldType, size, offset, controlErr := decoder.control(offset)
+	if controlErr != nil {
+		return nil, 0, controlErr
+	}
+
+	if fieldType == mmdbPointer {
+		pointer, next, pointerErr := decoder.pointer(size, offset)
+		if pointerErr != nil {
+			return nil, 0, pointerErr
+		}
+		value, _, valueErr := decoder.decode(pointer)
+		return value, next, valueErr
+	}
+
+	switch fieldType {
+	case mmdbMap:
+		fields := make(map[string]any, size)
+		for range size {
+			key, next, keyErr := decoder.decode(offset)
+			if keyErr != nil {
+				return nil, 0, keyErr
+			}
+			name, isString := key.(string)
+			if !isString {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, valueErr := decoder.decode(next)
+			if valueErr != nil {
+				return nil, 0, valueErr
+			}
+			fields[name] = value
+			offset = next
+		}
+		return fields, offset, nil
+
+	case mmdbArray:
+		values := make([]any, 0, size)
+		for range size {
+			value, next, valueErr := decoder.decode(offset)
+			if valueErr != nil {
+				return nil, 0, valueErr
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+
+	case mmdbBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(decoder.data)) {
+		return nil, 0, errors.New("value runs past the end of the data section")
+	}
+	payload := decoder.data[offset : offset+size]
+	next := offset + size
+
+	switch fieldType {
+	case mmdbString:
+		return string(payload), next, nil
+	case mmdbBytes:
+		return payload, next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double is not 8 bytes long")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float is not 4 bytes long")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(payload)), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128, mmdbInt32:
+		if size > 8 {
+			if fieldType == mmdbUint128 && bytes.Count(payload[:size-8], []byte{0}) == int(size-8) {
+				payload = payload[size-8:]
+			} else {
+				return nil, 0, errors.New("integer is too large")
+			}
+		}
+		var value uint64
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		if fieldType == mmdbInt32 {
+			return int64(int32(value)), next, nil
+		}
+		return value, next, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown field type %d", fieldType)
+}
+
+// control reads the control byte(s) at offset and returns the field type, its
+// payload size and the offset of the payload.
+func (decoder decoder) control(offset uint) (uint, uint, uint, error) {
+
+	if offset >= uint(len(decoder.data)) {
+		return 0, 0, 0, errors.New("offset is past the end of the data section")
+	}
+	control := decoder.data[offset]
+	offset++
+
+	fieldType := uint(control >> 5)
+	if fieldType == mmdbExtended {
+		if offset >= uint(len(decoder.data)) {
+			return 0, 0, 0, errors.New("extended type is past the end of the data section")
+		}
+		fieldType = 7 + uint(decoder.data[offset])
+		offset++
+	}
+
+	size := uint(control & 0x1f)
+	if fieldType == mmdbPointer {
+		return fieldType, size, offset, nil
+	}
+
+	// Sizes from 29 on are followed by 1, 2 or 3 extra bytes.
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(decoder.data)) {
+			return 0, 0, 0, errors.New("size is past the end of the data section")
+		}
+		var value uint
+		for _, b := range decoder.data[offset : offset+extra] {
+			value = value<<8 | uint(b)
+		}
+		offset += extra
+		switch extra {
+		case 1:
+			size = 29 + value
+		case 2:
+			size = 285 + value
+		case 3:
+			size = 65821 + value
+		}
+	}
+	return fieldType, size, offset, nil
+}
+
+// pointer decodes the pointer whose control byte low bits are bits and whose
+// extra bytes start at offset. It returns the data section offset pointed to
+// and the offset following the pointer.
+func (decoder decoder) pointer(bits uint, offset uint) (uint, uint, error) {
+
+	length := (bits>>3)&0x3 + 1
+	if offset+length > uint(len(decoder.data)) {
+		return 0, 0, errors.New("pointer is past the end of the data section")
+	}
+	var value uint
+	for _, b := range decoder.data[offset : offset+length] {
+		value = value<<8 | uint(b)
+	}
+
+	switch length {
+	case 1:
+		value = (bits&0x7)<<8 | value
+	case 2:
+		value = ((bits&0x7)<<16 | value) + 2048
+	case 3:
+		value = ((bits&0x7)<<24 | value) + 526336
+	}
+	return value, offset + length, nil
+}
@@ -0,0 +1,84 @@
+package asndb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// asnRange is a line of the iptoasn TSV dump: the addresses from start to end
+// are announced by the ASN of record.
+type asnRange struct {
+	start  netip.Addr
+	end    netip.Addr
+	record record
+}
+
+// tsvTable is an iptoasn TSV dump, sorted by start address.
+type tsvTable []asnRange
+
+// newTSV parses an iptoasn TSV dump. Each line holds the range start, the
+// range end, the ASN, the country code and the AS description; ranges with
+// ASN 0 are not routed and are skipped.
+func newTSV(content []byte) (tsvTable, error) {
+
+	var table tsvTable
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("line %d has %d fields, 5 are expected", line, len(fields))
+		}
+		start, startErr := netip.ParseAddr(fields[0])
+		end, endErr := netip.ParseAddr(fields[1])
+		asn, asnErr := strconv.ParseUint(fields[2], 10, 32)
+		if startErr != nil || endErr != nil || asnErr != nil || start.Is4() != end.Is4() {
+			return nil, fmt.Errorf("line %d is not a \"start end asn country description\" range: %q", line, text)
+		}
+		if asn == 0 {
+			continue
+		}
+
+		table = append(table, asnRange{start: start, end: end, record: record{asn: uint32(asn), orgName: strings.TrimSpace(fields[4])}})
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		return nil, scanErr
+	}
+	if len(table) == 0 {
+		return nil, fmt.Errorf("no announced range found")
+	}
+
+	slices.SortFunc(table, func(a, b asnRange) int { return a.start.Compare(b.start) })
+	return table, nil
+}
+
+// lookup finds the last range starting at or before address and checks that
+// it also covers it.
+func (table tsvTable) lookup(address netip.Addr) (record, bool, error) {
+
+	index, _ := slices.BinarySearchFunc(table, address, func(candidate asnRange, target netip.Addr) int {
+		if candidate.start.Compare(target) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if index == 0 {
+		return record{}, false, nil
+	}
+	candidate := table[index-1]
+	if candidate.end.Compare(address) < 0 {
+		return record{}, false, nil
+	}
+	return candidate.record, true, nil
+}
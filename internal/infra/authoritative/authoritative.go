@@ -0,0 +1,279 @@
+package authoritative
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	dnswire "github.com/a-castellano/home-ip-monitor/internal/infra/dnswire"
+)
+
+// Resolver is the adapter of domain.DNSResolver that asks the authoritative
+// nameservers of the zone directly, so no cache can hide a recent change. It
+// finds the zone and its nameservers through the Recursive resolver
+// ("host:port"), then asks every nameserver, without recursion, for the
+// records and the zone serial. All of them must give the same records;
+// otherwise a *domain.NameserverLagError tells which ones lag behind. The
+// nameservers that cannot be resolved or asked are left out of the comparison
+// and reported as unreachable; Resolve only fails when none of them answers.
+//
+// Nameservers are asked on Port, "53" when empty.
+type Resolver struct {
+	Recursive string
+	Port      string
+}
+
+// nameserver is an authoritative nameserver of the zone.
+type nameserver struct {
+	name    string
+	address netip.Addr
+}
+
+// authoritativeAnswer is the answer of a nameserver: its zone serial and
+// refresh interval and the address records of the domain.
+type authoritativeAnswer struct {
+	serial  uint32
+	refresh time.Duration
+	records []domain.DNSRecord
+}
+
+// addresses returns the sorted addresses of the answer records.
+func (answer authoritativeAnswer) addresses() []string {
+	var addresses []string
+	for _, record := range answer.records {
+		addresses = append(addresses, record.Address)
+	}
+	slices.Sort(addresses)
+	return addresses
+}
+
+// Resolve resolves the given domain to the address records of the requested
+// family on every authoritative nameserver of its zone. It implements
+// domain.DNSResolver.
+func (resolver Resolver) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+
+	log := logger.FromContext(ctx).With("operation", "authoritative.Resolve")
+	domainName = strings.ToLower(strings.TrimSuffix(domainName, "."))
+
+	zone, zoneErr := resolver.zone(ctx, domainName)
+	if zoneErr != nil {
+		log.ErrorContext(ctx, "Error finding the zone of the domain", "domain", domainName, "error", zoneErr)
+		return nil, zoneErr
+	}
+	nameservers, unreachable, nameserversErr := resolver.nameservers(ctx, zone)
+	if nameserversErr != nil {
+		log.ErrorContext(ctx, "Error finding the nameservers of the zone", "zone", zone, "error", nameserversErr)
+		return nil, nameserversErr
+	}
+	log.DebugContext(ctx, "Asking the authoritative nameservers of the zone", "zone", zone, "nameservers", len(nameservers))
+
+	answers := make([]authoritativeAnswer, len(nameservers))
+	errs := make([]error, len(nameservers))
+	var wait sync.WaitGroup
+	for index, server := range nameservers {
+		wait.Go(func() {
+			answers[index], errs[index] = resolver.askAuthoritative(ctx, server, zone, domainName, family)
+		})
+	}
+	wait.Wait()
+
+	// A nameserver that cannot be asked says nothing about the zone, the
+	// others are compared without it.
+	var answered []nameserver
+	var answeredAnswers []authoritativeAnswer
+	for index, server := range nameservers {
+		if errs[index] != nil {
+			unreachable = append(unreachable, server.name)
+			continue
+		}
+		answered = append(answered, server)
+		answeredAnswers = append(answeredAnswers, answers[index])
+	}
+	if joinedErr := errors.Join(errs...); len(answered) == 0 {
+		log.ErrorContext(ctx, "Error asking the authoritative nameservers", "domain", domainName, "family", family, "error", joinedErr)
+		return nil, joinedErr
+	} else if joinedErr != nil {
+		log.WarnContext(ctx, "Some authoritative nameservers cannot be asked, comparing the others", "domain", domainName, "family", family, "unreachable", unreachable, "error", joinedErr)
+	}
+
+	if lagErr := compare(domainName, answered, answeredAnswers); lagErr != nil {
+		lagErr.Unreachable = unreachable
+		log.WarnContext(ctx, "Authoritative nameservers do not give the same answer", "domain", domainName, "family", family, "answers", lagErr.Answers, "lagging", lagErr.Lagging, "unreachable", unreachable)
+		return nil, lagErr
+	}
+
+	// No record is an answer too: the monitor republishes the missing one.
+	records := answeredAnswers[0].records
+	if len(records) == 0 {
+		log.WarnContext(ctx, "Domain has no record of the family", "domain", domainName, "family", family, "zone", zone)
+		return records, nil
+	}
+
+	log.InfoContext(ctx, "domain ip retrived", "domain", domainName, "family", family, "records", records, "zone", zone)
+	return records, nil
+}
+
+// compare returns a *domain.NameserverLagError when the nameservers do not
+// give the same answer, nil otherwise.
+func compare(domainName string, nameservers []nameserver, answers []authoritativeAnswer) *domain.NameserverLagError {
+
+	agree := true
+	latest := answers[0].serial
+	for _, answer := range answers[1:] {
+		agree = agree && slices.Equal(answer.addresses(), answers[0].addresses())
+		if serialBefore(latest, answer.serial) {
+			latest = answer.serial
+		}
+	}
+	if agree {
+		return nil
+	}
+
+	lagErr := &domain.NameserverLagError{Domain: domainName, Answers: map[string]string{}}
+	for index, answer := range answers {
+		lagErr.Answers[nameservers[index].name] = strings.Join(answer.addresses(), ",")
+		if serialBefore(answer.serial, latest) {
+			lagErr.Lagging = append(lagErr.Lagging, nameservers[index].name)
+		} else {
+			lagErr.Refresh = answer.refresh
+		}
+	}
+	return lagErr
+}
+
+// serialBefore reports whether zone serial a is older than b, using the
+// serial number arithmetic of RFC 1982 so serials may wrap around.
+func serialBefore(a uint32, b uint32) bool {
+	return a != b && int32(b-a) > 0
+}
+
+// zone finds the zone domainName belongs to: the owner of the SOA record the
+// recursive resolver returns for it, either as the answer at the zone apex or
+// in the authority section below it.
+func (resolver Resolver) zone(ctx context.Context, domainName string) (string, error) {
+
+	for candidate := domainName; candidate != ""; {
+		message, askErr := dnswire.Ask(ctx, resolver.Recursive, candidate, dnswire.TypeSOA, true)
+		if askErr != nil {
+			return "", askErr
+		}
+		if message.RCode != dnswire.RCodeSuccess && message.RCode != dnswire.RCodeNameError {
+			return "", message.Err(candidate, resolver.Recursive)
+		}
+		for _, record := range slices.Concat(message.Answers, message.Authority) {
+			if record.Type == dnswire.TypeSOA && (record.Name == candidate || strings.HasSuffix(candidate, "."+record.Name)) {
+				return record.Name, nil
+			}
+		}
+		// A CNAME answer carries no SOA record, go on with the parent.
+		_, candidate, _ = strings.Cut(candidate, ".")
+	}
+	return "", fmt.Errorf("no zone holding %s has been found", domainName)
+}
+
+// nameservers returns the authoritative nameservers of zone with the first
+// address the recursive resolver returns for each of them, IPv4 first, and
+// the names of the ones without an address. It only fails when no nameserver
+// has an address.
+func (resolver Resolver) nameservers(ctx context.Context, zone string) ([]nameserver, []string, error) {
+
+	log := logger.FromContext(ctx).With("operation", "authoritative.nameservers")
+
+	message, askErr := dnswire.Ask(ctx, resolver.Recursive, zone, dnswire.TypeNS, true)
+	if askErr == nil {
+		askErr = message.Err(zone, resolver.Recursive)
+	}
+	if askErr != nil {
+		return nil, nil, askErr
+	}
+
+	var nameservers []nameserver
+	var unresolved []string
+	var errs []error
+	for _, record := range message.Records(zone, dnswire.TypeNS) {
+		address, addressErr := resolver.address(ctx, record.Target)
+		if addressErr != nil {
+			log.WarnContext(ctx, "Error resolving a nameserver of the zone, leaving it out", "zone", zone, "nameserver", record.Target, "error", addressErr)
+			unresolved = append(unresolved, record.Target)
+			errs = append(errs, fmt.Errorf("nameserver %s: %w", record.Target, addressErr))
+			continue
+		}
+		nameservers = append(nameservers, nameserver{name: record.Target, address: address})
+	}
+	if len(nameservers) == 0 {
+		return nil, nil, cmp.Or(errors.Join(errs...), fmt.Errorf("zone %s has no nameserver", zone))
+	}
+	return nameservers, unresolved, nil
+}
+
+// address returns the first address of host, its IPv4 addresses first.
+func (resolver Resolver) address(ctx context.Context, host string) (netip.Addr, error) {
+
+	var errs []error
+	for _, recordType := range []uint16{dnswire.TypeA, dnswire.TypeAAAA} {
+		message, askErr := dnswire.Ask(ctx, resolver.Recursive, host, recordType, true)
+		if askErr == nil {
+			askErr = message.Err(host, resolver.Recursive)
+		}
+		if askErr != nil {
+			errs = append(errs, askErr)
+			continue
+		}
+		if records := message.Records(host, recordType); len(records) > 0 {
+			return records[0].Address, nil
+		}
+	}
+	return netip.Addr{}, cmp.Or(errors.Join(errs...), dnswire.NotFoundError(host, resolver.Recursive))
+}
+
+// askAuthoritative asks server, without recursion, for the zone serial and
+// for the address records of family of domainName.
+func (resolver Resolver) askAuthoritative(ctx context.Context, server nameserver, zone string, domainName string, family domain.IPFamily) (authoritativeAnswer, error) {
+
+	address := net.JoinHostPort(server.address.String(), cmp.Or(resolver.Port, "53"))
+
+	soa, soaErr := dnswire.Ask(ctx, address, zone, dnswire.TypeSOA, false)
+	if soaErr == nil {
+		soaErr = authoritativeErr(soa, zone, server.name)
+	}
+	if soaErr != nil {
+		return authoritativeAnswer{}, fmt.Errorf("nameserver %s: %w", server.name, soaErr)
+	}
+	serials := soa.Records(zone, dnswire.TypeSOA)
+	if len(serials) == 0 {
+		return authoritativeAnswer{}, fmt.Errorf("nameserver %s returned no SOA record of %s", server.name, zone)
+	}
+
+	message, askErr := dnswire.Ask(ctx, address, domainName, dnswire.RecordType(family), false)
+	if askErr == nil {
+		askErr = authoritativeErr(message, domainName, server.name)
+	}
+	if askErr != nil {
+		return authoritativeAnswer{}, fmt.Errorf("nameserver %s: %w", server.name, askErr)
+	}
+
+	return authoritativeAnswer{serial: serials[0].Serial, refresh: time.Duration(serials[0].Refresh) * time.Second, records: message.AddressRecords(domainName, family)}, nil
+}
+
+// authoritativeErr returns the error of an answer about name that does not
+// come from an authority of its zone. A name error is a valid answer.
+func authoritativeErr(message dnswire.Message, name string, server string) error {
+	if message.RCode != dnswire.RCodeNameError {
+		if rcodeErr := message.Err(name, server); rcodeErr != nil {
+			return rcodeErr
+		}
+	}
+	if !message.Authoritative {
+		return fmt.Errorf("answer about %s is not authoritative", name)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+//go:build integration_tests || unit_tests || authoritative_tests || authoritative_unit_tests
+
+package authoritative
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// mockRecord is a record served by dnsServerMock.
+type mockRecord struct {
+	name       string
+	recordType uint16
+	data       []byte
+}
+
+// dnsServerMock is a local DNS server answering over UDP and TCP from its
+// records. Questions without a record get an empty answer with the zone SOA
+// record in the authority section. Authoritative servers refuse recursive
+// queries and, with truncateUDP, only answer in full over TCP.
+type dnsServerMock struct {
+	address       string
+	authoritative bool
+	truncateUDP   bool
+	records       []mockRecord
+}
+
+func encodeName(name string) []byte {
+	var encoded []byte
+	for label := range strings.SplitSeq(name, ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
+
+func soaRecord(zone string, serial uint32) mockRecord {
+	data := append(encodeName("ns1."+zone), encodeName("hostmaster."+zone)...)
+	data = binary.BigEndian.AppendUint32(data, serial)
+	data = binary.BigEndian.AppendUint32(data, 3600)
+	data = append(data, make([]byte, 12)...)
+	return mockRecord{zone, 6, data}
+}
+
+func nsRecord(zone string, target string) mockRecord {
+	return mockRecord{zone, 2, encodeName(target)}
+}
+
+func aRecord(name string, address ...byte) mockRecord {
+	return mockRecord{name, 1, address}
+}
+
+// listen starts the server on host, on port when not empty.
+func (server *dnsServerMock) listen(t *testing.T, host string, port string) string {
+	t.Helper()
+	conn, listenErr := net.ListenPacket("udp", net.JoinHostPort(host, port))
+	if listenErr != nil {
+		t.Skipf("Cannot listen on %s: %v", host, listenErr)
+	}
+	t.Cleanup(func() { conn.Close() })
+	server.address = conn.LocalAddr().String()
+	listener, listenErr := net.Listen("tcp", server.address)
+	if listenErr != nil {
+		t.Skipf("Cannot listen on %s: %v", server.address, listenErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, from, readErr := conn.ReadFrom(buffer)
+			if readErr != nil {
+				return
+			}
+			conn.WriteTo(server.answer(buffer[:n], server.truncateUDP), from)
+		}
+	}()
+	go func() {
+		for {
+			client, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			var length [2]byte
+			if _, readErr := io.ReadFull(client, length[:]); readErr == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				io.ReadFull(client, query)
+				response := server.answer(query, false)
+				client.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+			}
+			client.Close()
+		}
+	}()
+	_, listenPort, _ := net.SplitHostPort(server.address)
+	return listenPort
+}
+
+// answer builds the response to query.
+func (server *dnsServerMock) answer(query []byte, truncate bool) []byte {
+
+	end := 12
+	for query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	var labels []string
+	for offset := 12; offset < end; offset += int(query[offset]) + 1 {
+		labels = append(labels, string(query[offset+1:offset+1+int(query[offset])]))
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	questionType := binary.BigEndian.Uint16(query[end+1 : end+3])
+
+	// The OPT record of the query is not echoed.
+	response := slices.Clone(query[:end+5])
+	binary.BigEndian.PutUint16(response[10:12], 0)
+	response[2] = 0x80 | query[2]&0x01
+	response[3] = 0
+	if server.authoritative {
+		response[2] |= 0x04
+		if query[2]&0x01 != 0 {
+			response[3] = 5
+			return response
+		}
+	} else {
+		response[3] = 0x80
+	}
+	if truncate {
+		response[2] |= 0x02
+		return response
+	}
+
+	answers, authority := 0, 0
+	for _, record := range server.records {
+		if record.name == name && record.recordType == questionType {
+			response = appendRecord(response, record)
+			answers++
+		}
+	}
+	if answers == 0 {
+		for _, record := range server.records {
+			if record.recordType == 6 && strings.HasSuffix(name, record.name) {
+				response = appendRecord(response, record)
+				authority++
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(response[6:8], uint16(answers))
+	binary.BigEndian.PutUint16(response[8:10], uint16(authority))
+	return response
+}
+
+func appendRecord(response []byte, record mockRecord) []byte {
+	response = append(response, encodeName(record.name)...)
+	response = binary.BigEndian.AppendUint16(response, record.recordType)
+	response = append(response, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(record.data)))
+	return append(response, record.data...)
+}
+
+// newZoneMock starts a recursive resolver for example.com, whose nameservers
+// ns1 and ns2 are the given authoritative servers, listening on 127.0.0.1
+// and 127.0.0.2, with the extra records. It returns a Resolver using them.
+func newZoneMock(t *testing.T, ns1 *dnsServerMock, ns2 *dnsServerMock, extra ...mockRecord) Resolver {
+	t.Helper()
+
+	port := ns1.listen(t, "127.0.0.1", "")
+	ns2.listen(t, "127.0.0.2", port)
+
+	recursive := &dnsServerMock{records: []mockRecord{
+		soaRecord("example.com", 1),
+		nsRecord("example.com", "ns1.example.com"),
+		nsRecord("example.com", "ns2.example.com"),
+		aRecord("ns1.example.com", 127, 0, 0, 1),
+		aRecord("ns2.example.com", 127, 0, 0, 2),
+		aRecord("home.example.com", 79, 116, 1, 1),
+	}}
+	recursive.records = append(recursive.records, extra...)
+	recursive.listen(t, "127.0.0.1", "")
+	return Resolver{Recursive: recursive.address, Port: port}
+}
+
+func TestResolve(t *testing.T) {
+
+	ns1 := &dnsServerMock{authoritative: true, records: []mockRecord{soaRecord("example.com", 2026061802), aRecord("home.example.com", 79, 116, 1, 2), aRecord("home.example.com", 79, 116, 1, 3)}}
+	// The same record set in another order, as round-robin answers give it.
+	ns2 := &dnsServerMock{authoritative: true, truncateUDP: true, records: []mockRecord{ns1.records[0], ns1.records[2], ns1.records[1]}}
+	resolver := newZoneMock(t, ns1, ns2)
+
+	records, resolveErr := resolver.Resolve(context.Background(), "Home.example.com.", domain.IPv4)
+	if resolveErr != nil || len(records) != 2 || records[0] != (domain.DNSRecord{Address: "79.116.1.2", Type: "A", TTL: 300 * time.Second}) || records[1].Address != "79.116.1.3" {
+		t.Errorf("Resolve should return the authoritative records 79.116.1.2 and 79.116.1.3, got %+v (%v)", records, resolveErr)
+	}
+
+	if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv6); resolveErr != nil || len(records) != 0 {
+		t.Errorf("Resolve should return no record without AAAA records, got %+v (%v)", records, resolveErr)
+	}
+
+}
+
+func TestResolveLagging(t *testing.T) {
+
+	for _, test := range []struct {
+		serial  uint32
+		lagging []string
+	}{
+		{2026061801, []string{"ns2.example.com"}},
+		{2026061802, nil},
+	} {
+		ns1 := &dnsServerMock{authoritative: true, records: []mockRecord{soaRecord("example.com", 2026061802), aRecord("home.example.com", 79, 116, 1, 2)}}
+		ns2 := &dnsServerMock{authoritative: true, records: []mockRecord{soaRecord("example.com", test.serial), aRecord("home.example.com", 79, 116, 1, 1)}}
+		resolver := newZoneMock(t, ns1, ns2)
+
+		var lagErr *domain.NameserverLagError
+		_, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4)
+		if !errors.As(resolveErr, &lagErr) || !slices.Equal(lagErr.Lagging, test.lagging) || lagErr.Answers["ns1.example.com"] != "79.116.1.2" || lagErr.Answers["ns2.example.com"] != "79.116.1.1" || lagErr.Refresh != time.Hour {
+			t.Errorf("Resolve should report %v lagging behind with ns2 on serial %d and the zone refresh interval, got %v", test.lagging, test.serial, resolveErr)
+		}
+	}
+
+}
+
+func TestResolveNotAuthoritative(t *testing.T) {
+
+	ns1 := &dnsServerMock{records: []mockRecord{soaRecord("example.com", 2026061802), aRecord("home.example.com", 79, 116, 1, 2)}}
+	ns2 := &dnsServerMock{records: ns1.records}
+	resolver := newZoneMock(t, ns1, ns2)
+
+	if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr == nil {
+		t.Errorf("Resolve should fail when no nameserver is authoritative, got %+v", records)
+	}
+
+}
+
+func TestResolveUnreachable(t *testing.T) {
+
+	// ns2 is not authoritative and ns3 has no address: only ns1 is compared.
+	ns1 := &dnsServerMock{authoritative: true, records: []mockRecord{soaRecord("example.com", 2026061802), aRecord("home.example.com", 79, 116, 1, 2)}}
+	ns2 := &dnsServerMock{records: ns1.records}
+	resolver := newZoneMock(t, ns1, ns2, nsRecord("example.com", "ns3.example.com"))
+
+	records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4)
+	if resolveErr != nil || len(records) != 1 || records[0].Address != "79.116.1.2" {
+		t.Errorf("Resolve should return the records of the nameservers answering, got %+v (%v)", records, resolveErr)
+	}
+
+	// ns1 and ns3 disagree, ns2 is left out.
+	ns1 = &dnsServerMock{authoritative: true, records: []mockRecord{soaRecord("example.com", 2026061802), aRecord("home.example.com", 79, 116, 1, 2)}}
+	ns2 = &dnsServerMock{records: ns1.records}
+	ns3 := &dnsServerMock{authoritative: true, records: []mockRecord{soaRecord("example.com", 2026061801), aRecord("home.example.com", 79, 116, 1, 1)}}
+	resolver = newZoneMock(t, ns1, ns2, nsRecord("example.com", "ns3.example.com"), aRecord("ns3.example.com", 127, 0, 0, 3))
+	ns3.listen(t, "127.0.0.3", resolver.Port)
+
+	var lagErr *domain.NameserverLagError
+	_, resolveErr = resolver.Resolve(context.Background(), "home.example.com", domain.IPv4)
+	if !errors.As(resolveErr, &lagErr) || !slices.Equal(lagErr.Lagging, []string{"ns3.example.com"}) || !slices.Equal(lagErr.Unreachable, []string{"ns2.example.com"}) || len(lagErr.Answers) != 2 {
+		t.Errorf("Resolve should report ns3 lagging behind and ns2 unreachable, got %v (%+v)", resolveErr, lagErr)
+	}
+
+}
+
+func TestSerialBefore(t *testing.T) {
+
+	for _, test := range []struct {
+		a, b   uint32
+		before bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{2, 2, false},
+		{0xffffffff, 1, true},
+		{1, 0xffffffff, false},
+	} {
+		if before := serialBefore(test.a, test.b); before != test.before {
+			t.Errorf("serialBefore(%d, %d) should be %t", test.a, test.b, test.before)
+		}
+	}
+
+}
@@ -0,0 +1,165 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// now returns the current time, replaced in tests.
+var now = time.Now
+
+// Breaker is a circuit breaker whose state is kept in a domain.BreakerStore,
+// so it carries across runs of the oneshot service:
+//
+//   - closed: calls go through; Threshold consecutive failures open it;
+//   - open: calls fail right away with a *domain.BreakerOpenError until
+//     Cooldown has passed since it opened;
+//   - half-open: a single trial call closes it when it succeeds and opens it
+//     again when it fails. Other calls fail right away while the trial is
+//     pending; a trial whose result is not saved within Cooldown, as when its
+//     run died, is replaced by a new one.
+//
+// Errors that are answers of a working service (providers disagreeing,
+// carrier-grade NAT, non-public addresses) and cancellations are not failures.
+// When the store cannot be read the call goes through as if closed.
+type Breaker struct {
+	Name      string
+	Store     domain.BreakerStore
+	Threshold int           // Consecutive failures that open the breaker
+	Cooldown  time.Duration // Time the breaker stays open before a trial call
+}
+
+// IsOutage reports whether err means the called service is not working, as
+// opposed to an answer of a working service.
+func IsOutage(err error) bool {
+
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var disagreement *domain.DisagreementError
+	var cgnat *domain.CGNATError
+	var nonPublic *domain.NonPublicAddressError
+	var lag *domain.NameserverLagError
+	return !errors.As(err, &disagreement) && !errors.As(err, &cgnat) && !errors.As(err, &nonPublic) && !errors.As(err, &lag)
+}
+
+// call calls call through breaker.
+func (breaker Breaker) call(ctx context.Context, call func() error) error {
+
+	log := logger.FromContext(ctx).With("operation", "Breaker.call", "breaker", breaker.Name)
+
+	state, _, stateErr := breaker.Store.BreakerState(ctx, breaker.Name)
+	if stateErr != nil {
+		log.ErrorContext(ctx, "Error retrieving circuit breaker state from store, calling anyway", "error", stateErr)
+		return call()
+	}
+
+	switch state.Status {
+	case domain.BreakerOpen:
+		retryAt := state.OpenedAt.Add(breaker.Cooldown)
+		if now().Before(retryAt) {
+			log.DebugContext(ctx, "Circuit breaker is open, not calling", "retryAt", retryAt)
+			return &domain.BreakerOpenError{Breaker: breaker.Name, RetryAt: retryAt}
+		}
+		log.InfoContext(ctx, "Circuit breaker cooldown is over, making a trial call")
+	case domain.BreakerHalfOpen:
+		retryAt := state.TrialAt.Add(breaker.Cooldown)
+		if now().Before(retryAt) {
+			log.DebugContext(ctx, "Circuit breaker trial call is pending, not calling", "trialAt", state.TrialAt, "retryAt", retryAt)
+			return &domain.BreakerOpenError{Breaker: breaker.Name, RetryAt: retryAt}
+		}
+		log.WarnContext(ctx, "Circuit breaker trial call has no result, making another one", "trialAt", state.TrialAt)
+	}
+	if state.Status == domain.BreakerOpen || state.Status == domain.BreakerHalfOpen {
+		state.Status = domain.BreakerHalfOpen
+		state.TrialAt = now()
+		breaker.save(ctx, state)
+	}
+
+	callErr := call()
+	switch {
+	case !IsOutage(callErr):
+		if state.Status == domain.BreakerHalfOpen || state.Failures > 0 {
+			log.InfoContext(ctx, "Circuit breaker is closed", "previousStatus", state.Status)
+			breaker.save(ctx, domain.BreakerState{Status: domain.BreakerClosed})
+		}
+	case state.Status == domain.BreakerHalfOpen || state.Failures+1 >= breaker.Threshold:
+		log.WarnContext(ctx, "Circuit breaker is open", "failures", state.Failures+1, "cooldown", breaker.Cooldown, "error", callErr)
+		breaker.save(ctx, domain.BreakerState{Status: domain.BreakerOpen, Failures: state.Failures + 1, OpenedAt: now()})
+	default:
+		breaker.save(ctx, domain.BreakerState{Status: domain.BreakerClosed, Failures: state.Failures + 1})
+	}
+	return callErr
+}
+
+// save persists state; a failure is only logged since the call result matters
+// more than the breaker bookkeeping.
+func (breaker Breaker) save(ctx context.Context, state domain.BreakerState) {
+	if saveErr := breaker.Store.SaveBreakerState(ctx, breaker.Name, state); saveErr != nil {
+		logger.FromContext(ctx).ErrorContext(ctx, "Error updating circuit breaker state in store", "operation", "Breaker.save", "breaker", breaker.Name, "error", saveErr)
+	}
+}
+
+// Provider guards a domain.IPInfoProvider with a circuit breaker.
+type Provider struct {
+	Provider domain.IPInfoProvider
+	Breaker  Breaker
+}
+
+// GetIPInfo implements domain.IPInfoProvider.
+func (provider Provider) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+	var ipinfo domain.IPInfo
+	err := provider.Breaker.call(ctx, func() error {
+		var getIPInfoErr error
+		ipinfo, getIPInfoErr = provider.Provider.GetIPInfo(ctx)
+		return getIPInfoErr
+	})
+	return ipinfo, err
+}
+
+// Resolver guards a domain.DNSResolver with a circuit breaker.
+type Resolver struct {
+	Resolver domain.DNSResolver
+	Breaker  Breaker
+}
+
+// Resolve implements domain.DNSResolver.
+func (resolver Resolver) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+	var records []domain.DNSRecord
+	err := resolver.Breaker.call(ctx, func() error {
+		var resolveErr error
+		records, resolveErr = resolver.Resolver.Resolve(ctx, domainName, family)
+		return resolveErr
+	})
+	return records, err
+}
+
+// Fallback is a domain.IPInfoProvider asking Providers in order: the next one
+// is only asked when the previous one is out of service (see IsOutage), such
+// as when its circuit breaker is open.
+type Fallback struct {
+	Providers []domain.IPInfoProvider
+}
+
+// GetIPInfo implements domain.IPInfoProvider. It returns the answer of the
+// first working provider, or the errors of every one of them.
+func (fallback Fallback) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "Fallback.GetIPInfo")
+
+	var providerErrs []error
+	for index, provider := range fallback.Providers {
+		ipinfo, err := provider.GetIPInfo(ctx)
+		if !IsOutage(err) {
+			return ipinfo, err
+		}
+		log.WarnContext(ctx, "Public IP provider is out of service, falling back to the next one", "position", index+1, "error", err)
+		providerErrs = append(providerErrs, err)
+	}
+	return domain.IPInfo{}, errors.Join(providerErrs...)
+}
@@ -0,0 +1,190 @@
+//go:build integration_tests || unit_tests || breaker_tests || breaker_unit_tests
+
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// breakerStoreMock fakes a domain.BreakerStore in memory.
+type breakerStoreMock struct {
+	states map[string]domain.BreakerState
+}
+
+func (mock breakerStoreMock) BreakerState(ctx context.Context, breaker string) (domain.BreakerState, bool, error) {
+	state, found := mock.states[breaker]
+	return state, found, nil
+}
+
+func (mock breakerStoreMock) SaveBreakerState(ctx context.Context, breaker string, state domain.BreakerState) error {
+	mock.states[breaker] = state
+	return nil
+}
+
+// providerMock fakes a domain.IPInfoProvider and counts its calls.
+type providerMock struct {
+	ipinfo domain.IPInfo
+	err    *error
+	calls  *int
+}
+
+func (mock providerMock) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+	*mock.calls++
+	return mock.ipinfo, *mock.err
+}
+
+// setNow makes now return current for the rest of the test.
+func setNow(t *testing.T, current *time.Time) {
+	t.Helper()
+	now = func() time.Time { return *current }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+
+	current := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	setNow(t, &current)
+
+	var calls int
+	providerErr := errors.New("ipinfo timeout")
+	store := breakerStoreMock{states: map[string]domain.BreakerState{}}
+	provider := Provider{
+		Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "79.116.12.34"}, err: &providerErr, calls: &calls},
+		Breaker:  Breaker{Name: "ipinfo", Store: store, Threshold: 2, Cooldown: 5 * time.Minute},
+	}
+
+	// Closed: failures go through until the threshold opens the breaker.
+	for range 2 {
+		if _, err := provider.GetIPInfo(context.Background()); !errors.Is(err, providerErr) {
+			t.Fatalf("TestBreakerOpensAndCloses should return the provider error while closed, got %v", err)
+		}
+	}
+	if state := store.states["ipinfo"]; state.Status != domain.BreakerOpen || !state.OpenedAt.Equal(current) {
+		t.Fatalf("TestBreakerOpensAndCloses should open the breaker after 2 failures, got %+v", state)
+	}
+
+	// Open: the provider is not called until the cooldown is over.
+	current = current.Add(time.Minute)
+	var open *domain.BreakerOpenError
+	if _, err := provider.GetIPInfo(context.Background()); !errors.As(err, &open) || calls != 2 {
+		t.Fatalf("TestBreakerOpensAndCloses should refuse calls while open, got %v after %d calls", err, calls)
+	}
+
+	// Half-open: a failed trial opens it again.
+	current = current.Add(5 * time.Minute)
+	if _, err := provider.GetIPInfo(context.Background()); !errors.Is(err, providerErr) || calls != 3 {
+		t.Fatalf("TestBreakerOpensAndCloses should make a trial call after the cooldown, got %v after %d calls", err, calls)
+	}
+	if state := store.states["ipinfo"]; state.Status != domain.BreakerOpen || !state.OpenedAt.Equal(current) {
+		t.Fatalf("TestBreakerOpensAndCloses should open the breaker again after a failed trial, got %+v", state)
+	}
+
+	// Half-open: a successful trial closes it.
+	current = current.Add(5 * time.Minute)
+	providerErr = nil
+	if ipinfo, err := provider.GetIPInfo(context.Background()); err != nil || ipinfo.IPv4 != "79.116.12.34" {
+		t.Fatalf("TestBreakerOpensAndCloses should return the trial answer, got %+v (%v)", ipinfo, err)
+	}
+	if state := store.states["ipinfo"]; state != (domain.BreakerState{Status: domain.BreakerClosed}) {
+		t.Errorf("TestBreakerOpensAndCloses should close the breaker after a successful trial, got %+v", state)
+	}
+
+}
+
+// Only one trial call is made while a breaker is half-open.
+func TestBreakerSingleTrial(t *testing.T) {
+
+	current := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	setNow(t, &current)
+
+	var calls int
+	var providerErr error
+	store := breakerStoreMock{states: map[string]domain.BreakerState{
+		"ipinfo": {Status: domain.BreakerOpen, Failures: 2, OpenedAt: current.Add(-5 * time.Minute)},
+	}}
+	breaker := Breaker{Name: "ipinfo", Store: store, Threshold: 2, Cooldown: 5 * time.Minute}
+
+	// Another run starting during the trial call is refused.
+	var open *domain.BreakerOpenError
+	var duringTrialErr error
+	trialErr := breaker.call(context.Background(), func() error {
+		calls++
+		duringTrialErr = breaker.call(context.Background(), func() error {
+			calls++
+			return nil
+		})
+		return errors.New("ipinfo timeout")
+	})
+	if trialErr == nil || !errors.As(duringTrialErr, &open) || calls != 1 {
+		t.Fatalf("TestBreakerSingleTrial should refuse calls during the trial, got %v after %d calls", duringTrialErr, calls)
+	}
+
+	// A trial whose result was never saved is replaced after the cooldown.
+	store.states["ipinfo"] = domain.BreakerState{Status: domain.BreakerHalfOpen, Failures: 2, OpenedAt: current.Add(-6 * time.Minute), TrialAt: current.Add(-time.Minute)}
+	provider := Provider{Provider: providerMock{err: &providerErr, calls: &calls}, Breaker: breaker}
+	if _, err := provider.GetIPInfo(context.Background()); !errors.As(err, &open) || !open.RetryAt.Equal(current.Add(4*time.Minute)) || calls != 1 {
+		t.Fatalf("TestBreakerSingleTrial should refuse calls while the trial is pending, got %v after %d calls", err, calls)
+	}
+	current = current.Add(4 * time.Minute)
+	if _, err := provider.GetIPInfo(context.Background()); err != nil || calls != 2 {
+		t.Fatalf("TestBreakerSingleTrial should make another trial after the cooldown, got %v after %d calls", err, calls)
+	}
+	if state := store.states["ipinfo"]; state != (domain.BreakerState{Status: domain.BreakerClosed}) {
+		t.Errorf("TestBreakerSingleTrial should close the breaker after a successful trial, got %+v", state)
+	}
+
+}
+
+// Answers of a working provider do not count as failures.
+func TestBreakerIgnoresAnswers(t *testing.T) {
+
+	var calls int
+	var providerErr error = &domain.DisagreementError{Family: domain.IPv4, Quorum: 2}
+	store := breakerStoreMock{states: map[string]domain.BreakerState{}}
+	provider := Provider{
+		Provider: providerMock{err: &providerErr, calls: &calls},
+		Breaker:  Breaker{Name: "quorum", Store: store, Threshold: 1, Cooldown: time.Minute},
+	}
+
+	for range 2 {
+		if _, err := provider.GetIPInfo(context.Background()); !errors.Is(err, providerErr) {
+			t.Errorf("TestBreakerIgnoresAnswers should return the disagreement, got %v", err)
+		}
+	}
+	if state, found := store.states["quorum"]; found || calls != 2 {
+		t.Errorf("TestBreakerIgnoresAnswers should not open the breaker, got %+v after %d calls", state, calls)
+	}
+
+}
+
+func TestFallback(t *testing.T) {
+
+	var primaryCalls, secondaryCalls int
+	var primaryErr error = &domain.BreakerOpenError{Breaker: "ipinfo"}
+	var secondaryErr error
+	fallback := Fallback{Providers: []domain.IPInfoProvider{
+		providerMock{err: &primaryErr, calls: &primaryCalls},
+		providerMock{ipinfo: domain.IPInfo{IPv4: "79.116.12.34", Source: "stun"}, err: &secondaryErr, calls: &secondaryCalls},
+	}}
+
+	if ipinfo, err := fallback.GetIPInfo(context.Background()); err != nil || ipinfo.Source != "stun" {
+		t.Errorf("TestFallback should fall back to the second provider, got %+v (%v)", ipinfo, err)
+	}
+
+	primaryErr = &domain.CGNATError{Address: "100.64.12.34"}
+	if _, err := fallback.GetIPInfo(context.Background()); !errors.Is(err, primaryErr) || secondaryCalls != 1 {
+		t.Errorf("TestFallback should return an answer of the first provider, got %v after %d fallback calls", err, secondaryCalls)
+	}
+
+	primaryErr = errors.New("ipinfo timeout")
+	secondaryErr = errors.New("stun timeout")
+	if _, err := fallback.GetIPInfo(context.Background()); !errors.Is(err, primaryErr) || !errors.Is(err, secondaryErr) {
+		t.Errorf("TestFallback should return every error when all providers are out of service, got %v", err)
+	}
+
+}
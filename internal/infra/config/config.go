@@ -4,22 +4,108 @@ import (
 	"cmp"
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	logger "github.com/a-castellano/go-services/infra/logger"
 	rabbitmqconfig "github.com/a-castellano/go-types/rabbitmq"
 	redisconfig "github.com/a-castellano/go-types/redis"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	doh "github.com/a-castellano/home-ip-monitor/internal/infra/doh"
+	dot "github.com/a-castellano/home-ip-monitor/internal/infra/dot"
+	echoip "github.com/a-castellano/home-ip-monitor/internal/infra/echoip"
+	notify "github.com/a-castellano/home-ip-monitor/internal/infra/notify"
+	nslookup "github.com/a-castellano/home-ip-monitor/internal/infra/nslookup"
+	retry "github.com/a-castellano/home-ip-monitor/internal/infra/retry"
+	stun "github.com/a-castellano/home-ip-monitor/internal/infra/stun"
 )
 
 // Config struct contains required config variables for the home IP monitor service
 type Config struct {
-	DomainName     string // The domain that should be used to check if home IP values mismatch
-	ISPName        string // home-ip-monitor will send new IP values to be updated if associated ISP is the same than this value
-	UpdateQueue    string // This will be the queue used to send IP changes
-	NotifyQueue    string // This will be the queue used to notify IP or ISP changes
-	DNSServer      string // This will be the external DNS Server used to notify for checking if home IP values mismatch
-	RedisConfig    *redisconfig.Config
-	RabbitmqConfig *rabbitmqconfig.Config
+	DomainName        string                 // The domain that should be used to check if home IP values mismatch
+	ISPName           string                 // home-ip-monitor will send new IP values to be updated if associated ISP is the same than this value
+	UpdateQueue       string                 // This will be the queue used to send IP changes
+	NotifyQueue       string                 // This will be the queue used to notify IP or ISP changes
+	PayloadFormat     notify.PayloadFormat   // Encoding of the messages sent to both queues
+	CloudEvents       notify.CloudEventsMode // Whether and how messages are wrapped in CloudEvents envelopes
+	CloudEventsSource string                 // CloudEvents "source" attribute of every message
+	DNSServer         string                 // This will be the external DNS Server used to notify for checking if home IP values mismatch
+	DNSResolver       string                 // Resolver of the domain records checked against the detected IP: "dns" (DNSServer), "authoritative", "doh" or "dot"
+	DoH               DoHConfig              // DNS-over-HTTPS server asked by the doh resolver
+	DoT               DoTConfig              // DNS-over-TLS server asked by the dot resolver
+	Families          []domain.IPFamily      // Address families to monitor, each one is detected, stored and checked separately
+	ISP               domain.ISPMatcher      // Criteria (ISPName plus aliases, ASNs, prefixes or pattern) that recognise the main ISP
+	ISPReminder       time.Duration          // Interval between reminders while home is on a backup ISP, zero disables them
+	Providers         []string               // Names of the public IP providers asked on each run
+	IPInfoToken       string                 // ipinfo.io API token, anonymous requests when empty; never logged
+	Quorum            int                    // Number of providers that must agree on an address
+	FallbackProviders []string               // Names of the public IP providers asked in order while the previous ones are out of service
+	STUNServers       []string               // STUN servers ("host:port") asked by the stun provider
+	MyIPQueries       []nslookup.MyIPQuery   // DNS queries asked by the dns provider
+	GatewayAddress    string                 // Router address asked by the gateway provider over NAT-PMP and PCP, the default route gateway when empty
+	EchoEndpoints     []echoip.Endpoint      // Echo services asked by the echoip provider
+	WANInterface      string                 // Interface read by the interface provider (e.g. "ppp0")
+	OrgLookup         string                 // Lookup filling the organization of the interface and echoip provider addresses: "ipinfo", "asndb" (every provider) or "none"
+	ASNDatabase       string                 // Path of the IP to ASN database read by the asndb lookup
+	History           HistoryConfig          // Retention of the IP change history
+	Daemon            DaemonConfig           // Scheduling of the long-running daemon mode
+	Retry             RetryConfig            // Retries of the calls to each port
+	Breaker           BreakerConfig          // Circuit breakers of the providers and the resolver
+	RedisConfig       *redisconfig.Config
+	RabbitmqConfig    *rabbitmqconfig.Config
+}
+
+// DaemonConfig contains the scheduling values used in daemon mode
+type DaemonConfig struct {
+	Interval   time.Duration // Time between two runs while they succeed
+	Jitter     time.Duration // Upper bound of the random delay added to each wait
+	RetryDelay time.Duration // Delay before re-checking after a failed run
+	MaxBackoff time.Duration // Upper bound of the delay while runs keep failing
+}
+
+// RetryConfig contains the retry policy of the calls to each port; the
+// retryable errors are left to retry.IsTransient
+type RetryConfig struct {
+	Provider retry.Policy // Public IP providers
+	Resolver retry.Policy // Domain DNS resolution
+	Store    retry.Policy // Redis/Valkey store
+	Notifier retry.Policy // RabbitMQ notifications
+}
+
+// BreakerConfig contains the circuit breaker values, a zero Threshold disables
+// the circuit breakers
+type BreakerConfig struct {
+	Threshold int           // Consecutive failures that open a circuit breaker
+	Cooldown  time.Duration // Time a circuit breaker stays open before a trial call
+}
+
+// DoHConfig contains the DNS-over-HTTPS server used by the doh resolver
+type DoHConfig struct {
+	URL       string       // Endpoint URL (e.g. "https://cloudflare-dns.com/dns-query")
+	Mode      string       // Request format: "post", "get" or "json"
+	Bootstrap []netip.Addr // Addresses the endpoint host is reached on, resolved through the system DNS when empty
+}
+
+// DoTConfig contains the DNS-over-TLS server used by the dot resolver
+type DoTConfig struct {
+	Server     string   // Server address ("host:port")
+	ServerName string   // Name sent as SNI and verified against the server certificate
+	Pins       [][]byte // SHA-256 digests of the accepted server public keys, any trusted key when empty
+}
+
+// HistoryConfig contains the retention limits of the IP change history, a
+// zero value disables the corresponding limit
+type HistoryConfig struct {
+	MaxEntries int           // Number of changes kept
+	MaxAge     time.Duration // Age after which a change is dropped
 }
 
 // NewConfig checks if required env variables are present, returns config instance
@@ -28,11 +114,50 @@ type Config struct {
 // Required environment variables:
 //   - DOMAIN_NAME: Domain to verify IP against
 //   - ISP_NAME: Expected ISP provider name
-//   - DNS_SERVER: External DNS server for lookups
+//   - DNS_SERVER: External DNS server for lookups, only required by the dns and authoritative resolvers
 //
 // Optional environment variables (with defaults):
+//   - DNS_RESOLVER: Resolver of the domain records, "dns" (DNS_SERVER), "authoritative" (every nameserver of the zone found through DNS_SERVER), "doh" or "dot" (default: "dns")
+//   - DOH_URL: DNS-over-HTTPS endpoint of the doh resolver (default: "https://cloudflare-dns.com/dns-query")
+//   - DOH_MODE: DNS-over-HTTPS request format, "post", "get" or "json" (default: "post")
+//   - DOH_BOOTSTRAP: Comma separated addresses the DOH_URL host is reached on (default: resolved through the system DNS)
+//   - DOT_SERVER: DNS-over-TLS server of the dot resolver, port 853 when missing (default: "1.1.1.1:853")
+//   - DOT_SERVER_NAME: Name sent as SNI and verified against the DOT_SERVER certificate (default: its host, "cloudflare-dns.com" for the default server)
+//   - DOT_PINS: Comma separated base64 SHA-256 digests of the accepted DOT_SERVER public keys (default: any trusted key)
 //   - UPDATE_QUEUE_NAME: Queue for IP updates (default: "home-ip-monitor-updates")
 //   - NOTIFY_QUEUE_NAME: Queue for notifications (default: "home-ip-monitor-notifications")
+//   - PAYLOAD_FORMAT: Encoding of queue messages, "text" or "json" (default: "text")
+//   - CLOUDEVENTS_MODE: CloudEvents envelope, "none", "structured" or "binary" (default: "none")
+//   - CLOUDEVENTS_SOURCE: CloudEvents source attribute (default: "home-ip-monitor")
+//   - IP_FAMILIES: Comma separated address families to monitor (default: "ipv4")
+//   - ISP_ALIASES: Comma separated alternative names of the ISP
+//   - ISP_ASNS: Comma separated ASNs of the ISP (e.g. "AS3352,12479")
+//   - ISP_PREFIXES: Comma separated CIDR ranges owned by the ISP
+//   - ISP_ORG_REGEX: Regular expression matched against the organization name
+//   - ISP_REMINDER_INTERVAL: Interval between reminders while on a backup ISP (default: "0s", disabled)
+//   - IP_PROVIDERS: Comma separated public IP providers to ask (default: "ipinfo")
+//   - IP_PROVIDERS_QUORUM: Providers that must agree on an address (default: majority)
+//   - IP_FALLBACK_PROVIDERS: Comma separated public IP providers asked in order while the previous ones are out of service (default: none)
+//   - BREAKER_THRESHOLD: Consecutive failures that open the circuit breaker of a provider or the resolver, 0 disables them (default: 3)
+//   - BREAKER_COOLDOWN: Time a circuit breaker stays open before a trial call (default: "5m")
+//   - IPINFO_TOKEN: ipinfo.io API token, sent as a bearer token (default: anonymous requests)
+//   - STUN_SERVERS: Comma separated "host:port" STUN servers of the stun provider (default: Google's and Cloudflare's)
+//   - MYIP_QUERIES: Comma separated "[txt:]name@host:port" DNS queries of the dns provider (default: OpenDNS's and Google's)
+//   - GATEWAY_ADDRESS: IPv4 address of the router asked by the gateway provider (default: the default route gateway)
+//   - ECHOIP_ENDPOINTS: Comma separated "url[|extractor]" echo services of the echoip provider (default: ipify, icanhazip, ifconfig.co and checkip.amazonaws.com)
+//   - WAN_INTERFACE: Interface holding the public address, required by the interface provider
+//   - ORG_LOOKUP: Lookup of the organization of the interface and echoip provider addresses, "ipinfo", "asndb" or "none"; "asndb" also fills it for every other provider (default: "ipinfo")
+//   - ASN_DATABASE: Path of an iptoasn TSV (optionally gzipped) or MaxMind GeoLite2-ASN MMDB file, required by the asndb lookup
+//   - IP_HISTORY_MAX_ENTRIES: IP changes kept in the history, 0 keeps all of them (default: 100)
+//   - IP_HISTORY_MAX_AGE: Age after which an IP change is dropped from the history (default: "0s", kept forever)
+//   - DAEMON_INTERVAL: Time between runs in daemon mode (default: "2m")
+//   - DAEMON_JITTER: Random delay added to each wait in daemon mode (default: "15s")
+//   - DAEMON_RETRY_DELAY: Delay before re-checking after a failure (default: "10s")
+//   - DAEMON_MAX_BACKOFF: Maximum delay while runs keep failing (default: "10m")
+//   - <PORT>_RETRY_ATTEMPTS: Calls made at most to PORT (PROVIDER, RESOLVER, STORE or NOTIFIER) on transient errors, 1 disables retries (default: 3)
+//   - <PORT>_RETRY_DELAY: Delay before the first retry of PORT, doubled on each retry (default: "1s", "500ms" for RESOLVER, "200ms" for STORE)
+//   - <PORT>_RETRY_MAX_DELAY: Maximum delay between retries of PORT (default: "10s", "5s" for RESOLVER, "2s" for STORE)
+//   - <PORT>_RETRY_JITTER: Random delay added to each wait before retrying PORT (default: "500ms", "250ms" for RESOLVER, "100ms" for STORE)
 //
 // Returns:
 //   - *Config: Initialized configuration struct
@@ -60,15 +185,61 @@ func NewConfig(ctx context.Context) (*Config, error) {
 	}
 	log.DebugContext(ctx, "ISP name has been set", "isp", config.ISPName)
 
-	// Retrieve DNSServer from environment
+	// Retrieve optional ISP matching criteria
+	ispMatcher, ispMatcherErr := newISPMatcher(config.ISPName)
+	if ispMatcherErr != nil {
+		log.ErrorContext(ctx, "Error configuring ISP matching criteria", "error", ispMatcherErr)
+		return nil, ispMatcherErr
+	}
+	config.ISP = ispMatcher
+	log.DebugContext(ctx, "ISP matching criteria have been set", "aliases", config.ISP.Aliases, "asns", config.ISP.ASNs, "prefixes", config.ISP.Prefixes, "orgPattern", config.ISP.OrgPattern)
+
+	// Retrieve backup ISP reminder interval, default is no reminders
+	ispReminder, ispReminderErr := durationFromEnv("ISP_REMINDER_INTERVAL", 0)
+	if ispReminderErr != nil {
+		log.ErrorContext(ctx, "Error configuring ISP reminder interval", "error", ispReminderErr)
+		return nil, ispReminderErr
+	}
+	config.ISPReminder = ispReminder
+	log.DebugContext(ctx, "ISP reminder interval has been set", "ispReminder", config.ISPReminder)
+
+	// Retrieve the resolver of the domain records, default is dns
+	config.DNSResolver = strings.ToLower(strings.TrimSpace(cmp.Or(os.Getenv("DNS_RESOLVER"), "dns")))
+	if config.DNSResolver != "dns" && config.DNSResolver != "authoritative" && config.DNSResolver != "doh" && config.DNSResolver != "dot" {
+		resolverErr := fmt.Errorf("env variable DNS_RESOLVER must be \"dns\", \"authoritative\", \"doh\" or \"dot\", got \"%s\"", config.DNSResolver)
+		log.ErrorContext(ctx, "Error configuring dns resolver", "error", resolverErr)
+		return nil, resolverErr
+	}
+
+	// Retrieve DNSServer from environment, the dns resolver asks it for the
+	// records and the authoritative one for the zone nameservers
 	config.DNSServer = cmp.Or(os.Getenv("DNS_SERVER"), "no_set")
 
 	if config.DNSServer == "no_set" {
-		dnsError := errors.New("env variable DNS_SERVER must be set")
-		log.ErrorContext(ctx, "Error configuring dns server", "error", dnsError)
-		return nil, dnsError
+		if config.DNSResolver == "dns" || config.DNSResolver == "authoritative" {
+			dnsError := errors.New("env variable DNS_SERVER must be set")
+			log.ErrorContext(ctx, "Error configuring dns server", "error", dnsError)
+			return nil, dnsError
+		}
+		config.DNSServer = ""
 	}
-	log.DebugContext(ctx, "DNS Server has been set", "dns", config.DNSServer)
+	log.DebugContext(ctx, "DNS Server has been set", "resolver", config.DNSResolver, "dns", config.DNSServer)
+
+	dohConfig, dohErr := newDoHConfig()
+	if dohErr != nil {
+		log.ErrorContext(ctx, "Error configuring DNS-over-HTTPS resolver", "error", dohErr)
+		return nil, dohErr
+	}
+	config.DoH = dohConfig
+	log.DebugContext(ctx, "DNS-over-HTTPS resolver has been set", "url", config.DoH.URL, "mode", config.DoH.Mode, "bootstrap", config.DoH.Bootstrap)
+
+	dotConfig, dotErr := newDoTConfig()
+	if dotErr != nil {
+		log.ErrorContext(ctx, "Error configuring DNS-over-TLS resolver", "error", dotErr)
+		return nil, dotErr
+	}
+	config.DoT = dotConfig
+	log.DebugContext(ctx, "DNS-over-TLS resolver has been set", "server", config.DoT.Server, "serverName", config.DoT.ServerName, "pins", len(config.DoT.Pins))
 
 	// Retrieve UpdateQueue name, default is home-ip-monitor-updates
 	config.UpdateQueue = cmp.Or(os.Getenv("UPDATE_QUEUE_NAME"), "home-ip-monitor-updates")
@@ -78,6 +249,238 @@ func NewConfig(ctx context.Context) (*Config, error) {
 	config.NotifyQueue = cmp.Or(os.Getenv("NOTIFY_QUEUE_NAME"), "home-ip-monitor-notifications")
 	log.DebugContext(ctx, "Notify queue name has been set", "notifyqueue", config.NotifyQueue)
 
+	// Retrieve PayloadFormat, default is text
+	payloadFormat, payloadFormatErr := notify.ParsePayloadFormat(cmp.Or(os.Getenv("PAYLOAD_FORMAT"), string(notify.TextFormat)))
+	if payloadFormatErr != nil {
+		log.ErrorContext(ctx, "Error configuring payload format", "error", payloadFormatErr)
+		return nil, payloadFormatErr
+	}
+	config.PayloadFormat = payloadFormat
+	log.DebugContext(ctx, "Payload format has been set", "payloadformat", config.PayloadFormat)
+
+	// Retrieve CloudEvents mode, default is no envelope
+	cloudEventsMode, cloudEventsModeErr := notify.ParseCloudEventsMode(cmp.Or(os.Getenv("CLOUDEVENTS_MODE"), string(notify.NoCloudEvents)))
+	if cloudEventsModeErr != nil {
+		log.ErrorContext(ctx, "Error configuring CloudEvents mode", "error", cloudEventsModeErr)
+		return nil, cloudEventsModeErr
+	}
+	config.CloudEvents = cloudEventsMode
+	config.CloudEventsSource = cmp.Or(os.Getenv("CLOUDEVENTS_SOURCE"), "home-ip-monitor")
+	log.DebugContext(ctx, "CloudEvents have been set", "mode", config.CloudEvents, "source", config.CloudEventsSource)
+
+	// Retrieve monitored families, default is ipv4 only
+	for _, familyName := range splitList(cmp.Or(os.Getenv("IP_FAMILIES"), string(domain.IPv4))) {
+		family, familyErr := domain.ParseIPFamily(familyName)
+		if familyErr != nil {
+			log.ErrorContext(ctx, "Error configuring IP families", "error", familyErr)
+			return nil, familyErr
+		}
+		config.Families = append(config.Families, family)
+	}
+	log.DebugContext(ctx, "IP families have been set", "families", config.Families)
+
+	// Retrieve public IP providers, default is ipinfo alone
+	config.Providers = splitList(cmp.Or(os.Getenv("IP_PROVIDERS"), "ipinfo"))
+	for index, providerName := range config.Providers {
+		if slices.Contains(config.Providers[:index], providerName) {
+			providersErr := fmt.Errorf("env variable IP_PROVIDERS lists \"%s\" more than once", providerName)
+			log.ErrorContext(ctx, "Error configuring public IP providers", "error", providersErr)
+			return nil, providersErr
+		}
+	}
+
+	// Retrieve providers quorum, default is a simple majority
+	config.Quorum = len(config.Providers)/2 + 1
+	if quorumValue := os.Getenv("IP_PROVIDERS_QUORUM"); quorumValue != "" {
+		quorum, quorumErr := strconv.Atoi(quorumValue)
+		if quorumErr != nil || quorum < 1 || quorum > len(config.Providers) {
+			providersErr := fmt.Errorf("env variable IP_PROVIDERS_QUORUM must be between 1 and %d, got \"%s\"", len(config.Providers), quorumValue)
+			log.ErrorContext(ctx, "Error configuring public IP providers", "error", providersErr)
+			return nil, providersErr
+		}
+		config.Quorum = quorum
+	}
+	// Retrieve fallback providers, default is none
+	config.FallbackProviders = splitList(os.Getenv("IP_FALLBACK_PROVIDERS"))
+	for index, providerName := range config.FallbackProviders {
+		if slices.Contains(config.FallbackProviders[:index], providerName) {
+			providersErr := fmt.Errorf("env variable IP_FALLBACK_PROVIDERS lists \"%s\" more than once", providerName)
+			log.ErrorContext(ctx, "Error configuring public IP providers", "error", providersErr)
+			return nil, providersErr
+		}
+	}
+	log.DebugContext(ctx, "Public IP providers have been set", "providers", config.Providers, "quorum", config.Quorum, "fallbackProviders", config.FallbackProviders)
+
+	// Retrieve circuit breaker values, default opens after 3 failures for 5 minutes
+	config.Breaker.Threshold = 3
+	if thresholdValue := os.Getenv("BREAKER_THRESHOLD"); thresholdValue != "" {
+		threshold, thresholdErr := strconv.Atoi(thresholdValue)
+		if thresholdErr != nil || threshold < 0 {
+			breakerErr := fmt.Errorf("env variable BREAKER_THRESHOLD must be a non-negative number, got \"%s\"", thresholdValue)
+			log.ErrorContext(ctx, "Error configuring circuit breakers", "error", breakerErr)
+			return nil, breakerErr
+		}
+		config.Breaker.Threshold = threshold
+	}
+	cooldown, cooldownErr := durationFromEnv("BREAKER_COOLDOWN", 5*time.Minute)
+	if cooldownErr != nil {
+		log.ErrorContext(ctx, "Error configuring circuit breakers", "error", cooldownErr)
+		return nil, cooldownErr
+	}
+	config.Breaker.Cooldown = cooldown
+	log.DebugContext(ctx, "Circuit breakers have been set", "breaker", config.Breaker)
+
+	// Retrieve ipinfo.io token, default is anonymous requests. Only whether
+	// it is set is logged.
+	config.IPInfoToken = strings.TrimSpace(os.Getenv("IPINFO_TOKEN"))
+	log.DebugContext(ctx, "ipinfo token has been set", "authenticated", config.IPInfoToken != "")
+
+	// Retrieve STUN servers, default is stun.DefaultServers
+	config.STUNServers = stun.DefaultServers
+	if stunServers := splitList(os.Getenv("STUN_SERVERS")); len(stunServers) > 0 {
+		config.STUNServers = stunServers
+	}
+	for _, stunServer := range config.STUNServers {
+		if _, _, splitErr := net.SplitHostPort(stunServer); splitErr != nil {
+			stunErr := fmt.Errorf("env variable STUN_SERVERS must list \"host:port\" servers, got \"%s\"", stunServer)
+			log.ErrorContext(ctx, "Error configuring STUN servers", "error", stunErr)
+			return nil, stunErr
+		}
+	}
+	log.DebugContext(ctx, "STUN servers have been set", "servers", config.STUNServers)
+
+	// Retrieve DNS queries, default is nslookup.DefaultMyIPQueries
+	config.MyIPQueries = nslookup.DefaultMyIPQueries
+	if myIPQueries := splitList(os.Getenv("MYIP_QUERIES")); len(myIPQueries) > 0 {
+		config.MyIPQueries = nil
+		for _, myIPQuery := range myIPQueries {
+			query, queryErr := nslookup.ParseMyIPQuery(myIPQuery)
+			if queryErr != nil {
+				myIPErr := fmt.Errorf("env variable MYIP_QUERIES is not valid: %w", queryErr)
+				log.ErrorContext(ctx, "Error configuring DNS queries", "error", myIPErr)
+				return nil, myIPErr
+			}
+			config.MyIPQueries = append(config.MyIPQueries, query)
+		}
+	}
+	log.DebugContext(ctx, "DNS queries have been set", "queries", config.MyIPQueries)
+
+	// Retrieve router address, default is the gateway of the default route
+	config.GatewayAddress = strings.TrimSpace(os.Getenv("GATEWAY_ADDRESS"))
+	if config.GatewayAddress != "" {
+		if gatewayAddress, parseErr := netip.ParseAddr(config.GatewayAddress); parseErr != nil || !gatewayAddress.Is4() {
+			gatewayErr := fmt.Errorf("env variable GATEWAY_ADDRESS must be an IPv4 address, got \"%s\"", config.GatewayAddress)
+			log.ErrorContext(ctx, "Error configuring router address", "error", gatewayErr)
+			return nil, gatewayErr
+		}
+	}
+	log.DebugContext(ctx, "Router address has been set", "gateway", config.GatewayAddress)
+
+	// Retrieve echo services, default is echoip.DefaultEndpoints
+	config.EchoEndpoints = echoip.DefaultEndpoints
+	if echoEndpoints := splitList(os.Getenv("ECHOIP_ENDPOINTS")); len(echoEndpoints) > 0 {
+		config.EchoEndpoints = nil
+		for _, echoEndpoint := range echoEndpoints {
+			endpoint, endpointErr := echoip.ParseEndpoint(echoEndpoint)
+			if endpointErr != nil {
+				echoErr := fmt.Errorf("env variable ECHOIP_ENDPOINTS is not valid: %w", endpointErr)
+				log.ErrorContext(ctx, "Error configuring echo services", "error", echoErr)
+				return nil, echoErr
+			}
+			config.EchoEndpoints = append(config.EchoEndpoints, endpoint)
+		}
+	}
+	log.DebugContext(ctx, "Echo services have been set", "endpoints", config.EchoEndpoints)
+
+	// Retrieve WAN interface, required by the interface provider only
+	config.WANInterface = strings.TrimSpace(os.Getenv("WAN_INTERFACE"))
+	if config.WANInterface == "" && (slices.Contains(config.Providers, "interface") || slices.Contains(config.FallbackProviders, "interface")) {
+		interfaceErr := errors.New("env variable WAN_INTERFACE must be set when IP_PROVIDERS or IP_FALLBACK_PROVIDERS lists \"interface\"")
+		log.ErrorContext(ctx, "Error configuring WAN interface", "error", interfaceErr)
+		return nil, interfaceErr
+	}
+
+	// Retrieve organization lookup, default is ipinfo
+	config.OrgLookup = strings.ToLower(strings.TrimSpace(cmp.Or(os.Getenv("ORG_LOOKUP"), "ipinfo")))
+	if config.OrgLookup != "ipinfo" && config.OrgLookup != "asndb" && config.OrgLookup != "none" {
+		orgLookupErr := fmt.Errorf("env variable ORG_LOOKUP must be \"ipinfo\", \"asndb\" or \"none\", got \"%s\"", config.OrgLookup)
+		log.ErrorContext(ctx, "Error configuring organization lookup", "error", orgLookupErr)
+		return nil, orgLookupErr
+	}
+	config.ASNDatabase = strings.TrimSpace(os.Getenv("ASN_DATABASE"))
+	if config.ASNDatabase == "" && config.OrgLookup == "asndb" {
+		asnDatabaseErr := errors.New("env variable ASN_DATABASE must be set when ORG_LOOKUP is \"asndb\"")
+		log.ErrorContext(ctx, "Error configuring organization lookup", "error", asnDatabaseErr)
+		return nil, asnDatabaseErr
+	}
+	log.DebugContext(ctx, "WAN interface has been set", "interface", config.WANInterface, "orgLookup", config.OrgLookup, "asnDatabase", config.ASNDatabase)
+
+	// Retrieve IP history retention, default is the last 100 changes
+	config.History.MaxEntries = 100
+	if maxEntriesValue := os.Getenv("IP_HISTORY_MAX_ENTRIES"); maxEntriesValue != "" {
+		maxEntries, maxEntriesErr := strconv.Atoi(maxEntriesValue)
+		if maxEntriesErr != nil || maxEntries < 0 {
+			historyErr := fmt.Errorf("env variable IP_HISTORY_MAX_ENTRIES must be a non-negative number, got \"%s\"", maxEntriesValue)
+			log.ErrorContext(ctx, "Error configuring IP history", "error", historyErr)
+			return nil, historyErr
+		}
+		config.History.MaxEntries = maxEntries
+	}
+	maxAge, maxAgeErr := durationFromEnv("IP_HISTORY_MAX_AGE", 0)
+	if maxAgeErr != nil {
+		log.ErrorContext(ctx, "Error configuring IP history", "error", maxAgeErr)
+		return nil, maxAgeErr
+	}
+	config.History.MaxAge = maxAge
+	log.DebugContext(ctx, "IP history retention has been set", "history", config.History)
+
+	// Retrieve daemon mode scheduling
+	daemonDurations := []struct {
+		env          string
+		defaultValue time.Duration
+		target       *time.Duration
+	}{
+		{"DAEMON_INTERVAL", 2 * time.Minute, &config.Daemon.Interval},
+		{"DAEMON_JITTER", 15 * time.Second, &config.Daemon.Jitter},
+		{"DAEMON_RETRY_DELAY", 10 * time.Second, &config.Daemon.RetryDelay},
+		{"DAEMON_MAX_BACKOFF", 10 * time.Minute, &config.Daemon.MaxBackoff},
+	}
+	for _, daemonDuration := range daemonDurations {
+		value, durationErr := durationFromEnv(daemonDuration.env, daemonDuration.defaultValue)
+		if durationErr != nil {
+			log.ErrorContext(ctx, "Error configuring daemon mode", "error", durationErr)
+			return nil, durationErr
+		}
+		*daemonDuration.target = value
+	}
+	if config.Daemon.Interval == 0 {
+		intervalErr := errors.New("env variable DAEMON_INTERVAL must be greater than zero")
+		log.ErrorContext(ctx, "Error configuring daemon mode", "error", intervalErr)
+		return nil, intervalErr
+	}
+	log.DebugContext(ctx, "Daemon mode scheduling has been set", "daemon", config.Daemon)
+
+	// Retrieve retry policies, every port is retried up to 3 times by default
+	retryPolicies := []struct {
+		port         string
+		defaultValue retry.Policy
+		target       *retry.Policy
+	}{
+		{"PROVIDER", retry.Policy{Attempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 500 * time.Millisecond}, &config.Retry.Provider},
+		{"RESOLVER", retry.Policy{Attempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second, Jitter: 250 * time.Millisecond}, &config.Retry.Resolver},
+		{"STORE", retry.Policy{Attempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 100 * time.Millisecond}, &config.Retry.Store},
+		{"NOTIFIER", retry.Policy{Attempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 500 * time.Millisecond}, &config.Retry.Notifier},
+	}
+	for _, retryPolicy := range retryPolicies {
+		policy, policyErr := retryPolicyFromEnv(retryPolicy.port, retryPolicy.defaultValue)
+		if policyErr != nil {
+			log.ErrorContext(ctx, "Error configuring retries", "error", policyErr)
+			return nil, policyErr
+		}
+		*retryPolicy.target = policy
+	}
+	log.DebugContext(ctx, "Retry policies have been set", "retry", config.Retry)
+
 	// Set RedisConfig and RabbitmqConfig
 	log.DebugContext(ctx, "Setting Redis config")
 	config.RedisConfig, redisConfigErr = redisconfig.NewConfig()
@@ -97,3 +500,145 @@ func NewConfig(ctx context.Context) (*Config, error) {
 
 	return &config, nil
 }
+
+// newDoHConfig reads the DNS-over-HTTPS server from the DOH_* variables.
+func newDoHConfig() (DoHConfig, error) {
+
+	dohConfig := DoHConfig{
+		URL:  strings.TrimSpace(cmp.Or(os.Getenv("DOH_URL"), doh.DefaultURL)),
+		Mode: strings.ToLower(strings.TrimSpace(cmp.Or(os.Getenv("DOH_MODE"), doh.ModePost))),
+	}
+
+	parsedURL, parseErr := url.Parse(dohConfig.URL)
+	if parseErr != nil || parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		return DoHConfig{}, fmt.Errorf("env variable DOH_URL must be an https URL, got \"%s\"", dohConfig.URL)
+	}
+	if dohConfig.Mode != doh.ModePost && dohConfig.Mode != doh.ModeGet && dohConfig.Mode != doh.ModeJSON {
+		return DoHConfig{}, fmt.Errorf("env variable DOH_MODE must be \"post\", \"get\" or \"json\", got \"%s\"", dohConfig.Mode)
+	}
+
+	for _, bootstrapValue := range splitList(os.Getenv("DOH_BOOTSTRAP")) {
+		address, addressErr := netip.ParseAddr(bootstrapValue)
+		if addressErr != nil {
+			return DoHConfig{}, fmt.Errorf("env variable DOH_BOOTSTRAP must hold IP addresses, got \"%s\"", bootstrapValue)
+		}
+		dohConfig.Bootstrap = append(dohConfig.Bootstrap, address)
+	}
+	return dohConfig, nil
+}
+
+// newDoTConfig reads the DNS-over-TLS server from the DOT_* variables.
+func newDoTConfig() (DoTConfig, error) {
+
+	dotConfig := DoTConfig{
+		Server:     strings.TrimSpace(os.Getenv("DOT_SERVER")),
+		ServerName: strings.TrimSpace(os.Getenv("DOT_SERVER_NAME")),
+	}
+
+	if dotConfig.Server == "" {
+		dotConfig.Server = dot.DefaultServer
+		dotConfig.ServerName = cmp.Or(dotConfig.ServerName, dot.DefaultServerName)
+	}
+	if _, _, splitErr := net.SplitHostPort(dotConfig.Server); splitErr != nil {
+		dotConfig.Server = net.JoinHostPort(strings.Trim(dotConfig.Server, "[]"), dot.DefaultPort)
+	}
+	host, _, _ := net.SplitHostPort(dotConfig.Server)
+	if host == "" {
+		return DoTConfig{}, fmt.Errorf("env variable DOT_SERVER must be a \"host[:port]\" address, got \"%s\"", os.Getenv("DOT_SERVER"))
+	}
+	dotConfig.ServerName = cmp.Or(dotConfig.ServerName, host)
+
+	for _, pinValue := range splitList(os.Getenv("DOT_PINS")) {
+		pin, pinErr := dot.ParsePin(pinValue)
+		if pinErr != nil {
+			return DoTConfig{}, fmt.Errorf("env variable DOT_PINS is not valid: %w", pinErr)
+		}
+		dotConfig.Pins = append(dotConfig.Pins, pin)
+	}
+	return dotConfig, nil
+}
+
+// newISPMatcher builds the main ISP matcher from ispName and the optional
+// ISP_ALIASES, ISP_ASNS, ISP_PREFIXES and ISP_ORG_REGEX env variables.
+func newISPMatcher(ispName string) (domain.ISPMatcher, error) {
+
+	matcher := domain.ISPMatcher{Name: ispName, Aliases: splitList(os.Getenv("ISP_ALIASES"))}
+
+	for _, asnValue := range splitList(os.Getenv("ISP_ASNS")) {
+		asn, asnErr := domain.ParseASN(asnValue)
+		if asnErr != nil {
+			return matcher, asnErr
+		}
+		matcher.ASNs = append(matcher.ASNs, asn)
+	}
+
+	for _, prefixValue := range splitList(os.Getenv("ISP_PREFIXES")) {
+		prefix, prefixErr := netip.ParsePrefix(prefixValue)
+		if prefixErr != nil {
+			return matcher, prefixErr
+		}
+		matcher.Prefixes = append(matcher.Prefixes, prefix.Masked())
+	}
+
+	if orgRegex := os.Getenv("ISP_ORG_REGEX"); orgRegex != "" {
+		orgPattern, regexErr := regexp.Compile(orgRegex)
+		if regexErr != nil {
+			return matcher, regexErr
+		}
+		matcher.OrgPattern = orgPattern
+	}
+
+	return matcher, nil
+}
+
+// splitList splits a comma separated env value, trimming spaces and dropping
+// empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// retryPolicyFromEnv reads the <port>_RETRY_* env variables, falling back to
+// the values of defaultValue for the unset ones.
+func retryPolicyFromEnv(port string, defaultValue retry.Policy) (retry.Policy, error) {
+
+	policy := defaultValue
+	if attemptsValue := os.Getenv(port + "_RETRY_ATTEMPTS"); attemptsValue != "" {
+		attempts, attemptsErr := strconv.Atoi(attemptsValue)
+		if attemptsErr != nil || attempts < 1 {
+			return policy, fmt.Errorf("env variable %s_RETRY_ATTEMPTS must be a positive number, got \"%s\"", port, attemptsValue)
+		}
+		policy.Attempts = attempts
+	}
+
+	var durationErr error
+	if policy.BaseDelay, durationErr = durationFromEnv(port+"_RETRY_DELAY", defaultValue.BaseDelay); durationErr != nil {
+		return policy, durationErr
+	}
+	if policy.MaxDelay, durationErr = durationFromEnv(port+"_RETRY_MAX_DELAY", defaultValue.MaxDelay); durationErr != nil {
+		return policy, durationErr
+	}
+	if policy.Jitter, durationErr = durationFromEnv(port+"_RETRY_JITTER", defaultValue.Jitter); durationErr != nil {
+		return policy, durationErr
+	}
+	return policy, nil
+}
+
+// durationFromEnv parses the env variable name as a time.Duration (e.g. "90s"),
+// returning defaultValue when it is unset. Negative durations are rejected.
+func durationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	duration, parseErr := time.ParseDuration(value)
+	if parseErr != nil || duration < 0 {
+		return 0, fmt.Errorf("env variable %s must be a valid non-negative duration, got \"%s\"", name, value)
+	}
+	return duration, nil
+}
@@ -4,8 +4,19 @@ package config
 
 import (
 	"context"
+	"net/netip"
 	"os"
+	"reflect"
+	"slices"
 	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	echoip "github.com/a-castellano/home-ip-monitor/internal/infra/echoip"
+	notify "github.com/a-castellano/home-ip-monitor/internal/infra/notify"
+	nslookup "github.com/a-castellano/home-ip-monitor/internal/infra/nslookup"
+	retry "github.com/a-castellano/home-ip-monitor/internal/infra/retry"
+	stun "github.com/a-castellano/home-ip-monitor/internal/infra/stun"
 )
 
 var currentDomainName string
@@ -395,3 +406,737 @@ func TestConfig(t *testing.T) {
 	}
 
 }
+
+func TestConfigDefaultFamilies(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("IP_FAMILIES", "")
+
+	ctx := context.Background()
+	config, err := NewConfig(ctx)
+
+	if err != nil {
+		t.Fatalf("TestConfigDefaultFamilies should not fail: %v", err)
+	}
+	if len(config.Families) != 1 || config.Families[0] != domain.IPv4 {
+		t.Errorf("config.Families should be [ipv4] but it was %v.", config.Families)
+	}
+
+}
+
+func TestConfigDualStackFamilies(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("IP_FAMILIES", "ipv4, ipv6")
+
+	ctx := context.Background()
+	config, err := NewConfig(ctx)
+
+	if err != nil {
+		t.Fatalf("TestConfigDualStackFamilies should not fail: %v", err)
+	}
+	if len(config.Families) != 2 || config.Families[0] != domain.IPv4 || config.Families[1] != domain.IPv6 {
+		t.Errorf("config.Families should be [ipv4 ipv6] but it was %v.", config.Families)
+	}
+
+}
+
+func TestConfigInvalidFamily(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("IP_FAMILIES", "ipv4,ipx")
+
+	ctx := context.Background()
+	_, err := NewConfig(ctx)
+
+	if err == nil {
+		t.Errorf("TestConfigInvalidFamily should fail.")
+	} else if err.Error() != "unknown IP family \"ipx\"" {
+		t.Errorf("TestConfigInvalidFamily error should be \"unknown IP family \"ipx\"\" but it was \"%s\".", err.Error())
+	}
+
+}
+
+func TestConfigISPMatcher(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "Movistar")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("ISP_ALIASES", "Telefonica, Telefonica de Espana")
+	t.Setenv("ISP_ASNS", "AS3352,12479")
+	t.Setenv("ISP_PREFIXES", "79.12.0.0/16,2a02:9000::/23")
+	t.Setenv("ISP_ORG_REGEX", "(?i)telef[oó]nica")
+
+	ctx := context.Background()
+	config, err := NewConfig(ctx)
+
+	if err != nil {
+		t.Fatalf("TestConfigISPMatcher should not fail: %v", err)
+	}
+	if config.ISP.Name != "Movistar" || len(config.ISP.Aliases) != 2 || len(config.ISP.ASNs) != 2 || len(config.ISP.Prefixes) != 2 || config.ISP.OrgPattern == nil {
+		t.Errorf("config.ISP was not fully set: %+v", config.ISP)
+	}
+	if config.ISP.ASNs[0] != 3352 || config.ISP.ASNs[1] != 12479 {
+		t.Errorf("config.ISP.ASNs should be [3352 12479] but it was %v.", config.ISP.ASNs)
+	}
+
+}
+
+func TestConfigInvalidISPCriteria(t *testing.T) {
+
+	for name, env := range map[string][2]string{
+		"asn":    {"ISP_ASNS", "ASX"},
+		"prefix": {"ISP_PREFIXES", "79.12.0.0/33"},
+		"regex":  {"ISP_ORG_REGEX", "(unclosed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setUp()
+			defer teardown()
+
+			os.Setenv("ISP_NAME", "DIGI")
+			os.Setenv("DNS_SERVER", "1.1.1.1:53")
+			os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+			t.Setenv(env[0], env[1])
+
+			if _, err := NewConfig(context.Background()); err == nil {
+				t.Errorf("NewConfig should fail with %s=\"%s\".", env[0], env[1])
+			}
+		})
+	}
+
+}
+
+func TestConfigDaemonDefaults(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("DAEMON_INTERVAL", "")
+	t.Setenv("DAEMON_JITTER", "")
+	t.Setenv("DAEMON_RETRY_DELAY", "0s")
+	t.Setenv("DAEMON_MAX_BACKOFF", "")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigDaemonDefaults should not fail: %v", err)
+	}
+	expected := DaemonConfig{Interval: 2 * time.Minute, Jitter: 15 * time.Second, RetryDelay: 0, MaxBackoff: 10 * time.Minute}
+	if config.Daemon != expected {
+		t.Errorf("config.Daemon should be %+v but it was %+v.", expected, config.Daemon)
+	}
+
+}
+
+func TestConfigInvalidDaemonDuration(t *testing.T) {
+
+	for _, env := range [][2]string{{"DAEMON_INTERVAL", "0s"}, {"DAEMON_JITTER", "soon"}, {"DAEMON_MAX_BACKOFF", "-1m"}} {
+		t.Run(env[0], func(t *testing.T) {
+			setUp()
+			defer teardown()
+
+			os.Setenv("ISP_NAME", "DIGI")
+			os.Setenv("DNS_SERVER", "1.1.1.1:53")
+			os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+			t.Setenv(env[0], env[1])
+
+			if _, err := NewConfig(context.Background()); err == nil {
+				t.Errorf("NewConfig should fail with %s=\"%s\".", env[0], env[1])
+			}
+		})
+	}
+
+}
+
+func TestConfigDefaultProviders(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("IP_PROVIDERS", "")
+	t.Setenv("IP_PROVIDERS_QUORUM", "")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigDefaultProviders should not fail: %v", err)
+	}
+	if len(config.Providers) != 1 || config.Providers[0] != "ipinfo" || config.Quorum != 1 {
+		t.Errorf("config should use ipinfo alone with a quorum of 1, got %v with quorum %d.", config.Providers, config.Quorum)
+	}
+
+}
+
+func TestConfigProvidersQuorum(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("IP_PROVIDERS", "ipinfo, stun, dns")
+	t.Setenv("IP_PROVIDERS_QUORUM", "")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigProvidersQuorum should not fail: %v", err)
+	}
+	if len(config.Providers) != 3 || config.Quorum != 2 {
+		t.Errorf("config should use 3 providers with a majority quorum of 2, got %v with quorum %d.", config.Providers, config.Quorum)
+	}
+
+}
+
+func TestConfigInvalidProviders(t *testing.T) {
+
+	for name, env := range map[string][2]string{
+		"duplicated": {"ipinfo,ipinfo", ""},
+		"zero":       {"ipinfo,stun", "0"},
+		"too high":   {"ipinfo,stun", "3"},
+		"not number": {"ipinfo,stun", "two"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setUp()
+			defer teardown()
+
+			os.Setenv("ISP_NAME", "DIGI")
+			os.Setenv("DNS_SERVER", "1.1.1.1:53")
+			os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+			t.Setenv("IP_PROVIDERS", env[0])
+			t.Setenv("IP_PROVIDERS_QUORUM", env[1])
+
+			if _, err := NewConfig(context.Background()); err == nil {
+				t.Errorf("NewConfig should fail with IP_PROVIDERS=\"%s\" and IP_PROVIDERS_QUORUM=\"%s\".", env[0], env[1])
+			}
+		})
+	}
+
+}
+
+func TestConfigISPReminder(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("ISP_REMINDER_INTERVAL", "6h")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigISPReminder should not fail: %v", err)
+	}
+	if config.ISPReminder != 6*time.Hour {
+		t.Errorf("config.ISPReminder should be 6h but it was %s.", config.ISPReminder)
+	}
+
+	t.Setenv("ISP_REMINDER_INTERVAL", "daily")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with ISP_REMINDER_INTERVAL=\"daily\".")
+	}
+
+}
+
+func TestConfigIPInfoToken(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil || config.IPInfoToken != "" {
+		t.Errorf("config.IPInfoToken should default to anonymous requests but it was \"%s\" (%v).", config.IPInfoToken, err)
+	}
+
+	t.Setenv("IPINFO_TOKEN", " 0123456789abcd ")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.IPInfoToken != "0123456789abcd" {
+		t.Errorf("config.IPInfoToken should be \"0123456789abcd\" but it was \"%s\" (%v).", config.IPInfoToken, err)
+	}
+
+}
+
+func TestConfigSTUNServers(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigSTUNServers should not fail: %v", err)
+	}
+	if !slices.Equal(config.STUNServers, stun.DefaultServers) {
+		t.Errorf("config.STUNServers should default to %v but it was %v.", stun.DefaultServers, config.STUNServers)
+	}
+
+	t.Setenv("STUN_SERVERS", "stun.example.net:3478, [2001:db8::1]:3478")
+	config, err = NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigSTUNServers should not fail: %v", err)
+	}
+	if !slices.Equal(config.STUNServers, []string{"stun.example.net:3478", "[2001:db8::1]:3478"}) {
+		t.Errorf("config.STUNServers was not parsed as expected: %v.", config.STUNServers)
+	}
+
+	t.Setenv("STUN_SERVERS", "stun.example.net")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with STUN_SERVERS=\"stun.example.net\".")
+	}
+
+}
+
+func TestConfigMyIPQueries(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigMyIPQueries should not fail: %v", err)
+	}
+	if !slices.Equal(config.MyIPQueries, nslookup.DefaultMyIPQueries) {
+		t.Errorf("config.MyIPQueries should default to %v but it was %v.", nslookup.DefaultMyIPQueries, config.MyIPQueries)
+	}
+
+	t.Setenv("MYIP_QUERIES", "myip.opendns.com@208.67.222.222:53, txt:o-o.myaddr.l.google.com@216.239.32.10:53")
+	config, err = NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigMyIPQueries should not fail: %v", err)
+	}
+	expected := []nslookup.MyIPQuery{{Name: "myip.opendns.com", Server: "208.67.222.222:53"}, {Name: "o-o.myaddr.l.google.com", Server: "216.239.32.10:53", TXT: true}}
+	if !slices.Equal(config.MyIPQueries, expected) {
+		t.Errorf("config.MyIPQueries was not parsed as expected: %v.", config.MyIPQueries)
+	}
+
+	t.Setenv("MYIP_QUERIES", "myip.opendns.com")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with MYIP_QUERIES=\"myip.opendns.com\".")
+	}
+
+}
+
+func TestConfigGatewayAddress(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigGatewayAddress should not fail: %v", err)
+	}
+	if config.GatewayAddress != "" {
+		t.Errorf("config.GatewayAddress should default to the default route gateway but it was \"%s\".", config.GatewayAddress)
+	}
+
+	t.Setenv("GATEWAY_ADDRESS", "192.168.1.1")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.GatewayAddress != "192.168.1.1" {
+		t.Errorf("config.GatewayAddress should be 192.168.1.1 but it was \"%s\" (%v).", config.GatewayAddress, err)
+	}
+
+	for _, value := range []string{"router.lan", "fe80::1"} {
+		t.Setenv("GATEWAY_ADDRESS", value)
+		if _, err := NewConfig(context.Background()); err == nil {
+			t.Errorf("NewConfig should fail with GATEWAY_ADDRESS=\"%s\".", value)
+		}
+	}
+
+}
+
+func TestConfigEchoEndpoints(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigEchoEndpoints should not fail: %v", err)
+	}
+	if !slices.Equal(config.EchoEndpoints, echoip.DefaultEndpoints) {
+		t.Errorf("config.EchoEndpoints should default to %v but it was %v.", echoip.DefaultEndpoints, config.EchoEndpoints)
+	}
+
+	t.Setenv("ECHOIP_ENDPOINTS", "https://api.ipify.org, https://ifconfig.co/json|json:ip")
+	config, err = NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigEchoEndpoints should not fail: %v", err)
+	}
+	expected := []echoip.Endpoint{{URL: "https://api.ipify.org", Extractor: "text"}, {URL: "https://ifconfig.co/json", Extractor: "json:ip"}}
+	if !slices.Equal(config.EchoEndpoints, expected) {
+		t.Errorf("config.EchoEndpoints was not parsed as expected: %v.", config.EchoEndpoints)
+	}
+
+	t.Setenv("ECHOIP_ENDPOINTS", "https://api.ipify.org|yaml")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with ECHOIP_ENDPOINTS=\"https://api.ipify.org|yaml\".")
+	}
+
+}
+
+func TestConfigWANInterface(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigWANInterface should not fail: %v", err)
+	}
+	if config.WANInterface != "" || config.OrgLookup != "ipinfo" {
+		t.Errorf("config should default to no WAN interface and the ipinfo lookup but it was \"%s\" and \"%s\".", config.WANInterface, config.OrgLookup)
+	}
+
+	t.Setenv("IP_PROVIDERS", "interface")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with the interface provider and no WAN_INTERFACE.")
+	}
+
+	t.Setenv("WAN_INTERFACE", "ppp0")
+	t.Setenv("ORG_LOOKUP", "None")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.WANInterface != "ppp0" || config.OrgLookup != "none" {
+		t.Errorf("config should read WAN_INTERFACE and ORG_LOOKUP, got %+v (%v).", config, err)
+	}
+
+	t.Setenv("ORG_LOOKUP", "whois")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with ORG_LOOKUP=\"whois\".")
+	}
+
+}
+
+func TestConfigASNDatabase(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	t.Setenv("ORG_LOOKUP", "asndb")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with the asndb lookup and no ASN_DATABASE.")
+	}
+
+	t.Setenv("ASN_DATABASE", " /var/lib/windmaker-home-ip-monitor/ip2asn-combined.tsv.gz ")
+	config, err := NewConfig(context.Background())
+
+	if err != nil || config.OrgLookup != "asndb" || config.ASNDatabase != "/var/lib/windmaker-home-ip-monitor/ip2asn-combined.tsv.gz" {
+		t.Errorf("config should read ORG_LOOKUP and ASN_DATABASE, got %+v (%v).", config, err)
+	}
+
+}
+
+func TestConfigRetryPolicies(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigRetryPolicies should not fail: %v", err)
+	}
+	if !reflect.DeepEqual(config.Retry.Store, retry.Policy{Attempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 100 * time.Millisecond}) {
+		t.Errorf("config should default to 3 store attempts from 200ms to 2s but it was %+v.", config.Retry.Store)
+	}
+
+	t.Setenv("NOTIFIER_RETRY_ATTEMPTS", "5")
+	t.Setenv("NOTIFIER_RETRY_DELAY", "2s")
+	t.Setenv("PROVIDER_RETRY_ATTEMPTS", "1")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || !reflect.DeepEqual(config.Retry.Notifier, retry.Policy{Attempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 10 * time.Second, Jitter: 500 * time.Millisecond}) || config.Retry.Provider.Attempts != 1 {
+		t.Errorf("config should read NOTIFIER_RETRY_* and PROVIDER_RETRY_ATTEMPTS, got %+v (%v).", config.Retry, err)
+	}
+
+	for env, value := range map[string]string{"STORE_RETRY_ATTEMPTS": "0", "RESOLVER_RETRY_MAX_DELAY": "soon"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := NewConfig(context.Background()); err == nil {
+				t.Errorf("NewConfig should fail with %s=\"%s\".", env, value)
+			}
+		})
+	}
+
+}
+
+func TestConfigBreakerAndFallback(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigBreakerAndFallback should not fail: %v", err)
+	}
+	if config.Breaker != (BreakerConfig{Threshold: 3, Cooldown: 5 * time.Minute}) || len(config.FallbackProviders) != 0 {
+		t.Errorf("config should default to breakers opening after 3 failures for 5m and no fallback but it was %+v and %v.", config.Breaker, config.FallbackProviders)
+	}
+
+	t.Setenv("BREAKER_THRESHOLD", "0")
+	t.Setenv("BREAKER_COOLDOWN", "1h")
+	t.Setenv("IP_FALLBACK_PROVIDERS", "stun, dns")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.Breaker != (BreakerConfig{Threshold: 0, Cooldown: time.Hour}) || !slices.Equal(config.FallbackProviders, []string{"stun", "dns"}) {
+		t.Errorf("config should read BREAKER_THRESHOLD, BREAKER_COOLDOWN and IP_FALLBACK_PROVIDERS, got %+v (%v).", config, err)
+	}
+
+	t.Setenv("IP_FALLBACK_PROVIDERS", "interface")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with the interface fallback provider and no WAN_INTERFACE.")
+	}
+
+	for env, value := range map[string]string{"BREAKER_THRESHOLD": "-1", "BREAKER_COOLDOWN": "later", "IP_FALLBACK_PROVIDERS": "stun,stun"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := NewConfig(context.Background()); err == nil {
+				t.Errorf("NewConfig should fail with %s=\"%s\".", env, value)
+			}
+		})
+	}
+
+}
+
+func TestConfigDNSResolver(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigDNSResolver should not fail: %v", err)
+	}
+	if config.DNSResolver != "dns" || config.DoH.URL != "https://cloudflare-dns.com/dns-query" || config.DoH.Mode != "post" || len(config.DoH.Bootstrap) != 0 {
+		t.Errorf("config should default to the dns resolver and Cloudflare's DNS-over-HTTPS endpoint but it was %s and %+v.", config.DNSResolver, config.DoH)
+	}
+
+	t.Setenv("DNS_RESOLVER", "authoritative")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.DNSResolver != "authoritative" || config.DNSServer != "1.1.1.1:53" {
+		t.Errorf("config should read the authoritative resolver, got %+v (%v).", config, err)
+	}
+
+	os.Unsetenv("DNS_SERVER")
+	if _, err := NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with the authoritative resolver and no DNS_SERVER.")
+	}
+
+	t.Setenv("DNS_RESOLVER", "DoH")
+	t.Setenv("DOH_URL", "https://dns.google/dns-query")
+	t.Setenv("DOH_MODE", "get")
+	t.Setenv("DOH_BOOTSTRAP", "8.8.8.8, 2001:4860:4860::8888")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.DNSResolver != "doh" || config.DNSServer != "" || config.DoH.URL != "https://dns.google/dns-query" || config.DoH.Mode != "get" || !slices.Equal(config.DoH.Bootstrap, []netip.Addr{netip.MustParseAddr("8.8.8.8"), netip.MustParseAddr("2001:4860:4860::8888")}) {
+		t.Errorf("config should read DNS_RESOLVER and the DOH_* variables without DNS_SERVER, got %+v (%v).", config, err)
+	}
+
+	if config.DoT.Server != "1.1.1.1:853" || config.DoT.ServerName != "cloudflare-dns.com" || len(config.DoT.Pins) != 0 {
+		t.Errorf("config should default to Cloudflare's DNS-over-TLS server but it was %+v.", config.DoT)
+	}
+
+	t.Setenv("DNS_RESOLVER", "dot")
+	t.Setenv("DOT_SERVER", "dns.quad9.net")
+	t.Setenv("DOT_PINS", "/SlsviBkb05Y/8XiKF9+CZsgCtrqPQk5bh47o0R3/Cg=")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.DNSResolver != "dot" || config.DoT.Server != "dns.quad9.net:853" || config.DoT.ServerName != "dns.quad9.net" || len(config.DoT.Pins) != 1 || len(config.DoT.Pins[0]) != 32 {
+		t.Errorf("config should read the DOT_* variables, got %+v (%v).", config.DoT, err)
+	}
+
+	t.Setenv("DOT_SERVER", "9.9.9.9:853")
+	t.Setenv("DOT_SERVER_NAME", "dns.quad9.net")
+	config, err = NewConfig(context.Background())
+
+	if err != nil || config.DoT.Server != "9.9.9.9:853" || config.DoT.ServerName != "dns.quad9.net" {
+		t.Errorf("config should read DOT_SERVER_NAME, got %+v (%v).", config.DoT, err)
+	}
+
+	for env, value := range map[string]string{"DNS_RESOLVER": "mdns", "DOH_URL": "http://dns.google/dns-query", "DOH_MODE": "xml", "DOH_BOOTSTRAP": "dns.google", "DOT_SERVER": ":853", "DOT_PINS": "c2hvcnQ="} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := NewConfig(context.Background()); err == nil {
+				t.Errorf("NewConfig should fail with %s=\"%s\".", env, value)
+			}
+		})
+	}
+
+}
+
+func TestConfigHistoryRetention(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigHistoryRetention should not fail: %v", err)
+	}
+	if config.History.MaxEntries != 100 || config.History.MaxAge != 0 {
+		t.Errorf("config.History should default to 100 entries of any age but it was %+v.", config.History)
+	}
+
+	t.Setenv("IP_HISTORY_MAX_ENTRIES", "0")
+	t.Setenv("IP_HISTORY_MAX_AGE", "2160h")
+	config, err = NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigHistoryRetention should not fail: %v", err)
+	}
+	if config.History.MaxEntries != 0 || config.History.MaxAge != 90*24*time.Hour {
+		t.Errorf("config.History should keep every entry of the last 90 days but it was %+v.", config.History)
+	}
+
+	for _, env := range [][2]string{{"-1", "0s"}, {"many", "0s"}, {"10", "-1h"}, {"10", "quarterly"}} {
+		t.Setenv("IP_HISTORY_MAX_ENTRIES", env[0])
+		t.Setenv("IP_HISTORY_MAX_AGE", env[1])
+		if _, err := NewConfig(context.Background()); err == nil {
+			t.Errorf("NewConfig should fail with IP_HISTORY_MAX_ENTRIES=\"%s\" and IP_HISTORY_MAX_AGE=\"%s\".", env[0], env[1])
+		}
+	}
+
+}
+
+func TestConfigPayloadFormat(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("PAYLOAD_FORMAT", "")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigPayloadFormat should not fail: %v", err)
+	}
+	if config.PayloadFormat != notify.TextFormat {
+		t.Errorf("config.PayloadFormat should default to text but it was \"%s\".", config.PayloadFormat)
+	}
+
+	t.Setenv("PAYLOAD_FORMAT", "json")
+	if config, err = NewConfig(context.Background()); err != nil || config.PayloadFormat != notify.JSONFormat {
+		t.Errorf("config.PayloadFormat should be json, got %v.", err)
+	}
+
+	t.Setenv("PAYLOAD_FORMAT", "xml")
+	if _, err = NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with PAYLOAD_FORMAT=\"xml\".")
+	}
+
+}
+
+func TestConfigCloudEvents(t *testing.T) {
+
+	setUp()
+	defer teardown()
+
+	os.Setenv("ISP_NAME", "DIGI")
+	os.Setenv("DNS_SERVER", "1.1.1.1:53")
+	os.Setenv("DOMAIN_NAME", "test.windmaker.net")
+	t.Setenv("CLOUDEVENTS_MODE", "binary")
+	t.Setenv("CLOUDEVENTS_SOURCE", "")
+
+	config, err := NewConfig(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestConfigCloudEvents should not fail: %v", err)
+	}
+	if config.CloudEvents != notify.BinaryCloudEvents || config.CloudEventsSource != "home-ip-monitor" {
+		t.Errorf("config should use binary CloudEvents from home-ip-monitor, got \"%s\" from \"%s\".", config.CloudEvents, config.CloudEventsSource)
+	}
+
+	t.Setenv("CLOUDEVENTS_MODE", "batched")
+	if _, err = NewConfig(context.Background()); err == nil {
+		t.Errorf("NewConfig should fail with CLOUDEVENTS_MODE=\"batched\".")
+	}
+
+}
@@ -0,0 +1,117 @@
+package dnswire
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// timeout bounds each query when the context has no earlier deadline.
+const timeout = 5 * time.Second
+
+// maxUDPSize is the largest answer read over UDP, advertised to the server
+// through EDNS0; larger ones are truncated by the server and asked again over
+// TCP.
+const maxUDPSize = 1232
+
+// Ask sends a query for the records of recordType of name to server
+// ("host:port") over UDP, and again over TCP when the answer is truncated.
+// Authoritative servers are asked with recursionDesired unset. The query
+// carries an EDNS0 record so servers answer up to maxUDPSize bytes over UDP
+// instead of 512, and UDP datagrams answering another query are dropped.
+func Ask(ctx context.Context, server string, name string, recordType uint16, recursionDesired bool) (Message, error) {
+
+	id := uint16(rand.N(1 << 16))
+	query, queryErr := NewQuery(id, name, recordType, recursionDesired)
+	if queryErr != nil {
+		return Message{}, queryErr
+	}
+	query = withEDNS(query)
+
+	var message Message
+	for _, network := range []string{"udp", "tcp"} {
+		response, exchangeErr := exchange(ctx, network, server, query)
+		if exchangeErr != nil {
+			return Message{}, fmt.Errorf("%s query to %s failed: %w", name, server, exchangeErr)
+		}
+		var parseErr error
+		message, parseErr = Parse(response)
+		if parseErr == nil && message.ID != id {
+			parseErr = fmt.Errorf("answer ID %d does not match query ID %d", message.ID, id)
+		}
+		if parseErr != nil {
+			return Message{}, fmt.Errorf("%s answer from %s cannot be read: %w", name, server, parseErr)
+		}
+		if !message.Truncated {
+			break
+		}
+	}
+	return message, nil
+}
+
+// withEDNS appends to query an OPT record advertising maxUDPSize as the UDP
+// payload size (RFC 6891 section 6.1.2).
+func withEDNS(query []byte) []byte {
+	binary.BigEndian.PutUint16(query[10:12], 1)
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, TypeOPT)
+	query = binary.BigEndian.AppendUint16(query, maxUDPSize)
+	// Extended rcode, version and flags, then an empty RDATA.
+	return append(query, 0, 0, 0, 0, 0, 0)
+}
+
+// exchange sends query to server over network and reads the answer. Over TCP
+// messages carry a two byte length prefix (RFC 1035 section 4.2.2). Over UDP
+// datagrams whose ID is not the one of query are dropped, as a late answer
+// to an earlier query or a spoofed one, and the next one is read until the
+// deadline.
+func exchange(ctx context.Context, network string, server string, query []byte) ([]byte, error) {
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, dialErr := dialer.DialContext(ctx, network, server)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	defer conn.Close()
+
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline || time.Until(deadline) > timeout {
+		deadline = time.Now().Add(timeout)
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if network == "udp" {
+		if _, writeErr := conn.Write(query); writeErr != nil {
+			return nil, writeErr
+		}
+		response := make([]byte, maxUDPSize)
+		for {
+			read, readErr := conn.Read(response)
+			if readErr != nil {
+				return nil, readErr
+			}
+			if read >= 2 && response[0] == query[0] && response[1] == query[1] {
+				return response[:read], nil
+			}
+		}
+	}
+
+	if _, writeErr := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); writeErr != nil {
+		return nil, writeErr
+	}
+	var length [2]byte
+	if _, readErr := io.ReadFull(conn, length[:]); readErr != nil {
+		return nil, readErr
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, readErr := io.ReadFull(conn, response); readErr != nil {
+		return nil, readErr
+	}
+	return response, nil
+}
@@ -0,0 +1,228 @@
+//go:build integration_tests || unit_tests || dnswire_tests || dnswire_unit_tests
+
+package dnswire
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serverMock is a local DNS server listening on the same port over UDP and
+// TCP. Each query is answered by answer, told the network it came from.
+type serverMock struct {
+	address    string
+	tcpQueries atomic.Int32
+	answer     func(query []byte, network string) []byte
+}
+
+func newServerMock(t *testing.T, answer func(query []byte, network string) []byte) *serverMock {
+	t.Helper()
+
+	conn, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Skipf("Cannot listen on 127.0.0.1: %v", listenErr)
+	}
+	t.Cleanup(func() { conn.Close() })
+	listener, listenErr := net.Listen("tcp", conn.LocalAddr().String())
+	if listenErr != nil {
+		t.Skipf("Cannot listen on %s: %v", conn.LocalAddr(), listenErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &serverMock{address: conn.LocalAddr().String(), answer: answer}
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, from, readErr := conn.ReadFrom(buffer)
+			if readErr != nil {
+				return
+			}
+			conn.WriteTo(server.answer(buffer[:n], "udp"), from)
+		}
+	}()
+	go func() {
+		for {
+			client, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			server.tcpQueries.Add(1)
+			var length [2]byte
+			if _, readErr := io.ReadFull(client, length[:]); readErr == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				io.ReadFull(client, query)
+				response := server.answer(query, "tcp")
+				client.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+			}
+			client.Close()
+		}
+	}()
+	return server
+}
+
+// reply returns the header and question of a response to query with rcode,
+// without the OPT record ending the query.
+func reply(query []byte, rcode byte) []byte {
+	response := slices.Clone(query[:len(query)-11])
+	binary.BigEndian.PutUint16(response[10:12], 0)
+	response[2] |= 0x80
+	response[3] = 0x80 | rcode
+	return response
+}
+
+// withAddress appends an A record of 79.116.1.2 for the question to response.
+func withAddress(response []byte) []byte {
+	binary.BigEndian.PutUint16(response[6:8], 1)
+	return append(response, 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x04, 79, 116, 1, 2)
+}
+
+func TestAskTruncatedRetriesOverTCP(t *testing.T) {
+
+	server := newServerMock(t, func(query []byte, network string) []byte {
+		if network == "udp" {
+			response := reply(query, RCodeSuccess)
+			response[2] |= 0x02
+			return response
+		}
+		return withAddress(reply(query, RCodeSuccess))
+	})
+
+	message, askErr := Ask(context.Background(), server.address, "home.example.com", TypeA, true)
+	if askErr != nil || message.Truncated {
+		t.Fatalf("Ask should read the full answer over TCP, got %+v (%v)", message, askErr)
+	}
+	if records := message.Records("home.example.com", TypeA); len(records) != 1 || records[0].Address != netip.MustParseAddr("79.116.1.2") {
+		t.Errorf("Ask read unexpected records %+v", records)
+	}
+	if queries := server.tcpQueries.Load(); queries != 1 {
+		t.Errorf("Ask should ask once over TCP, asked %d times", queries)
+	}
+
+}
+
+func TestAskUDPAnswer(t *testing.T) {
+
+	server := newServerMock(t, func(query []byte, network string) []byte {
+		return withAddress(reply(query, RCodeSuccess))
+	})
+
+	message, askErr := Ask(context.Background(), server.address, "home.example.com", TypeA, true)
+	if askErr != nil || len(message.Records("home.example.com", TypeA)) != 1 {
+		t.Errorf("Ask should read the UDP answer, got %+v (%v)", message, askErr)
+	}
+	if queries := server.tcpQueries.Load(); queries != 0 {
+		t.Errorf("Ask should not use TCP for an answer that is not truncated, asked %d times", queries)
+	}
+
+}
+
+func TestAskSendsEDNS(t *testing.T) {
+
+	opts := make(chan []byte, 1)
+	server := newServerMock(t, func(query []byte, network string) []byte {
+		opts <- slices.Clone(query[len(query)-11:])
+		return withAddress(reply(query, RCodeSuccess))
+	})
+
+	if _, askErr := Ask(context.Background(), server.address, "home.example.com", TypeA, true); askErr != nil {
+		t.Fatalf("Ask should not fail: %v", askErr)
+	}
+	if opt, expected := <-opts, []byte{0, 0x00, 0x29, 0x04, 0xd0, 0, 0, 0, 0, 0x00, 0x00}; !slices.Equal(opt, expected) {
+		t.Errorf("Ask should end the query with an OPT record advertising %d bytes, got %x", maxUDPSize, opt)
+	}
+
+}
+
+func TestAskSkipsAnotherUDPID(t *testing.T) {
+
+	conn, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Skipf("Cannot listen on 127.0.0.1: %v", listenErr)
+	}
+	defer conn.Close()
+	go func() {
+		buffer := make([]byte, 512)
+		n, from, readErr := conn.ReadFrom(buffer)
+		if readErr != nil {
+			return
+		}
+		// A late answer to another query comes first, then the right one.
+		stale := reply(buffer[:n], RCodeNameError)
+		stale[0] ^= 0xff
+		conn.WriteTo(stale, from)
+		conn.WriteTo(withAddress(reply(buffer[:n], RCodeSuccess)), from)
+	}()
+
+	message, askErr := Ask(context.Background(), conn.LocalAddr().String(), "home.example.com", TypeA, true)
+	if askErr != nil || message.RCode != RCodeSuccess || len(message.Records("home.example.com", TypeA)) != 1 {
+		t.Errorf("Ask should drop the answer to another query and read the next one, got %+v (%v)", message, askErr)
+	}
+
+}
+
+func TestAskRejectsAnotherID(t *testing.T) {
+
+	// Over UDP only answers to another query come, the query times out.
+	server := newServerMock(t, func(query []byte, network string) []byte {
+		response := withAddress(reply(query, RCodeSuccess))
+		response[0] ^= 0xff
+		return response
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if message, askErr := Ask(ctx, server.address, "home.example.com", TypeA, true); askErr == nil {
+		t.Errorf("Ask should not accept an answer to another query, got %+v", message)
+	}
+
+	// Over TCP the connection is ours, an answer to another query is an error.
+	server = newServerMock(t, func(query []byte, network string) []byte {
+		response := withAddress(reply(query, RCodeSuccess))
+		if network == "udp" {
+			response[2] |= 0x02
+		} else {
+			response[0] ^= 0xff
+		}
+		return response
+	})
+
+	if message, askErr := Ask(context.Background(), server.address, "home.example.com", TypeA, true); askErr == nil {
+		t.Errorf("Ask should reject a TCP answer to another query, got %+v", message)
+	}
+
+}
+
+func TestAskResponseCodes(t *testing.T) {
+
+	for _, test := range []struct {
+		rcode     byte
+		notFound  bool
+		temporary bool
+	}{
+		{RCodeNameError, true, false},
+		{RCodeServerFailure, false, true},
+		{RCodeRefused, false, false},
+	} {
+		server := newServerMock(t, func(query []byte, network string) []byte {
+			return reply(query, test.rcode)
+		})
+
+		message, askErr := Ask(context.Background(), server.address, "home.example.com", TypeA, true)
+		if askErr != nil {
+			t.Fatalf("Ask should read an answer with response code %d: %v", test.rcode, askErr)
+		}
+		var dnsErr *net.DNSError
+		if rcodeErr := message.Err("home.example.com", server.address); !errors.As(rcodeErr, &dnsErr) || dnsErr.IsNotFound != test.notFound || dnsErr.IsTemporary != test.temporary {
+			t.Errorf("Response code %d should map to a not found %t and temporary %t error, got %v", test.rcode, test.notFound, test.temporary, rcodeErr)
+		}
+	}
+
+}
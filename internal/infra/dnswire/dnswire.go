@@ -0,0 +1,349 @@
+package dnswire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// Record types used by the resolvers.
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypeSOA   uint16 = 6
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	TypeOPT   uint16 = 41
+)
+
+// Response codes (RFC 1035 and RFC 6895).
+const (
+	RCodeSuccess        = 0
+	RCodeFormatError    = 1
+	RCodeServerFailure  = 2
+	RCodeNameError      = 3
+	RCodeNotImplemented = 4
+	RCodeRefused        = 5
+)
+
+// classIN is the Internet class, the only one asked for.
+const classIN = 1
+
+// headerSize is the size of the fixed message header.
+const headerSize = 12
+
+// maxPointers bounds the compression pointers followed by a single name, so a
+// looping message cannot hang the parser.
+const maxPointers = 64
+
+// maxCNAMEs bounds the CNAME chain followed from the asked name, so a looping
+// chain cannot hang Records.
+const maxCNAMEs = 16
+
+// Record is a resource record of a message. Address is filled for A and AAAA
+// records, Target for NS and CNAME records and for the primary nameserver of
+// SOA records, Serial and Refresh (in seconds) for SOA records and Text for TXT
+// records.
+type Record struct {
+	Name    string
+	Type    uint16
+	TTL     uint32
+	Address netip.Addr
+	Target  string
+	Serial  uint32
+	Refresh uint32
+	Text    []string
+}
+
+// Message is a parsed DNS response.
+type Message struct {
+	ID                 uint16
+	Authoritative      bool
+	Truncated          bool
+	RecursionAvailable bool
+	RCode              int
+	Answers            []Record
+	Authority          []Record
+	Additional         []Record
+}
+
+// RecordType returns the address record type of family: A for IPv4 and AAAA
+// for IPv6.
+func RecordType(family domain.IPFamily) uint16 {
+	if family == domain.IPv6 {
+		return TypeAAAA
+	}
+	return TypeA
+}
+
+// NewQuery builds a query with the given ID asking for the records of
+// recordType of name. Authoritative servers are asked with recursionDesired
+// unset.
+func NewQuery(id uint16, name string, recordType uint16, recursionDesired bool) ([]byte, error) {
+
+	query := make([]byte, headerSize, headerSize+len(name)+6)
+	binary.BigEndian.PutUint16(query[0:2], id)
+	if recursionDesired {
+		query[2] = 0x01
+	}
+	binary.BigEndian.PutUint16(query[4:6], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("name \"%s\" is not a valid domain name", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	if len(query)-headerSize > 255 {
+		return nil, fmt.Errorf("name \"%s\" is longer than 255 bytes", name)
+	}
+	query = binary.BigEndian.AppendUint16(query, recordType)
+	return binary.BigEndian.AppendUint16(query, classIN), nil
+}
+
+// Parse parses a DNS response.
+func Parse(message []byte) (Message, error) {
+
+	if len(message) < headerSize {
+		return Message{}, errors.New("message is shorter than its header")
+	}
+	if message[2]&0x80 == 0 {
+		return Message{}, errors.New("message is not a response")
+	}
+
+	parsed := Message{
+		ID:                 binary.BigEndian.Uint16(message[0:2]),
+		Authoritative:      message[2]&0x04 != 0,
+		Truncated:          message[2]&0x02 != 0,
+		RecursionAvailable: message[3]&0x80 != 0,
+		RCode:              int(message[3] & 0x0f),
+	}
+	questions := int(binary.BigEndian.Uint16(message[4:6]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(message[6:8])),
+		int(binary.BigEndian.Uint16(message[8:10])),
+		int(binary.BigEndian.Uint16(message[10:12])),
+	}
+
+	offset := headerSize
+	for range questions {
+		_, next, nameErr := readName(message, offset)
+		if nameErr != nil {
+			return Message{}, nameErr
+		}
+		offset = next + 4
+	}
+
+	sections := []*[]Record{&parsed.Answers, &parsed.Authority, &parsed.Additional}
+	for index, section := range sections {
+		for range counts[index] {
+			record, next, recordErr := readRecord(message, offset)
+			if recordErr != nil {
+				// Truncated answers end early, keep what was read.
+				if parsed.Truncated {
+					return parsed, nil
+				}
+				return Message{}, recordErr
+			}
+			*section = append(*section, record)
+			offset = next
+		}
+	}
+	return parsed, nil
+}
+
+// readRecord reads the resource record at offset and returns it with the
+// offset of the next one.
+func readRecord(message []byte, offset int) (Record, int, error) {
+
+	name, offset, nameErr := readName(message, offset)
+	if nameErr != nil {
+		return Record{}, 0, nameErr
+	}
+	if offset+10 > len(message) {
+		return Record{}, 0, errors.New("record runs past the end of the message")
+	}
+	record := Record{
+		Name: name,
+		Type: binary.BigEndian.Uint16(message[offset : offset+2]),
+		TTL:  binary.BigEndian.Uint32(message[offset+4 : offset+8]),
+	}
+	length := int(binary.BigEndian.Uint16(message[offset+8 : offset+10]))
+	start := offset + 10
+	end := start + length
+	if end > len(message) {
+		return Record{}, 0, errors.New("record data runs past the end of the message")
+	}
+	data := message[start:end]
+
+	switch record.Type {
+	case TypeA, TypeAAAA:
+		address, valid := netip.AddrFromSlice(data)
+		if !valid || (record.Type == TypeA) != (length == 4) {
+			return Record{}, 0, fmt.Errorf("%s record holds %d bytes, not an address", name, length)
+		}
+		record.Address = address
+	case TypeNS, TypeCNAME, TypeSOA:
+		target, next, targetErr := readName(message, start)
+		if targetErr != nil {
+			return Record{}, 0, targetErr
+		}
+		record.Target = target
+		if record.Type != TypeSOA {
+			break
+		}
+		// The responsible mailbox follows, then the serial and the refresh
+		// interval.
+		_, next, mailboxErr := readName(message, next)
+		if mailboxErr != nil {
+			return Record{}, 0, mailboxErr
+		}
+		if next+20 > end {
+			return Record{}, 0, fmt.Errorf("%s SOA record is too short", name)
+		}
+		record.Serial = binary.BigEndian.Uint32(message[next : next+4])
+		record.Refresh = binary.BigEndian.Uint32(message[next+4 : next+8])
+	case TypeTXT:
+		for position := 0; position < len(data); {
+			size := int(data[position])
+			if position+1+size > len(data) {
+				return Record{}, 0, errors.New("TXT string runs past the end of the record")
+			}
+			record.Text = append(record.Text, string(data[position+1:position+1+size]))
+			position += 1 + size
+		}
+	}
+	return record, end, nil
+}
+
+// readName reads the possibly compressed name at offset and returns it,
+// lowercase and without the trailing dot, with the offset following it.
+func readName(message []byte, offset int) (string, int, error) {
+
+	var labels []string
+	next := -1
+	for pointers := 0; ; {
+		if offset >= len(message) {
+			return "", 0, errors.New("name runs past the end of the message")
+		}
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, nil
+
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(message) {
+				return "", 0, errors.New("name pointer runs past the end of the message")
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errors.New("name has too many compression pointers")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(message[offset:offset+2]) & 0x3fff)
+
+		case length&0xc0 != 0:
+			return "", 0, fmt.Errorf("name label type %#x is not supported", length&0xc0)
+
+		default:
+			if offset+1+length > len(message) {
+				return "", 0, errors.New("name label runs past the end of the message")
+			}
+			labels = append(labels, string(message[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// Records returns the answers of recordType owned by name, following the
+// CNAME chain from name first; the CNAME records are skipped. Answers owned by
+// any other name are not about name and are ignored.
+func (message Message) Records(name string, recordType uint16) []Record {
+	owner := strings.ToLower(strings.TrimSuffix(name, "."))
+	for range maxCNAMEs {
+		index := slices.IndexFunc(message.Answers, func(record Record) bool {
+			return record.Type == TypeCNAME && record.Name == owner
+		})
+		if index < 0 || recordType == TypeCNAME {
+			break
+		}
+		owner = message.Answers[index].Target
+	}
+
+	var records []Record
+	for _, record := range message.Answers {
+		if record.Type == recordType && record.Name == owner {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// AddressRecords returns the address records of family of name among the
+// answers as domain records, following its CNAME chain like Records.
+func (message Message) AddressRecords(name string, family domain.IPFamily) []domain.DNSRecord {
+	recordType, typeName := TypeA, "A"
+	if family == domain.IPv6 {
+		recordType, typeName = TypeAAAA, "AAAA"
+	}
+
+	var records []domain.DNSRecord
+	for _, record := range message.Records(name, recordType) {
+		records = append(records, domain.DNSRecord{Address: record.Address.String(), Type: typeName, TTL: time.Duration(record.TTL) * time.Second})
+	}
+	return records
+}
+
+// Err returns the error matching the response code of an answer to name
+// given by server, nil when it succeeded. Errors are *net.DNSError values, as
+// returned by net.Resolver: a name error is not found, a server failure is
+// temporary.
+func (message Message) Err(name string, server string) error {
+	dnsErr := &net.DNSError{Name: name, Server: server}
+	switch message.RCode {
+	case RCodeSuccess:
+		return nil
+	case RCodeNameError:
+		dnsErr.Err = "no such host"
+		dnsErr.IsNotFound = true
+	case RCodeServerFailure:
+		dnsErr.Err = "server misbehaving"
+		dnsErr.IsTemporary = true
+	case RCodeRefused:
+		dnsErr.Err = "query refused"
+	default:
+		dnsErr.Err = fmt.Sprintf("response code %d", message.RCode)
+	}
+	return dnsErr
+}
+
+// ResolveErr returns the error of an answer to name given by server when
+// resolving its address records. Unlike Err, a name error is not one: a name
+// that does not exist has no address records, as a name without records of
+// the asked type (NODATA).
+func (message Message) ResolveErr(name string, server string) error {
+	if message.RCode == RCodeNameError {
+		return nil
+	}
+	return message.Err(name, server)
+}
+
+// NotFoundError returns the error of an answer to name given by server that
+// holds no record of the asked type.
+func NotFoundError(name string, server string) error {
+	return &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+}
@@ -0,0 +1,185 @@
+//go:build integration_tests || unit_tests || dnswire_tests || dnswire_unit_tests
+
+package dnswire
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// response is an answer to "home.example.com" A: a CNAME to "edge.example.com"
+// followed by its A record and a TXT record, all names compressed against the
+// question.
+var response = []byte{
+	0x12, 0x34, 0x85, 0x80, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00,
+	// home.example.com A IN, at offset 12; "example.com" is at offset 17.
+	4, 'h', 'o', 'm', 'e', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x01, 0x00, 0x01,
+	// home.example.com CNAME edge.example.com, TTL 60.
+	0xc0, 0x0c, 0x00, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x07, 4, 'e', 'd', 'g', 'e', 0xc0, 0x11,
+	// edge.example.com A 79.116.1.2, TTL 300; the name points to the CNAME target at offset 46.
+	0xc0, 0x2e, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x04, 79, 116, 1, 2,
+	// edge.example.com TXT "v=1" "ok", TTL 30.
+	0xc0, 0x2e, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x1e, 0x00, 0x07, 3, 'v', '=', '1', 2, 'o', 'k',
+}
+
+func TestNewQuery(t *testing.T) {
+
+	query, queryErr := NewQuery(0x1234, "Home.example.com.", TypeAAAA, true)
+	expected := []byte{
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		4, 'H', 'o', 'm', 'e', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x1c, 0x00, 0x01,
+	}
+	if queryErr != nil || !bytes.Equal(query, expected) {
+		t.Errorf("NewQuery built an unexpected query %x (%v)", query, queryErr)
+	}
+
+	if query, _ := NewQuery(0, "example.com", TypeNS, false); query[2] != 0 {
+		t.Errorf("NewQuery should not set the recursion desired bit")
+	}
+
+	for _, name := range []string{"", "home..example.com", string(bytes.Repeat([]byte("a"), 64)) + ".com"} {
+		if _, queryErr := NewQuery(0, name, TypeA, true); queryErr == nil {
+			t.Errorf("NewQuery should fail with name %q", name)
+		}
+	}
+
+}
+
+func TestParse(t *testing.T) {
+
+	message, parseErr := Parse(response)
+	if parseErr != nil {
+		t.Fatalf("TestParse should not fail: %v", parseErr)
+	}
+	if message.ID != 0x1234 || !message.Authoritative || message.Truncated || !message.RecursionAvailable || message.RCode != RCodeSuccess {
+		t.Errorf("TestParse read an unexpected header %+v", message)
+	}
+	if len(message.Answers) != 3 {
+		t.Fatalf("TestParse should read 3 answers, got %+v", message.Answers)
+	}
+
+	cname := message.Answers[0]
+	if cname.Name != "home.example.com" || cname.Type != TypeCNAME || cname.TTL != 60 || cname.Target != "edge.example.com" {
+		t.Errorf("TestParse read an unexpected CNAME record %+v", cname)
+	}
+	records := message.Records("home.example.com", TypeA)
+	if len(records) != 1 || records[0].Name != "edge.example.com" || records[0].TTL != 300 || records[0].Address != netip.MustParseAddr("79.116.1.2") {
+		t.Errorf("TestParse read unexpected A records %+v", records)
+	}
+	if txt := message.Records("home.example.com", TypeTXT); len(txt) != 1 || !slices.Equal(txt[0].Text, []string{"v=1", "ok"}) {
+		t.Errorf("TestParse read unexpected TXT records %+v", txt)
+	}
+	if message.Err("home.example.com", "test") != nil {
+		t.Errorf("Err should be nil on a successful answer")
+	}
+
+	// example.com SOA ns1.example.com hostmaster.example.com, serial 2026061801,
+	// in the authority section of a name error.
+	nameError := slices.Clone(response[:34])
+	nameError[3] = 0x83
+	nameError[7], nameError[9] = 0, 1
+	nameError = append(nameError, 0xc0, 0x11, 0x00, 0x06, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x27,
+		3, 'n', 's', '1', 0xc0, 0x11, 10, 'h', 'o', 's', 't', 'm', 'a', 's', 't', 'e', 'r', 0xc0, 0x11,
+		0x78, 0xc3, 0x3f, 0xe9, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x00, 0x03, 0x84, 0x00, 0x09, 0x3a, 0x80, 0x00, 0x00, 0x0e, 0x10)
+	message, parseErr = Parse(nameError)
+	if parseErr != nil || message.RCode != RCodeNameError || len(message.Authority) != 1 {
+		t.Fatalf("TestParse should read the authority section of a name error, got %+v (%v)", message, parseErr)
+	}
+	if soa := message.Authority[0]; soa.Name != "example.com" || soa.Type != TypeSOA || soa.Target != "ns1.example.com" || soa.Serial != 2026061801 || soa.Refresh != 3600 {
+		t.Errorf("TestParse read an unexpected SOA record %+v", soa)
+	}
+
+}
+
+func TestParseInvalid(t *testing.T) {
+
+	looping := slices.Clone(response[:46])
+	looping[7] = 1
+	looping = append(looping, 0xc0, 0x2e, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04, 1, 2, 3, 4)
+	looping[46], looping[47] = 0xc0, 0x2e
+
+	query, _ := NewQuery(1, "example.com", TypeA, true)
+
+	for name, message := range map[string][]byte{
+		"short":       response[:8],
+		"query":       query,
+		"cut":         response[:len(response)-3],
+		"looping":     looping,
+		"bad address": append(slices.Clone(response[:57]), 0x00, 0x03, 79, 116, 1),
+	} {
+		if parsed, parseErr := Parse(message); parseErr == nil {
+			t.Errorf("Parse should fail with a %s message, got %+v", name, parsed)
+		}
+	}
+
+	truncated := slices.Clone(response[:len(response)-3])
+	truncated[2] |= 0x02
+	if message, parseErr := Parse(truncated); parseErr != nil || !message.Truncated || len(message.Answers) != 2 {
+		t.Errorf("Parse should keep the answers read from a truncated message, got %+v (%v)", message, parseErr)
+	}
+
+}
+
+func TestRecords(t *testing.T) {
+
+	address := netip.MustParseAddr("79.116.1.2")
+	message := Message{Answers: []Record{
+		{Name: "other.example.com", Type: TypeA, Address: netip.MustParseAddr("192.0.2.1")},
+		{Name: "home.example.com", Type: TypeCNAME, Target: "edge.example.com"},
+		{Name: "edge.example.com", Type: TypeCNAME, Target: "cdn.example.net"},
+		{Name: "cdn.example.net", Type: TypeA, TTL: 60, Address: address},
+	}}
+
+	if records := message.Records("Home.example.com.", TypeA); len(records) != 1 || records[0].Address != address {
+		t.Errorf("Records should follow the CNAME chain to the A record, got %+v", records)
+	}
+	if records := message.AddressRecords("home.example.com", domain.IPv4); len(records) != 1 || records[0].Address != "79.116.1.2" || records[0].TTL != time.Minute {
+		t.Errorf("AddressRecords should follow the CNAME chain to the A record, got %+v", records)
+	}
+	if records := message.Records("home.example.com", TypeCNAME); len(records) != 1 || records[0].Target != "edge.example.com" {
+		t.Errorf("Records should return the CNAME record of the name, got %+v", records)
+	}
+	if records := message.Records("www.example.com", TypeA); len(records) != 0 {
+		t.Errorf("Records should ignore the records of another name, got %+v", records)
+	}
+
+	looping := Message{Answers: []Record{
+		{Name: "home.example.com", Type: TypeCNAME, Target: "edge.example.com"},
+		{Name: "edge.example.com", Type: TypeCNAME, Target: "home.example.com"},
+		{Name: "edge.example.com", Type: TypeA, Address: address},
+	}}
+	if records := looping.Records("home.example.com", TypeA); len(records) > 1 {
+		t.Errorf("Records should stop following a looping CNAME chain, got %+v", records)
+	}
+
+}
+
+func TestErr(t *testing.T) {
+
+	var dnsErr *net.DNSError
+	notFound := Message{RCode: RCodeNameError}.Err("home.example.com", "test")
+	if !errors.As(notFound, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Err should return a not found error on a name error, got %v", notFound)
+	}
+	serverFailure := Message{RCode: RCodeServerFailure}.Err("home.example.com", "test")
+	if !errors.As(serverFailure, &dnsErr) || !dnsErr.IsTemporary || dnsErr.IsNotFound {
+		t.Errorf("Err should return a temporary error on a server failure, got %v", serverFailure)
+	}
+	if (Message{RCode: RCodeNameError}).ResolveErr("home.example.com", "test") != nil {
+		t.Errorf("ResolveErr should accept a name error")
+	}
+	if resolveErr := (Message{RCode: RCodeRefused}).ResolveErr("home.example.com", "test"); !errors.As(resolveErr, &dnsErr) {
+		t.Errorf("ResolveErr should return the error of a refused query, got %v", resolveErr)
+	}
+	if !errors.As(NotFoundError("home.example.com", "test"), &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("NotFoundError should return a not found error")
+	}
+
+}
@@ -0,0 +1,233 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	dnswire "github.com/a-castellano/home-ip-monitor/internal/infra/dnswire"
+)
+
+// Request formats of a DNS-over-HTTPS server.
+const (
+	ModePost = "post" // RFC 8484 wire format message sent as the POST body
+	ModeGet  = "get"  // RFC 8484 wire format message sent in the "dns" GET parameter
+	ModeJSON = "json" // JSON API (application/dns-json) of Google and Cloudflare
+)
+
+// DefaultURL is the DNS-over-HTTPS endpoint asked when none is configured.
+const DefaultURL = "https://cloudflare-dns.com/dns-query"
+
+// maxBodySize bounds the response bodies read, a DNS message is at most 64KiB.
+const maxBodySize = 64 << 10
+
+// Resolver is the DNS-over-HTTPS (RFC 8484) adapter of domain.DNSResolver. It
+// resolves the domain records through the server at URL, using the injected
+// *http.Client, so the answer cannot be rewritten by a proxy intercepting the
+// port 53 traffic. Mode is one of ModePost (the default), ModeGet or ModeJSON.
+type Resolver struct {
+	HttpClient *http.Client
+	URL        string
+	Mode       string
+}
+
+// jsonAnswer is the JSON API answer, only the fields used are decoded.
+type jsonAnswer struct {
+	Status int  `json:"Status"`
+	TC     bool `json:"TC"`
+	Answer []struct {
+		Name string `json:"name"`
+		Type uint16 `json:"type"`
+		TTL  uint32 `json:"TTL"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+// NewHTTPClient returns an HTTP/2 capable client for a DNS-over-HTTPS server.
+// When bootstrap addresses are given the server is reached on them, in
+// order, instead of resolving its host name, so the resolver does not depend
+// on the system DNS; the TLS certificate is still verified against the host
+// name of the URL.
+func NewHTTPClient(bootstrap []netip.Addr, timeout time.Duration) *http.Client {
+
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: timeout,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	if len(bootstrap) > 0 {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			_, port, splitErr := net.SplitHostPort(address)
+			if splitErr != nil {
+				return nil, splitErr
+			}
+			var errs []error
+			for _, server := range bootstrap {
+				conn, dialErr := dialer.DialContext(ctx, network, net.JoinHostPort(server.String(), port))
+				if dialErr == nil {
+					return conn, nil
+				}
+				errs = append(errs, dialErr)
+			}
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// Resolve resolves the given domain to the address records of the requested
+// family through the DNS-over-HTTPS server. It implements domain.DNSResolver.
+func (resolver Resolver) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+
+	log := logger.FromContext(ctx).With("operation", "doh.Resolve")
+
+	recordType := dnswire.RecordType(family)
+	var message dnswire.Message
+	var queryErr error
+	if resolver.Mode == ModeJSON {
+		message, queryErr = resolver.askJSON(ctx, domainName, recordType)
+	} else {
+		message, queryErr = resolver.askWire(ctx, domainName, recordType)
+	}
+	if queryErr == nil {
+		queryErr = message.ResolveErr(domainName, resolver.URL)
+	}
+	if queryErr != nil {
+		log.ErrorContext(ctx, "Error during domain DNS-over-HTTPS lookup", "domain", domainName, "family", family, "url", resolver.URL, "error", queryErr)
+		return nil, queryErr
+	}
+
+	records := message.AddressRecords(domainName, family)
+	// No record is an answer too: the monitor republishes the missing one.
+	if len(records) == 0 {
+		log.WarnContext(ctx, "Domain has no record of the family", "domain", domainName, "family", family, "url", resolver.URL)
+		return records, nil
+	}
+
+	log.InfoContext(ctx, "domain ip retrived", "domain", domainName, "family", family, "records", records)
+	return records, nil
+}
+
+// askWire sends a wire format query with the POST or GET method. The query ID
+// is zero, as RFC 8484 recommends for cache friendliness.
+func (resolver Resolver) askWire(ctx context.Context, domainName string, recordType uint16) (dnswire.Message, error) {
+
+	query, queryErr := dnswire.NewQuery(0, domainName, recordType, true)
+	if queryErr != nil {
+		return dnswire.Message{}, queryErr
+	}
+
+	var request *http.Request
+	var requestErr error
+	if resolver.Mode == ModeGet {
+		request, requestErr = resolver.newRequest(ctx, url.Values{"dns": {base64.RawURLEncoding.EncodeToString(query)}})
+	} else {
+		request, requestErr = http.NewRequestWithContext(ctx, http.MethodPost, resolver.URL, bytes.NewReader(query))
+		if requestErr == nil {
+			request.Header.Set("Content-Type", "application/dns-message")
+		}
+	}
+	if requestErr != nil {
+		return dnswire.Message{}, requestErr
+	}
+	request.Header.Set("Accept", "application/dns-message")
+
+	body, bodyErr := resolver.do(request)
+	if bodyErr != nil {
+		return dnswire.Message{}, bodyErr
+	}
+	return dnswire.Parse(body)
+}
+
+// askJSON sends a JSON API query and converts its answer to a message.
+func (resolver Resolver) askJSON(ctx context.Context, domainName string, recordType uint16) (dnswire.Message, error) {
+
+	request, requestErr := resolver.newRequest(ctx, url.Values{"name": {domainName}, "type": {strconv.Itoa(int(recordType))}})
+	if requestErr != nil {
+		return dnswire.Message{}, requestErr
+	}
+	request.Header.Set("Accept", "application/dns-json")
+
+	body, bodyErr := resolver.do(request)
+	if bodyErr != nil {
+		return dnswire.Message{}, bodyErr
+	}
+	var answer jsonAnswer
+	if unmarshalErr := json.Unmarshal(body, &answer); unmarshalErr != nil {
+		return dnswire.Message{}, fmt.Errorf("JSON answer cannot be decoded: %w", unmarshalErr)
+	}
+
+	message := dnswire.Message{RCode: answer.Status, Truncated: answer.TC}
+	for _, answerRecord := range answer.Answer {
+		// Names are compared the way the wire format parser returns them.
+		record := dnswire.Record{Name: jsonName(answerRecord.Name), Type: answerRecord.Type, TTL: answerRecord.TTL}
+		switch record.Type {
+		case dnswire.TypeA, dnswire.TypeAAAA:
+			address, parseErr := netip.ParseAddr(answerRecord.Data)
+			if parseErr != nil {
+				return dnswire.Message{}, fmt.Errorf("JSON answer holds an invalid address: %w", parseErr)
+			}
+			record.Address = address
+		case dnswire.TypeCNAME:
+			record.Target = jsonName(answerRecord.Data)
+		}
+		message.Answers = append(message.Answers, record)
+	}
+	return message, nil
+}
+
+// jsonName returns name of the JSON API lower-cased and without its trailing
+// dot.
+func jsonName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// newRequest returns a GET request to the server URL with parameters added
+// to its query string.
+func (resolver Resolver) newRequest(ctx context.Context, parameters url.Values) (*http.Request, error) {
+
+	requestURL, parseErr := url.Parse(resolver.URL)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	query := requestURL.Query()
+	for name, values := range parameters {
+		query[name] = values
+	}
+	requestURL.RawQuery = query.Encode()
+	return http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+}
+
+// do sends request and returns the body of a successful answer.
+func (resolver Resolver) do(request *http.Request) ([]byte, error) {
+
+	response, responseErr := resolver.HttpClient.Do(request)
+	if responseErr != nil {
+		return nil, responseErr
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS server returned status code %d", response.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxBodySize))
+}
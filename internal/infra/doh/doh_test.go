@@ -0,0 +1,141 @@
+//go:build integration_tests || unit_tests || doh_tests || doh_unit_tests
+
+package doh
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// answerQuery builds the response to a wire format query: the A and AAAA
+// questions of home.example.com are answered, other names get a name error.
+func answerQuery(query []byte) []byte {
+
+	response := append([]byte{}, query...)
+	response[2] |= 0x80
+	response[3] = 0x80
+	questionType := binary.BigEndian.Uint16(query[len(query)-4 : len(query)-2])
+
+	var data []byte
+	switch {
+	case string(query[12:len(query)-4]) != "\x04home\x07example\x03com\x00":
+		response[3] |= 3
+		return response
+	case questionType == 1:
+		data = []byte{79, 116, 1, 2}
+	case questionType == 28:
+		data = netip.MustParseAddr("2a0c:5a80::1").AsSlice()
+	}
+	binary.BigEndian.PutUint16(response[6:8], 1)
+	response = append(response, 0xc0, 0x0c)
+	response = binary.BigEndian.AppendUint16(response, questionType)
+	response = append(response, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(data)))
+	return append(response, data...)
+}
+
+// newServerMock starts a DNS-over-HTTPS server answering wire format POST and
+// GET queries and JSON API queries on /dns-query.
+func newServerMock(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var query []byte
+		switch {
+		case request.URL.Path != "/dns-query":
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		case request.Method == http.MethodPost && request.Header.Get("Content-Type") == "application/dns-message":
+			query, _ = io.ReadAll(request.Body)
+		case request.URL.Query().Has("dns"):
+			query, _ = base64.RawURLEncoding.DecodeString(request.URL.Query().Get("dns"))
+		case request.Header.Get("Accept") == "application/dns-json":
+			writer.Header().Set("Content-Type", "application/dns-json")
+			if request.URL.Query().Get("name") != "home.example.com" {
+				fmt.Fprint(writer, `{"Status":3}`)
+				return
+			}
+			fmt.Fprintf(writer, `{"Status":0,"TC":false,"Answer":[{"name":"Home.example.com.","type":5,"TTL":60,"data":"edge.example.com."},{"name":"edge.example.com.","type":%s,"TTL":300,"data":"79.116.1.3"}]}`, request.URL.Query().Get("type"))
+			return
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if binary.BigEndian.Uint16(query[0:2]) != 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/dns-message")
+		writer.Write(answerQuery(query))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolve(t *testing.T) {
+
+	server := newServerMock(t)
+
+	for _, test := range []struct {
+		mode   string
+		family domain.IPFamily
+		record domain.DNSRecord
+	}{
+		{ModePost, domain.IPv4, domain.DNSRecord{Address: "79.116.1.2", Type: "A", TTL: 300 * time.Second}},
+		{"", domain.IPv6, domain.DNSRecord{Address: "2a0c:5a80::1", Type: "AAAA", TTL: 300 * time.Second}},
+		{ModeGet, domain.IPv4, domain.DNSRecord{Address: "79.116.1.2", Type: "A", TTL: 300 * time.Second}},
+		{ModeJSON, domain.IPv4, domain.DNSRecord{Address: "79.116.1.3", Type: "A", TTL: 300 * time.Second}},
+	} {
+		resolver := Resolver{HttpClient: server.Client(), URL: server.URL + "/dns-query", Mode: test.mode}
+		records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", test.family)
+		if resolveErr != nil || len(records) != 1 || records[0] != test.record {
+			t.Errorf("Resolve in mode %q should return %+v, got %+v (%v)", test.mode, test.record, records, resolveErr)
+		}
+	}
+
+}
+
+func TestResolveErrors(t *testing.T) {
+
+	server := newServerMock(t)
+
+	for _, mode := range []string{ModePost, ModeGet, ModeJSON} {
+		resolver := Resolver{HttpClient: server.Client(), URL: server.URL + "/dns-query", Mode: mode}
+		if records, resolveErr := resolver.Resolve(context.Background(), "missing.example.com", domain.IPv4); resolveErr != nil || len(records) != 0 {
+			t.Errorf("Resolve in mode %q should return no record on a name error, got %+v (%v)", mode, records, resolveErr)
+		}
+	}
+
+	resolver := Resolver{HttpClient: server.Client(), URL: server.URL + "/missing"}
+	if _, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr == nil {
+		t.Errorf("Resolve should fail when the server does not answer 200")
+	}
+
+}
+
+func TestBootstrap(t *testing.T) {
+
+	server := newServerMock(t)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// The test certificate is valid for example.com, which is only reachable
+	// through the bootstrap addresses; the server only listens on the second.
+	client := NewHTTPClient([]netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}, 2*time.Second)
+	client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	resolver := Resolver{HttpClient: client, URL: "https://example.com:" + port + "/dns-query"}
+	if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr != nil || len(records) != 1 || records[0].Address != "79.116.1.2" {
+		t.Errorf("Resolve should reach the server through its bootstrap address, got %+v (%v)", records, resolveErr)
+	}
+
+}
@@ -0,0 +1,243 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	dnswire "github.com/a-castellano/home-ip-monitor/internal/infra/dnswire"
+)
+
+// DefaultServer and DefaultServerName are the DNS-over-TLS server asked when
+// none is configured.
+const (
+	DefaultServer     = "1.1.1.1:853"
+	DefaultServerName = "cloudflare-dns.com"
+)
+
+// DefaultPort is the DNS-over-TLS port (RFC 7858).
+const DefaultPort = "853"
+
+// timeout bounds the connection and each query when the context has no
+// earlier deadline.
+const timeout = 5 * time.Second
+
+// idleTimeout is how long an unused connection is kept. Servers close idle
+// connections after a few seconds, so in practice a connection is reused by
+// the queries of a single run.
+const idleTimeout = 10 * time.Second
+
+// ParsePin decodes a pin written as the base64 SHA-256 digest of a
+// certificate SubjectPublicKeyInfo, as printed by
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func ParsePin(value string) ([]byte, error) {
+	pin, decodeErr := base64.StdEncoding.DecodeString(value)
+	if decodeErr != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("pin \"%s\" is not a base64 SHA-256 digest", value)
+	}
+	return pin, nil
+}
+
+// Resolver is the DNS-over-TLS (RFC 7858) adapter of domain.DNSResolver. It
+// resolves the domain records through Server ("host:port"), verifying its
+// certificate against ServerName, which is also sent as SNI. When Pins are
+// set, one of the certificates of the chain must also have one of those
+// SubjectPublicKeyInfo digests, so a proxy holding a certificate from another
+// trusted authority is rejected too. Certificates are verified against the
+// system roots unless RootCAs is set.
+//
+// The connection is kept open and reused by the following queries while it
+// is not idle for long. A Resolver must not be copied after first use.
+type Resolver struct {
+	Server     string
+	ServerName string
+	Pins       [][]byte
+	RootCAs    *x509.CertPool
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	lastUsed time.Time
+}
+
+// Resolve resolves the given domain to the address records of the requested
+// family through the DNS-over-TLS server. It implements domain.DNSResolver.
+func (resolver *Resolver) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+
+	log := logger.FromContext(ctx).With("operation", "dot.Resolve")
+
+	recordType := dnswire.RecordType(family)
+	message, queryErr := resolver.ask(ctx, domainName, recordType)
+	if queryErr == nil {
+		queryErr = message.ResolveErr(domainName, resolver.Server)
+	}
+	if queryErr != nil {
+		log.ErrorContext(ctx, "Error during domain DNS-over-TLS lookup", "domain", domainName, "family", family, "server", resolver.Server, "error", queryErr)
+		return nil, queryErr
+	}
+
+	records := message.AddressRecords(domainName, family)
+	// No record is an answer too: the monitor republishes the missing one.
+	if len(records) == 0 {
+		log.WarnContext(ctx, "Domain has no record of the family", "domain", domainName, "family", family, "server", resolver.Server)
+		return records, nil
+	}
+
+	log.InfoContext(ctx, "domain ip retrived", "domain", domainName, "family", family, "records", records)
+	return records, nil
+}
+
+// Close closes the open connection, if any.
+func (resolver *Resolver) Close() error {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	return resolver.closeConn()
+}
+
+func (resolver *Resolver) closeConn() error {
+	if resolver.conn == nil {
+		return nil
+	}
+	closeErr := resolver.conn.Close()
+	resolver.conn = nil
+	return closeErr
+}
+
+// ask sends a query for the records of recordType of name and reads its
+// answer. A query on a reused connection the server closed meanwhile is sent
+// again on a new one.
+func (resolver *Resolver) ask(ctx context.Context, name string, recordType uint16) (dnswire.Message, error) {
+
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+
+	id := uint16(rand.N(1 << 16))
+	query, queryErr := dnswire.NewQuery(id, name, recordType, true)
+	if queryErr != nil {
+		return dnswire.Message{}, queryErr
+	}
+
+	if resolver.conn != nil && time.Since(resolver.lastUsed) > idleTimeout {
+		resolver.closeConn()
+	}
+	reused := resolver.conn != nil
+
+	for {
+		if resolver.conn == nil {
+			conn, dialErr := resolver.dial(ctx)
+			if dialErr != nil {
+				return dnswire.Message{}, dialErr
+			}
+			resolver.conn = conn
+		}
+
+		response, exchangeErr := resolver.exchange(ctx, query)
+		if exchangeErr != nil {
+			resolver.closeConn()
+			if reused && ctx.Err() == nil {
+				reused = false
+				continue
+			}
+			return dnswire.Message{}, exchangeErr
+		}
+		resolver.lastUsed = time.Now()
+
+		message, parseErr := dnswire.Parse(response)
+		if parseErr == nil && message.ID != id {
+			parseErr = fmt.Errorf("answer ID %d does not match query ID %d", message.ID, id)
+		}
+		if parseErr != nil {
+			resolver.closeConn()
+		}
+		return message, parseErr
+	}
+}
+
+// dial opens a TLS connection to the server, verified against ServerName and
+// Pins.
+func (resolver *Resolver) dial(ctx context.Context) (net.Conn, error) {
+
+	server := resolver.Server
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		server = net.JoinHostPort(server, DefaultPort)
+	}
+	serverName := resolver.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(server)
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config: &tls.Config{
+			ServerName:       serverName,
+			RootCAs:          resolver.RootCAs,
+			MinVersion:       tls.VersionTLS12,
+			VerifyConnection: resolver.verifyPins,
+		},
+	}
+	conn, dialErr := dialer.DialContext(ctx, "tcp", server)
+	if dialErr != nil {
+		return nil, fmt.Errorf("DNS-over-TLS server %s cannot be reached: %w", server, dialErr)
+	}
+	return conn, nil
+}
+
+// verifyPins checks that a certificate of the presented chain has one of the
+// pinned SubjectPublicKeyInfo digests.
+func (resolver *Resolver) verifyPins(state tls.ConnectionState) error {
+	if len(resolver.Pins) == 0 {
+		return nil
+	}
+	for _, certificate := range state.PeerCertificates {
+		digest := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+		for _, pin := range resolver.Pins {
+			if bytes.Equal(digest[:], pin) {
+				return nil
+			}
+		}
+	}
+	return errors.New("no certificate of the server matches the pinned public keys")
+}
+
+// exchange writes query with its two byte length prefix (RFC 1035 section
+// 4.2.2) and reads the answer.
+func (resolver *Resolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline || time.Until(deadline) > timeout {
+		deadline = time.Now().Add(timeout)
+	}
+	conn := resolver.conn
+	conn.SetDeadline(deadline)
+
+	// Unblock the connection when the context is canceled meanwhile.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, writeErr := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); writeErr != nil {
+		return nil, writeErr
+	}
+
+	var length [2]byte
+	if _, readErr := io.ReadFull(conn, length[:]); readErr != nil {
+		return nil, readErr
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, readErr := io.ReadFull(conn, response); readErr != nil {
+		return nil, readErr
+	}
+	return response, nil
+}
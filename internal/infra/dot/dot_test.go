@@ -0,0 +1,186 @@
+//go:build integration_tests || unit_tests || dot_tests || dot_unit_tests
+
+package dot
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// serverMock is a local DNS-over-TLS server answering every A question with
+// 79.116.1.2 and every AAAA question with a name error. It closes each
+// connection after closeAfter answers, never when zero.
+type serverMock struct {
+	listener    net.Listener
+	certificate *x509.Certificate
+	connections atomic.Int32
+	closeAfter  int
+}
+
+func newServerMock(t *testing.T, closeAfter int) *serverMock {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example.test"},
+		DNSNames:              []string{"dns.example.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	raw, certificateErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if certificateErr != nil {
+		t.Fatal(certificateErr)
+	}
+	certificate, _ := x509.ParseCertificate(raw)
+
+	listener, listenErr := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{raw}, PrivateKey: key}}})
+	if listenErr != nil {
+		t.Skipf("Cannot listen on 127.0.0.1: %v", listenErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &serverMock{listener: listener, certificate: certificate, closeAfter: closeAfter}
+	go server.serve()
+	return server
+}
+
+func (server *serverMock) serve() {
+	for {
+		conn, acceptErr := server.listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		server.connections.Add(1)
+		go server.answer(conn)
+	}
+}
+
+// answer answers the queries sent on conn.
+func (server *serverMock) answer(conn net.Conn) {
+	defer conn.Close()
+	for answered := 0; server.closeAfter == 0 || answered < server.closeAfter; answered++ {
+		var length [2]byte
+		if _, readErr := io.ReadFull(conn, length[:]); readErr != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, readErr := io.ReadFull(conn, query); readErr != nil {
+			return
+		}
+
+		response := append([]byte{}, query...)
+		response[2] |= 0x80
+		response[3] = 0x80
+		if binary.BigEndian.Uint16(query[len(query)-4:len(query)-2]) == 1 {
+			binary.BigEndian.PutUint16(response[6:8], 1)
+			response = append(response, 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x04, 79, 116, 1, 2)
+		} else {
+			response[3] |= 3
+		}
+		conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+	}
+}
+
+// resolver returns a Resolver of the server trusting its certificate.
+func (server *serverMock) resolver(pins ...[]byte) *Resolver {
+	roots := x509.NewCertPool()
+	roots.AddCert(server.certificate)
+	return &Resolver{Server: server.listener.Addr().String(), ServerName: "dns.example.test", Pins: pins, RootCAs: roots}
+}
+
+func TestResolveReusesConnection(t *testing.T) {
+
+	server := newServerMock(t, 0)
+	resolver := server.resolver()
+	defer resolver.Close()
+
+	for range 3 {
+		if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr != nil || len(records) != 1 || records[0].Address != "79.116.1.2" {
+			t.Fatalf("Resolve should return 79.116.1.2, got %+v (%v)", records, resolveErr)
+		}
+	}
+	if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv6); resolveErr != nil || len(records) != 0 {
+		t.Errorf("Resolve should return no record on a name error, got %+v (%v)", records, resolveErr)
+	}
+	if connections := server.connections.Load(); connections != 1 {
+		t.Errorf("Resolve should reuse a single connection, opened %d", connections)
+	}
+
+}
+
+func TestResolveReconnects(t *testing.T) {
+
+	server := newServerMock(t, 1)
+	resolver := server.resolver()
+	defer resolver.Close()
+
+	for range 2 {
+		if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr != nil || len(records) != 1 || records[0].Address != "79.116.1.2" {
+			t.Fatalf("Resolve should return 79.116.1.2, got %+v (%v)", records, resolveErr)
+		}
+	}
+	if connections := server.connections.Load(); connections != 2 {
+		t.Errorf("Resolve should open a new connection after the server closed it, opened %d", connections)
+	}
+
+}
+
+func TestResolvePins(t *testing.T) {
+
+	server := newServerMock(t, 0)
+	digest := sha256.Sum256(server.certificate.RawSubjectPublicKeyInfo)
+	otherDigest := sha256.Sum256([]byte("other key"))
+
+	resolver := server.resolver(otherDigest[:], digest[:])
+	if records, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr != nil || len(records) != 1 || records[0].Address != "79.116.1.2" {
+		t.Errorf("Resolve should accept a server matching a pin, got %+v (%v)", records, resolveErr)
+	}
+	resolver.Close()
+
+	resolver = server.resolver(otherDigest[:])
+	if _, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr == nil {
+		t.Errorf("Resolve should reject a server matching no pin")
+	}
+
+	resolver = server.resolver()
+	resolver.ServerName = "other.example.test"
+	if _, resolveErr := resolver.Resolve(context.Background(), "home.example.com", domain.IPv4); resolveErr == nil {
+		t.Errorf("Resolve should reject a certificate of another name")
+	}
+
+}
+
+func TestParsePin(t *testing.T) {
+
+	digest := sha256.Sum256([]byte("key"))
+	if pin, parseErr := ParsePin(base64.StdEncoding.EncodeToString(digest[:])); parseErr != nil || string(pin) != string(digest[:]) {
+		t.Errorf("ParsePin should decode a base64 digest, got %x (%v)", pin, parseErr)
+	}
+	for _, value := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, parseErr := ParsePin(value); parseErr == nil {
+			t.Errorf("ParsePin should fail with %q", value)
+		}
+	}
+
+}
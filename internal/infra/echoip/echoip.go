@@ -0,0 +1,225 @@
+package echoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// Endpoint is a "what is my IP" service answering with the address the
+// request came from. Extractor tells how the address is read from the body:
+//
+//   - "text": the whole body is the address (ipify, icanhazip, checkip.amazonaws.com);
+//   - "json:<field>": the body is a JSON object holding the address in field
+//     (ifconfig.co/json);
+//   - "find": the first address found anywhere in the body, for HTML pages
+//     such as checkip.dyndns.org.
+type Endpoint struct {
+	URL       string
+	Extractor string
+}
+
+// DefaultEndpoints are the services asked when none are configured. Services
+// listening on a single family come in pairs, so dual-stack hosts get both
+// addresses.
+var DefaultEndpoints = []Endpoint{
+	{URL: "https://api.ipify.org", Extractor: "text"},
+	{URL: "https://api6.ipify.org", Extractor: "text"},
+	{URL: "https://ipv4.icanhazip.com", Extractor: "text"},
+	{URL: "https://ipv6.icanhazip.com", Extractor: "text"},
+	{URL: "https://ifconfig.co/json", Extractor: "json:ip"},
+	{URL: "https://checkip.amazonaws.com", Extractor: "text"},
+}
+
+// maxBodySize bounds the response bodies read, echo services answer with a
+// few bytes.
+const maxBodySize = 64 << 10
+
+// addressPattern matches IPv4 and IPv6 looking tokens for the "find" extractor;
+// candidates are then validated by netip.ParseAddr.
+var addressPattern = regexp.MustCompile(`[0-9A-Fa-f:.]*[:.][0-9A-Fa-f:.]+`)
+
+// ParseEndpoint parses an endpoint written as "url[|extractor]", where the
+// extractor defaults to "text".
+func ParseEndpoint(value string) (Endpoint, error) {
+
+	rawURL, extractor, _ := strings.Cut(strings.TrimSpace(value), "|")
+	endpoint := Endpoint{URL: strings.TrimSpace(rawURL), Extractor: strings.TrimSpace(extractor)}
+	if endpoint.Extractor == "" {
+		endpoint.Extractor = "text"
+	}
+
+	parsedURL, parseErr := url.Parse(endpoint.URL)
+	if parseErr != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return Endpoint{}, fmt.Errorf("endpoint \"%s\" must start with an http or https URL", value)
+	}
+	if extractorErr := endpoint.validExtractor(); extractorErr != nil {
+		return Endpoint{}, extractorErr
+	}
+	return endpoint, nil
+}
+
+// String returns the endpoint as accepted by ParseEndpoint.
+func (endpoint Endpoint) String() string {
+	return endpoint.URL + "|" + endpoint.Extractor
+}
+
+func (endpoint Endpoint) validExtractor() error {
+	switch {
+	case endpoint.Extractor == "text", endpoint.Extractor == "find":
+		return nil
+	case strings.HasPrefix(endpoint.Extractor, "json:") && len(endpoint.Extractor) > len("json:"):
+		return nil
+	}
+	return fmt.Errorf("endpoint %s has an unknown extractor \"%s\", expected \"text\", \"json:<field>\" or \"find\"", endpoint.URL, endpoint.Extractor)
+}
+
+// extract reads the address from body with the endpoint extractor.
+func (endpoint Endpoint) extract(body []byte) (netip.Addr, error) {
+
+	switch {
+	case endpoint.Extractor == "text":
+		return netip.ParseAddr(strings.TrimSpace(string(body)))
+
+	case endpoint.Extractor == "find":
+		for _, candidate := range addressPattern.FindAllString(string(body), -1) {
+			if address, parseErr := netip.ParseAddr(strings.Trim(candidate, ".:")); parseErr == nil {
+				return address, nil
+			}
+		}
+		return netip.Addr{}, errors.New("no address found in the response")
+
+	case strings.HasPrefix(endpoint.Extractor, "json:"):
+		var fields map[string]any
+		if unmarshalErr := json.Unmarshal(body, &fields); unmarshalErr != nil {
+			return netip.Addr{}, unmarshalErr
+		}
+		field := strings.TrimPrefix(endpoint.Extractor, "json:")
+		value, isString := fields[field].(string)
+		if !isString {
+			return netip.Addr{}, fmt.Errorf("response has no \"%s\" string field", field)
+		}
+		return netip.ParseAddr(strings.TrimSpace(value))
+	}
+	return netip.Addr{}, endpoint.validExtractor()
+}
+
+// Requester is the echo service HTTP adapter. It implements
+// domain.IPInfoProvider by asking Endpoints in order, using the injected
+// *http.Client, until an address of every family in Families is known; when
+// Families is empty only IPv4 is detected. Each answer fills the family it
+// belongs to, so a service answering over the other family is not wasted.
+//
+// Echo services do not report the organization, so Enricher, when set, is
+// asked for the ASN and organization of the detected addresses.
+type Requester struct {
+	HttpClient *http.Client
+	Endpoints  []Endpoint
+	Families   []domain.IPFamily
+	Enricher   domain.IPInfoEnricher
+}
+
+// GetIPInfo detects the public address of every configured family. A family
+// no endpoint answered for is left empty; an error is returned when no family
+// could be detected or when the enricher fails.
+func (requester Requester) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "echoip.GetIPInfo")
+
+	families := requester.Families
+	if len(families) == 0 {
+		families = []domain.IPFamily{domain.IPv4}
+	}
+	endpoints := requester.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = DefaultEndpoints
+	}
+
+	var ipinfo domain.IPInfo
+	var errs []error
+	detected := 0
+
+	for _, endpoint := range endpoints {
+		if detected == len(families) || ctx.Err() != nil {
+			break
+		}
+
+		address, endpointErr := requester.ask(ctx, endpoint)
+		if endpointErr != nil {
+			log.DebugContext(ctx, "Echo service did not answer", "endpoint", endpoint.URL, "error", endpointErr)
+			errs = append(errs, fmt.Errorf("%s: %w", endpoint.URL, endpointErr))
+			continue
+		}
+
+		family, _ := domain.FamilyOf(address.String())
+		if !slices.Contains(families, family) || ipinfo.Address(family) != "" {
+			continue
+		}
+		if setAddressErr := ipinfo.SetAddress(address.String()); setAddressErr != nil {
+			return domain.IPInfo{}, setAddressErr
+		}
+		detected++
+		log.DebugContext(ctx, "Public address detected through echo service", "endpoint", endpoint.URL, "family", family, "address", address)
+	}
+
+	if detected == 0 {
+		getIPInfoErr := fmt.Errorf("no echo service returned a monitored address: %w", errors.Join(append(errs, ctx.Err())...))
+		log.ErrorContext(ctx, "Error detecting public address through echo services", "error", getIPInfoErr)
+		return domain.IPInfo{}, getIPInfoErr
+	}
+
+	if requester.Enricher != nil {
+		enriched, enrichErr := requester.Enricher.Enrich(ctx, ipinfo)
+		if enrichErr != nil {
+			log.ErrorContext(ctx, "Error looking up the organization of the detected addresses", "error", enrichErr)
+			return domain.IPInfo{}, fmt.Errorf("organization of %s cannot be looked up: %w", ipinfo.Addresses()[0], enrichErr)
+		}
+		ipinfo = enriched
+	}
+
+	ipinfo.Source = "echoip"
+	return ipinfo, nil
+}
+
+// ask requests endpoint and extracts the address from its answer.
+func (requester Requester) ask(ctx context.Context, endpoint Endpoint) (netip.Addr, error) {
+
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.URL, nil)
+	if requestErr != nil {
+		return netip.Addr{}, requestErr
+	}
+	// Some services answer curl-like clients with plain text only.
+	request.Header.Set("User-Agent", "curl/8 (home-ip-monitor)")
+
+	response, responseErr := requester.HttpClient.Do(request)
+	if responseErr != nil {
+		return netip.Addr{}, responseErr
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("returned status code is %d", response.StatusCode)
+	}
+
+	body, bodyErr := io.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	if bodyErr != nil {
+		return netip.Addr{}, bodyErr
+	}
+
+	address, extractErr := endpoint.extract(body)
+	if extractErr != nil {
+		return netip.Addr{}, extractErr
+	}
+	return address.Unmap(), nil
+}
@@ -0,0 +1,162 @@
+//go:build integration_tests || unit_tests || echoip_tests || echoip_unit_tests
+
+package echoip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// newEchoServerMock serves each body of bodies on its path; other paths are
+// answered with 503.
+func newEchoServerMock(t *testing.T, bodies map[string]string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requested = append(requested, request.URL.Path)
+		body, found := bodies[request.URL.Path]
+		if !found {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(writer, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requested
+}
+
+// enricherMock fakes a domain.IPInfoEnricher.
+type enricherMock struct {
+	err error
+}
+
+func (mock enricherMock) Enrich(ctx context.Context, ipinfo domain.IPInfo) (domain.IPInfo, error) {
+	if mock.err != nil {
+		return ipinfo, mock.err
+	}
+	ipinfo.ASN = 57269
+	ipinfo.OrgName = "DIGI SPAIN TELECOM S.L."
+	return ipinfo, nil
+}
+
+func TestParseEndpoint(t *testing.T) {
+
+	endpoint, parseErr := ParseEndpoint(" https://ifconfig.co/json | json:ip ")
+	if parseErr != nil || endpoint != (Endpoint{URL: "https://ifconfig.co/json", Extractor: "json:ip"}) {
+		t.Errorf("ParseEndpoint should parse a JSON endpoint, got %+v (%v)", endpoint, parseErr)
+	}
+
+	endpoint, parseErr = ParseEndpoint("https://api.ipify.org")
+	if parseErr != nil || endpoint.Extractor != "text" || endpoint.String() != "https://api.ipify.org|text" {
+		t.Errorf("ParseEndpoint should default to the text extractor, got %+v (%v)", endpoint, parseErr)
+	}
+
+	for _, value := range []string{"api.ipify.org", "ftp://api.ipify.org", "https://ifconfig.co/json|json:", "https://api.ipify.org|xml"} {
+		if _, parseErr := ParseEndpoint(value); parseErr == nil {
+			t.Errorf("ParseEndpoint should fail with \"%s\"", value)
+		}
+	}
+
+}
+
+func TestExtractors(t *testing.T) {
+
+	for _, test := range []struct {
+		endpoint Endpoint
+		body     string
+		expected string
+	}{
+		{Endpoint{Extractor: "text"}, "79.12.12.12\n", "79.12.12.12"},
+		{Endpoint{Extractor: "json:ip"}, `{"ip":"2001:db8::7","country":"Spain"}`, "2001:db8::7"},
+		{Endpoint{Extractor: "find"}, "<html><head><title>Current IP Check</title></head><body>Current IP Address: 79.12.12.12</body></html>\r\n", "79.12.12.12"},
+	} {
+		address, extractErr := test.endpoint.extract([]byte(test.body))
+		if extractErr != nil || address.String() != test.expected {
+			t.Errorf("Extractor %s should read %s, got %s (%v)", test.endpoint.Extractor, test.expected, address, extractErr)
+		}
+	}
+
+	for _, test := range []struct {
+		endpoint Endpoint
+		body     string
+	}{
+		{Endpoint{Extractor: "text"}, "<html>Too many requests</html>"},
+		{Endpoint{Extractor: "json:ip"}, `{"address":"79.12.12.12"}`},
+		{Endpoint{Extractor: "find"}, "Version 1.2 of the page, no address"},
+	} {
+		if address, extractErr := test.endpoint.extract([]byte(test.body)); extractErr == nil {
+			t.Errorf("Extractor %s should fail with \"%s\", got %s", test.endpoint.Extractor, test.body, address)
+		}
+	}
+
+}
+
+// Endpoints are asked in order until every family is known; answers of a
+// family already known are ignored.
+func TestGetIPInfoFamilies(t *testing.T) {
+
+	server, requested := newEchoServerMock(t, map[string]string{
+		"/v4":   "79.12.12.12",
+		"/v4b":  "79.12.12.13",
+		"/json": `{"ip":"2001:db8::7"}`,
+	})
+
+	requester := Requester{HttpClient: server.Client(), Families: []domain.IPFamily{domain.IPv4, domain.IPv6}, Endpoints: []Endpoint{
+		{URL: server.URL + "/down", Extractor: "text"},
+		{URL: server.URL + "/v4", Extractor: "text"},
+		{URL: server.URL + "/v4b", Extractor: "text"},
+		{URL: server.URL + "/json", Extractor: "json:ip"},
+		{URL: server.URL + "/unused", Extractor: "text"},
+	}}
+
+	ipinfo, err := requester.GetIPInfo(context.Background())
+	if err != nil {
+		t.Fatalf("TestGetIPInfoFamilies should not fail: %v", err)
+	}
+	if ipinfo != (domain.IPInfo{IPv4: "79.12.12.12", IPv6: "2001:db8::7", Source: "echoip"}) {
+		t.Errorf("TestGetIPInfoFamilies returned an unexpected ipinfo: %+v", ipinfo)
+	}
+	if len(*requested) != 4 {
+		t.Errorf("TestGetIPInfoFamilies should stop once both families are known, requested %v", *requested)
+	}
+
+}
+
+func TestGetIPInfoEnriched(t *testing.T) {
+
+	server, _ := newEchoServerMock(t, map[string]string{"/": "79.12.12.12\n"})
+
+	requester := Requester{HttpClient: server.Client(), Endpoints: []Endpoint{{URL: server.URL + "/", Extractor: "text"}}, Enricher: enricherMock{}}
+
+	ipinfo, err := requester.GetIPInfo(context.Background())
+	if err != nil || ipinfo.OrgName != "DIGI SPAIN TELECOM S.L." || ipinfo.ASN != 57269 || ipinfo.IPv4 != "79.12.12.12" {
+		t.Errorf("TestGetIPInfoEnriched should return the enriched address, got %+v (%v)", ipinfo, err)
+	}
+
+	requester.Enricher = enricherMock{err: errors.New("FAIL")}
+	if ipinfo, err := requester.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestGetIPInfoEnriched should fail when the enricher fails, got %+v", ipinfo)
+	}
+
+}
+
+func TestGetIPInfoNoAnswer(t *testing.T) {
+
+	server, _ := newEchoServerMock(t, map[string]string{"/v6": "2001:db8::7"})
+
+	requester := Requester{HttpClient: server.Client(), Endpoints: []Endpoint{
+		{URL: server.URL + "/down", Extractor: "text"},
+		{URL: server.URL + "/v6", Extractor: "text"},
+	}}
+
+	if ipinfo, err := requester.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestGetIPInfoNoAnswer should fail without an IPv4 answer, got %+v", ipinfo)
+	}
+
+}
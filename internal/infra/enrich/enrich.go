@@ -0,0 +1,36 @@
+package enrich
+
+import (
+	"context"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// Provider decorates a domain.IPInfoProvider that only detects addresses so
+// it also reports the organization announcing them, which the ISP check
+// needs. Answers that already carry an organization are returned as they are.
+type Provider struct {
+	Provider domain.IPInfoProvider
+	Enricher domain.IPInfoEnricher
+}
+
+// GetIPInfo implements domain.IPInfoProvider. It fails when the decorated
+// provider fails or when the organization of its answer cannot be found.
+func (provider Provider) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "enrich.GetIPInfo")
+
+	ipinfo, err := provider.Provider.GetIPInfo(ctx)
+	if err != nil || ipinfo.OrgName != "" || len(ipinfo.Addresses()) == 0 {
+		return ipinfo, err
+	}
+
+	enriched, enrichErr := provider.Enricher.Enrich(ctx, ipinfo)
+	if enrichErr != nil {
+		log.ErrorContext(ctx, "Error looking up organization", "source", ipinfo.Source, "error", enrichErr)
+		return domain.IPInfo{}, enrichErr
+	}
+	log.DebugContext(ctx, "Organization found", "source", ipinfo.Source, "asn", enriched.ASN, "orgName", enriched.OrgName)
+	return enriched, nil
+}
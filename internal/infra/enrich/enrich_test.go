@@ -0,0 +1,75 @@
+//go:build integration_tests || unit_tests || enrich_tests || enrich_unit_tests
+
+package enrich
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// providerMock fakes the decorated domain.IPInfoProvider.
+type providerMock struct {
+	ipinfo domain.IPInfo
+	err    error
+}
+
+func (mock providerMock) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+	return mock.ipinfo, mock.err
+}
+
+// enricherMock fakes a domain.IPInfoEnricher and counts its calls.
+type enricherMock struct {
+	err   error
+	calls *int
+}
+
+func (mock enricherMock) Enrich(ctx context.Context, ipinfo domain.IPInfo) (domain.IPInfo, error) {
+	*mock.calls++
+	if mock.err != nil {
+		return ipinfo, mock.err
+	}
+	ipinfo.ASN = 57269
+	ipinfo.OrgName = "DIGI SPAIN TELECOM S.L."
+	return ipinfo, nil
+}
+
+func TestGetIPInfoEnriched(t *testing.T) {
+
+	var calls int
+	provider := Provider{Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "79.12.12.12", Source: "stun"}}, Enricher: enricherMock{calls: &calls}}
+
+	ipinfo, err := provider.GetIPInfo(context.Background())
+	if err != nil || ipinfo != (domain.IPInfo{IPv4: "79.12.12.12", ASN: 57269, OrgName: "DIGI SPAIN TELECOM S.L.", Source: "stun"}) {
+		t.Errorf("TestGetIPInfoEnriched should fill the organization, got %+v (%v)", ipinfo, err)
+	}
+
+	provider.Enricher = enricherMock{err: errors.New("FAIL"), calls: &calls}
+	if ipinfo, err := provider.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestGetIPInfoEnriched should fail when the enricher fails, got %+v", ipinfo)
+	}
+
+}
+
+func TestGetIPInfoNotEnriched(t *testing.T) {
+
+	var calls int
+	enricher := enricherMock{calls: &calls}
+
+	provider := Provider{Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "79.12.12.12", OrgName: "Digi Spain Telecom S.L.U.", Source: "ipinfo"}}, Enricher: enricher}
+	if ipinfo, err := provider.GetIPInfo(context.Background()); err != nil || ipinfo.OrgName != "Digi Spain Telecom S.L.U." {
+		t.Errorf("TestGetIPInfoNotEnriched should keep the reported organization, got %+v (%v)", ipinfo, err)
+	}
+
+	provider.Provider = providerMock{err: errors.New("FAIL")}
+	if ipinfo, err := provider.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestGetIPInfoNotEnriched should fail when the provider fails, got %+v", ipinfo)
+	}
+
+	if calls != 0 {
+		t.Errorf("TestGetIPInfoNotEnriched should not call the enricher, called %d times", calls)
+	}
+
+}
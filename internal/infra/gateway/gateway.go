@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// defaultTimeout bounds each protocol when Client.Timeout is unset.
+const defaultTimeout = 2 * time.Second
+
+// Client is the home router adapter. It implements domain.IPInfoProvider by
+// asking the router for its WAN address, without any external call. UPnP IGD
+// is tried first: the router is discovered through SSDP and
+// GetExternalIPAddress is called on its WANIPConnection (or WANPPPConnection)
+// service. NAT-PMP and then PCP are tried when UPnP fails.
+//
+// Only IPv4 is detected, since NAT is what these protocols are about. When
+// the WAN address is in the RFC 6598 shared address space GetIPInfo fails
+// with a *domain.CGNATError, and with a *domain.NonPublicAddressError for any
+// other address that is not public, because the router address is then not
+// the public one.
+type Client struct {
+	HttpClient *http.Client  // Used for the UPnP description and SOAP calls, http.DefaultClient when nil
+	Gateway    string        // Router address for NAT-PMP and PCP, the gateway of the default route when empty
+	Timeout    time.Duration // Per protocol, defaultTimeout when zero
+}
+
+// protocol is a way of asking the router for its WAN address.
+type protocol struct {
+	name  string
+	query func(client Client, ctx context.Context) (netip.Addr, error)
+}
+
+// protocols are tried in order until one answers.
+var protocols = []protocol{
+	{name: "UPnP", query: Client.upnp},
+	{name: "NAT-PMP", query: Client.natpmp},
+	{name: "PCP", query: Client.pcp},
+}
+
+// GetIPInfo returns the WAN address of the router as the IPv4 address.
+func (client Client) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "gateway.GetIPInfo")
+
+	var errs []error
+	for _, protocol := range protocols {
+		queryCtx, cancel := context.WithTimeout(ctx, client.timeout())
+		address, queryErr := protocol.query(client, queryCtx)
+		cancel()
+
+		if queryErr == nil && !address.Is4() {
+			queryErr = fmt.Errorf("router returned %s, which is not an ipv4 address", address)
+		}
+		if queryErr != nil {
+			log.DebugContext(ctx, "Router did not answer", "protocol", protocol.name, "error", queryErr)
+			errs = append(errs, fmt.Errorf("%s: %w", protocol.name, queryErr))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		log.DebugContext(ctx, "Router WAN address retrieved", "protocol", protocol.name, "address", address)
+		switch scope, _ := domain.ClassifyAddress(address.String()); scope {
+		case domain.PublicAddress:
+		case domain.SharedAddress:
+			cgnatErr := &domain.CGNATError{Address: address.String()}
+			log.ErrorContext(ctx, "Router is behind a carrier-grade NAT", "address", address)
+			return domain.IPInfo{}, cgnatErr
+		default:
+			// A private address means the router is behind another NAT.
+			nonPublicErr := &domain.NonPublicAddressError{Address: address.String(), Scope: scope}
+			log.ErrorContext(ctx, "Router WAN address is not public", "address", address, "error", nonPublicErr)
+			return domain.IPInfo{}, nonPublicErr
+		}
+
+		return domain.IPInfo{IPv4: address.String(), Source: "gateway"}, nil
+	}
+
+	getIPInfoErr := fmt.Errorf("router did not return its WAN address: %w", errors.Join(errs...))
+	log.ErrorContext(ctx, "Error retrieving the router WAN address", "error", getIPInfoErr)
+	return domain.IPInfo{}, getIPInfoErr
+}
+
+func (client Client) timeout() time.Duration {
+	if client.Timeout > 0 {
+		return client.Timeout
+	}
+	return defaultTimeout
+}
+
+func (client Client) httpClient() *http.Client {
+	if client.HttpClient != nil {
+		return client.HttpClient
+	}
+	return http.DefaultClient
+}
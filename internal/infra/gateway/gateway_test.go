@@ -0,0 +1,258 @@
+//go:build integration_tests || unit_tests || gateway_tests || gateway_unit_tests
+
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// udpServerMock is a local UDP responder standing in for the router. It
+// answers every datagram with the message built by answer; when answer is nil
+// or returns nil it does not answer.
+type udpServerMock struct {
+	conn   net.PacketConn
+	answer func(request []byte) []byte
+}
+
+func newUDPServerMock(t *testing.T, answer func(request []byte) []byte) *udpServerMock {
+	t.Helper()
+	conn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Skipf("Cannot listen on udp4 127.0.0.1:0: %v", listenErr)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	server := &udpServerMock{conn: conn, answer: answer}
+	go server.serve()
+	return server
+}
+
+func (server *udpServerMock) serve() {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, readErr := server.conn.ReadFrom(buffer)
+		if readErr != nil {
+			return
+		}
+		if server.answer == nil {
+			continue
+		}
+		if answer := server.answer(append([]byte(nil), buffer[:n]...)); answer != nil {
+			server.conn.WriteTo(answer, from)
+		}
+	}
+}
+
+func (server *udpServerMock) port() string {
+	_, port, _ := net.SplitHostPort(server.conn.LocalAddr().String())
+	return port
+}
+
+// useRouter points SSDP and NAT-PMP/PCP to the given mocks for the test.
+func useRouter(t *testing.T, ssdp *udpServerMock, natpmp *udpServerMock) {
+	t.Helper()
+	previousSSDPAddress, previousNATPMPPort := ssdpAddress, natpmpPort
+	t.Cleanup(func() { ssdpAddress, natpmpPort = previousSSDPAddress, previousNATPMPPort })
+	ssdpAddress = ssdp.conn.LocalAddr().String()
+	natpmpPort = natpmp.port()
+}
+
+// natpmpAnswer answers NAT-PMP external address requests with address and
+// ignores anything else.
+func natpmpAnswer(address [4]byte) func(request []byte) []byte {
+	return func(request []byte) []byte {
+		if len(request) != 2 || request[0] != 0 {
+			return nil
+		}
+		response := []byte{0, 128, 0, 0, 0, 0, 0, 1}
+		return append(response, address[:]...)
+	}
+}
+
+const rootDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><controlURL>/ctl/L3F</controlURL></service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service><serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType><controlURL>/ctl/IPConn</controlURL></service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const externalIPAddressBody = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body><u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"><NewExternalIPAddress>79.116.1.5</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body>
+</s:Envelope>`
+
+func TestGatewayUPnP(t *testing.T) {
+
+	var soapAction, soapBody string
+	router := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/rootDesc.xml":
+			io.WriteString(writer, rootDescription)
+		case "/ctl/IPConn":
+			body, _ := io.ReadAll(request.Body)
+			soapAction, soapBody = request.Header.Get("SOAPAction"), string(body)
+			io.WriteString(writer, externalIPAddressBody)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer router.Close()
+
+	ssdp := newUDPServerMock(t, func(request []byte) []byte {
+		if !strings.Contains(string(request), "ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1") {
+			return nil
+		}
+		return []byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLOCATION: " + router.URL + "/rootDesc.xml\r\n\r\n")
+	})
+	useRouter(t, ssdp, newUDPServerMock(t, nil))
+
+	client := Client{HttpClient: router.Client(), Gateway: "127.0.0.1", Timeout: time.Second}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestGatewayUPnP should not fail: %v", err)
+	}
+	if ipinfo.IPv4 != "79.116.1.5" || ipinfo.Source != "gateway" {
+		t.Errorf("TestGatewayUPnP should detect 79.116.1.5 through gateway, got %+v", ipinfo)
+	}
+	if soapAction != `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"` || !strings.Contains(soapBody, `<u:GetExternalIPAddress xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"/>`) {
+		t.Errorf("TestGatewayUPnP sent an unexpected SOAP request: %s %s", soapAction, soapBody)
+	}
+
+}
+
+// Without UPnP, NAT-PMP is asked.
+func TestGatewayNATPMPFallback(t *testing.T) {
+
+	useRouter(t, newUDPServerMock(t, nil), newUDPServerMock(t, natpmpAnswer([4]byte{79, 116, 1, 4})))
+
+	client := Client{Gateway: "127.0.0.1", Timeout: 300 * time.Millisecond}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil || ipinfo.IPv4 != "79.116.1.4" {
+		t.Errorf("TestGatewayNATPMPFallback should detect 79.116.1.4, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+// A PCP router rejects the NAT-PMP request with an unsupported version
+// response and answers the MAP request, which must not create a mapping.
+func TestGatewayPCPFallback(t *testing.T) {
+
+	router := newUDPServerMock(t, func(request []byte) []byte {
+		response := make([]byte, 60)
+		response[0] = 2
+		if request[0] != 2 {
+			response[1], response[3] = 128, 1 // UNSUPP_VERSION
+			return response[:24]
+		}
+		response[1] = request[1] | 128
+		if binary.BigEndian.Uint32(request[4:8]) != 0 {
+			response[3] = 2 // NOT_AUTHORIZED: only deletions are accepted
+			return response
+		}
+		copy(response[24:44], request[24:44]) // nonce, protocol and internal port
+		copy(response[44:60], net.ParseIP("79.116.1.77").To16())
+		return response
+	})
+	useRouter(t, newUDPServerMock(t, nil), router)
+
+	client := Client{Gateway: "127.0.0.1", Timeout: 300 * time.Millisecond}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil || ipinfo.IPv4 != "79.116.1.77" {
+		t.Errorf("TestGatewayPCPFallback should detect 79.116.1.77, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+func TestGatewayCGNAT(t *testing.T) {
+
+	useRouter(t, newUDPServerMock(t, nil), newUDPServerMock(t, natpmpAnswer([4]byte{100, 64, 12, 34})))
+
+	client := Client{Gateway: "127.0.0.1", Timeout: 300 * time.Millisecond}
+	_, err := client.GetIPInfo(context.Background())
+
+	var cgnat *domain.CGNATError
+	if !errors.As(err, &cgnat) || cgnat.Address != "100.64.12.34" {
+		t.Errorf("TestGatewayCGNAT should fail with a CGNATError for 100.64.12.34, got %v", err)
+	}
+
+}
+
+// A private WAN address means the router is behind another NAT: it is
+// reported as non-public, so it is neither retried nor counted as an outage.
+func TestGatewayPrivateAddress(t *testing.T) {
+
+	useRouter(t, newUDPServerMock(t, nil), newUDPServerMock(t, natpmpAnswer([4]byte{192, 168, 1, 2})))
+
+	client := Client{Gateway: "127.0.0.1", Timeout: 300 * time.Millisecond}
+	_, err := client.GetIPInfo(context.Background())
+
+	var nonPublic *domain.NonPublicAddressError
+	if !errors.As(err, &nonPublic) || nonPublic.Address != "192.168.1.2" || nonPublic.Scope != domain.PrivateAddress {
+		t.Errorf("TestGatewayPrivateAddress should fail with a NonPublicAddressError for 192.168.1.2, got %v", err)
+	}
+
+}
+
+func TestGatewayNoAnswer(t *testing.T) {
+
+	useRouter(t, newUDPServerMock(t, nil), newUDPServerMock(t, nil))
+
+	client := Client{Gateway: "127.0.0.1", Timeout: 200 * time.Millisecond}
+
+	if ipinfo, err := client.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestGatewayNoAnswer should fail, got %+v", ipinfo)
+	}
+
+}
+
+func TestDefaultGateway(t *testing.T) {
+
+	previousRouteFile := routeFile
+	defer func() { routeFile = previousRouteFile }()
+	routeFile = filepath.Join(t.TempDir(), "route")
+
+	routes := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t0001A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"
+	if writeErr := os.WriteFile(routeFile, []byte(routes), 0o600); writeErr != nil {
+		t.Fatalf("TestDefaultGateway cannot write the route file: %v", writeErr)
+	}
+
+	gateway, gatewayErr := defaultGateway()
+	if gatewayErr != nil || gateway != "192.168.1.1" {
+		t.Errorf("TestDefaultGateway should return 192.168.1.1, got \"%s\" (%v)", gateway, gatewayErr)
+	}
+
+}
@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natpmpPort is the router port of both NAT-PMP (RFC 6886) and PCP (RFC 6887).
+// It is a package var so tests can point it to a local responder.
+var natpmpPort = "5351"
+
+// routeFile is the kernel routing table the default gateway is read from.
+var routeFile = "/proc/net/route"
+
+// NAT-PMP and PCP message constants.
+const (
+	natpmpVersion         = 0
+	natpmpExternalAddress = 0
+	natpmpResponseLength  = 12
+
+	pcpVersion        = 2
+	pcpOpcodeMap      = 1
+	pcpResponseBit    = 0x80
+	pcpRequestLength  = 60
+	pcpResponseLength = 60
+
+	protocolUDP = 17
+)
+
+// initialRetransmission is the delay before the first retransmission of a
+// NAT-PMP or PCP request; it doubles on each one, as RFC 6886 recommends.
+const initialRetransmission = 250 * time.Millisecond
+
+// errUnrelatedMessage is returned by the response parsers for datagrams that
+// are not the answer to our request, which are ignored.
+var errUnrelatedMessage = errors.New("not a response to the request")
+
+// natpmp asks the router for its external address with NAT-PMP.
+func (client Client) natpmp(ctx context.Context) (netip.Addr, error) {
+	return client.exchange(ctx, func(netip.AddrPort) []byte {
+		return []byte{natpmpVersion, natpmpExternalAddress}
+	}, parseNATPMPResponse)
+}
+
+// parseNATPMPResponse returns the address of an external address response.
+func parseNATPMPResponse(message []byte) (netip.Addr, error) {
+
+	// Routers speaking only PCP answer with a version 2 message.
+	if len(message) >= 4 && message[0] == pcpVersion {
+		return netip.Addr{}, errors.New("router does not support NAT-PMP")
+	}
+	if len(message) < natpmpResponseLength || message[0] != natpmpVersion || message[1] != natpmpExternalAddress|0x80 {
+		return netip.Addr{}, errUnrelatedMessage
+	}
+	if result := binary.BigEndian.Uint16(message[2:4]); result != 0 {
+		return netip.Addr{}, fmt.Errorf("NAT-PMP request failed with result code %d", result)
+	}
+	return netip.AddrFrom4([4]byte(message[8:12])), nil
+}
+
+// pcp asks the router for its external address with a PCP MAP request for the
+// query socket, whose answer carries the external address. The request has a
+// zero lifetime, which only deletes a mapping (RFC 6887 section 15), so
+// reading the address never opens a port on the router.
+func (client Client) pcp(ctx context.Context) (netip.Addr, error) {
+
+	var nonce [12]byte
+	if _, randErr := rand.Read(nonce[:]); randErr != nil {
+		return netip.Addr{}, randErr
+	}
+
+	return client.exchange(ctx, func(local netip.AddrPort) []byte {
+		request := make([]byte, pcpRequestLength)
+		request[0] = pcpVersion
+		request[1] = pcpOpcodeMap
+		clientAddress := local.Addr().As16()
+		copy(request[8:24], clientAddress[:])
+		copy(request[24:36], nonce[:])
+		request[36] = protocolUDP
+		binary.BigEndian.PutUint16(request[40:42], local.Port())
+		// Any external port and address: the IPv4-mapped unspecified address.
+		request[54], request[55] = 0xff, 0xff
+		return request
+	}, func(message []byte) (netip.Addr, error) {
+		return parsePCPResponse(message, nonce)
+	})
+}
+
+// parsePCPResponse returns the assigned external address of the answer to the
+// MAP request with nonce.
+func parsePCPResponse(message []byte, nonce [12]byte) (netip.Addr, error) {
+
+	// Routers speaking only NAT-PMP answer with a version 0 message.
+	if len(message) >= 4 && message[0] == natpmpVersion {
+		return netip.Addr{}, errors.New("router does not support PCP")
+	}
+	if len(message) < 4 || message[0] != pcpVersion || message[1] != pcpOpcodeMap|pcpResponseBit {
+		return netip.Addr{}, errUnrelatedMessage
+	}
+	if result := message[3]; result != 0 {
+		return netip.Addr{}, fmt.Errorf("PCP request failed with result code %d", result)
+	}
+	if len(message) < pcpResponseLength || [12]byte(message[24:36]) != nonce {
+		return netip.Addr{}, errUnrelatedMessage
+	}
+	address := netip.AddrFrom16([16]byte(message[44:60])).Unmap()
+	if address.IsUnspecified() {
+		return netip.Addr{}, errors.New("PCP answer holds no external address")
+	}
+	return address, nil
+}
+
+// exchange sends the request built from the local address of the socket to
+// the router, retransmitting it until parse accepts an answer or the context
+// is done.
+func (client Client) exchange(ctx context.Context, build func(local netip.AddrPort) []byte, parse func([]byte) (netip.Addr, error)) (netip.Addr, error) {
+
+	gateway, gatewayErr := client.gatewayAddress()
+	if gatewayErr != nil {
+		return netip.Addr{}, gatewayErr
+	}
+
+	var dialer net.Dialer
+	conn, dialErr := dialer.DialContext(ctx, "udp4", net.JoinHostPort(gateway, natpmpPort))
+	if dialErr != nil {
+		return netip.Addr{}, dialErr
+	}
+	defer conn.Close()
+
+	// Unblock reads as soon as the context is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	request := build(conn.LocalAddr().(*net.UDPAddr).AddrPort())
+
+	buffer := make([]byte, 1100)
+	retransmission := initialRetransmission
+	for {
+		if _, writeErr := conn.Write(request); writeErr != nil {
+			return netip.Addr{}, writeErr
+		}
+		conn.SetReadDeadline(time.Now().Add(retransmission))
+
+		for {
+			n, readErr := conn.Read(buffer)
+			if readErr != nil {
+				if ctx.Err() != nil {
+					return netip.Addr{}, ctx.Err()
+				}
+				var netErr net.Error
+				if errors.As(readErr, &netErr) && netErr.Timeout() {
+					break // retransmit
+				}
+				return netip.Addr{}, readErr
+			}
+
+			address, parseErr := parse(buffer[:n])
+			if errors.Is(parseErr, errUnrelatedMessage) {
+				continue
+			}
+			return address, parseErr
+		}
+		retransmission *= 2
+	}
+}
+
+// gatewayAddress returns the configured router address or, when unset, the
+// gateway of the default route.
+func (client Client) gatewayAddress() (string, error) {
+	if client.Gateway != "" {
+		return client.Gateway, nil
+	}
+	return defaultGateway()
+}
+
+// defaultGateway reads the gateway of the IPv4 default route from routeFile,
+// where addresses are little-endian hexadecimal numbers.
+func defaultGateway() (string, error) {
+
+	file, openErr := os.Open(routeFile)
+	if openErr != nil {
+		return "", fmt.Errorf("default gateway cannot be read: %w", openErr)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gateway, parseErr := strconv.ParseUint(fields[2], 16, 32)
+		if parseErr != nil || gateway == 0 {
+			continue
+		}
+		var address [4]byte
+		binary.LittleEndian.PutUint32(address[:], uint32(gateway))
+		return netip.AddrFrom4(address).String(), nil
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		return "", scanErr
+	}
+	return "", errors.New("no default gateway found")
+}
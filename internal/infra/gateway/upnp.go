@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ssdpAddress is the SSDP multicast group and port M-SEARCH requests are sent
+// to. It is a package var so tests can point it to a local responder.
+var ssdpAddress = "239.255.255.250:1900"
+
+// internetGatewayDevice is the SSDP search target of UPnP routers; IGD:2
+// devices answer it too.
+const internetGatewayDevice = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+// wanConnectionServices are the type prefixes of the services providing
+// GetExternalIPAddress, for IP and PPP WAN links.
+var wanConnectionServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:",
+	"urn:schemas-upnp-org:service:WANPPPConnection:",
+}
+
+// deviceDescription is the unexported DTO of the UPnP device description XML.
+// Only the parts leading to the WAN connection service are mapped.
+type deviceDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  deviceData `xml:"device"`
+}
+
+type deviceData struct {
+	Services []serviceData `xml:"serviceList>service"`
+	Devices  []deviceData  `xml:"deviceList>device"`
+}
+
+type serviceData struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// externalIPAddressResponse is the unexported DTO of the SOAP response to
+// GetExternalIPAddress.
+type externalIPAddressResponse struct {
+	Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+}
+
+// upnp discovers the router through SSDP and calls GetExternalIPAddress on its
+// WAN connection service.
+func (client Client) upnp(ctx context.Context) (netip.Addr, error) {
+
+	location, discoverErr := discover(ctx)
+	if discoverErr != nil {
+		return netip.Addr{}, discoverErr
+	}
+
+	serviceType, controlURL, serviceErr := client.wanConnectionService(ctx, location)
+	if serviceErr != nil {
+		return netip.Addr{}, serviceErr
+	}
+
+	return client.externalIPAddress(ctx, serviceType, controlURL)
+}
+
+// discover sends an SSDP M-SEARCH for Internet Gateway Devices and returns the
+// description URL (LOCATION) of the first one that answers.
+func discover(ctx context.Context) (string, error) {
+
+	conn, listenErr := net.ListenPacket("udp4", ":0")
+	if listenErr != nil {
+		return "", listenErr
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	destination, resolveErr := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + internetGatewayDevice + "\r\n\r\n"
+	if _, writeErr := conn.WriteTo([]byte(search), destination); writeErr != nil {
+		return "", writeErr
+	}
+
+	buffer := make([]byte, 2048)
+	for {
+		n, _, readErr := conn.ReadFrom(buffer)
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("no Internet Gateway Device answered the SSDP search: %w", ctx.Err())
+			}
+			return "", readErr
+		}
+		response, parseErr := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+		if parseErr != nil || response.StatusCode != http.StatusOK {
+			continue
+		}
+		if location := response.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// wanConnectionService fetches the device description at location and returns
+// the type and absolute control URL of its WAN connection service.
+func (client Client) wanConnectionService(ctx context.Context, location string) (string, string, error) {
+
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if requestErr != nil {
+		return "", "", requestErr
+	}
+	response, responseErr := client.httpClient().Do(request)
+	if responseErr != nil {
+		return "", "", responseErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("device description request returned status code %d", response.StatusCode)
+	}
+
+	var description deviceDescription
+	if decodeErr := xml.NewDecoder(response.Body).Decode(&description); decodeErr != nil {
+		return "", "", fmt.Errorf("device description cannot be parsed: %w", decodeErr)
+	}
+
+	service, found := description.Device.wanConnectionService()
+	if !found {
+		return "", "", errors.New("router does not provide a WAN connection service")
+	}
+
+	base, baseErr := url.Parse(location)
+	if description.URLBase != "" {
+		base, baseErr = url.Parse(description.URLBase)
+	}
+	if baseErr != nil {
+		return "", "", baseErr
+	}
+	controlURL, controlErr := base.Parse(strings.TrimSpace(service.ControlURL))
+	if controlErr != nil {
+		return "", "", controlErr
+	}
+	return strings.TrimSpace(service.ServiceType), controlURL.String(), nil
+}
+
+// wanConnectionService searches the device and its embedded devices for a WAN
+// connection service.
+func (device deviceData) wanConnectionService() (serviceData, bool) {
+	for _, service := range device.Services {
+		for _, prefix := range wanConnectionServices {
+			if strings.HasPrefix(strings.TrimSpace(service.ServiceType), prefix) {
+				return service, true
+			}
+		}
+	}
+	for _, embedded := range device.Devices {
+		if service, found := embedded.wanConnectionService(); found {
+			return service, true
+		}
+	}
+	return serviceData{}, false
+}
+
+// externalIPAddress calls the GetExternalIPAddress SOAP action of serviceType
+// at controlURL.
+func (client Client) externalIPAddress(ctx context.Context, serviceType string, controlURL string) (netip.Addr, error) {
+
+	envelope := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, strings.NewReader(envelope))
+	if requestErr != nil {
+		return netip.Addr{}, requestErr
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+
+	response, responseErr := client.httpClient().Do(request)
+	if responseErr != nil {
+		return netip.Addr{}, responseErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("GetExternalIPAddress returned status code %d", response.StatusCode)
+	}
+
+	body, bodyErr := io.ReadAll(response.Body)
+	if bodyErr != nil {
+		return netip.Addr{}, bodyErr
+	}
+	var external externalIPAddressResponse
+	if decodeErr := xml.Unmarshal(body, &external); decodeErr != nil {
+		return netip.Addr{}, fmt.Errorf("GetExternalIPAddress response cannot be parsed: %w", decodeErr)
+	}
+	// Routers without a WAN link answer with an empty address.
+	if strings.TrimSpace(external.Address) == "" {
+		return netip.Addr{}, errors.New("router has no WAN address")
+	}
+	return netip.ParseAddr(strings.TrimSpace(external.Address))
+}
@@ -9,7 +9,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	logger "github.com/a-castellano/go-services/infra/logger"
 	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
@@ -25,28 +28,39 @@ type ipinfoData struct {
 	Region   string `json:"region"`   // Region/state
 	Country  string `json:"country"`  // Country code
 	Loc      string `json:"loc"`      // Latitude/longitude
-	Org      string `json:"org"`      // Organization/ISP (e.g., "AS12345 DIGI")
+	Org      string `json:"org"`      // ASN and organization/ISP (e.g., "AS57269 DIGI SPAIN TELECOM S.L.")
 	Postal   string `json:"postal"`   // Postal code
 	Timezone string `json:"timezone"` // Timezone
 	Readme   string `json:"readme"`   // API documentation URL
 }
 
-// getOrgName retrieves OrgName from Org field
-// It parses the "org" field which typically contains "AS12345 ISP_NAME"
-// and extracts just the ISP name part
+// getOrgName retrieves the ASN and the full OrgName from the Org field.
+// It parses the "org" field, which contains "AS12345 ISP NAME", into the ASN
+// number (12345) and the complete organization name ("ISP NAME"). The returned
+// domain.IPInfo carries the address in the field matching its family.
 func (ipinfoData ipinfoData) getOrgName(ctx context.Context) (domain.IPInfo, error) {
 
 	log := logger.FromContext(ctx).With("operation", "getOrgName")
 	var ipinfo domain.IPInfo
 
-	splitedOrgData := strings.Split(ipinfoData.Org, " ")
+	splitedOrgData := strings.SplitN(strings.TrimSpace(ipinfoData.Org), " ", 2)
 	if len(splitedOrgData) < 2 {
 		log.ErrorContext(ctx, "ipinfo retrieved Org value format cannot be processed", "data", ipinfoData.Org)
 		return ipinfo, fmt.Errorf("ipinfo data format cannot be processed : \"%s\"", ipinfoData.Org)
 	}
-	orgName := splitedOrgData[1]
 
-	ipinfo = domain.IPInfo{IP: ipinfoData.IP, OrgName: orgName}
+	asn, asnErr := domain.ParseASN(splitedOrgData[0])
+	if asnErr != nil {
+		log.ErrorContext(ctx, "ipinfo retrieved Org value does not start with an ASN", "data", ipinfoData.Org)
+		return ipinfo, fmt.Errorf("ipinfo data format cannot be processed : \"%s\"", ipinfoData.Org)
+	}
+	orgName := strings.TrimSpace(splitedOrgData[1])
+
+	ipinfo = domain.IPInfo{ASN: asn, OrgName: orgName}
+	if setAddressErr := ipinfo.SetAddress(ipinfoData.IP); setAddressErr != nil {
+		log.ErrorContext(ctx, "ipinfo retrieved IP cannot be parsed", "ip", ipinfoData.IP, "error", setAddressErr)
+		return domain.IPInfo{}, setAddressErr
+	}
 
 	log.InfoContext(ctx, "Retrieve IPInfo data", "data", ipinfo)
 	return ipinfo, nil
@@ -54,47 +68,167 @@ func (ipinfoData ipinfoData) getOrgName(ctx context.Context) (domain.IPInfo, err
 
 // IPInfoRequester is the ipinfo.io HTTP adapter. It implements
 // domain.IPInfoProvider by fetching and parsing the public IP information,
-// using the injected *http.Client.
+// using the injected *http.Client. Each family in Families is queried on its
+// own endpoint; when Families is empty only IPv4 is queried.
+//
+// When Token is set it is sent as a bearer token, so requests count against
+// that account instead of the anonymous quota shared by everyone behind the
+// same address. The token only travels in the Authorization header, never in
+// the URL, so it never reaches the logs.
 type IPInfoRequester struct {
 	HttpClient *http.Client
+	Families   []domain.IPFamily
+	Token      string
 }
 
-// ipInfoURL is the ipinfo.io endpoint queried for public IP information.
-// It is a package var (not a const) so tests can override it to exercise
-// request-creation errors.
+// ipInfoURL and ipInfoV6URL are the ipinfo.io endpoints queried for public IP
+// information over IPv4 and IPv6 respectively. They are package vars (not
+// consts) so tests can override them to exercise request-creation errors.
 var (
-	ipInfoURL string = "https://ipinfo.io/"
+	ipInfoURL   string = "https://ipinfo.io/"
+	ipInfoV6URL string = "https://v6.ipinfo.io/"
 )
 
-// GetIPInfo fetches the public IP information from ipinfo.io and maps it to a
-// domain.IPInfo. It builds the request, validates the status code, reads and
-// parses the JSON body, ensures an IP was returned, and extracts the ISP name.
-// It returns an error if any of those steps fails.
+// ipLookupURL is the ipinfo.io endpoint queried for the information of a given
+// address, formatted with that address. It is a package var for the same
+// reason as ipInfoURL.
+var ipLookupURL string = "https://ipinfo.io/%s/json"
+
+// familyURL returns the endpoint used to detect the address of family.
+func familyURL(family domain.IPFamily) string {
+	if family == domain.IPv6 {
+		return ipInfoV6URL
+	}
+	return ipInfoURL
+}
+
+// GetIPInfo fetches the public IP information from ipinfo.io for every
+// configured family and merges the answers into a single domain.IPInfo. A
+// family whose request fails is left empty; an error is returned only when
+// no family could be retrieved.
 func (requester IPInfoRequester) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "GetIPInfo")
+
+	families := requester.Families
+	if len(families) == 0 {
+		families = []domain.IPFamily{domain.IPv4}
+	}
+
+	var ipinfo domain.IPInfo
+	var lastErr error
+	retrieved := false
+
+	for _, family := range families {
+		familyInfo, familyErr := requester.getFamilyIPInfo(ctx, familyURL(family))
+		if familyErr != nil {
+			log.ErrorContext(ctx, "Error retrieving ipinfo data for family", "family", family, "error", familyErr)
+			lastErr = familyErr
+			// Every endpoint shares the quota, asking the next one would
+			// only be refused too.
+			var rateLimit *domain.RateLimitError
+			if errors.As(familyErr, &rateLimit) {
+				break
+			}
+			continue
+		}
+		if familyInfo.Address(family) == "" {
+			lastErr = fmt.Errorf("ipinfo did not return an %s address", family)
+			log.ErrorContext(ctx, "Error retrieving ipinfo data for family", "family", family, "error", lastErr)
+			continue
+		}
+		if !retrieved {
+			ipinfo.ASN = familyInfo.ASN
+			ipinfo.OrgName = familyInfo.OrgName
+		}
+		if setAddressErr := ipinfo.SetAddress(familyInfo.Address(family)); setAddressErr != nil {
+			return domain.IPInfo{}, setAddressErr
+		}
+		retrieved = true
+	}
+
+	if !retrieved {
+		return domain.IPInfo{}, lastErr
+	}
+
+	ipinfo.Source = "ipinfo"
+	return ipinfo, nil
+}
+
+// Enrich implements domain.IPInfoEnricher for providers that only detect the
+// addresses: it looks up the first address of ipinfo on ipinfo.io and returns
+// ipinfo with the ASN and OrgName found there.
+func (requester IPInfoRequester) Enrich(ctx context.Context, ipinfo domain.IPInfo) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "Enrich")
+
+	addresses := ipinfo.Addresses()
+	if len(addresses) == 0 {
+		return ipinfo, errors.New("no address to look up on ipinfo")
+	}
+
+	lookup, lookupErr := requester.getFamilyIPInfo(ctx, fmt.Sprintf(ipLookupURL, addresses[0]))
+	if lookupErr != nil {
+		log.ErrorContext(ctx, "Error looking up address on ipinfo", "ip", addresses[0], "error", lookupErr)
+		return ipinfo, lookupErr
+	}
+	if !slices.Contains(lookup.Addresses(), addresses[0]) {
+		log.ErrorContext(ctx, "ipinfo returned the information of another address", "ip", addresses[0], "returned", lookup.Addresses())
+		return ipinfo, fmt.Errorf("ipinfo returned the information of %s instead of %s", strings.Join(lookup.Addresses(), ", "), addresses[0])
+	}
+
+	ipinfo.ASN = lookup.ASN
+	ipinfo.OrgName = lookup.OrgName
+	return ipinfo, nil
+}
+
+// getFamilyIPInfo fetches the public IP information from one ipinfo.io
+// endpoint and maps it to a domain.IPInfo. It builds the request, validates
+// the status code, reads and parses the JSON body, ensures an IP was returned,
+// and extracts the ISP name. It returns an error if any of those steps fails.
+func (requester IPInfoRequester) getFamilyIPInfo(ctx context.Context, url string) (domain.IPInfo, error) {
 	ipinfo := domain.IPInfo{}
 	var retrievedInfo ipinfoData
 
 	log := logger.FromContext(ctx).With("operation", "GetIPInfo")
-	log.DebugContext(ctx, "Creating a request to ipinfo", "url", ipInfoURL)
-	req, reqErr := http.NewRequestWithContext(ctx, "GET", ipInfoURL, nil)
+	log.DebugContext(ctx, "Creating a request to ipinfo", "url", url)
+	req, reqErr := http.NewRequestWithContext(ctx, "GET", url, nil)
 
 	if reqErr != nil {
-		log.ErrorContext(ctx, "Error during request to ipinfo creation", "url", ipInfoURL, "error", reqErr.Error())
+		log.ErrorContext(ctx, "Error during request to ipinfo creation", "url", url, "error", reqErr.Error())
 		return ipinfo, reqErr
 	}
 
-	log.DebugContext(ctx, "Executing request to ipinfo", "url", ipInfoURL)
+	if requester.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+requester.Token)
+	}
+
+	log.DebugContext(ctx, "Executing request to ipinfo", "url", url, "authenticated", requester.Token != "")
 
 	response, responseErr := requester.HttpClient.Do(req)
 
 	if responseErr != nil {
-		log.ErrorContext(ctx, "Error performing request to ipinfo", "url", ipInfoURL, "error", responseErr.Error())
-		return ipinfo, responseErr
+		log.ErrorContext(ctx, "Error performing request to ipinfo", "url", url, "error", responseErr.Error())
+		return ipinfo, &domain.UnavailableError{Provider: "ipinfo", Err: responseErr}
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != 200 {
-		log.ErrorContext(ctx, "Error performing request to ipinfo, returned status code is not 200", "url", ipInfoURL, "StatusCode", response.StatusCode)
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		rateLimitErr := &domain.RateLimitError{Provider: "ipinfo", RetryAfter: retryAfter(response.Header.Get("Retry-After"), time.Now())}
+		log.ErrorContext(ctx, "ipinfo request quota is exhausted", "url", url, "retryAfter", rateLimitErr.RetryAfter)
+		return ipinfo, rateLimitErr
+
+	case response.StatusCode >= 500:
+		log.ErrorContext(ctx, "ipinfo is unavailable", "url", url, "StatusCode", response.StatusCode)
+		return ipinfo, &domain.UnavailableError{Provider: "ipinfo", Err: fmt.Errorf("returned status code is %d", response.StatusCode)}
+
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		log.ErrorContext(ctx, "ipinfo rejected the request, check IPINFO_TOKEN", "url", url, "StatusCode", response.StatusCode)
+		return ipinfo, fmt.Errorf("ipinfo rejected the request with status code %d, the API token is not valid", response.StatusCode)
+
+	case response.StatusCode != 200:
+		log.ErrorContext(ctx, "Error performing request to ipinfo, returned status code is not 200", "url", url, "StatusCode", response.StatusCode)
 
 		return ipinfo, errors.New("error performing request to ipinfo, returned status code is not 200")
 	}
@@ -102,14 +236,14 @@ func (requester IPInfoRequester) GetIPInfo(ctx context.Context) (domain.IPInfo,
 	log.DebugContext(ctx, "Reading body response")
 	body, bodyErr := io.ReadAll(response.Body)
 	if bodyErr != nil {
-		log.ErrorContext(ctx, "Error reading body response from ipinfo", "url", ipInfoURL, "error", bodyErr)
+		log.ErrorContext(ctx, "Error reading body response from ipinfo", "url", url, "error", bodyErr)
 		return ipinfo, bodyErr
 	}
 
 	log.DebugContext(ctx, "Parsing JSON body response")
 	unmarshalErr := json.Unmarshal(body, &retrievedInfo)
 	if unmarshalErr != nil {
-		log.ErrorContext(ctx, "Error reading json response from ipinfo", "url", ipInfoURL, "error", unmarshalErr)
+		log.ErrorContext(ctx, "Error reading json response from ipinfo", "url", url, "error", unmarshalErr)
 		return ipinfo, unmarshalErr
 	}
 
@@ -128,3 +262,20 @@ func (requester IPInfoRequester) GetIPInfo(ctx context.Context) (domain.IPInfo,
 
 	return ipinfo, nil
 }
+
+// retryAfter parses a Retry-After header, given either in seconds or as an
+// HTTP date, into the time left from now. It returns zero when the header is
+// missing, invalid or in the past.
+func retryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, parseErr := strconv.Atoi(value); parseErr == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, parseErr := http.ParseTime(value); parseErr == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -8,8 +8,11 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
 	mock "github.com/a-castellano/home-ip-monitor/internal/infra/ipinfodata/mocks"
 	"go.uber.org/mock/gomock"
 )
@@ -140,8 +143,18 @@ func TestGetIPInfoTelefonica(t *testing.T) {
 		t.Fatal("GetIPInfo shouldn't fail when valid JSON is returned")
 	} else {
 		expectedIP := "79.12.12.12"
-		if ipinfo.IP != expectedIP {
-			t.Fatalf("ipinfo.IP should be '%s' but got '%s'", expectedIP, ipinfo.IP)
+		if ipinfo.IPv4 != expectedIP {
+			t.Fatalf("ipinfo.IPv4 should be '%s' but got '%s'", expectedIP, ipinfo.IPv4)
+		}
+		if ipinfo.ASN != 57269 {
+			t.Fatalf("ipinfo.ASN should be 57269 but got %d", ipinfo.ASN)
+		}
+		expectedOrgName := "DIGI SPAIN TELECOM S.L."
+		if ipinfo.OrgName != expectedOrgName {
+			t.Fatalf("ipinfo.OrgName should be '%s' but got '%s'", expectedOrgName, ipinfo.OrgName)
+		}
+		if ipinfo.Source != "ipinfo" {
+			t.Fatalf("ipinfo.Source should be 'ipinfo' but got '%s'", ipinfo.Source)
 		}
 	}
 }
@@ -163,3 +176,209 @@ func TestGetIPInfoInvalidOrgName(t *testing.T) {
 		t.Fatal("GetIPInfo should fail when org name cannot be splited")
 	}
 }
+
+func TestGetIPInfoDualStack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	// Each family is queried on its own endpoint, the IPv6 one answers with an
+	// IPv6 address that must end up in the IPv6 field.
+	transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		body := `{"ip": "79.12.12.12","org": "AS57269 DIGI SPAIN TELECOM S.L."}`
+		if req.URL.String() == ipInfoV6URL {
+			body = `{"ip": "2a0c:5a80::1","org": "AS57269 DIGI SPAIN TELECOM S.L."}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+	}).Times(2)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}, Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	ipinfo, err := requester.GetIPInfo(context.Background())
+	if err != nil {
+		t.Fatalf("GetIPInfo shouldn't fail when both families answer: %v", err)
+	}
+	if ipinfo.IPv4 != "79.12.12.12" || ipinfo.IPv6 != "2a0c:5a80::1" {
+		t.Fatalf("GetIPInfo should return both addresses, got IPv4 '%s' and IPv6 '%s'", ipinfo.IPv4, ipinfo.IPv6)
+	}
+}
+
+func TestGetIPInfoDualStackIPv6Fails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	// The IPv6 endpoint is unreachable: the IPv4 answer is still returned.
+	transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		if req.URL.String() == ipInfoV6URL {
+			return nil, errors.New("network is unreachable")
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(`{"ip": "79.12.12.12","org": "AS57269 DIGI SPAIN TELECOM S.L."}`))}, nil
+	}).Times(2)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}, Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	ipinfo, err := requester.GetIPInfo(context.Background())
+	if err != nil {
+		t.Fatalf("GetIPInfo shouldn't fail when only one family fails: %v", err)
+	}
+	if ipinfo.IPv4 != "79.12.12.12" || ipinfo.IPv6 != "" {
+		t.Fatalf("GetIPInfo should only return the IPv4 address, got IPv4 '%s' and IPv6 '%s'", ipinfo.IPv4, ipinfo.IPv6)
+	}
+}
+
+func TestGetIPInfoOrgWithoutASN(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	// 200 OK, but the org value does not start with an ASN.
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(bytes.NewBufferString(`{"ip": "79.12.12.12","org": "Telefonica de Espana"}`)),
+	}, nil)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}}
+
+	_, err := requester.GetIPInfo(context.Background())
+	if err == nil {
+		t.Fatal("GetIPInfo should fail when org value does not start with an ASN")
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		if req.URL.String() != "https://ipinfo.io/79.12.12.12/json" {
+			t.Errorf("Enrich should look up the given address, requested %s", req.URL)
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(`{"ip": "79.12.12.12","org": "AS57269 DIGI SPAIN TELECOM S.L."}`))}, nil
+	})
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}}
+
+	ipinfo, err := requester.Enrich(context.Background(), domain.IPInfo{IPv4: "79.12.12.12", IPv6: "2001:db8::1", Source: "interface"})
+	if err != nil {
+		t.Fatalf("Enrich shouldn't fail when valid JSON is returned: %v", err)
+	}
+	if ipinfo.ASN != 57269 || ipinfo.OrgName != "DIGI SPAIN TELECOM S.L." || ipinfo.IPv6 != "2001:db8::1" || ipinfo.Source != "interface" {
+		t.Fatalf("Enrich should only add the ASN and OrgName, got %+v", ipinfo)
+	}
+}
+
+func TestEnrichOtherAddress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	// ipinfo answers about the caller when the address is not understood.
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(bytes.NewBufferString(`{"ip": "80.1.1.1","org": "AS3352 Telefonica de Espana"}`)),
+	}, nil)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}}
+
+	if _, err := requester.Enrich(context.Background(), domain.IPInfo{IPv4: "79.12.12.12"}); err == nil {
+		t.Fatal("Enrich should fail when ipinfo returns the information of another address")
+	}
+}
+
+func TestGetIPInfoToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	transport.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Authorization") != "Bearer secret-token" {
+			t.Errorf("GetIPInfo should send the token as a bearer token, got \"%s\"", req.Header.Get("Authorization"))
+		}
+		if strings.Contains(req.URL.String(), "secret-token") {
+			t.Errorf("GetIPInfo should keep the token out of the URL, requested %s", req.URL)
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(`{"ip": "79.12.12.12","org": "AS57269 DIGI SPAIN TELECOM S.L."}`))}, nil
+	})
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}, Token: "secret-token"}
+
+	if _, err := requester.GetIPInfo(context.Background()); err != nil {
+		t.Fatalf("GetIPInfo shouldn't fail with a token: %v", err)
+	}
+}
+
+// A rate limited request is reported with its Retry-After, and the other
+// family is not asked since it shares the quota.
+func TestGetIPInfoRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: 429,
+		Header:     http.Header{"Retry-After": []string{"3600"}},
+		Body:       io.NopCloser(bytes.NewBufferString(`{"status": 429, "error": {"title": "Rate limit exceeded"}}`)),
+	}, nil).Times(1)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}, Families: []domain.IPFamily{domain.IPv4, domain.IPv6}}
+
+	_, err := requester.GetIPInfo(context.Background())
+
+	var rateLimit *domain.RateLimitError
+	if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != time.Hour {
+		t.Fatalf("GetIPInfo should fail with a RateLimitError retrying after an hour, got %v", err)
+	}
+}
+
+func TestGetIPInfoUnavailable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: 503,
+		Body:       io.NopCloser(bytes.NewBufferString("Service Unavailable")),
+	}, nil)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}}
+
+	_, err := requester.GetIPInfo(context.Background())
+
+	var unavailable *domain.UnavailableError
+	var rateLimit *domain.RateLimitError
+	if !errors.As(err, &unavailable) || errors.As(err, &rateLimit) {
+		t.Fatalf("GetIPInfo should fail with an UnavailableError, got %v", err)
+	}
+}
+
+func TestGetIPInfoInvalidToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transport := mock.NewMockRoundTripper(ctrl)
+
+	transport.EXPECT().RoundTrip(gomock.Any()).Return(&http.Response{
+		StatusCode: 403,
+		Body:       io.NopCloser(bytes.NewBufferString(`{"status": 403, "error": {"title": "Wrong token"}}`)),
+	}, nil)
+
+	requester := IPInfoRequester{HttpClient: &http.Client{Transport: transport}, Token: "wrong"}
+
+	_, err := requester.GetIPInfo(context.Background())
+
+	var unavailable *domain.UnavailableError
+	var rateLimit *domain.RateLimitError
+	if err == nil || errors.As(err, &unavailable) || errors.As(err, &rateLimit) {
+		t.Fatalf("GetIPInfo should fail with a plain error when the token is rejected, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC)
+
+	expected := map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Wed, 24 Jun 2026 10:30:00 GMT": 30 * time.Minute,
+		"Wed, 24 Jun 2026 09:30:00 GMT": 0,
+		"-5":                            0,
+		"soon":                          0,
+		"":                              0,
+	}
+	for value, delay := range expected {
+		if got := retryAfter(value, now); got != delay {
+			t.Errorf("retryAfter(\"%s\") should be %s but it was %s", value, delay, got)
+		}
+	}
+}
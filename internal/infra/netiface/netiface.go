@@ -0,0 +1,113 @@
+package netiface
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// interfaceAddrs returns the addresses of the named interface. It is a package
+// var so tests can fake the interfaces of the host.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, interfaceErr := net.InterfaceByName(name)
+	if interfaceErr != nil {
+		return nil, interfaceErr
+	}
+	return iface.Addrs()
+}
+
+// Provider is the local interface adapter, for hosts (usually the edge router
+// itself) whose WAN interface holds the public address. It implements
+// domain.IPInfoProvider by reading the addresses of Interface (e.g. "ppp0"),
+// one per family in Families; when Families is empty only IPv4 is read.
+//
+// Link-local, loopback, private (RFC 1918) and unique local (ULA) addresses
+// are skipped, so only a public address is returned. An interface only holding
+// a carrier-grade NAT address makes GetIPInfo fail with a *domain.CGNATError.
+//
+// The interface does not know its ISP, so Enricher, when set, is asked for the
+// ASN and organization of the addresses.
+type Provider struct {
+	Interface string
+	Families  []domain.IPFamily
+	Enricher  domain.IPInfoEnricher
+}
+
+// GetIPInfo returns the public addresses of the interface. A family without
+// one is left empty; an error is returned when no family has one or when the
+// enricher fails.
+func (provider Provider) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "netiface.GetIPInfo", "interface", provider.Interface)
+
+	families := provider.Families
+	if len(families) == 0 {
+		families = []domain.IPFamily{domain.IPv4}
+	}
+
+	addrs, addrsErr := interfaceAddrs(provider.Interface)
+	if addrsErr != nil {
+		log.ErrorContext(ctx, "Error reading interface addresses", "error", addrsErr)
+		return domain.IPInfo{}, fmt.Errorf("addresses of interface %s cannot be read: %w", provider.Interface, addrsErr)
+	}
+
+	var ipinfo domain.IPInfo
+	cgnat := ""
+	for _, addr := range addrs {
+		prefix, parseErr := netip.ParsePrefix(addr.String())
+		if parseErr != nil {
+			continue
+		}
+		address := prefix.Addr().Unmap()
+		family, _ := domain.FamilyOf(address.String())
+		if ipinfo.Address(family) != "" || !slices.Contains(families, family) {
+			continue
+		}
+		if domain.IsCGNAT(address.String()) {
+			cgnat = address.String()
+			continue
+		}
+		if !isPublic(address) {
+			log.DebugContext(ctx, "Skipping non public interface address", "address", address)
+			continue
+		}
+		if setAddressErr := ipinfo.SetAddress(address.String()); setAddressErr != nil {
+			return domain.IPInfo{}, setAddressErr
+		}
+		log.DebugContext(ctx, "Public address read from interface", "family", family, "address", address)
+	}
+
+	if len(ipinfo.Addresses()) == 0 {
+		if cgnat != "" {
+			cgnatErr := &domain.CGNATError{Address: cgnat}
+			log.ErrorContext(ctx, "Interface is behind a carrier-grade NAT", "address", cgnat)
+			return domain.IPInfo{}, cgnatErr
+		}
+		noAddressErr := fmt.Errorf("interface %s has no public address", provider.Interface)
+		log.ErrorContext(ctx, "Error reading public address from interface", "error", noAddressErr)
+		return domain.IPInfo{}, noAddressErr
+	}
+
+	if provider.Enricher != nil {
+		enriched, enrichErr := provider.Enricher.Enrich(ctx, ipinfo)
+		if enrichErr != nil {
+			log.ErrorContext(ctx, "Error looking up the organization of the interface addresses", "error", enrichErr)
+			return domain.IPInfo{}, fmt.Errorf("organization of %s cannot be looked up: %w", ipinfo.Addresses()[0], enrichErr)
+		}
+		ipinfo = enriched
+	}
+
+	ipinfo.Source = "interface"
+	return ipinfo, nil
+}
+
+// isPublic reports whether address is a global unicast address outside the
+// private and unique local ranges.
+func isPublic(address netip.Addr) bool {
+	return address.IsGlobalUnicast() && !address.IsPrivate()
+}
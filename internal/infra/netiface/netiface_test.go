@@ -0,0 +1,124 @@
+//go:build integration_tests || unit_tests || netiface_tests || netiface_unit_tests
+
+package netiface
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// useInterface fakes an interface named "ppp0" holding prefixes.
+func useInterface(t *testing.T, prefixes ...string) {
+	t.Helper()
+	previous := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = previous })
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		if name != "ppp0" {
+			return nil, errors.New("no such network interface")
+		}
+		var addrs []net.Addr
+		for _, prefix := range prefixes {
+			parsed := netip.MustParsePrefix(prefix)
+			addrs = append(addrs, &net.IPNet{IP: parsed.Addr().AsSlice(), Mask: net.CIDRMask(parsed.Bits(), parsed.Addr().BitLen())})
+		}
+		return addrs, nil
+	}
+}
+
+// enricherMock fakes a domain.IPInfoEnricher, recording the addresses it was
+// asked about.
+type enricherMock struct {
+	asked   *[]string
+	asn     uint32
+	orgName string
+	err     error
+}
+
+func (mock enricherMock) Enrich(ctx context.Context, ipinfo domain.IPInfo) (domain.IPInfo, error) {
+	*mock.asked = append(*mock.asked, ipinfo.Addresses()...)
+	if mock.err != nil {
+		return ipinfo, mock.err
+	}
+	ipinfo.ASN = mock.asn
+	ipinfo.OrgName = mock.orgName
+	return ipinfo, nil
+}
+
+// Non public addresses are skipped and the organization is looked up.
+func TestInterfacePublicAddresses(t *testing.T) {
+
+	useInterface(t, "10.0.0.2/8", "100.64.1.1/10", "79.12.12.12/32", "79.12.12.13/32", "fe80::1/64", "fd00::1/64", "2001:db8:1::7/64")
+
+	var asked []string
+	provider := Provider{Interface: "ppp0", Families: []domain.IPFamily{domain.IPv4, domain.IPv6}, Enricher: enricherMock{asked: &asked, asn: 57269, orgName: "DIGI SPAIN TELECOM S.L."}}
+
+	ipinfo, err := provider.GetIPInfo(context.Background())
+	if err != nil {
+		t.Fatalf("TestInterfacePublicAddresses should not fail: %v", err)
+	}
+	expected := domain.IPInfo{IPv4: "79.12.12.12", IPv6: "2001:db8:1::7", ASN: 57269, OrgName: "DIGI SPAIN TELECOM S.L.", Source: "interface"}
+	if ipinfo != expected {
+		t.Errorf("TestInterfacePublicAddresses should return %+v, got %+v", expected, ipinfo)
+	}
+	if len(asked) != 2 {
+		t.Errorf("TestInterfacePublicAddresses should look up the public addresses, asked %v", asked)
+	}
+
+}
+
+// Without an enricher only the addresses are returned.
+func TestInterfaceWithoutEnricher(t *testing.T) {
+
+	useInterface(t, "79.12.12.12/32", "2001:db8:1::7/64")
+
+	ipinfo, err := Provider{Interface: "ppp0"}.GetIPInfo(context.Background())
+	if err != nil || ipinfo != (domain.IPInfo{IPv4: "79.12.12.12", Source: "interface"}) {
+		t.Errorf("TestInterfaceWithoutEnricher should return the IPv4 address only, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+func TestInterfaceEnricherError(t *testing.T) {
+
+	useInterface(t, "79.12.12.12/32")
+
+	var asked []string
+	provider := Provider{Interface: "ppp0", Enricher: enricherMock{asked: &asked, err: errors.New("FAIL")}}
+
+	if ipinfo, err := provider.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestInterfaceEnricherError should fail, got %+v", ipinfo)
+	}
+
+}
+
+func TestInterfaceNoPublicAddress(t *testing.T) {
+
+	useInterface(t, "192.168.1.2/24", "fe80::1/64")
+
+	if ipinfo, err := (Provider{Interface: "ppp0"}).GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestInterfaceNoPublicAddress should fail, got %+v", ipinfo)
+	}
+
+	if ipinfo, err := (Provider{Interface: "eth9"}).GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestInterfaceNoPublicAddress should fail with an unknown interface, got %+v", ipinfo)
+	}
+
+}
+
+func TestInterfaceCGNAT(t *testing.T) {
+
+	useInterface(t, "100.64.1.1/10")
+
+	_, err := Provider{Interface: "ppp0"}.GetIPInfo(context.Background())
+
+	var cgnat *domain.CGNATError
+	if !errors.As(err, &cgnat) || cgnat.Address != "100.64.1.1" {
+		t.Errorf("TestInterfaceCGNAT should fail with a CGNATError for 100.64.1.1, got %v", err)
+	}
+
+}
@@ -0,0 +1,57 @@
+//go:build integration_tests || notify_tests
+
+package notify
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	rabbitmqconfig "github.com/a-castellano/go-types/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// TestAMQPPublisher publishes a binary CloudEvent to the development RabbitMQ
+// (RABBITMQ_HOST, "rabbitmq" by default) and reads it back.
+func TestAMQPPublisher(t *testing.T) {
+
+	config := &rabbitmqconfig.Config{Host: cmp.Or(os.Getenv("RABBITMQ_HOST"), "rabbitmq"), Port: 5672, User: "guest", Password: "guest"}
+	queue := "home-ip-monitor-amqp-test"
+
+	publisher := AMQPPublisher{Config: config}
+	defer publisher.Close()
+
+	ctx := context.Background()
+	headers := map[string]any{"cloudEvents:id": "1234"}
+	if publishErr := publisher.Publish(ctx, queue, []byte("1.1.1.1"), textContentType, "1234", headers); publishErr != nil {
+		t.Fatalf("TestAMQPPublisher should not fail publishing: %v", publishErr)
+	}
+
+	connection, dialErr := amqp.Dial(publisher.url())
+	if dialErr != nil {
+		t.Fatalf("TestAMQPPublisher should connect to RabbitMQ: %v", dialErr)
+	}
+	defer connection.Close()
+	channel, channelErr := connection.Channel()
+	if channelErr != nil {
+		t.Fatalf("TestAMQPPublisher should open a channel: %v", channelErr)
+	}
+
+	var delivery amqp.Delivery
+	var found bool
+	for deadline := time.Now().Add(5 * time.Second); !found && time.Now().Before(deadline); {
+		var getErr error
+		if delivery, found, getErr = channel.Get(queue, true); getErr != nil {
+			t.Fatalf("TestAMQPPublisher should read the queue: %v", getErr)
+		}
+	}
+
+	if !found {
+		t.Fatalf("TestAMQPPublisher should find the published message")
+	}
+	if string(delivery.Body) != "1.1.1.1" || delivery.ContentType != textContentType || delivery.MessageId != "1234" || delivery.Headers["cloudEvents:id"] != "1234" {
+		t.Errorf("TestAMQPPublisher read an unexpected message: %s (%s, %s) %v", delivery.Body, delivery.ContentType, delivery.MessageId, delivery.Headers)
+	}
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// CloudEventsMode selects whether and how events are wrapped in a CloudEvents
+// 1.0 envelope.
+type CloudEventsMode string
+
+const (
+	NoCloudEvents         CloudEventsMode = "none"       // Bare payload, as sent by the message broker
+	StructuredCloudEvents CloudEventsMode = "structured" // Attributes and payload in one application/cloudevents+json body
+	BinaryCloudEvents     CloudEventsMode = "binary"     // Payload as body, attributes as AMQP headers
+)
+
+// ParseCloudEventsMode returns the CloudEventsMode named by value.
+func ParseCloudEventsMode(value string) (CloudEventsMode, error) {
+	switch mode := CloudEventsMode(value); mode {
+	case NoCloudEvents, StructuredCloudEvents, BinaryCloudEvents:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown CloudEvents mode \"%s\"", value)
+}
+
+const (
+	// cloudEventsSpecVersion is the CloudEvents specification implemented.
+	cloudEventsSpecVersion = "1.0"
+	// cloudEventsContentType is the content type of structured mode bodies.
+	cloudEventsContentType = "application/cloudevents+json; charset=UTF-8"
+	// cloudEventsHeaderPrefix prefixes every attribute sent as an AMQP header
+	// in binary mode, as defined by the CloudEvents AMQP protocol binding.
+	cloudEventsHeaderPrefix = "cloudEvents:"
+	// cloudEventsTypePrefix turns an event type into a reverse-DNS CloudEvents
+	// type, e.g. "ip.changed" into "net.windmaker.homeipmonitor.ip.changed".
+	cloudEventsTypePrefix = "net.windmaker.homeipmonitor."
+)
+
+// cloudEventAttributes returns the CloudEvents context attributes of event.
+// The address family, when the event is about one, is sent as the "ipfamily"
+// extension so consumers can route on it.
+func cloudEventAttributes(event domain.Event, source string, contentType string) map[string]string {
+	attributes := map[string]string{
+		"specversion":     cloudEventsSpecVersion,
+		"id":              event.ID,
+		"source":          source,
+		"type":            cloudEventsTypePrefix + string(event.Type),
+		"time":            event.Time.UTC().Format(time.RFC3339Nano),
+		"datacontenttype": contentType,
+	}
+	if event.Domain != "" {
+		attributes["subject"] = event.Domain
+	}
+	if event.Family != "" {
+		attributes["ipfamily"] = string(event.Family)
+	}
+	return attributes
+}
+
+// structuredCloudEvent returns the structured mode body wrapping payload. A
+// JSON payload is embedded as is in "data"; any other payload as a string.
+func structuredCloudEvent(attributes map[string]string, payload []byte, contentType string) ([]byte, error) {
+	envelope := make(map[string]any, len(attributes)+1)
+	for name, value := range attributes {
+		envelope[name] = value
+	}
+	if contentType == jsonContentType {
+		envelope["data"] = json.RawMessage(payload)
+	} else {
+		envelope["data"] = string(payload)
+	}
+	return json.Marshal(envelope)
+}
+
+// binaryCloudEventHeaders returns the AMQP headers carrying attributes in
+// binary mode. datacontenttype is left out: it travels as the message
+// content type.
+func binaryCloudEventHeaders(attributes map[string]string) map[string]any {
+	headers := make(map[string]any, len(attributes))
+	for name, value := range attributes {
+		if name == "datacontenttype" {
+			continue
+		}
+		headers[cloudEventsHeaderPrefix+name] = value
+	}
+	return headers
+}
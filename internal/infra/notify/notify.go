@@ -2,33 +2,158 @@ package notify
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	logger "github.com/a-castellano/go-services/infra/logger"
 	messagebroker "github.com/a-castellano/go-services/services/messagebroker"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
 )
 
-// BrokerNotifier is the messaging adapter. It wraps a
-// messagebroker.MessageBroker and implements domain.Notifier.
+// PayloadFormat selects how events are encoded before being sent.
+type PayloadFormat string
+
+const (
+	TextFormat PayloadFormat = "text" // Only the event message, as sent before events existed
+	JSONFormat PayloadFormat = "json" // The whole event as a versioned JSON document
+)
+
+// ParsePayloadFormat returns the PayloadFormat named by value.
+func ParsePayloadFormat(value string) (PayloadFormat, error) {
+	switch format := PayloadFormat(value); format {
+	case TextFormat, JSONFormat:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown payload format \"%s\"", value)
+}
+
+// Content types of the payload formats.
+const (
+	textContentType = "text/plain; charset=UTF-8"
+	jsonContentType = "application/json"
+)
+
+// eventData is the unexported DTO that maps a domain.Event to its JSON
+// payload. Field names are part of the published schema.
+type eventData struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Type          string    `json:"type"`
+	ID            string    `json:"id"`
+	Time          time.Time `json:"time"`
+	Family        string    `json:"family,omitempty"`
+	OldIP         string    `json:"oldIP,omitempty"`
+	NewIP         string    `json:"newIP,omitempty"`
+	Addresses     []string  `json:"addresses,omitempty"`
+	ISP           string    `json:"isp,omitempty"`
+	ASN           uint32    `json:"asn,omitempty"`
+	Domain        string    `json:"domain,omitempty"`
+	Source        string    `json:"source,omitempty"`
+	Message       string    `json:"message"`
+}
+
+// Publisher sends a message along with its content type, message ID and AMQP
+// headers. The messagebroker.MessageBroker can only send a bare body, so a
+// Publisher is required to wrap events in CloudEvents envelopes.
+type Publisher interface {
+	Publish(ctx context.Context, queue string, body []byte, contentType string, messageID string, headers map[string]any) error
+}
+
+// BrokerNotifier is the messaging adapter and implements domain.Notifier.
+// Events are encoded according to Format; an empty Format means TextFormat.
+//
+// Events are sent through Publisher, with the event ID as the message ID, so
+// consumers of text payloads can discard duplicates too. Without Publisher
+// they are sent through Broker as a bare body, with no message ID. When
+// CloudEvents is StructuredCloudEvents or BinaryCloudEvents, events are
+// wrapped in a CloudEvents envelope, with Source as the "source" attribute,
+// which requires Publisher.
 type BrokerNotifier struct {
-	Broker messagebroker.MessageBroker
+	Broker      messagebroker.MessageBroker
+	Format      PayloadFormat
+	CloudEvents CloudEventsMode
+	Source      string
+	Publisher   Publisher
 }
 
-// Notify sends message to the given queue through the message broker.
-// It implements domain.Notifier.
+// Notify encodes event and sends it to the given queue through the message
+// broker (or the publisher, for CloudEvents). It implements domain.Notifier.
 //
 // Parameters:
 //   - ctx: Context for cancellation and timeouts
 //   - queue: Name of the queue to send the message to
-//   - message: Message content as byte array
+//   - event: Event to send
 //
 // Returns:
-//   - error: Error if message sending fails
-func (brokerNotifier *BrokerNotifier) Notify(ctx context.Context, queue string, message []byte) error {
+//   - error: Error if the event cannot be encoded or message sending fails
+func (brokerNotifier *BrokerNotifier) Notify(ctx context.Context, queue string, event domain.Event) error {
 	log := logger.FromContext(ctx).With("operation", "Notify")
-	log.DebugContext(ctx, "Notifying message to queue", "queue", queue, "message", message)
 
-	notifyError := brokerNotifier.Broker.SendMessage(ctx, queue, message)
+	message, contentType, encodeErr := brokerNotifier.encode(event)
+	if encodeErr != nil {
+		log.ErrorContext(ctx, "Error encoding event", "event", event.ID, "error", encodeErr)
+		return encodeErr
+	}
+
+	if brokerNotifier.CloudEvents == "" || brokerNotifier.CloudEvents == NoCloudEvents {
+		log.DebugContext(ctx, "Notifying message to queue", "queue", queue, "event", event.ID, "message", message)
+
+		if brokerNotifier.Publisher != nil {
+			return brokerNotifier.Publisher.Publish(ctx, queue, message, contentType, event.ID, nil)
+		}
+
+		notifyError := brokerNotifier.Broker.SendMessage(ctx, queue, message)
+
+		return notifyError
+	}
+
+	if brokerNotifier.Publisher == nil {
+		publisherErr := errors.New("CloudEvents envelopes require a publisher")
+		log.ErrorContext(ctx, "Error notifying CloudEvent", "error", publisherErr)
+		return publisherErr
+	}
 
-	return notifyError
+	attributes := cloudEventAttributes(event, brokerNotifier.Source, contentType)
+	var headers map[string]any
+
+	if brokerNotifier.CloudEvents == StructuredCloudEvents {
+		structuredMessage, structuredErr := structuredCloudEvent(attributes, message, contentType)
+		if structuredErr != nil {
+			log.ErrorContext(ctx, "Error encoding structured CloudEvent", "event", event.ID, "error", structuredErr)
+			return structuredErr
+		}
+		message, contentType = structuredMessage, cloudEventsContentType
+	} else {
+		headers = binaryCloudEventHeaders(attributes)
+	}
+
+	log.DebugContext(ctx, "Notifying CloudEvent to queue", "queue", queue, "event", event.ID, "mode", brokerNotifier.CloudEvents, "contentType", contentType, "message", message)
+
+	return brokerNotifier.Publisher.Publish(ctx, queue, message, contentType, event.ID, headers)
+
+}
 
+// encode returns the payload of event in the configured format along with
+// its content type.
+func (brokerNotifier *BrokerNotifier) encode(event domain.Event) ([]byte, string, error) {
+	if brokerNotifier.Format != JSONFormat {
+		return []byte(event.Message), textContentType, nil
+	}
+	payload, marshalErr := json.Marshal(eventData{
+		SchemaVersion: event.SchemaVersion,
+		Type:          string(event.Type),
+		ID:            event.ID,
+		Time:          event.Time,
+		Family:        string(event.Family),
+		OldIP:         event.OldIP,
+		NewIP:         event.NewIP,
+		Addresses:     event.Addresses,
+		ISP:           event.ISP,
+		ASN:           event.ASN,
+		Domain:        event.Domain,
+		Source:        event.Source,
+		Message:       event.Message,
+	})
+	return payload, jsonContentType, marshalErr
 }
@@ -3,20 +3,28 @@
 package notify
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	messagebroker "github.com/a-castellano/go-services/services/messagebroker"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	"maps"
 	"testing"
+	"time"
 )
 
 type RabbitmqMock struct {
 	LaunchError bool
+	Sent        *[]string
 }
 
 func (client RabbitmqMock) SendMessage(ctx context.Context, queueName string, message []byte) error {
 	if client.LaunchError {
 		return errors.New("Error")
 	}
+	if client.Sent != nil {
+		*client.Sent = append(*client.Sent, string(message))
+	}
 	return nil
 }
 
@@ -44,11 +52,156 @@ func TestNotify(t *testing.T) {
 
 	brokerNotifier := BrokerNotifier{Broker: messageBroker}
 
-	testMessage := []byte("This is a test")
+	testEvent := domain.Event{Message: "This is a test"}
 
-	notifyError := brokerNotifier.Notify(ctx, "testQueue", testMessage)
+	notifyError := brokerNotifier.Notify(ctx, "testQueue", testEvent)
 
 	if notifyError != nil {
 		t.Errorf("TestNotify should not fail")
 	}
 }
+
+// The payload format decides what reaches the queue: the bare message in text
+// format (the historical payload) or the whole versioned event in JSON format.
+func TestNotifyPayloadFormats(t *testing.T) {
+
+	ctx := context.Background()
+
+	event := domain.Event{SchemaVersion: 1, Type: domain.DNSUpdateEvent, ID: "6f1c0e52-7a43-4b8e-9d2a-2f1f5bfae001", Time: time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC), Family: domain.IPv4, OldIP: "1.1.1.1", NewIP: "1.1.1.2", Addresses: []string{"1.1.1.2"}, ISP: "DIGI", ASN: 57269, Domain: "home.windmaker.net", Source: "ipinfo", Message: "1.1.1.2"}
+
+	expected := map[PayloadFormat]string{
+		"":         "1.1.1.2",
+		TextFormat: "1.1.1.2",
+		JSONFormat: `{"schemaVersion":1,"type":"dns.update","id":"6f1c0e52-7a43-4b8e-9d2a-2f1f5bfae001","time":"2026-06-24T10:00:00Z","family":"ipv4","oldIP":"1.1.1.1","newIP":"1.1.1.2","addresses":["1.1.1.2"],"isp":"DIGI","asn":57269,"domain":"home.windmaker.net","source":"ipinfo","message":"1.1.1.2"}`,
+	}
+
+	for format, payload := range expected {
+		var sent []string
+		messageBroker := messagebroker.MessageBroker{Client: RabbitmqMock{Sent: &sent}}
+		brokerNotifier := BrokerNotifier{Broker: messageBroker, Format: format}
+
+		if notifyError := brokerNotifier.Notify(ctx, "testQueue", event); notifyError != nil {
+			t.Fatalf("Notify should not fail with format \"%s\": %v", format, notifyError)
+		}
+		if len(sent) != 1 || sent[0] != payload {
+			t.Errorf("Notify with format \"%s\" should send %s but sent %v", format, payload, sent)
+		}
+	}
+}
+
+func TestParsePayloadFormat(t *testing.T) {
+
+	if format, err := ParsePayloadFormat("json"); err != nil || format != JSONFormat {
+		t.Errorf("ParsePayloadFormat(\"json\") should return JSONFormat, got \"%s\" (%v)", format, err)
+	}
+	if _, err := ParsePayloadFormat("xml"); err == nil {
+		t.Errorf("ParsePayloadFormat should fail with an unknown format")
+	}
+}
+
+// publisherMock fakes Publisher, recording the last published message.
+type publisherMock struct {
+	body        *[]byte
+	contentType *string
+	messageID   *string
+	headers     *map[string]any
+}
+
+func (mock publisherMock) Publish(ctx context.Context, queue string, body []byte, contentType string, messageID string, headers map[string]any) error {
+	*mock.body, *mock.contentType, *mock.headers = body, contentType, headers
+	if mock.messageID != nil {
+		*mock.messageID = messageID
+	}
+	return nil
+}
+
+var cloudEventTest = domain.Event{SchemaVersion: 1, Type: domain.IPChangedEvent, ID: "6f1c0e52-7a43-4b8e-9d2a-2f1f5bfae001", Time: time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC), Family: domain.IPv4, NewIP: "1.1.1.2", Domain: "home.windmaker.net", Message: "Home IPv4 has changed to 1.1.1.2."}
+
+// Structured mode sends the attributes and the payload in a single
+// application/cloudevents+json body.
+func TestNotifyStructuredCloudEvent(t *testing.T) {
+
+	var body []byte
+	var contentType string
+	var headers map[string]any
+	brokerNotifier := BrokerNotifier{CloudEvents: StructuredCloudEvents, Source: "home-ip-monitor", Publisher: publisherMock{body: &body, contentType: &contentType, headers: &headers}}
+
+	if notifyError := brokerNotifier.Notify(context.Background(), "testQueue", cloudEventTest); notifyError != nil {
+		t.Fatalf("TestNotifyStructuredCloudEvent should not fail: %v", notifyError)
+	}
+
+	expected := `{"data":"Home IPv4 has changed to 1.1.1.2.","datacontenttype":"text/plain; charset=UTF-8","id":"6f1c0e52-7a43-4b8e-9d2a-2f1f5bfae001","ipfamily":"ipv4","source":"home-ip-monitor","specversion":"1.0","subject":"home.windmaker.net","time":"2026-06-24T10:00:00Z","type":"net.windmaker.homeipmonitor.ip.changed"}`
+	if string(body) != expected {
+		t.Errorf("TestNotifyStructuredCloudEvent should send %s but sent %s", expected, body)
+	}
+	if contentType != "application/cloudevents+json; charset=UTF-8" || headers != nil {
+		t.Errorf("TestNotifyStructuredCloudEvent should use the CloudEvents content type without headers, got \"%s\" and %v", contentType, headers)
+	}
+
+	brokerNotifier.Format = JSONFormat
+	if notifyError := brokerNotifier.Notify(context.Background(), "testQueue", cloudEventTest); notifyError != nil {
+		t.Fatalf("TestNotifyStructuredCloudEvent should not fail with JSON payloads: %v", notifyError)
+	}
+	if !bytes.Contains(body, []byte(`"data":{"schemaVersion":1,`)) || !bytes.Contains(body, []byte(`"datacontenttype":"application/json"`)) {
+		t.Errorf("TestNotifyStructuredCloudEvent should embed JSON payloads as objects, sent %s", body)
+	}
+}
+
+// Binary mode sends the payload as the body and the attributes as AMQP headers.
+func TestNotifyBinaryCloudEvent(t *testing.T) {
+
+	var body []byte
+	var contentType string
+	var headers map[string]any
+	brokerNotifier := BrokerNotifier{Format: JSONFormat, CloudEvents: BinaryCloudEvents, Source: "home-ip-monitor", Publisher: publisherMock{body: &body, contentType: &contentType, headers: &headers}}
+
+	if notifyError := brokerNotifier.Notify(context.Background(), "testQueue", cloudEventTest); notifyError != nil {
+		t.Fatalf("TestNotifyBinaryCloudEvent should not fail: %v", notifyError)
+	}
+
+	if !bytes.HasPrefix(body, []byte(`{"schemaVersion":1,"type":"ip.changed"`)) || contentType != "application/json" {
+		t.Errorf("TestNotifyBinaryCloudEvent should send the JSON payload as body, sent %s as \"%s\"", body, contentType)
+	}
+	expected := map[string]any{
+		"cloudEvents:specversion": "1.0",
+		"cloudEvents:id":          "6f1c0e52-7a43-4b8e-9d2a-2f1f5bfae001",
+		"cloudEvents:source":      "home-ip-monitor",
+		"cloudEvents:type":        "net.windmaker.homeipmonitor.ip.changed",
+		"cloudEvents:time":        "2026-06-24T10:00:00Z",
+		"cloudEvents:subject":     "home.windmaker.net",
+		"cloudEvents:ipfamily":    "ipv4",
+	}
+	if !maps.Equal(headers, expected) {
+		t.Errorf("TestNotifyBinaryCloudEvent should send headers %v but sent %v", expected, headers)
+	}
+}
+
+// Without CloudEvents, the event ID still reaches consumers of text payloads
+// as the message ID.
+func TestNotifyTextMessageID(t *testing.T) {
+
+	var body []byte
+	var contentType, messageID string
+	var headers map[string]any
+	brokerNotifier := BrokerNotifier{Publisher: publisherMock{body: &body, contentType: &contentType, messageID: &messageID, headers: &headers}}
+
+	if notifyError := brokerNotifier.Notify(context.Background(), "testQueue", cloudEventTest); notifyError != nil {
+		t.Fatalf("TestNotifyTextMessageID should not fail: %v", notifyError)
+	}
+
+	if string(body) != cloudEventTest.Message || contentType != textContentType || headers != nil {
+		t.Errorf("TestNotifyTextMessageID should send the bare text message, sent %s as \"%s\" with %v", body, contentType, headers)
+	}
+	if messageID != cloudEventTest.ID {
+		t.Errorf("TestNotifyTextMessageID should send message ID %s, sent \"%s\"", cloudEventTest.ID, messageID)
+	}
+}
+
+func TestNotifyCloudEventWithoutPublisher(t *testing.T) {
+
+	brokerNotifier := BrokerNotifier{CloudEvents: BinaryCloudEvents}
+
+	if notifyError := brokerNotifier.Notify(context.Background(), "testQueue", cloudEventTest); notifyError == nil {
+		t.Errorf("TestNotifyCloudEventWithoutPublisher should fail without a publisher")
+	}
+}
@@ -0,0 +1,166 @@
+package nslookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// MyIPQuery is a DNS question whose answer is the address the query came
+// from. Name is asked to Server ("host:port"), which must be the server that
+// knows the trick (e.g. myip.opendns.com on resolver1.opendns.com), not a
+// recursive resolver in between. When TXT is set the address is read from a
+// TXT record, as o-o.myaddr.l.google.com does; otherwise from the A or AAAA
+// record of the detected family.
+type MyIPQuery struct {
+	Name   string
+	Server string
+	TXT    bool
+}
+
+// DefaultMyIPQueries are the queries asked when none are configured: OpenDNS
+// first, then Google.
+var DefaultMyIPQueries = []MyIPQuery{
+	{Name: "myip.opendns.com", Server: "resolver1.opendns.com:53"},
+	{Name: "o-o.myaddr.l.google.com", Server: "ns1.google.com:53", TXT: true},
+}
+
+// ParseMyIPQuery parses a query written as "[txt:]name@host:port".
+func ParseMyIPQuery(value string) (MyIPQuery, error) {
+
+	var query MyIPQuery
+	value = strings.TrimSpace(value)
+	if rest, found := strings.CutPrefix(value, "txt:"); found {
+		query.TXT = true
+		value = rest
+	}
+
+	name, server, found := strings.Cut(value, "@")
+	if !found || name == "" {
+		return MyIPQuery{}, fmt.Errorf("DNS query \"%s\" must be written as \"[txt:]name@host:port\"", value)
+	}
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		return MyIPQuery{}, fmt.Errorf("DNS query \"%s\" must name a \"host:port\" server: %w", value, splitErr)
+	}
+	query.Name = name
+	query.Server = server
+	return query, nil
+}
+
+// String returns the query as accepted by ParseMyIPQuery.
+func (query MyIPQuery) String() string {
+	if query.TXT {
+		return "txt:" + query.Name + "@" + query.Server
+	}
+	return query.Name + "@" + query.Server
+}
+
+// MyIPLookup is the DNS adapter of domain.IPInfoProvider. It finds the public
+// address by asking Queries in order until one answers. Each family in
+// Families is detected on its own transport, since the servers answer with the
+// address the query came from; when Families is empty only IPv4 is detected.
+//
+// Like STUN, these queries only reveal the address, so the returned
+// domain.IPInfo carries no ASN or organization.
+type MyIPLookup struct {
+	Queries  []MyIPQuery
+	Families []domain.IPFamily
+}
+
+// GetIPInfo detects the public address of every configured family. A family
+// no query answered for is left empty; an error is returned only when no
+// family could be detected.
+func (lookup MyIPLookup) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "nslookup.GetIPInfo")
+
+	families := lookup.Families
+	if len(families) == 0 {
+		families = []domain.IPFamily{domain.IPv4}
+	}
+	queries := lookup.Queries
+	if len(queries) == 0 {
+		queries = DefaultMyIPQueries
+	}
+
+	var ipinfo domain.IPInfo
+	var errs []error
+
+	for _, family := range families {
+		address, familyErr := lookup.detect(ctx, queries, family)
+		if familyErr != nil {
+			log.ErrorContext(ctx, "Error detecting public address through DNS", "family", family, "error", familyErr)
+			errs = append(errs, familyErr)
+			continue
+		}
+		if setAddressErr := ipinfo.SetAddress(address.String()); setAddressErr != nil {
+			return domain.IPInfo{}, setAddressErr
+		}
+		log.DebugContext(ctx, "Public address detected through DNS", "family", family, "address", address)
+	}
+
+	if len(ipinfo.Addresses()) == 0 {
+		return domain.IPInfo{}, errors.Join(errs...)
+	}
+
+	ipinfo.Source = "dns"
+	return ipinfo, nil
+}
+
+// detect asks queries in order for the address of family and returns the
+// first answer.
+func (lookup MyIPLookup) detect(ctx context.Context, queries []MyIPQuery, family domain.IPFamily) (netip.Addr, error) {
+
+	log := logger.FromContext(ctx).With("operation", "nslookup.detect", "family", family)
+
+	var errs []error
+	for _, query := range queries {
+		address, queryErr := query.ask(ctx, family)
+		if queryErr == nil && address.Is6() != (family == domain.IPv6) {
+			queryErr = fmt.Errorf("DNS query returned %s, which is not an %s address", address, family)
+		}
+		if queryErr == nil {
+			return address, nil
+		}
+		log.DebugContext(ctx, "DNS query did not answer", "query", query, "error", queryErr)
+		errs = append(errs, fmt.Errorf("%s: %w", query, queryErr))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no DNS query returned an %s address: %w", family, errors.Join(errs...))
+}
+
+// ask sends the query to its server over the transport of family.
+func (query MyIPQuery) ask(ctx context.Context, family domain.IPFamily) (netip.Addr, error) {
+
+	resolver := newResolver(query.Server, family)
+
+	if !query.TXT {
+		ips, lookupErr := resolver.LookupIP(ctx, lookupNetworks[family], query.Name)
+		if lookupErr != nil {
+			return netip.Addr{}, lookupErr
+		}
+		address, _ := netip.AddrFromSlice(ips[0])
+		return address.Unmap(), nil
+	}
+
+	records, lookupErr := resolver.LookupTXT(ctx, query.Name)
+	if lookupErr != nil {
+		return netip.Addr{}, lookupErr
+	}
+	// Servers may add informational records, such as the EDNS client subnet
+	// Google returns when asked through a recursive resolver.
+	for _, record := range records {
+		if address, parseErr := netip.ParseAddr(strings.TrimSpace(record)); parseErr == nil {
+			return address.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no TXT record of %s holds an address", query.Name)
+}
@@ -0,0 +1,187 @@
+//go:build integration_tests || unit_tests || nslookup_tests || nslookup_unit_tests
+
+package nslookup
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// DNS record types answered by dnsServerMock.
+const (
+	typeA    = 1
+	typeTXT  = 16
+	typeAAAA = 28
+)
+
+// dnsServerMock is a local DNS server answering over UDP. answers maps a
+// record type to the data of its single answer; other types get an empty
+// answer.
+type dnsServerMock struct {
+	conn    net.PacketConn
+	answers map[uint16][]byte
+}
+
+func newDNSServerMock(t *testing.T, network string, address string, answers map[uint16][]byte) *dnsServerMock {
+	t.Helper()
+	conn, listenErr := net.ListenPacket(network, address)
+	if listenErr != nil {
+		t.Skipf("Cannot listen on %s %s: %v", network, address, listenErr)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	server := &dnsServerMock{conn: conn, answers: answers}
+	go server.serve()
+	return server
+}
+
+func (server *dnsServerMock) serve() {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, readErr := server.conn.ReadFrom(buffer)
+		if readErr != nil {
+			return
+		}
+		if answer := server.answer(buffer[:n]); answer != nil {
+			server.conn.WriteTo(answer, from)
+		}
+	}
+}
+
+// answer builds the response to the single question of query.
+func (server *dnsServerMock) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// Skip the question name, then its type and class.
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	questionType := binary.BigEndian.Uint16(query[end-4 : end-2])
+
+	response := make([]byte, 12, 512)
+	copy(response, query[:2])
+	binary.BigEndian.PutUint16(response[2:4], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(response[4:6], 1)
+	response = append(response, query[12:end]...)
+
+	data, found := server.answers[questionType]
+	if !found {
+		return response
+	}
+	binary.BigEndian.PutUint16(response[6:8], 1)
+	record := make([]byte, 12)
+	binary.BigEndian.PutUint16(record[0:2], 0xc00c) // pointer to the question name
+	binary.BigEndian.PutUint16(record[2:4], questionType)
+	binary.BigEndian.PutUint16(record[4:6], 1) // IN
+	binary.BigEndian.PutUint32(record[6:10], 60)
+	binary.BigEndian.PutUint16(record[10:12], uint16(len(data)))
+	return append(append(response, record...), data...)
+}
+
+func (server *dnsServerMock) address() string {
+	return server.conn.LocalAddr().String()
+}
+
+// txtData builds the data of a TXT record holding strings.
+func txtData(strings ...string) []byte {
+	var data []byte
+	for _, value := range strings {
+		data = append(data, byte(len(value)))
+		data = append(data, value...)
+	}
+	return data
+}
+
+func TestParseMyIPQuery(t *testing.T) {
+
+	query, parseErr := ParseMyIPQuery(" txt:o-o.myaddr.l.google.com@ns1.google.com:53 ")
+	if parseErr != nil || query != (MyIPQuery{Name: "o-o.myaddr.l.google.com", Server: "ns1.google.com:53", TXT: true}) {
+		t.Errorf("ParseMyIPQuery should parse a TXT query, got %+v (%v)", query, parseErr)
+	}
+	if query.String() != "txt:o-o.myaddr.l.google.com@ns1.google.com:53" {
+		t.Errorf("MyIPQuery.String should return the parsed form, got %s", query)
+	}
+
+	query, parseErr = ParseMyIPQuery("myip.opendns.com@[2620:119:35::35]:53")
+	if parseErr != nil || query.TXT || query.Server != "[2620:119:35::35]:53" {
+		t.Errorf("ParseMyIPQuery should parse an address query, got %+v (%v)", query, parseErr)
+	}
+
+	for _, value := range []string{"myip.opendns.com", "@resolver1.opendns.com:53", "myip.opendns.com@resolver1.opendns.com"} {
+		if _, parseErr := ParseMyIPQuery(value); parseErr == nil {
+			t.Errorf("ParseMyIPQuery should fail with \"%s\"", value)
+		}
+	}
+
+}
+
+func TestMyIPLookupAddressRecord(t *testing.T) {
+
+	server := newDNSServerMock(t, "udp4", "127.0.0.1:0", map[uint16][]byte{typeA: netip.MustParseAddr("203.0.113.7").AsSlice()})
+
+	lookup := MyIPLookup{Queries: []MyIPQuery{{Name: "myip.opendns.com", Server: server.address()}}}
+	ipinfo, err := lookup.GetIPInfo(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestMyIPLookupAddressRecord should not fail: %v", err)
+	}
+	if ipinfo.IPv4 != "203.0.113.7" || ipinfo.Source != "dns" {
+		t.Errorf("TestMyIPLookupAddressRecord should detect 203.0.113.7 through dns, got %+v", ipinfo)
+	}
+
+}
+
+func TestMyIPLookupAddressRecordIPv6(t *testing.T) {
+
+	server := newDNSServerMock(t, "udp6", "[::1]:0", map[uint16][]byte{typeAAAA: netip.MustParseAddr("2001:db8::7").AsSlice()})
+
+	lookup := MyIPLookup{Queries: []MyIPQuery{{Name: "myip.opendns.com", Server: server.address()}}, Families: []domain.IPFamily{domain.IPv6}}
+	ipinfo, err := lookup.GetIPInfo(context.Background())
+
+	if err != nil || ipinfo.IPv6 != "2001:db8::7" || ipinfo.IPv4 != "" {
+		t.Errorf("TestMyIPLookupAddressRecordIPv6 should detect 2001:db8::7 only, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+// Informational TXT records are skipped.
+func TestMyIPLookupTXTRecord(t *testing.T) {
+
+	server := newDNSServerMock(t, "udp4", "127.0.0.1:0", map[uint16][]byte{typeTXT: txtData("edns0-client-subnet 198.51.100.0/24")})
+	serverWithAddress := newDNSServerMock(t, "udp4", "127.0.0.1:0", map[uint16][]byte{typeTXT: txtData("198.51.100.20")})
+
+	lookup := MyIPLookup{Queries: []MyIPQuery{
+		{Name: "o-o.myaddr.l.google.com", Server: server.address(), TXT: true},
+		{Name: "o-o.myaddr.l.google.com", Server: serverWithAddress.address(), TXT: true},
+	}}
+	ipinfo, err := lookup.GetIPInfo(context.Background())
+
+	if err != nil || ipinfo.IPv4 != "198.51.100.20" {
+		t.Errorf("TestMyIPLookupTXTRecord should detect 198.51.100.20 from the second query, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+// An answer of the wrong family is not taken as the address.
+func TestMyIPLookupWrongFamily(t *testing.T) {
+
+	server := newDNSServerMock(t, "udp4", "127.0.0.1:0", map[uint16][]byte{typeTXT: txtData("2001:db8::7")})
+
+	lookup := MyIPLookup{Queries: []MyIPQuery{{Name: "o-o.myaddr.l.google.com", Server: server.address(), TXT: true}}}
+
+	if ipinfo, err := lookup.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestMyIPLookupWrongFamily should fail, got %+v", ipinfo)
+	}
+
+}
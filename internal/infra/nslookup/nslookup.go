@@ -2,9 +2,12 @@ package nslookup
 
 import (
 	"context"
-	logger "github.com/a-castellano/go-services/infra/logger"
 	"net"
 	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	dnswire "github.com/a-castellano/home-ip-monitor/internal/infra/dnswire"
 )
 
 // DNSLookup retrieves dns lookup information
@@ -13,45 +16,71 @@ type DNSLookup struct {
 	DNSServer string // DNS server address (e.g., "8.8.8.8:53")
 }
 
-// Resolve resolves the given domain to an IP address using the configured DNS
-// server. It implements domain.DNSResolver.
-//
-// Parameters:
-//   - ctx: Context for cancellation and timeouts
-//   - domain: Domain name to resolve
-//
-// Returns:
-//   - string: Resolved IP address (the first result)
-//   - error: Error if DNS lookup fails
-func (dnsLookup DNSLookup) Resolve(ctx context.Context, domain string) (string, error) {
+// lookupNetworks maps each family to the net.Resolver network that queries
+// only its record type: "ip4" asks for A records, "ip6" for AAAA records.
+var lookupNetworks = map[domain.IPFamily]string{
+	domain.IPv4: "ip4",
+	domain.IPv6: "ip6",
+}
 
-	log := logger.FromContext(ctx).With("operation", "Resolve")
-	var ip string
+// transportSuffixes maps each family to the suffix restricting a dial network
+// ("udp", "tcp") to it.
+var transportSuffixes = map[domain.IPFamily]string{
+	domain.IPv4: "4",
+	domain.IPv6: "6",
+}
+
+// newResolver returns a resolver that sends every query to server. When
+// transport is a family, server is reached over that family only.
+func newResolver(server string, transport domain.IPFamily) *net.Resolver {
 
-	log.DebugContext(ctx, "Creating dialer and resolver")
 	// Create dialer with timeout for DNS connections
 	dialer := &net.Dialer{
 		Timeout: time.Second * 5,
 	}
 
-	// Create custom resolver using the configured DNS server
-	resolver := &net.Resolver{
+	// Create custom resolver using the given DNS server
+	return &net.Resolver{
 		PreferGo: true,
 		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			return dialer.DialContext(ctx, network, dnsLookup.DNSServer)
+			return dialer.DialContext(ctx, network+transportSuffixes[transport], server)
 		},
 	}
+}
+
+// Resolve resolves the given domain to the address records of the requested
+// family using the configured DNS server. It implements domain.DNSResolver.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - domain: Domain name to resolve
+//   - family: Address family to resolve (A records for IPv4, AAAA for IPv6)
+//
+// Returns:
+//   - []domain.DNSRecord: Every record of that family, with its TTL (empty if there is none)
+//   - error: Error if DNS lookup fails
+func (dnsLookup DNSLookup) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+
+	log := logger.FromContext(ctx).With("operation", "Resolve")
 
-	// Perform DNS lookup for the domain
-	ips, err := resolver.LookupHost(ctx, domain)
+	// Perform DNS lookup for the domain, restricted to the requested family.
+	// The records are read from the answer itself, net.Resolver drops TTLs.
+	message, err := dnswire.Ask(ctx, dnsLookup.DNSServer, domainName, dnswire.RecordType(family), true)
+	if err == nil {
+		err = message.ResolveErr(domainName, dnsLookup.DNSServer)
+	}
 	if err != nil {
-		log.ErrorContext(ctx, "Error during domain nslookup", "domain", domain, "error", err.Error())
-		return ip, err
-	} else {
-		// Return the first IP address from the results
-		ip = ips[0]
+		log.ErrorContext(ctx, "Error during domain nslookup", "domain", domainName, "family", family, "error", err.Error())
+		return nil, err
+	}
+
+	records := message.AddressRecords(domainName, family)
+	// No record is an answer too: the monitor republishes the missing one.
+	if len(records) == 0 {
+		log.WarnContext(ctx, "Domain has no record of the family", "domain", domainName, "family", family)
+		return records, nil
 	}
-	log.InfoContext(ctx, "domain ip retrived", "domain", domain, "ip", ip)
+	log.InfoContext(ctx, "domain ip retrived", "domain", domainName, "family", family, "records", records)
 
-	return ip, nil
+	return records, nil
 }
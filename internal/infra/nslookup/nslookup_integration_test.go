@@ -5,6 +5,8 @@ package nslookup
 import (
 	"context"
 	"testing"
+
+	hostdomain "github.com/a-castellano/home-ip-monitor/internal/domain"
 )
 
 func TestGetIP(t *testing.T) {
@@ -16,12 +18,12 @@ func TestGetIP(t *testing.T) {
 	domain := "test.windmaker.net"
 	expectedIP := "213.32.122.25"
 
-	ip, err := dnsLookup.Resolve(ctx, domain)
+	records, err := dnsLookup.Resolve(ctx, domain, hostdomain.IPv4)
 	if err != nil {
 		t.Errorf("GetIP should not fail resolving test.windmaker.net: %v", err)
 	} else {
-		if ip != expectedIP {
-			t.Errorf("GetIP returned %s, expected %s", ip, expectedIP)
+		if len(records) != 1 || records[0].Address != expectedIP || records[0].Type != "A" {
+			t.Errorf("GetIP returned %+v, expected a single A record of %s", records, expectedIP)
 		}
 	}
 }
@@ -34,7 +36,7 @@ func TestGetIPBadDNS(t *testing.T) {
 
 	domain := "test.windmaker.net"
 
-	_, err := dnsLookup.Resolve(ctx, domain)
+	_, err := dnsLookup.Resolve(ctx, domain, hostdomain.IPv4)
 	if err == nil {
 		t.Errorf("GetIP should fail resolving test.windmaker.net from bad DNS server: %v", err)
 	}
@@ -0,0 +1,171 @@
+package quorum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// Member is one of the public IP providers asked by Provider, identified by
+// the name used in logs and disagreement reports.
+type Member struct {
+	Name     string
+	Provider domain.IPInfoProvider
+}
+
+// Provider is a composite domain.IPInfoProvider. It asks every member
+// concurrently and only trusts an address when at least Quorum members report
+// it, so a single bad or stale answer never triggers an update. When Quorum is
+// zero a simple majority of the members is required.
+type Provider struct {
+	Members []Member
+	Quorum  int
+}
+
+// memberResult is the answer (or failure) of a single member.
+type memberResult struct {
+	ipinfo domain.IPInfo
+	err    error
+}
+
+// GetIPInfo implements domain.IPInfoProvider. Families are voted on
+// independently:
+//
+//   - a family fewer members than the quorum reported is left empty, since
+//     single-stack members cannot vote on the other family;
+//   - a family whose most reported address reaches the quorum gets it;
+//   - any other family, split between different addresses, makes GetIPInfo
+//     fail with a *domain.DisagreementError.
+//
+// It fails with a plain error when fewer members than the quorum answered at
+// all, since that is an outage rather than a disagreement, and with the
+// *domain.CGNATError of any member that reported one. ASN and OrgName are
+// taken from the first member, in configured order, that reported them and
+// agrees with every elected address. Source lists every agreeing member.
+func (provider Provider) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "quorum.GetIPInfo")
+	quorum := provider.quorum()
+
+	results := make([]memberResult, len(provider.Members))
+	var wg sync.WaitGroup
+	for index, member := range provider.Members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ipinfo, err := member.Provider.GetIPInfo(ctx)
+			results[index] = memberResult{ipinfo: ipinfo, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var memberErrs []error
+	for index, result := range results {
+		if result.err != nil {
+			log.ErrorContext(ctx, "Error retrieving public IP from provider", "provider", provider.Members[index].Name, "error", result.err)
+			memberErrs = append(memberErrs, fmt.Errorf("%s: %w", provider.Members[index].Name, result.err))
+		}
+	}
+	// A router behind a carrier-grade NAT makes any address the other members
+	// agree on unreachable, so it is not outvoted.
+	for _, memberErr := range memberErrs {
+		var cgnat *domain.CGNATError
+		if errors.As(memberErr, &cgnat) {
+			return domain.IPInfo{}, memberErr
+		}
+	}
+	if answered := len(results) - len(memberErrs); answered < quorum {
+		return domain.IPInfo{}, errors.Join(append([]error{fmt.Errorf("only %d public IP providers answered, %d are required", answered, quorum)}, memberErrs...)...)
+	}
+
+	var elected domain.IPInfo
+	for _, family := range domain.Families {
+
+		answers := make([]domain.ProviderAnswer, len(results))
+		votes := make(map[string]int)
+		winner := ""
+		for index, result := range results {
+			answers[index] = domain.ProviderAnswer{Provider: provider.Members[index].Name}
+			if result.err != nil {
+				continue
+			}
+			address := result.ipinfo.Address(family)
+			if address == "" {
+				continue
+			}
+			answers[index].Address = address
+			votes[address]++
+			if votes[address] > votes[winner] {
+				winner = address
+			}
+		}
+
+		reported := 0
+		for _, count := range votes {
+			reported += count
+		}
+		if reported == 0 {
+			continue
+		}
+		if reported < quorum {
+			log.WarnContext(ctx, "Too few public IP providers reported the family to vote on it, leaving it empty", "family", family, "reported", reported, "quorum", quorum)
+			continue
+		}
+		if votes[winner] < quorum {
+			disagreementErr := &domain.DisagreementError{Family: family, Quorum: quorum, Answers: answers}
+			log.ErrorContext(ctx, "Public IP providers do not agree", "family", family, "quorum", quorum, "answers", disagreementErr.Summary())
+			return domain.IPInfo{}, disagreementErr
+		}
+
+		log.DebugContext(ctx, "Public IP providers agree", "family", family, "address", winner, "votes", votes[winner], "quorum", quorum)
+		if setAddressErr := elected.SetAddress(winner); setAddressErr != nil {
+			return domain.IPInfo{}, setAddressErr
+		}
+	}
+
+	var sources []string
+	for index, result := range results {
+		if result.err != nil || !agrees(result.ipinfo, elected) {
+			continue
+		}
+		sources = append(sources, provider.Members[index].Name)
+		if elected.OrgName == "" && result.ipinfo.OrgName != "" {
+			elected.ASN = result.ipinfo.ASN
+			elected.OrgName = result.ipinfo.OrgName
+		}
+	}
+	elected.Source = strings.Join(sources, ",")
+
+	return elected, nil
+}
+
+// quorum returns the configured quorum or, when unset, a simple majority.
+func (provider Provider) quorum() int {
+	if provider.Quorum > 0 {
+		return provider.Quorum
+	}
+	return len(provider.Members)/2 + 1
+}
+
+// agrees reports whether ipinfo reported at least one elected address and no
+// other address for an elected family. Families left out of the vote are
+// ignored.
+func agrees(ipinfo domain.IPInfo, elected domain.IPInfo) bool {
+	agreed := false
+	for _, family := range domain.Families {
+		address, electedAddress := ipinfo.Address(family), elected.Address(family)
+		if address == "" || electedAddress == "" {
+			continue
+		}
+		if address != electedAddress {
+			return false
+		}
+		agreed = true
+	}
+	return agreed
+}
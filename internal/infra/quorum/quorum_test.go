@@ -0,0 +1,154 @@
+//go:build integration_tests || unit_tests || quorum_tests || quorum_unit_tests
+
+package quorum
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// providerMock fakes a member domain.IPInfoProvider.
+type providerMock struct {
+	ipinfo domain.IPInfo
+	err    error
+}
+
+func (mock providerMock) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+	return mock.ipinfo, mock.err
+}
+
+func TestQuorumAgreement(t *testing.T) {
+
+	provider := Provider{Members: []Member{
+		{Name: "stun", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+		{Name: "ipinfo", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1", IPv6: "2001:db8::1", ASN: 57269, OrgName: "DIGI SPAIN TELECOM S.L."}}},
+		{Name: "stale", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "2.2.2.2", ASN: 1, OrgName: "Stale"}}},
+	}, Quorum: 2}
+
+	// Only one provider reported an IPv6 address: there is nothing to vote on.
+	ipinfo, err := provider.GetIPInfo(context.Background())
+	if err != nil || ipinfo.IPv4 != "1.1.1.1" || ipinfo.IPv6 != "" || ipinfo.Source != "stun,ipinfo" {
+		t.Fatalf("TestQuorumAgreement should elect 1.1.1.1 from stun and ipinfo and leave IPv6 empty, got %+v (%v)", ipinfo, err)
+	}
+
+	provider.Quorum = 1
+	ipinfo, err = provider.GetIPInfo(context.Background())
+	if err != nil {
+		t.Fatalf("TestQuorumAgreement should not fail with a quorum of 1: %v", err)
+	}
+	if ipinfo.IPv4 != "1.1.1.1" || ipinfo.IPv6 != "2001:db8::1" {
+		t.Errorf("TestQuorumAgreement should elect 1.1.1.1 and 2001:db8::1, got %+v", ipinfo)
+	}
+	if ipinfo.OrgName != "DIGI SPAIN TELECOM S.L." || ipinfo.ASN != 57269 {
+		t.Errorf("TestQuorumAgreement should take the organization from an agreeing provider, got %+v", ipinfo)
+	}
+
+}
+
+func TestQuorumMajorityWithoutIPv6(t *testing.T) {
+
+	provider := Provider{Members: []Member{
+		{Name: "a", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "2.2.2.2", OrgName: "Wrong"}}},
+		{Name: "b", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+		{Name: "c", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1", OrgName: "DIGI"}}},
+	}}
+
+	ipinfo, err := provider.GetIPInfo(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestQuorumMajorityWithoutIPv6 should not fail: %v", err)
+	}
+	if ipinfo.IPv4 != "1.1.1.1" || ipinfo.IPv6 != "" || ipinfo.OrgName != "DIGI" {
+		t.Errorf("TestQuorumMajorityWithoutIPv6 should elect 1.1.1.1 from DIGI without IPv6, got %+v", ipinfo)
+	}
+	if ipinfo.Source != "b,c" {
+		t.Errorf("TestQuorumMajorityWithoutIPv6 should report b and c as sources, got \"%s\"", ipinfo.Source)
+	}
+
+}
+
+func TestQuorumDisagreement(t *testing.T) {
+
+	provider := Provider{Members: []Member{
+		{Name: "a", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+		{Name: "b", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "2.2.2.2"}}},
+		{Name: "c", Provider: providerMock{err: errors.New("timeout")}},
+	}, Quorum: 2}
+
+	_, err := provider.GetIPInfo(context.Background())
+
+	var disagreement *domain.DisagreementError
+	if !errors.As(err, &disagreement) {
+		t.Fatalf("TestQuorumDisagreement should fail with a DisagreementError, got %v", err)
+	}
+	if disagreement.Family != domain.IPv4 || disagreement.Summary() != "a=1.1.1.1, b=2.2.2.2, c=none" {
+		t.Errorf("TestQuorumDisagreement reported an unexpected disagreement: %v", disagreement)
+	}
+
+}
+
+// An IPv4-only member next to dual-stack ones: IPv6 is voted on among the
+// members that reported it, and only a split between addresses disagrees.
+func TestQuorumMixedFamilies(t *testing.T) {
+
+	provider := Provider{Members: []Member{
+		{Name: "ipinfo", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1", OrgName: "DIGI"}}},
+		{Name: "echoip", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1", IPv6: "2a0c:5a80::1"}}},
+		{Name: "stun", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+	}, Quorum: 2}
+
+	ipinfo, err := provider.GetIPInfo(context.Background())
+	if err != nil || ipinfo.IPv4 != "1.1.1.1" || ipinfo.IPv6 != "" || ipinfo.OrgName != "DIGI" || ipinfo.Source != "ipinfo,echoip,stun" {
+		t.Errorf("TestQuorumMixedFamilies should elect 1.1.1.1 without IPv6 from every member, got %+v (%v)", ipinfo, err)
+	}
+
+	provider.Members[2].Provider = providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1", IPv6: "2a0c:5a80::1"}}
+	if ipinfo, err = provider.GetIPInfo(context.Background()); err != nil || ipinfo.IPv6 != "2a0c:5a80::1" {
+		t.Errorf("TestQuorumMixedFamilies should elect the IPv6 address two members reported, got %+v (%v)", ipinfo, err)
+	}
+
+	provider.Members[2].Provider = providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1", IPv6: "2a0c:5a80::2"}}
+	var disagreement *domain.DisagreementError
+	if _, err = provider.GetIPInfo(context.Background()); !errors.As(err, &disagreement) || disagreement.Family != domain.IPv6 {
+		t.Errorf("TestQuorumMixedFamilies should fail with an IPv6 disagreement when two members split, got %v", err)
+	}
+
+}
+
+func TestQuorumNotEnoughAnswers(t *testing.T) {
+
+	provider := Provider{Members: []Member{
+		{Name: "a", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+		{Name: "b", Provider: providerMock{err: errors.New("timeout")}},
+		{Name: "c", Provider: providerMock{err: errors.New("timeout")}},
+	}}
+
+	_, err := provider.GetIPInfo(context.Background())
+
+	var disagreement *domain.DisagreementError
+	if err == nil || errors.As(err, &disagreement) {
+		t.Errorf("TestQuorumNotEnoughAnswers should fail with an outage error rather than a disagreement, got %v", err)
+	}
+
+}
+
+// A router behind a carrier-grade NAT is not outvoted by the other members.
+func TestQuorumCGNAT(t *testing.T) {
+
+	provider := Provider{Members: []Member{
+		{Name: "a", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+		{Name: "b", Provider: providerMock{ipinfo: domain.IPInfo{IPv4: "1.1.1.1"}}},
+		{Name: "gateway", Provider: providerMock{err: &domain.CGNATError{Address: "100.64.0.1"}}},
+	}}
+
+	_, err := provider.GetIPInfo(context.Background())
+
+	var cgnat *domain.CGNATError
+	if !errors.As(err, &cgnat) {
+		t.Errorf("TestQuorumCGNAT should fail with the CGNATError, got %v", err)
+	}
+
+}
@@ -0,0 +1,226 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// Policy describes how the calls to a port are retried. The delay before the
+// second attempt is BaseDelay, then it doubles up to MaxDelay; a random jitter
+// up to Jitter is added to each wait so several instances do not retry in
+// lockstep.
+type Policy struct {
+	Attempts  int                  // Calls made at most, including the first one; 0 or 1 disables retries
+	BaseDelay time.Duration        // Delay before the first retry
+	MaxDelay  time.Duration        // Upper bound of the delay between attempts, zero means no bound
+	Jitter    time.Duration        // Upper bound of the random delay added to each wait
+	Retryable func(err error) bool // Whether an error is worth retrying, IsTransient when nil
+}
+
+// random returns a random number in [0, n), replaced in tests.
+var random = rand.Int64N
+
+// IsTransient is the default classification of Policy: an error is retried
+// unless retrying cannot change the outcome. Cancellations, rate limits (asking
+// again burns more quota, the scheduler waits for Retry-After instead), open
+// circuit breakers, provider and nameserver disagreements, carrier-grade NAT
+// and non-public addresses, and domain names that do not exist are not
+// retried.
+func IsTransient(err error) bool {
+
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var rateLimit *domain.RateLimitError
+	var disagreement *domain.DisagreementError
+	var cgnat *domain.CGNATError
+	var nonPublic *domain.NonPublicAddressError
+	var breakerOpen *domain.BreakerOpenError
+	var lag *domain.NameserverLagError
+	if errors.As(err, &rateLimit) || errors.As(err, &disagreement) || errors.As(err, &cgnat) || errors.As(err, &nonPublic) || errors.As(err, &breakerOpen) || errors.As(err, &lag) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	return true
+}
+
+// delay returns how long to wait after the given failed attempt (1 for the
+// first call).
+func (policy Policy) delay(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for retry := 1; retry < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); retry++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 {
+		delay = min(delay, policy.MaxDelay)
+	}
+	if policy.Jitter > 0 {
+		delay += time.Duration(random(int64(policy.Jitter) + 1))
+	}
+	return delay
+}
+
+// do calls call until it succeeds, fails with an error policy does not retry,
+// or policy.Attempts calls were made. Waits between attempts are interrupted
+// by ctx, in which case the last error is returned joined with the cause of
+// the cancellation.
+func do[T any](ctx context.Context, policy Policy, operation string, call func() (T, error)) (T, error) {
+
+	log := logger.FromContext(ctx).With("operation", operation)
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := call()
+		if err == nil || attempt >= policy.Attempts || !retryable(err) {
+			return result, err
+		}
+
+		delay := policy.delay(attempt)
+		log.WarnContext(ctx, "Call failed, retrying", "attempt", attempt, "attempts", policy.Attempts, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, errors.Join(err, context.Cause(ctx))
+		case <-timer.C:
+		}
+	}
+}
+
+// Provider retries a domain.IPInfoProvider.
+type Provider struct {
+	Provider domain.IPInfoProvider
+	Policy   Policy
+}
+
+// GetIPInfo implements domain.IPInfoProvider.
+func (provider Provider) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+	return do(ctx, provider.Policy, "retry.GetIPInfo", func() (domain.IPInfo, error) {
+		return provider.Provider.GetIPInfo(ctx)
+	})
+}
+
+// Resolver retries a domain.DNSResolver.
+type Resolver struct {
+	Resolver domain.DNSResolver
+	Policy   Policy
+}
+
+// Resolve implements domain.DNSResolver.
+func (resolver Resolver) Resolve(ctx context.Context, domainName string, family domain.IPFamily) ([]domain.DNSRecord, error) {
+	return do(ctx, resolver.Policy, "retry.Resolve", func() ([]domain.DNSRecord, error) {
+		return resolver.Resolver.Resolve(ctx, domainName, family)
+	})
+}
+
+// Store retries a domain.IPStore. Every write of the store is idempotent
+// (SaveIP skips the change and messages a failed attempt already committed,
+// acknowledging a message twice is harmless), so all of them are retried.
+type Store struct {
+	Store  domain.IPStore
+	Policy Policy
+}
+
+// storeResult holds the multiple results of the reads of domain.IPStore.
+type storeResult[T any] struct {
+	value T
+	found bool
+}
+
+// StoredIP implements domain.IPStore.
+func (store Store) StoredIP(ctx context.Context, family domain.IPFamily) (string, bool, error) {
+	result, err := do(ctx, store.Policy, "retry.StoredIP", func() (storeResult[string], error) {
+		ip, found, err := store.Store.StoredIP(ctx, family)
+		return storeResult[string]{value: ip, found: found}, err
+	})
+	return result.value, result.found, err
+}
+
+// SaveIP implements domain.IPStore.
+func (store Store) SaveIP(ctx context.Context, change domain.IPChange, messages []domain.OutboxMessage) error {
+	_, err := do(ctx, store.Policy, "retry.SaveIP", func() (struct{}, error) {
+		return struct{}{}, store.Store.SaveIP(ctx, change, messages)
+	})
+	return err
+}
+
+// PendingMessages implements domain.IPStore.
+func (store Store) PendingMessages(ctx context.Context) ([]domain.OutboxMessage, error) {
+	return do(ctx, store.Policy, "retry.PendingMessages", func() ([]domain.OutboxMessage, error) {
+		return store.Store.PendingMessages(ctx)
+	})
+}
+
+// AckMessage implements domain.IPStore.
+func (store Store) AckMessage(ctx context.Context, eventID string) error {
+	_, err := do(ctx, store.Policy, "retry.AckMessage", func() (struct{}, error) {
+		return struct{}{}, store.Store.AckMessage(ctx, eventID)
+	})
+	return err
+}
+
+// AlertState implements domain.IPStore.
+func (store Store) AlertState(ctx context.Context, alert string) (domain.AlertState, bool, error) {
+	result, err := do(ctx, store.Policy, "retry.AlertState", func() (storeResult[domain.AlertState], error) {
+		state, found, err := store.Store.AlertState(ctx, alert)
+		return storeResult[domain.AlertState]{value: state, found: found}, err
+	})
+	return result.value, result.found, err
+}
+
+// SaveAlertState implements domain.IPStore.
+func (store Store) SaveAlertState(ctx context.Context, alert string, state domain.AlertState) error {
+	_, err := do(ctx, store.Policy, "retry.SaveAlertState", func() (struct{}, error) {
+		return struct{}{}, store.Store.SaveAlertState(ctx, alert, state)
+	})
+	return err
+}
+
+// Propagation implements domain.IPStore.
+func (store Store) Propagation(ctx context.Context, family domain.IPFamily) (domain.Propagation, bool, error) {
+	result, err := do(ctx, store.Policy, "retry.Propagation", func() (storeResult[domain.Propagation], error) {
+		propagation, found, err := store.Store.Propagation(ctx, family)
+		return storeResult[domain.Propagation]{value: propagation, found: found}, err
+	})
+	return result.value, result.found, err
+}
+
+// SavePropagation implements domain.IPStore.
+func (store Store) SavePropagation(ctx context.Context, family domain.IPFamily, propagation domain.Propagation) error {
+	_, err := do(ctx, store.Policy, "retry.SavePropagation", func() (struct{}, error) {
+		return struct{}{}, store.Store.SavePropagation(ctx, family, propagation)
+	})
+	return err
+}
+
+// Notifier retries a domain.Notifier. A retried message may be delivered
+// twice when the broker received it but the confirmation was lost, which
+// consumers already handle since the outbox delivers at least once.
+type Notifier struct {
+	Notifier domain.Notifier
+	Policy   Policy
+}
+
+// Notify implements domain.Notifier.
+func (notifier Notifier) Notify(ctx context.Context, queue string, event domain.Event) error {
+	_, err := do(ctx, notifier.Policy, "retry.Notify", func() (struct{}, error) {
+		return struct{}{}, notifier.Notifier.Notify(ctx, queue, event)
+	})
+	return err
+}
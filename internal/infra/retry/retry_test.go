@@ -0,0 +1,174 @@
+//go:build integration_tests || unit_tests || retry_tests || retry_unit_tests
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// flakyProviderMock fakes a domain.IPInfoProvider failing with each error of
+// errs in turn before answering.
+type flakyProviderMock struct {
+	errs  []error
+	calls *int
+}
+
+func (mock flakyProviderMock) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+	*mock.calls++
+	if *mock.calls <= len(mock.errs) {
+		return domain.IPInfo{}, mock.errs[*mock.calls-1]
+	}
+	return domain.IPInfo{IPv4: "79.116.12.34"}, nil
+}
+
+// flakyNotifierMock fakes a domain.Notifier failing the first failures calls.
+type flakyNotifierMock struct {
+	failures int
+	calls    *int
+}
+
+func (mock flakyNotifierMock) Notify(ctx context.Context, queue string, event domain.Event) error {
+	*mock.calls++
+	if *mock.calls <= mock.failures {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+// flakyStoreMock fakes a domain.IPStore whose reads fail the first failures
+// calls.
+type flakyStoreMock struct {
+	domain.IPStore
+	failures int
+	calls    *int
+}
+
+func (mock flakyStoreMock) StoredIP(ctx context.Context, family domain.IPFamily) (string, bool, error) {
+	*mock.calls++
+	if *mock.calls <= mock.failures {
+		return "", false, errors.New("i/o timeout")
+	}
+	return "79.116.12.34", true, nil
+}
+
+func TestIsTransient(t *testing.T) {
+
+	for _, err := range []error{
+		errors.New("connection reset by peer"),
+		&domain.UnavailableError{Provider: "ipinfo", Err: errors.New("503 Service Unavailable")},
+		&net.DNSError{Err: "server misbehaving", IsTemporary: true},
+		context.DeadlineExceeded,
+	} {
+		if !IsTransient(err) {
+			t.Errorf("IsTransient should retry %v", err)
+		}
+	}
+
+	for _, err := range []error{
+		nil,
+		context.Canceled,
+		&domain.RateLimitError{Provider: "ipinfo", RetryAfter: time.Minute},
+		fmt.Errorf("stun: %w", &domain.CGNATError{Address: "100.64.12.34"}),
+		&domain.DisagreementError{Family: domain.IPv4, Quorum: 2},
+		&domain.NonPublicAddressError{Address: "192.168.1.1", Scope: domain.PrivateAddress},
+		&domain.BreakerOpenError{Breaker: "ipinfo"},
+		&domain.NameserverLagError{Domain: "home.example.com", Lagging: []string{"ns2.example.com"}},
+		&net.DNSError{Err: "no such host", IsNotFound: true},
+	} {
+		if IsTransient(err) {
+			t.Errorf("IsTransient should not retry %v", err)
+		}
+	}
+
+}
+
+func TestPolicyDelay(t *testing.T) {
+
+	policy := Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if delay := policy.delay(attempt); delay != expected {
+			t.Errorf("Delay after attempt %d should be %s, got %s", attempt, expected, delay)
+		}
+	}
+
+	random = func(n int64) int64 { return n - 1 }
+	defer func() { random = rand.Int64N }()
+	policy.Jitter = 500 * time.Millisecond
+	if delay := policy.delay(1); delay != 1500*time.Millisecond {
+		t.Errorf("Delay with jitter should be at most 1.5s, got %s", delay)
+	}
+
+}
+
+func TestProviderRetries(t *testing.T) {
+
+	var calls int
+	provider := Provider{
+		Provider: flakyProviderMock{errs: []error{errors.New("timeout"), errors.New("timeout")}, calls: &calls},
+		Policy:   Policy{Attempts: 3, BaseDelay: time.Millisecond},
+	}
+
+	ipinfo, err := provider.GetIPInfo(context.Background())
+	if err != nil || ipinfo.IPv4 != "79.116.12.34" || calls != 3 {
+		t.Errorf("TestProviderRetries should succeed on the third attempt, got %+v (%v) after %d calls", ipinfo, err, calls)
+	}
+
+	calls = 0
+	provider.Policy.Attempts = 2
+	if _, err := provider.GetIPInfo(context.Background()); err == nil || calls != 2 {
+		t.Errorf("TestProviderRetries should give up after 2 attempts, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	rateLimit := &domain.RateLimitError{Provider: "ipinfo"}
+	provider.Provider = flakyProviderMock{errs: []error{rateLimit}, calls: &calls}
+	if _, err := provider.GetIPInfo(context.Background()); !errors.Is(err, rateLimit) || calls != 1 {
+		t.Errorf("TestProviderRetries should not retry a rate limit, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	provider.Provider = flakyProviderMock{errs: []error{errors.New("timeout")}, calls: &calls}
+	provider.Policy.Retryable = func(err error) bool { return false }
+	if _, err := provider.GetIPInfo(context.Background()); err == nil || calls != 1 {
+		t.Errorf("TestProviderRetries should use the policy classification, got %v after %d calls", err, calls)
+	}
+
+}
+
+func TestStoreRetries(t *testing.T) {
+
+	var calls int
+	store := Store{Store: flakyStoreMock{failures: 1, calls: &calls}, Policy: Policy{Attempts: 2}}
+
+	ip, found, err := store.StoredIP(context.Background(), domain.IPv4)
+	if err != nil || !found || ip != "79.116.12.34" || calls != 2 {
+		t.Errorf("TestStoreRetries should read the stored IP on the second attempt, got %s %t (%v) after %d calls", ip, found, err, calls)
+	}
+
+}
+
+func TestNotifierCancelled(t *testing.T) {
+
+	var calls int
+	notifier := Notifier{
+		Notifier: flakyNotifierMock{failures: 5, calls: &calls},
+		Policy:   Policy{Attempts: 5, BaseDelay: time.Hour},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := notifier.Notify(ctx, "notify", domain.Event{})
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Errorf("TestNotifierCancelled should stop waiting once the context is done, got %v after %d calls", err, calls)
+	}
+
+}
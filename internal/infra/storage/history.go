@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// historyKey holds the IP history as a JSON array, oldest change first.
+const historyKey = "ipHistory"
+
+// ipChangeData is the unexported DTO persisted for a domain.IPChange.
+type ipChangeData struct {
+	Family  domain.IPFamily `json:"family"`
+	OldIP   string          `json:"oldIP,omitempty"`
+	NewIP   string          `json:"newIP"`
+	OrgName string          `json:"orgName,omitempty"`
+	ASN     uint32          `json:"asn,omitempty"`
+	Time    time.Time       `json:"time"`
+}
+
+// History returns every recorded IP change of any family, newest first. It
+// implements domain.IPHistory.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//
+// Returns:
+//   - []domain.IPChange: The recorded changes (empty if none was recorded)
+//   - error: Error if the read operation fails or the value cannot be decoded
+func (store *Store) History(ctx context.Context) ([]domain.IPChange, error) {
+
+	log := logger.FromContext(ctx).With("operation", "History")
+	log.DebugContext(ctx, "Retrieving IP history from store")
+
+	entries, readErr := store.readHistory(ctx)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	changes := make([]domain.IPChange, len(entries))
+	for index, entry := range entries {
+		changes[len(entries)-1-index] = domain.IPChange(entry)
+	}
+	return changes, nil
+}
+
+// appendHistory adds change to the history and applies the retention limits.
+// If the latest entry of the family already describes the same change (a
+// retry after a failed write), it is replaced instead.
+func (store *Store) appendHistory(ctx context.Context, change domain.IPChange) error {
+
+	entries, readErr := store.readHistory(ctx)
+	if readErr != nil {
+		return readErr
+	}
+
+	entry := ipChangeData(change)
+	retried := false
+	for index := len(entries) - 1; index >= 0; index-- {
+		if entries[index].Family != change.Family {
+			continue
+		}
+		if entries[index].OldIP == entry.OldIP && entries[index].NewIP == entry.NewIP {
+			entries[index] = entry
+			retried = true
+		}
+		break
+	}
+	if !retried {
+		entries = append(entries, entry)
+	}
+
+	// Retention: age is measured from the change being recorded.
+	if store.HistoryMaxAge > 0 {
+		oldest := change.Time.Add(-store.HistoryMaxAge)
+		kept := entries[:0]
+		for _, entry := range entries {
+			if !entry.Time.Before(oldest) {
+				kept = append(kept, entry)
+			}
+		}
+		entries = kept
+	}
+	if store.HistoryMaxEntries > 0 && len(entries) > store.HistoryMaxEntries {
+		entries = entries[len(entries)-store.HistoryMaxEntries:]
+	}
+
+	encodedEntries, marshalErr := json.Marshal(entries)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return store.Database.WriteString(ctx, historyKey, string(encodedEntries), 0)
+}
+
+// readHistory returns the stored history entries, oldest first.
+func (store *Store) readHistory(ctx context.Context) ([]ipChangeData, error) {
+
+	value, found, readErr := store.Database.ReadString(ctx, historyKey)
+	if readErr != nil || !found {
+		return nil, readErr
+	}
+
+	var entries []ipChangeData
+	if unmarshalErr := json.Unmarshal([]byte(value), &entries); unmarshalErr != nil {
+		logger.FromContext(ctx).ErrorContext(ctx, "Stored IP history cannot be decoded", "error", unmarshalErr)
+		return nil, unmarshalErr
+	}
+	return entries, nil
+}
@@ -0,0 +1,88 @@
+//go:build integration_tests || unit_tests || storage_tests || storage_unit_tests
+
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	memorydatabase "github.com/a-castellano/go-services/services/memorydatabase"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	redismock "github.com/go-redis/redismock/v9"
+)
+
+func TestHistoryNewestFirst(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("ipHistory").SetVal(`[{"family":"ipv4","newIP":"1.1.1.1","time":"2026-06-20T10:00:00Z"},{"family":"ipv4","oldIP":"1.1.1.1","newIP":"1.1.1.2","orgName":"DIGI","asn":57269,"time":"2026-06-24T10:00:00Z"}]`)
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	history, historyErr := ipstore.History(ctx)
+	if historyErr != nil {
+		t.Fatalf("TestHistoryNewestFirst should not fail: %v", historyErr)
+	}
+
+	expected := domain.IPChange{Family: domain.IPv4, OldIP: "1.1.1.1", NewIP: "1.1.1.2", OrgName: "DIGI", ASN: 57269, Time: changeTime}
+	if len(history) != 2 || history[0] != expected || history[1].NewIP != "1.1.1.1" {
+		t.Errorf("TestHistoryNewestFirst should return the newest change first, got %+v", history)
+	}
+
+}
+
+func TestHistoryEmpty(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("ipHistory").RedisNil()
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	history, historyErr := ipstore.History(ctx)
+	if historyErr != nil || len(history) != 0 {
+		t.Errorf("TestHistoryEmpty should return no change without failing, got %v (%v)", history, historyErr)
+	}
+
+}
+
+// Entries beyond HistoryMaxEntries or older than HistoryMaxAge are dropped
+// when a new change is recorded.
+func TestHistoryRetention(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("ipHistory").SetVal(`[{"family":"ipv4","newIP":"1.1.1.1","time":"2025-01-01T10:00:00Z"},{"family":"ipv4","oldIP":"1.1.1.1","newIP":"1.1.1.2","time":"2026-06-20T10:00:00Z"},{"family":"ipv6","newIP":"2001:db8::1","time":"2026-06-21T10:00:00Z"}]`)
+	mock.ExpectSet("ipHistory", `[{"family":"ipv6","newIP":"2001:db8::1","time":"2026-06-21T10:00:00Z"},{"family":"ipv4","oldIP":"1.1.1.2","newIP":"1.1.1.3","time":"2026-06-24T10:00:00Z"}]`, 0).SetVal("OK")
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase, HistoryMaxEntries: 2, HistoryMaxAge: 30 * 24 * time.Hour}
+
+	if saveErr := ipstore.appendHistory(ctx, domain.IPChange{Family: domain.IPv4, OldIP: "1.1.1.2", NewIP: "1.1.1.3", Time: changeTime}); saveErr != nil {
+		t.Fatalf("TestHistoryRetention should not fail: %v", saveErr)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestHistoryRetention should keep the 2 newest entries of the last 30 days: %v", expectationsErr)
+	}
+
+}
+
+// Recording the same change again (a retry after the stored IP could not be
+// written) replaces the entry instead of duplicating it.
+func TestHistoryRetriedChange(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("ipHistory").SetVal(`[{"family":"ipv4","oldIP":"1.1.1.1","newIP":"1.1.1.2","time":"2026-06-24T09:58:00Z"},{"family":"ipv6","newIP":"2001:db8::1","time":"2026-06-24T09:59:00Z"}]`)
+	mock.ExpectSet("ipHistory", `[{"family":"ipv4","oldIP":"1.1.1.1","newIP":"1.1.1.2","time":"2026-06-24T10:00:00Z"},{"family":"ipv6","newIP":"2001:db8::1","time":"2026-06-24T09:59:00Z"}]`, 0).SetVal("OK")
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	if saveErr := ipstore.appendHistory(ctx, domain.IPChange{Family: domain.IPv4, OldIP: "1.1.1.1", NewIP: "1.1.1.2", Time: changeTime}); saveErr != nil {
+		t.Fatalf("TestHistoryRetriedChange should not fail: %v", saveErr)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestHistoryRetriedChange should replace the retried entry: %v", expectationsErr)
+	}
+
+}
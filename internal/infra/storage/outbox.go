@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// outboxKey holds the outbox: the IP changes not applied yet and the messages
+// not delivered yet. Writing it is the commit point of an update, since it is
+// a single key and therefore written atomically.
+const outboxKey = "outbox"
+
+// outboxData is the unexported DTO persisted under outboxKey.
+type outboxData struct {
+	Changes  []ipChangeData      `json:"changes,omitempty"`
+	Messages []outboxMessageData `json:"messages,omitempty"`
+}
+
+// outboxMessageData is the unexported DTO persisted for a domain.OutboxMessage.
+type outboxMessageData struct {
+	Queue string          `json:"queue"`
+	Event outboxEventData `json:"event"`
+}
+
+// outboxEventData is the unexported DTO persisted for a domain.Event.
+type outboxEventData struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Type          domain.EventType `json:"type"`
+	ID            string           `json:"id"`
+	Time          time.Time        `json:"time"`
+	Family        domain.IPFamily  `json:"family,omitempty"`
+	OldIP         string           `json:"oldIP,omitempty"`
+	NewIP         string           `json:"newIP,omitempty"`
+	Addresses     []string         `json:"addresses,omitempty"`
+	ISP           string           `json:"isp,omitempty"`
+	ASN           uint32           `json:"asn,omitempty"`
+	Domain        string           `json:"domain,omitempty"`
+	Source        string           `json:"source,omitempty"`
+	Message       string           `json:"message"`
+}
+
+// PendingMessages returns the messages of the outbox not acknowledged yet, in
+// the order they were saved.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//
+// Returns:
+//   - []domain.OutboxMessage: The pending messages (empty if none)
+//   - error: Error if the read operation fails or the value cannot be decoded
+func (store *Store) PendingMessages(ctx context.Context) ([]domain.OutboxMessage, error) {
+
+	log := logger.FromContext(ctx).With("operation", "PendingMessages")
+	log.DebugContext(ctx, "Retrieving pending messages from outbox")
+
+	outbox, readErr := store.readOutbox(ctx)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	messages := make([]domain.OutboxMessage, len(outbox.Messages))
+	for index, message := range outbox.Messages {
+		messages[index] = domain.OutboxMessage{Queue: message.Queue, Event: domain.Event(message.Event)}
+	}
+	return messages, nil
+}
+
+// AckMessage removes the message carrying event eventID from the outbox once
+// it has been delivered. Changes left in the outbox by an interrupted SaveIP
+// are applied first, so delivering the messages also completes their change.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - eventID: ID of the delivered event
+//
+// Returns:
+//   - error: Error if a pending change cannot be applied or a write fails
+func (store *Store) AckMessage(ctx context.Context, eventID string) error {
+
+	log := logger.FromContext(ctx).With("operation", "AckMessage")
+	log.DebugContext(ctx, "Removing delivered message from outbox", "event", eventID)
+
+	outbox, readErr := store.readOutbox(ctx)
+	if readErr != nil {
+		return readErr
+	}
+
+	if applyErr := store.applyChanges(ctx, &outbox); applyErr != nil {
+		log.ErrorContext(ctx, "Error applying pending IP change", "error", applyErr)
+		return applyErr
+	}
+
+	outbox.Messages = slices.DeleteFunc(outbox.Messages, func(message outboxMessageData) bool {
+		return message.Event.ID == eventID
+	})
+	return store.writeOutbox(ctx, outbox)
+}
+
+// applyChanges records every change pending in outbox in the history and
+// persists its IP, oldest first, then empties outbox.Changes. The caller
+// writes the outbox. A change republishing the IP already stored, because
+// only the DNS records drifted, is not an IP change and stays out of the
+// history.
+func (store *Store) applyChanges(ctx context.Context, outbox *outboxData) error {
+
+	for _, change := range outbox.Changes {
+		storedIP, found, readErr := store.Database.ReadString(ctx, storedIPKeys[change.Family])
+		if readErr != nil {
+			return readErr
+		}
+		if !found || storedIP != change.NewIP {
+			if historyErr := store.appendHistory(ctx, domain.IPChange(change)); historyErr != nil {
+				return historyErr
+			}
+		}
+		if writeErr := store.Database.WriteString(ctx, storedIPKeys[change.Family], change.NewIP, 0); writeErr != nil {
+			return writeErr
+		}
+	}
+	outbox.Changes = nil
+	return nil
+}
+
+// add appends change and messages to outbox, skipping those already in it:
+// a retried SaveIP whose previous attempt was committed adds nothing. Messages
+// are told apart by their event ID.
+func (outbox *outboxData) add(change ipChangeData, messages []domain.OutboxMessage) {
+	if !slices.ContainsFunc(outbox.Changes, change.sameAs) {
+		outbox.Changes = append(outbox.Changes, change)
+	}
+	for _, message := range messages {
+		committed := slices.ContainsFunc(outbox.Messages, func(pending outboxMessageData) bool {
+			return pending.Event.ID == message.Event.ID
+		})
+		if !committed {
+			outbox.Messages = append(outbox.Messages, outboxMessageData{Queue: message.Queue, Event: outboxEventData(message.Event)})
+		}
+	}
+}
+
+// sameAs reports whether change and other describe the same detected change,
+// as a retried SaveIP commits it again.
+func (change ipChangeData) sameAs(other ipChangeData) bool {
+	return change.Family == other.Family && change.OldIP == other.OldIP && change.NewIP == other.NewIP && change.Time.Equal(other.Time)
+}
+
+// pendingChange returns the latest change of family committed to the outbox
+// but not applied yet, if any.
+func (outbox outboxData) pendingChange(family domain.IPFamily) (ipChangeData, bool) {
+	for index := len(outbox.Changes) - 1; index >= 0; index-- {
+		if outbox.Changes[index].Family == family {
+			return outbox.Changes[index], true
+		}
+	}
+	return ipChangeData{}, false
+}
+
+// readOutbox returns the stored outbox, empty if there is none.
+func (store *Store) readOutbox(ctx context.Context) (outboxData, error) {
+
+	var outbox outboxData
+
+	value, found, readErr := store.Database.ReadString(ctx, outboxKey)
+	if readErr != nil || !found {
+		return outbox, readErr
+	}
+
+	if unmarshalErr := json.Unmarshal([]byte(value), &outbox); unmarshalErr != nil {
+		logger.FromContext(ctx).ErrorContext(ctx, "Stored outbox cannot be decoded", "error", unmarshalErr)
+		return outboxData{}, unmarshalErr
+	}
+	return outbox, nil
+}
+
+// writeOutbox persists outbox with no TTL.
+func (store *Store) writeOutbox(ctx context.Context, outbox outboxData) error {
+
+	encodedOutbox, marshalErr := json.Marshal(outbox)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return store.Database.WriteString(ctx, outboxKey, string(encodedOutbox), 0)
+}
@@ -0,0 +1,144 @@
+//go:build integration_tests || unit_tests || storage_tests || storage_unit_tests
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	memorydatabase "github.com/a-castellano/go-services/services/memorydatabase"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+	redismock "github.com/go-redis/redismock/v9"
+)
+
+// updateMessage is the DNS update message saved by the tests, and
+// updateMessageData its persisted form.
+var updateMessage = domain.OutboxMessage{Queue: "update", Event: domain.Event{SchemaVersion: 1, Type: domain.DNSUpdateEvent, ID: "event-2", Time: changeTime, Family: domain.IPv4, NewIP: "12.12.12.12", Message: "12.12.12.12"}}
+
+const updateMessageData = `{"queue":"update","event":{"schemaVersion":1,"type":"dns.update","id":"event-2","time":"2026-06-24T10:00:00Z","family":"ipv4","newIP":"12.12.12.12","message":"12.12.12.12"}}`
+
+const notifyMessageData = `{"queue":"notify","event":{"schemaVersion":1,"type":"ip.changed","id":"event-1","time":"2026-06-24T10:00:00Z","family":"ipv4","newIP":"12.12.12.12","message":"Home IPv4 has changed to 12.12.12.12."}}`
+
+// A change committed to the outbox but not applied yet is already the stored IP.
+func TestStoredIPPendingChange(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("storedIP").SetVal("11.11.11.11")
+	mock.ExpectGet("outbox").SetVal(`{"changes":[{"family":"ipv6","newIP":"2001:db8::1","time":"2026-06-24T10:00:00Z"},{"family":"ipv4","oldIP":"11.11.11.11","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]}`)
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	storedIP, found, storedIPErr := ipstore.StoredIP(ctx, domain.IPv4)
+	if storedIPErr != nil || !found || storedIP != "12.12.12.12" {
+		t.Errorf("TestStoredIPPendingChange should return the pending IP 12.12.12.12, got \"%s\" (%v)", storedIP, storedIPErr)
+	}
+
+}
+
+func TestPendingMessages(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").SetVal(`{"messages":[` + notifyMessageData + `,` + updateMessageData + `]}`)
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	messages, pendingErr := ipstore.PendingMessages(ctx)
+	if pendingErr != nil {
+		t.Fatalf("TestPendingMessages should not fail: %v", pendingErr)
+	}
+	if len(messages) != 2 || messages[0].Event.ID != "event-1" || messages[0].Queue != "notify" {
+		t.Fatalf("TestPendingMessages should return both messages in order, got %+v", messages)
+	}
+	if messages[1].Queue != updateMessage.Queue || messages[1].Event.Type != updateMessage.Event.Type || !messages[1].Event.Time.Equal(changeTime) || messages[1].Event.Message != updateMessage.Event.Message {
+		t.Errorf("TestPendingMessages should decode the whole event, got %+v", messages[1])
+	}
+
+}
+
+func TestPendingMessagesEmpty(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").RedisNil()
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	messages, pendingErr := ipstore.PendingMessages(ctx)
+	if pendingErr != nil || len(messages) != 0 {
+		t.Errorf("TestPendingMessagesEmpty should return no message without failing, got %+v (%v)", messages, pendingErr)
+	}
+
+}
+
+// Acknowledging a message applies the change an interrupted SaveIP left in
+// the outbox and removes only that message.
+func TestAckMessageAppliesPendingChange(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").SetVal(`{"changes":[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}],"messages":[` + notifyMessageData + `,` + updateMessageData + `]}`)
+	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("ipHistory").RedisNil()
+	mock.ExpectSet("ipHistory", `[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]`, 0).SetVal("OK")
+	mock.ExpectSet("storedIP", "12.12.12.12", 0).SetVal("OK")
+	mock.ExpectSet("outbox", `{"messages":[`+updateMessageData+`]}`, 0).SetVal("OK")
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	if ackErr := ipstore.AckMessage(ctx, "event-1"); ackErr != nil {
+		t.Fatalf("TestAckMessageAppliesPendingChange should not fail: %v", ackErr)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestAckMessageAppliesPendingChange should apply the change and keep the other message: %v", expectationsErr)
+	}
+
+}
+
+// A SaveIP retried after its commit adds neither the change nor the messages
+// again.
+func TestSaveIPRetriedAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	committed := `{"changes":[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}],"messages":[` + updateMessageData + `]}`
+	mock.ExpectGet("outbox").SetVal(committed)
+	mock.ExpectSet("outbox", committed, 0).SetVal("OK")
+	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("ipHistory").SetVal(`[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]`)
+	mock.ExpectSet("ipHistory", `[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]`, 0).SetVal("OK")
+	mock.ExpectSet("storedIP", "12.12.12.12", 0).SetVal("OK")
+	mock.ExpectSet("outbox", `{"messages":[`+updateMessageData+`]}`, 0).SetVal("OK")
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	if saveErr := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv4, NewIP: "12.12.12.12", Time: changeTime}, []domain.OutboxMessage{updateMessage}); saveErr != nil {
+		t.Fatalf("TestSaveIPRetriedAfterCommit should not fail: %v", saveErr)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestSaveIPRetriedAfterCommit should not duplicate the committed update: %v", expectationsErr)
+	}
+
+}
+
+// A message is kept when the pending change still cannot be applied.
+func TestAckMessageApplyError(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").SetVal(`{"changes":[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}],"messages":[` + updateMessageData + `]}`)
+	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("ipHistory").SetErr(errors.New("FAIL"))
+
+	memoryDatabase := memorydatabase.NewMemoryDatabase(RedisClientMock{client: dbMock})
+	ipstore := Store{Database: memoryDatabase}
+
+	if ackErr := ipstore.AckMessage(ctx, "event-2"); ackErr == nil {
+		t.Errorf("TestAckMessageApplyError should fail")
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestAckMessageApplyError should not rewrite the outbox: %v", expectationsErr)
+	}
+
+}
@@ -2,51 +2,308 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	logger "github.com/a-castellano/go-services/infra/logger"
 	memorydatabase "github.com/a-castellano/go-services/services/memorydatabase"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
 )
 
 // Store is the persistence adapter for the monitored IP. It wraps the
 // memorydatabase.MemoryDatabase abstraction (not Redis directly) and
-// implements domain.IPStore.
+// implements domain.IPStore, domain.IPHistory and domain.BreakerStore.
+//
+// Updates go through an outbox (see SaveIP), so the stored IPs and the
+// messages announcing them never diverge.
+//
+// The history keeps at most HistoryMaxEntries changes no older than
+// HistoryMaxAge; a zero value disables the corresponding limit.
 type Store struct {
-	Database memorydatabase.MemoryDatabase
+	Database          memorydatabase.MemoryDatabase
+	HistoryMaxEntries int
+	HistoryMaxAge     time.Duration
+}
+
+// storedIPKeys maps each family to its key. IPv4 keeps the historical
+// "storedIP" key so existing deployments do not lose their stored value.
+var storedIPKeys = map[domain.IPFamily]string{
+	domain.IPv4: "storedIP",
+	domain.IPv6: "storedIPv6",
 }
 
-// StoredIP returns the IP currently persisted under the "storedIP" key.
-// It only reads the value; deciding whether an update is required is the
-// use case's responsibility, not the store's.
+// StoredIP returns the IP of the given family currently persisted in the store
+// ("storedIP" for IPv4, "storedIPv6" for IPv6), or the one of a change already
+// committed to the outbox but not applied yet. It only reads the value;
+// deciding whether an update is required is the use case's responsibility,
+// not the store's.
 //
 // Parameters:
 //   - ctx: Context for cancellation and timeouts
+//   - family: Address family to read
 //
 // Returns:
 //   - string: The stored IP address (empty if none was found)
 //   - bool: Whether a value was found
 //   - error: Error if the read operation fails
-func (store *Store) StoredIP(ctx context.Context) (string, bool, error) {
+func (store *Store) StoredIP(ctx context.Context, family domain.IPFamily) (string, bool, error) {
 
 	log := logger.FromContext(ctx).With("operation", "StoredIP")
-	log.DebugContext(ctx, "Retrieving stored IP from store")
+	log.DebugContext(ctx, "Retrieving stored IP from store", "family", family)
+
+	storedIP, found, readErr := store.Database.ReadString(ctx, storedIPKeys[family])
+	if readErr != nil {
+		return "", false, readErr
+	}
+
+	outbox, outboxErr := store.readOutbox(ctx)
+	if outboxErr != nil {
+		return "", false, outboxErr
+	}
+	if change, pending := outbox.pendingChange(family); pending {
+		log.DebugContext(ctx, "Stored IP has a pending change in the outbox", "family", family, "ip", change.NewIP)
+		return change.NewIP, true, nil
+	}
 
-	return store.Database.ReadString(ctx, "storedIP")
+	return storedIP, found, nil
 }
 
-// SaveIP persists ip under the "storedIP" key with no TTL (persistent),
+// SaveIP commits change and the messages announcing it to the outbox in a
+// single write, then applies the change: it is recorded in the history and its
+// new IP is persisted under the key of its family with no TTL (persistent),
 // overwriting any previous value.
 //
+// Once the outbox is written the update is committed: if applying the change
+// fails, StoredIP already returns the new IP and the next AckMessage applies
+// it again. Retrying SaveIP is safe: the change and the messages a previous
+// attempt already committed are not added again, nor is the history entry.
+//
 // Parameters:
 //   - ctx: Context for cancellation and timeouts
-//   - ip: IP address to store
+//   - change: The IP change to persist
+//   - messages: Messages announcing the change, delivered by the dispatcher
 //
 // Returns:
-//   - error: Error if the write operation fails
-func (store *Store) SaveIP(ctx context.Context, ip string) error {
+//   - error: Error if any write operation fails
+func (store *Store) SaveIP(ctx context.Context, change domain.IPChange, messages []domain.OutboxMessage) error {
 	// Store IP with no TTL (persistent storage)
 	log := logger.FromContext(ctx).With("operation", "SaveIP")
-	log.DebugContext(ctx, "Storing required IP into store", "ip", ip)
+	log.DebugContext(ctx, "Storing required IP into store", "family", change.Family, "ip", change.NewIP)
+
+	outbox, readErr := store.readOutbox(ctx)
+	if readErr != nil {
+		return readErr
+	}
+
+	outbox.add(ipChangeData(change), messages)
+
+	if commitErr := store.writeOutbox(ctx, outbox); commitErr != nil {
+		log.ErrorContext(ctx, "Error committing IP change to outbox", "error", commitErr)
+		return commitErr
+	}
+
+	if applyErr := store.applyChanges(ctx, &outbox); applyErr != nil {
+		log.ErrorContext(ctx, "Error applying committed IP change, it stays in the outbox", "error", applyErr)
+		return applyErr
+	}
+
+	return store.writeOutbox(ctx, outbox)
+}
+
+// alertStateData is the unexported DTO persisted for a domain.AlertState.
+type alertStateData struct {
+	Active       bool      `json:"active"`
+	Since        time.Time `json:"since"`
+	LastNotified time.Time `json:"lastNotified"`
+	Detail       string    `json:"detail"`
+}
+
+// alertKey returns the key holding the state of alert (e.g. "alert:differentISP").
+func alertKey(alert string) string {
+	return "alert:" + alert
+}
+
+// AlertState returns the persisted state of alert, stored as JSON under
+// "alert:<alert>".
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - alert: Name of the alert
+//
+// Returns:
+//   - domain.AlertState: The stored state (zero value if none was found)
+//   - bool: Whether a state was found
+//   - error: Error if the read operation fails or the value cannot be decoded
+func (store *Store) AlertState(ctx context.Context, alert string) (domain.AlertState, bool, error) {
+
+	log := logger.FromContext(ctx).With("operation", "AlertState")
+	log.DebugContext(ctx, "Retrieving alert state from store", "alert", alert)
+
+	value, found, readErr := store.Database.ReadString(ctx, alertKey(alert))
+	if readErr != nil || !found {
+		return domain.AlertState{}, found, readErr
+	}
+
+	var data alertStateData
+	if unmarshalErr := json.Unmarshal([]byte(value), &data); unmarshalErr != nil {
+		log.ErrorContext(ctx, "Stored alert state cannot be decoded", "alert", alert, "error", unmarshalErr)
+		return domain.AlertState{}, false, unmarshalErr
+	}
+
+	return domain.AlertState(data), true, nil
+}
+
+// SaveAlertState persists state as JSON under the key of alert with no TTL,
+// overwriting any previous value.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - alert: Name of the alert
+//   - state: State to store
+//
+// Returns:
+//   - error: Error if the write operation fails
+func (store *Store) SaveAlertState(ctx context.Context, alert string, state domain.AlertState) error {
+
+	log := logger.FromContext(ctx).With("operation", "SaveAlertState")
+	log.DebugContext(ctx, "Storing alert state into store", "alert", alert, "state", state)
+
+	encodedState, marshalErr := json.Marshal(alertStateData(state))
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	return store.Database.WriteString(ctx, alertKey(alert), string(encodedState), 0)
+}
+
+// propagationData is the unexported DTO persisted for a domain.Propagation.
+type propagationData struct {
+	Address string        `json:"address"`
+	Since   time.Time     `json:"since"`
+	TTL     time.Duration `json:"ttl"`
+}
+
+// propagationKey returns the key holding the propagation of the last update of
+// family (e.g. "propagation:ipv4").
+func propagationKey(family domain.IPFamily) string {
+	return "propagation:" + string(family)
+}
+
+// Propagation returns the persisted propagation of the last update of family,
+// stored as JSON under "propagation:<family>".
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - family: Address family of the update
+//
+// Returns:
+//   - domain.Propagation: The stored propagation (zero value if none was found)
+//   - bool: Whether a propagation was found
+//   - error: Error if the read operation fails or the value cannot be decoded
+func (store *Store) Propagation(ctx context.Context, family domain.IPFamily) (domain.Propagation, bool, error) {
+
+	log := logger.FromContext(ctx).With("operation", "Propagation")
+	log.DebugContext(ctx, "Retrieving update propagation from store", "family", family)
+
+	value, found, readErr := store.Database.ReadString(ctx, propagationKey(family))
+	if readErr != nil || !found {
+		return domain.Propagation{}, found, readErr
+	}
+
+	var data propagationData
+	if unmarshalErr := json.Unmarshal([]byte(value), &data); unmarshalErr != nil {
+		log.ErrorContext(ctx, "Stored update propagation cannot be decoded", "family", family, "error", unmarshalErr)
+		return domain.Propagation{}, false, unmarshalErr
+	}
+
+	return domain.Propagation(data), true, nil
+}
+
+// SavePropagation persists propagation as JSON under the key of family with
+// no TTL, overwriting the one of the previous update.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - family: Address family of the update
+//   - propagation: Propagation to store
+//
+// Returns:
+//   - error: Error if the write operation fails
+func (store *Store) SavePropagation(ctx context.Context, family domain.IPFamily, propagation domain.Propagation) error {
+
+	log := logger.FromContext(ctx).With("operation", "SavePropagation")
+	log.DebugContext(ctx, "Storing update propagation into store", "family", family, "propagation", propagation)
+
+	encodedPropagation, marshalErr := json.Marshal(propagationData(propagation))
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	return store.Database.WriteString(ctx, propagationKey(family), string(encodedPropagation), 0)
+}
+
+// breakerStateData is the unexported DTO persisted for a domain.BreakerState.
+type breakerStateData struct {
+	Status   domain.BreakerStatus `json:"status"`
+	Failures int                  `json:"failures"`
+	OpenedAt time.Time            `json:"openedAt"`
+	TrialAt  time.Time            `json:"trialAt,omitzero"`
+}
+
+// breakerKey returns the key holding the state of breaker (e.g. "breaker:ipinfo").
+func breakerKey(breaker string) string {
+	return "breaker:" + breaker
+}
+
+// BreakerState returns the persisted state of breaker, stored as JSON under
+// "breaker:<breaker>".
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - breaker: Name of the circuit breaker
+//
+// Returns:
+//   - domain.BreakerState: The stored state (closed if none was found)
+//   - bool: Whether a state was found
+//   - error: Error if the read operation fails or the value cannot be decoded
+func (store *Store) BreakerState(ctx context.Context, breaker string) (domain.BreakerState, bool, error) {
+
+	log := logger.FromContext(ctx).With("operation", "BreakerState")
+	log.DebugContext(ctx, "Retrieving circuit breaker state from store", "breaker", breaker)
+
+	value, found, readErr := store.Database.ReadString(ctx, breakerKey(breaker))
+	if readErr != nil || !found {
+		return domain.BreakerState{}, found, readErr
+	}
+
+	var data breakerStateData
+	if unmarshalErr := json.Unmarshal([]byte(value), &data); unmarshalErr != nil {
+		log.ErrorContext(ctx, "Stored circuit breaker state cannot be decoded", "breaker", breaker, "error", unmarshalErr)
+		return domain.BreakerState{}, false, unmarshalErr
+	}
+
+	return domain.BreakerState(data), true, nil
+}
+
+// SaveBreakerState persists state as JSON under the key of breaker with no
+// TTL, overwriting any previous value.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - breaker: Name of the circuit breaker
+//   - state: State to store
+//
+// Returns:
+//   - error: Error if the write operation fails
+func (store *Store) SaveBreakerState(ctx context.Context, breaker string, state domain.BreakerState) error {
+
+	log := logger.FromContext(ctx).With("operation", "SaveBreakerState")
+	log.DebugContext(ctx, "Storing circuit breaker state into store", "breaker", breaker, "state", state)
+
+	encodedState, marshalErr := json.Marshal(breakerStateData(state))
+	if marshalErr != nil {
+		return marshalErr
+	}
 
-	writeError := store.Database.WriteString(ctx, "storedIP", ip, 0)
-	return writeError
+	return store.Database.WriteString(ctx, breakerKey(breaker), string(encodedState), 0)
 }
@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	memorydatabase "github.com/a-castellano/go-services/services/memorydatabase"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
 	redismock "github.com/go-redis/redismock/v9"
 	goredis "github.com/redis/go-redis/v9"
 	"testing"
@@ -49,7 +50,7 @@ func TestErrorRedis(t *testing.T) {
 
 	ipstore := Store{Database: memoryDatabase}
 
-	_, _, storedIPErr := ipstore.StoredIP(ctx)
+	_, _, storedIPErr := ipstore.StoredIP(ctx, domain.IPv4)
 	if storedIPErr == nil {
 		t.Errorf("TestErrorRedis should return error when redis read has failed.")
 	}
@@ -60,12 +61,13 @@ func TestIPNotSetYetRedis(t *testing.T) {
 	ctx := context.Background()
 	dbMock, mock := redismock.NewClientMock()
 	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("outbox").RedisNil()
 
 	redisClientMock := RedisClientMock{client: dbMock}
 	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
 	ipstore := Store{Database: memoryDatabase}
 
-	_, found, storedIPErr := ipstore.StoredIP(ctx)
+	_, found, storedIPErr := ipstore.StoredIP(ctx, domain.IPv4)
 	if storedIPErr != nil {
 		t.Errorf("TestIPNotSetYetRedis shoudld not fail.")
 	}
@@ -80,12 +82,13 @@ func TestStoredIP(t *testing.T) {
 	dbMock, mock := redismock.NewClientMock()
 	expectedIP := "12.12.12.12"
 	mock.ExpectGet("storedIP").SetVal(expectedIP)
+	mock.ExpectGet("outbox").RedisNil()
 
 	redisClientMock := RedisClientMock{client: dbMock}
 	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
 	ipstore := Store{Database: memoryDatabase}
 
-	storedIP, found, storedIPErr := ipstore.StoredIP(ctx)
+	storedIP, found, storedIPErr := ipstore.StoredIP(ctx, domain.IPv4)
 	if storedIPErr != nil {
 		t.Errorf("TestStoredSameIP should not fail.")
 	}
@@ -98,34 +101,282 @@ func TestStoredIP(t *testing.T) {
 
 }
 
+// changeTime is the time of the IP changes saved by the tests.
+var changeTime = time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC)
+
 func TestUpdateIPWithNoError(t *testing.T) {
 	ctx := context.Background()
 	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectSet("outbox", `{"changes":[{"family":"ipv4","newIP":"12.12.12.12","orgName":"DIGI","asn":57269,"time":"2026-06-24T10:00:00Z"}],"messages":[`+updateMessageData+`]}`, 0).SetVal("OK")
+	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("ipHistory").RedisNil()
+	mock.ExpectSet("ipHistory", `[{"family":"ipv4","newIP":"12.12.12.12","orgName":"DIGI","asn":57269,"time":"2026-06-24T10:00:00Z"}]`, 0).SetVal("OK")
 	mock.ExpectSet("storedIP", "12.12.12.12", 0).SetVal("OK")
+	mock.ExpectSet("outbox", `{"messages":[`+updateMessageData+`]}`, 0).SetVal("OK")
 
 	redisClientMock := RedisClientMock{client: dbMock}
 	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
 
 	ipstore := Store{Database: memoryDatabase}
-	errorOnUpdate := ipstore.SaveIP(ctx, "12.12.12.12")
+	errorOnUpdate := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv4, NewIP: "12.12.12.12", OrgName: "DIGI", ASN: 57269, Time: changeTime}, []domain.OutboxMessage{updateMessage})
 	if errorOnUpdate != nil {
 		t.Errorf("TestUpdateIPWithNoError should not fail.")
 	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestUpdateIPWithNoError should commit the change to the outbox, record it and store the IP: %v", expectationsErr)
+	}
 
 }
 
+// Republishing the stored IP, because only the DNS records drifted, leaves
+// the history alone.
+func TestUpdateIPUnchangedSkipsHistory(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectSet("outbox", `{"changes":[{"family":"ipv4","oldIP":"1.1.1.1","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]}`, 0).SetVal("OK")
+	mock.ExpectGet("storedIP").SetVal("12.12.12.12")
+	mock.ExpectSet("storedIP", "12.12.12.12", 0).SetVal("OK")
+	mock.ExpectSet("outbox", `{}`, 0).SetVal("OK")
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+
+	ipstore := Store{Database: memoryDatabase}
+	if errorOnUpdate := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv4, OldIP: "1.1.1.1", NewIP: "12.12.12.12", Time: changeTime}, nil); errorOnUpdate != nil {
+		t.Errorf("TestUpdateIPUnchangedSkipsHistory should not fail: %v", errorOnUpdate)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestUpdateIPUnchangedSkipsHistory should not record a history entry: %v", expectationsErr)
+	}
+
+}
+
+// Once committed to the outbox, a change that cannot be applied stays there.
 func TestUpdateIPWithError(t *testing.T) {
 	ctx := context.Background()
 	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectSet("outbox", `{"changes":[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]}`, 0).SetVal("OK")
+	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("ipHistory").RedisNil()
+	mock.ExpectSet("ipHistory", `[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]`, 0).SetVal("OK")
 	mock.ExpectSet("storedIP", "12.12.12.12", 0).SetErr(errors.New("FAIL"))
 
 	redisClientMock := RedisClientMock{client: dbMock}
 	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
 
 	ipstore := Store{Database: memoryDatabase}
-	errorOnUpdate := ipstore.SaveIP(ctx, "12.12.12.12")
+	errorOnUpdate := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv4, NewIP: "12.12.12.12", Time: changeTime}, nil)
 	if errorOnUpdate == nil {
 		t.Errorf("TestUpdateIPWithError should fail.")
 	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestUpdateIPWithError should leave the change in the outbox: %v", expectationsErr)
+	}
+
+}
+
+func TestUpdateIPHistoryError(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectSet("outbox", `{"changes":[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}]}`, 0).SetVal("OK")
+	mock.ExpectGet("storedIP").RedisNil()
+	mock.ExpectGet("ipHistory").SetErr(errors.New("FAIL"))
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+
+	ipstore := Store{Database: memoryDatabase}
+	errorOnUpdate := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv4, NewIP: "12.12.12.12", Time: changeTime}, nil)
+	if errorOnUpdate == nil {
+		t.Errorf("TestUpdateIPHistoryError should fail, the IP must not be stored without its history entry.")
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestUpdateIPHistoryError should not store the IP: %v", expectationsErr)
+	}
+
+}
+
+// Nothing is applied when the change cannot be committed to the outbox.
+func TestUpdateIPCommitError(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectSet("outbox", `{"changes":[{"family":"ipv4","newIP":"12.12.12.12","time":"2026-06-24T10:00:00Z"}],"messages":[`+updateMessageData+`]}`, 0).SetErr(errors.New("FAIL"))
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+
+	ipstore := Store{Database: memoryDatabase}
+	errorOnUpdate := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv4, NewIP: "12.12.12.12", Time: changeTime}, []domain.OutboxMessage{updateMessage})
+	if errorOnUpdate == nil {
+		t.Errorf("TestUpdateIPCommitError should fail.")
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestUpdateIPCommitError should not apply the change: %v", expectationsErr)
+	}
+
+}
+
+func TestStoredIPv6UsesItsOwnKey(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	expectedIP := "2001:db8::1"
+	mock.ExpectGet("storedIPv6").SetVal(expectedIP)
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectGet("outbox").RedisNil()
+	mock.ExpectSet("outbox", `{"changes":[{"family":"ipv6","oldIP":"2001:db8::1","newIP":"2001:db8::2","time":"2026-06-24T10:00:00Z"}]}`, 0).SetVal("OK")
+	mock.ExpectGet("storedIPv6").SetVal(expectedIP)
+	mock.ExpectGet("ipHistory").RedisNil()
+	mock.ExpectSet("ipHistory", `[{"family":"ipv6","oldIP":"2001:db8::1","newIP":"2001:db8::2","time":"2026-06-24T10:00:00Z"}]`, 0).SetVal("OK")
+	mock.ExpectSet("storedIPv6", "2001:db8::2", 0).SetVal("OK")
+	mock.ExpectSet("outbox", `{}`, 0).SetVal("OK")
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+	ipstore := Store{Database: memoryDatabase}
+
+	storedIP, found, storedIPErr := ipstore.StoredIP(ctx, domain.IPv6)
+	if storedIPErr != nil || !found {
+		t.Errorf("TestStoredIPv6UsesItsOwnKey should find an stored IPv6 address.")
+	}
+	if storedIP != expectedIP {
+		t.Fatalf("Stored ip should be '%s' instead of the actual stored '%s'", expectedIP, storedIP)
+	}
+
+	if errorOnUpdate := ipstore.SaveIP(ctx, domain.IPChange{Family: domain.IPv6, OldIP: expectedIP, NewIP: "2001:db8::2", Time: changeTime}, nil); errorOnUpdate != nil {
+		t.Errorf("TestStoredIPv6UsesItsOwnKey should not fail saving the IPv6 address.")
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestStoredIPv6UsesItsOwnKey should only use the storedIPv6 key: %v", expectationsErr)
+	}
+
+}
+
+func TestAlertStateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	since := time.Date(2026, 6, 24, 10, 0, 0, 0, time.UTC)
+	state := domain.AlertState{Active: true, Since: since, LastNotified: since, Detail: "ORANGE"}
+	encodedState := `{"active":true,"since":"2026-06-24T10:00:00Z","lastNotified":"2026-06-24T10:00:00Z","detail":"ORANGE"}`
+	mock.ExpectSet("alert:differentISP", encodedState, 0).SetVal("OK")
+	mock.ExpectGet("alert:differentISP").SetVal(encodedState)
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+	ipstore := Store{Database: memoryDatabase}
+
+	if saveErr := ipstore.SaveAlertState(ctx, "differentISP", state); saveErr != nil {
+		t.Fatalf("TestAlertStateRoundTrip should not fail saving the state: %v", saveErr)
+	}
+
+	storedState, found, readErr := ipstore.AlertState(ctx, "differentISP")
+	if readErr != nil || !found {
+		t.Fatalf("TestAlertStateRoundTrip should find the stored state: %v", readErr)
+	}
+	if storedState != state {
+		t.Errorf("Stored alert state should be %+v instead of %+v", state, storedState)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestAlertStateRoundTrip should use the alert:differentISP key: %v", expectationsErr)
+	}
+
+}
+
+func TestBreakerStateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	openedAt := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	state := domain.BreakerState{Status: domain.BreakerOpen, Failures: 3, OpenedAt: openedAt}
+	encodedState := `{"status":"open","failures":3,"openedAt":"2026-10-18T10:00:00Z"}`
+	mock.ExpectSet("breaker:ipinfo", encodedState, 0).SetVal("OK")
+	mock.ExpectGet("breaker:ipinfo").SetVal(encodedState)
+	mock.ExpectGet("breaker:stun").RedisNil()
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+	ipstore := Store{Database: memoryDatabase}
+
+	if saveErr := ipstore.SaveBreakerState(ctx, "ipinfo", state); saveErr != nil {
+		t.Fatalf("TestBreakerStateRoundTrip should not fail saving the state: %v", saveErr)
+	}
+
+	storedState, found, readErr := ipstore.BreakerState(ctx, "ipinfo")
+	if readErr != nil || !found || storedState != state {
+		t.Errorf("Stored circuit breaker state should be %+v instead of %+v (%v)", state, storedState, readErr)
+	}
+
+	if _, found, readErr := ipstore.BreakerState(ctx, "stun"); readErr != nil || found {
+		t.Errorf("TestBreakerStateRoundTrip should not find a state never saved: %v", readErr)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestBreakerStateRoundTrip should use the breaker:<name> keys: %v", expectationsErr)
+	}
+
+}
+
+func TestPropagationRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	since := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	propagation := domain.Propagation{Address: "2a0c:5a80::2", Since: since, TTL: 5 * time.Minute}
+	encodedPropagation := `{"address":"2a0c:5a80::2","since":"2026-10-18T10:00:00Z","ttl":300000000000}`
+	mock.ExpectSet("propagation:ipv6", encodedPropagation, 0).SetVal("OK")
+	mock.ExpectGet("propagation:ipv6").SetVal(encodedPropagation)
+	mock.ExpectGet("propagation:ipv4").RedisNil()
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+	ipstore := Store{Database: memoryDatabase}
+
+	if saveErr := ipstore.SavePropagation(ctx, domain.IPv6, propagation); saveErr != nil {
+		t.Fatalf("TestPropagationRoundTrip should not fail saving the propagation: %v", saveErr)
+	}
+
+	storedPropagation, found, readErr := ipstore.Propagation(ctx, domain.IPv6)
+	if readErr != nil || !found || storedPropagation != propagation {
+		t.Errorf("Stored propagation should be %+v instead of %+v (%v)", propagation, storedPropagation, readErr)
+	}
+
+	if _, found, readErr := ipstore.Propagation(ctx, domain.IPv4); readErr != nil || found {
+		t.Errorf("TestPropagationRoundTrip should not find a propagation never saved: %v", readErr)
+	}
+	if expectationsErr := mock.ExpectationsWereMet(); expectationsErr != nil {
+		t.Errorf("TestPropagationRoundTrip should use the propagation:<family> keys: %v", expectationsErr)
+	}
+
+}
+
+func TestAlertStateNotSetYet(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("alert:differentISP").RedisNil()
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+	ipstore := Store{Database: memoryDatabase}
+
+	_, found, readErr := ipstore.AlertState(ctx, "differentISP")
+	if readErr != nil || found {
+		t.Errorf("TestAlertStateNotSetYet should not find any state nor fail: %v", readErr)
+	}
+
+}
+
+func TestAlertStateInvalidValue(t *testing.T) {
+	ctx := context.Background()
+	dbMock, mock := redismock.NewClientMock()
+	mock.ExpectGet("alert:differentISP").SetVal("not json")
+
+	redisClientMock := RedisClientMock{client: dbMock}
+	memoryDatabase := memorydatabase.NewMemoryDatabase(redisClientMock)
+	ipstore := Store{Database: memoryDatabase}
+
+	if _, _, readErr := ipstore.AlertState(ctx, "differentISP"); readErr == nil {
+		t.Errorf("TestAlertStateInvalidValue should fail when the stored value cannot be decoded")
+	}
 
 }
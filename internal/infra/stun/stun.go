@@ -0,0 +1,298 @@
+package stun
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// STUN message types, attributes and constants (RFC 5389).
+const (
+	bindingRequest         = 0x0001
+	bindingSuccessResponse = 0x0101
+	bindingErrorResponse   = 0x0111
+
+	attributeMappedAddress    = 0x0001
+	attributeErrorCode        = 0x0009
+	attributeXORMappedAddress = 0x0020
+
+	addressFamilyIPv4 = 0x01
+	addressFamilyIPv6 = 0x02
+
+	magicCookie  = 0x2112A442
+	headerLength = 20
+)
+
+// DefaultServers are the public STUN servers asked when none are configured.
+var DefaultServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+// defaultTimeout bounds each server query when Client.Timeout is unset.
+const defaultTimeout = 3 * time.Second
+
+// initialRetransmission is the delay before the first retransmission of a
+// request; it doubles on each one, as recommended by RFC 5389.
+const initialRetransmission = 500 * time.Millisecond
+
+// Client is the STUN adapter. It implements domain.IPInfoProvider by sending
+// Binding requests to Servers ("host:port") and reading the address they saw
+// the request come from. Each family in Families is detected on its own
+// socket (udp4 or udp6); when Families is empty only IPv4 is detected. Servers
+// are tried in order until one answers.
+//
+// STUN only reveals the address, so the returned domain.IPInfo carries no ASN
+// or organization: use it in a quorum with a provider that does, or match the
+// main ISP with ISP_PREFIXES.
+type Client struct {
+	Servers  []string
+	Families []domain.IPFamily
+	Timeout  time.Duration // Per server query, defaultTimeout when zero
+}
+
+// GetIPInfo detects the public address of every configured family. A family
+// no server answered for is left empty; an error is returned only when no
+// family could be detected.
+func (client Client) GetIPInfo(ctx context.Context) (domain.IPInfo, error) {
+
+	log := logger.FromContext(ctx).With("operation", "stun.GetIPInfo")
+
+	families := client.Families
+	if len(families) == 0 {
+		families = []domain.IPFamily{domain.IPv4}
+	}
+	servers := client.Servers
+	if len(servers) == 0 {
+		servers = DefaultServers
+	}
+
+	var ipinfo domain.IPInfo
+	var errs []error
+
+	for _, family := range families {
+		address, familyErr := client.detect(ctx, servers, family)
+		if familyErr != nil {
+			log.ErrorContext(ctx, "Error detecting public address through STUN", "family", family, "error", familyErr)
+			errs = append(errs, familyErr)
+			continue
+		}
+		if setAddressErr := ipinfo.SetAddress(address.String()); setAddressErr != nil {
+			return domain.IPInfo{}, setAddressErr
+		}
+		log.DebugContext(ctx, "Public address detected through STUN", "family", family, "address", address)
+	}
+
+	if len(ipinfo.Addresses()) == 0 {
+		return domain.IPInfo{}, errors.Join(errs...)
+	}
+
+	ipinfo.Source = "stun"
+	return ipinfo, nil
+}
+
+// detect asks servers in order for the address of family and returns the
+// first answer.
+func (client Client) detect(ctx context.Context, servers []string, family domain.IPFamily) (netip.Addr, error) {
+
+	log := logger.FromContext(ctx).With("operation", "stun.detect", "family", family)
+
+	network := "udp4"
+	if family == domain.IPv6 {
+		network = "udp6"
+	}
+
+	var errs []error
+	for _, server := range servers {
+		address, bindingErr := client.binding(ctx, network, server)
+		if bindingErr == nil && address.Is6() != (family == domain.IPv6) {
+			bindingErr = fmt.Errorf("STUN server returned %s, which is not an %s address", address, family)
+		}
+		if bindingErr == nil {
+			return address, nil
+		}
+		log.DebugContext(ctx, "STUN server did not answer", "server", server, "error", bindingErr)
+		errs = append(errs, fmt.Errorf("%s: %w", server, bindingErr))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no STUN server returned an %s address: %w", family, errors.Join(errs...))
+}
+
+// binding sends a Binding request to server over network, retransmitting it
+// until a matching response arrives or the timeout expires.
+func (client Client) binding(ctx context.Context, network string, server string) (netip.Addr, error) {
+
+	timeout := client.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, dialErr := dialer.DialContext(ctx, network, server)
+	if dialErr != nil {
+		return netip.Addr{}, dialErr
+	}
+	defer conn.Close()
+
+	// Unblock reads as soon as the context is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	request, transactionID, requestErr := newBindingRequest()
+	if requestErr != nil {
+		return netip.Addr{}, requestErr
+	}
+
+	buffer := make([]byte, 1500)
+	retransmission := initialRetransmission
+	for {
+		if _, writeErr := conn.Write(request); writeErr != nil {
+			return netip.Addr{}, writeErr
+		}
+		conn.SetReadDeadline(time.Now().Add(retransmission))
+
+		for {
+			n, readErr := conn.Read(buffer)
+			if readErr != nil {
+				if ctx.Err() != nil {
+					return netip.Addr{}, ctx.Err()
+				}
+				var netErr net.Error
+				if errors.As(readErr, &netErr) && netErr.Timeout() {
+					break // retransmit
+				}
+				return netip.Addr{}, readErr
+			}
+
+			address, parseErr := parseBindingResponse(buffer[:n], transactionID)
+			if errors.Is(parseErr, errUnrelatedMessage) {
+				continue
+			}
+			return address, parseErr
+		}
+		retransmission *= 2
+	}
+}
+
+// errUnrelatedMessage is returned by parseBindingResponse for datagrams that
+// are not the response to our request, which are ignored.
+var errUnrelatedMessage = errors.New("not a response to the STUN request")
+
+// newBindingRequest returns a Binding request without attributes and its
+// random transaction ID.
+func newBindingRequest() ([]byte, [12]byte, error) {
+
+	var transactionID [12]byte
+	if _, randErr := rand.Read(transactionID[:]); randErr != nil {
+		return nil, transactionID, randErr
+	}
+
+	request := make([]byte, headerLength)
+	binary.BigEndian.PutUint16(request[0:2], bindingRequest)
+	binary.BigEndian.PutUint16(request[2:4], 0)
+	binary.BigEndian.PutUint32(request[4:8], magicCookie)
+	copy(request[8:20], transactionID[:])
+	return request, transactionID, nil
+}
+
+// parseBindingResponse returns the mapped address of a Binding response to
+// the request with transactionID. XOR-MAPPED-ADDRESS is preferred; the plain
+// MAPPED-ADDRESS sent by RFC 3489 servers is used otherwise.
+func parseBindingResponse(message []byte, transactionID [12]byte) (netip.Addr, error) {
+
+	if len(message) < headerLength || binary.BigEndian.Uint32(message[4:8]) != magicCookie || [12]byte(message[8:20]) != transactionID {
+		return netip.Addr{}, errUnrelatedMessage
+	}
+
+	messageType := binary.BigEndian.Uint16(message[0:2])
+	attributes := message[headerLength:]
+	length := int(binary.BigEndian.Uint16(message[2:4]))
+	if length > len(attributes) {
+		return netip.Addr{}, errors.New("truncated STUN response")
+	}
+	attributes = attributes[:length]
+
+	var mapped, xorMapped netip.Addr
+	for len(attributes) >= 4 {
+		attributeType := binary.BigEndian.Uint16(attributes[0:2])
+		attributeLength := int(binary.BigEndian.Uint16(attributes[2:4]))
+		if 4+attributeLength > len(attributes) {
+			return netip.Addr{}, errors.New("truncated STUN attribute")
+		}
+		value := attributes[4 : 4+attributeLength]
+
+		switch attributeType {
+		case attributeXORMappedAddress:
+			xorMapped = parseAddress(value, message[4:20])
+		case attributeMappedAddress:
+			mapped = parseAddress(value, nil)
+		case attributeErrorCode:
+			if messageType == bindingErrorResponse && len(value) >= 4 {
+				return netip.Addr{}, fmt.Errorf("STUN server answered with error %d: %s", int(value[2]&0x07)*100+int(value[3]), string(value[4:]))
+			}
+		}
+
+		// Attributes are padded to a multiple of 4 bytes.
+		padded := (attributeLength + 3) &^ 3
+		if 4+padded > len(attributes) {
+			break
+		}
+		attributes = attributes[4+padded:]
+	}
+
+	switch {
+	case messageType == bindingErrorResponse:
+		return netip.Addr{}, errors.New("STUN server answered with an error")
+	case messageType != bindingSuccessResponse:
+		return netip.Addr{}, errUnrelatedMessage
+	case xorMapped.IsValid():
+		return xorMapped, nil
+	case mapped.IsValid():
+		return mapped, nil
+	}
+	return netip.Addr{}, errors.New("STUN response has no mapped address")
+}
+
+// parseAddress decodes a (XOR-)MAPPED-ADDRESS value. For XOR-MAPPED-ADDRESS,
+// mask is the magic cookie followed by the transaction ID, XORed with the
+// address; it is nil for MAPPED-ADDRESS. An invalid address is returned when
+// value cannot be decoded.
+func parseAddress(value []byte, mask []byte) netip.Addr {
+
+	if len(value) < 4 {
+		return netip.Addr{}
+	}
+
+	var addressLength int
+	switch value[1] {
+	case addressFamilyIPv4:
+		addressLength = 4
+	case addressFamilyIPv6:
+		addressLength = 16
+	default:
+		return netip.Addr{}
+	}
+	if len(value) < 4+addressLength {
+		return netip.Addr{}
+	}
+
+	raw := make([]byte, addressLength)
+	copy(raw, value[4:4+addressLength])
+	if mask != nil {
+		for index := range raw {
+			raw[index] ^= mask[index]
+		}
+	}
+
+	address, _ := netip.AddrFromSlice(raw)
+	return address
+}
@@ -0,0 +1,201 @@
+//go:build integration_tests || unit_tests || stun_tests || stun_unit_tests
+
+package stun
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	domain "github.com/a-castellano/home-ip-monitor/internal/domain"
+)
+
+// stunServerMock is a local STUN stand-in. It answers every Binding request
+// with the message built by answer from the request header; when answer is
+// nil it reads requests without answering them.
+type stunServerMock struct {
+	conn   net.PacketConn
+	answer func(request []byte) []byte
+}
+
+func newSTUNServerMock(t *testing.T, network string, address string, answer func(request []byte) []byte) *stunServerMock {
+	t.Helper()
+	conn, listenErr := net.ListenPacket(network, address)
+	if listenErr != nil {
+		t.Skipf("Cannot listen on %s %s: %v", network, address, listenErr)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	server := &stunServerMock{conn: conn, answer: answer}
+	go server.serve()
+	return server
+}
+
+func (server *stunServerMock) serve() {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, readErr := server.conn.ReadFrom(buffer)
+		if readErr != nil {
+			return
+		}
+		if server.answer == nil || n < headerLength || binary.BigEndian.Uint16(buffer[0:2]) != bindingRequest {
+			continue
+		}
+		server.conn.WriteTo(server.answer(buffer[:headerLength]), from)
+	}
+}
+
+func (server *stunServerMock) address() string {
+	return server.conn.LocalAddr().String()
+}
+
+// response builds a STUN message of messageType answering request with the
+// given attributes.
+func response(request []byte, messageType uint16, attributes ...[]byte) []byte {
+	message := make([]byte, headerLength)
+	binary.BigEndian.PutUint16(message[0:2], messageType)
+	copy(message[4:20], request[4:20])
+	for _, attribute := range attributes {
+		message = append(message, attribute...)
+	}
+	binary.BigEndian.PutUint16(message[2:4], uint16(len(message)-headerLength))
+	return message
+}
+
+// addressAttribute builds a (XOR-)MAPPED-ADDRESS attribute for address. With
+// xor set, the address is masked with the magic cookie and the transaction ID
+// of request.
+func addressAttribute(request []byte, address netip.Addr, xor bool) []byte {
+	raw := address.AsSlice()
+	family := byte(addressFamilyIPv4)
+	if address.Is6() {
+		family = addressFamilyIPv6
+	}
+	attributeType := uint16(attributeMappedAddress)
+	if xor {
+		attributeType = attributeXORMappedAddress
+		for index := range raw {
+			raw[index] ^= request[4+index]
+		}
+	}
+	attribute := make([]byte, 8, 8+len(raw))
+	binary.BigEndian.PutUint16(attribute[0:2], attributeType)
+	binary.BigEndian.PutUint16(attribute[2:4], uint16(4+len(raw)))
+	attribute[5] = family
+	binary.BigEndian.PutUint16(attribute[6:8], 40000)
+	return append(attribute, raw...)
+}
+
+func TestSTUNXORMappedAddressIPv4(t *testing.T) {
+
+	server := newSTUNServerMock(t, "udp4", "127.0.0.1:0", func(request []byte) []byte {
+		// A software attribute, of a length needing padding, comes first.
+		software := []byte{0x80, 0x22, 0x00, 0x03, 'm', 'o', 'k', 0x00}
+		return response(request, bindingSuccessResponse, software, addressAttribute(request, netip.MustParseAddr("203.0.113.7"), true))
+	})
+
+	client := Client{Servers: []string{server.address()}, Families: []domain.IPFamily{domain.IPv4}, Timeout: time.Second}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestSTUNXORMappedAddressIPv4 should not fail: %v", err)
+	}
+	if ipinfo.IPv4 != "203.0.113.7" || ipinfo.Source != "stun" {
+		t.Errorf("TestSTUNXORMappedAddressIPv4 should detect 203.0.113.7 through stun, got %+v", ipinfo)
+	}
+
+}
+
+func TestSTUNXORMappedAddressIPv6(t *testing.T) {
+
+	server := newSTUNServerMock(t, "udp6", "[::1]:0", func(request []byte) []byte {
+		return response(request, bindingSuccessResponse, addressAttribute(request, netip.MustParseAddr("2001:db8::7"), true))
+	})
+
+	client := Client{Servers: []string{server.address()}, Families: []domain.IPFamily{domain.IPv6}, Timeout: time.Second}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil {
+		t.Fatalf("TestSTUNXORMappedAddressIPv6 should not fail: %v", err)
+	}
+	if ipinfo.IPv6 != "2001:db8::7" || ipinfo.IPv4 != "" {
+		t.Errorf("TestSTUNXORMappedAddressIPv6 should detect 2001:db8::7 only, got %+v", ipinfo)
+	}
+
+}
+
+// The IPv6 XOR mask covers the transaction ID; checked without a socket, so
+// it runs on hosts without IPv6 too.
+func TestParseXORMappedAddressIPv6(t *testing.T) {
+
+	request, transactionID, requestErr := newBindingRequest()
+	if requestErr != nil {
+		t.Fatalf("TestParseXORMappedAddressIPv6 cannot build a request: %v", requestErr)
+	}
+	expected := netip.MustParseAddr("2001:db8:1:2:3:4:5:6")
+
+	address, parseErr := parseBindingResponse(response(request, bindingSuccessResponse, addressAttribute(request, expected, true)), transactionID)
+	if parseErr != nil || address != expected {
+		t.Errorf("TestParseXORMappedAddressIPv6 should decode %s, got %s (%v)", expected, address, parseErr)
+	}
+
+}
+
+// RFC 3489 servers only send MAPPED-ADDRESS.
+func TestSTUNMappedAddressFallback(t *testing.T) {
+
+	server := newSTUNServerMock(t, "udp4", "127.0.0.1:0", func(request []byte) []byte {
+		return response(request, bindingSuccessResponse, addressAttribute(request, netip.MustParseAddr("198.51.100.1"), false))
+	})
+
+	client := Client{Servers: []string{server.address()}, Timeout: time.Second}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil || ipinfo.IPv4 != "198.51.100.1" {
+		t.Errorf("TestSTUNMappedAddressFallback should detect 198.51.100.1, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+// A server that does not answer, or answers with an error, is skipped.
+func TestSTUNServerFailover(t *testing.T) {
+
+	silent := newSTUNServerMock(t, "udp4", "127.0.0.1:0", nil)
+	failing := newSTUNServerMock(t, "udp4", "127.0.0.1:0", func(request []byte) []byte {
+		// ERROR-CODE 500 "Serv"
+		errorCode := []byte{0x00, 0x09, 0x00, 0x08, 0x00, 0x00, 0x05, 0x00, 'S', 'e', 'r', 'v'}
+		return response(request, bindingErrorResponse, errorCode)
+	})
+	working := newSTUNServerMock(t, "udp4", "127.0.0.1:0", func(request []byte) []byte {
+		return response(request, bindingSuccessResponse, addressAttribute(request, netip.MustParseAddr("203.0.113.9"), true))
+	})
+
+	client := Client{Servers: []string{silent.address(), failing.address(), working.address()}, Timeout: 200 * time.Millisecond}
+	ipinfo, err := client.GetIPInfo(context.Background())
+
+	if err != nil || ipinfo.IPv4 != "203.0.113.9" {
+		t.Errorf("TestSTUNServerFailover should detect 203.0.113.9 from the last server, got %+v (%v)", ipinfo, err)
+	}
+
+}
+
+// Datagrams with another transaction ID are not taken as the answer.
+func TestSTUNIgnoresUnrelatedResponses(t *testing.T) {
+
+	server := newSTUNServerMock(t, "udp4", "127.0.0.1:0", func(request []byte) []byte {
+		other := make([]byte, headerLength)
+		copy(other, request)
+		other[19] ^= 0xff
+		return response(other, bindingSuccessResponse, addressAttribute(other, netip.MustParseAddr("192.0.2.1"), true))
+	})
+
+	client := Client{Servers: []string{server.address()}, Timeout: 200 * time.Millisecond}
+
+	if ipinfo, err := client.GetIPInfo(context.Background()); err == nil {
+		t.Errorf("TestSTUNIgnoresUnrelatedResponses should fail, got %+v", ipinfo)
+	}
+
+}
@@ -0,0 +1,57 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+
+	logger "github.com/a-castellano/go-services/infra/logger"
+)
+
+// Notifier states understood by systemd for Type=notify services.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+)
+
+// Notify sends state to the service manager through the datagram socket
+// named by NOTIFY_SOCKET (sd_notify protocol). It returns false, without an
+// error, when the process was not started by systemd with Type=notify.
+//
+// Parameters:
+//   - ctx: Context used for logging
+//   - state: State to send (e.g. Ready or Stopping)
+//
+// Returns:
+//   - bool: Whether the state was sent
+//   - error: Error if the socket cannot be reached
+func Notify(ctx context.Context, state string) (bool, error) {
+
+	log := logger.FromContext(ctx).With("operation", "systemd.Notify")
+
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		log.DebugContext(ctx, "NOTIFY_SOCKET is not set, skipping systemd notification", "state", state)
+		return false, nil
+	}
+
+	// A leading "@" denotes a socket in the abstract namespace.
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, dialErr := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if dialErr != nil {
+		log.ErrorContext(ctx, "Error connecting to systemd notify socket", "error", dialErr)
+		return false, dialErr
+	}
+	defer conn.Close()
+
+	if _, writeErr := conn.Write([]byte(state)); writeErr != nil {
+		log.ErrorContext(ctx, "Error notifying systemd", "state", state, "error", writeErr)
+		return false, writeErr
+	}
+
+	log.DebugContext(ctx, "systemd has been notified", "state", state)
+	return true, nil
+}
@@ -0,0 +1,58 @@
+//go:build integration_tests || unit_tests || systemd_tests || systemd_unit_tests
+
+package systemd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestNotifyWithoutSocket(t *testing.T) {
+
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	sent, err := Notify(context.Background(), Ready)
+	if err != nil {
+		t.Errorf("TestNotifyWithoutSocket should not fail: %v", err)
+	}
+	if sent {
+		t.Errorf("TestNotifyWithoutSocket should not send anything when NOTIFY_SOCKET is unset")
+	}
+}
+
+func TestNotifyReady(t *testing.T) {
+
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	listener, listenErr := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if listenErr != nil {
+		t.Fatalf("TestNotifyReady cannot create the notify socket: %v", listenErr)
+	}
+	defer listener.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	sent, err := Notify(context.Background(), Ready)
+	if err != nil || !sent {
+		t.Fatalf("TestNotifyReady should send the state: sent=%t err=%v", sent, err)
+	}
+
+	buffer := make([]byte, 64)
+	n, readErr := listener.Read(buffer)
+	if readErr != nil {
+		t.Fatalf("TestNotifyReady cannot read the notify socket: %v", readErr)
+	}
+	if string(buffer[:n]) != Ready {
+		t.Errorf("TestNotifyReady should send \"%s\" but it sent \"%s\"", Ready, string(buffer[:n]))
+	}
+}
+
+func TestNotifyUnreachableSocket(t *testing.T) {
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+
+	if _, err := Notify(context.Background(), Ready); err == nil {
+		t.Errorf("TestNotifyUnreachableSocket should fail when the socket does not exist")
+	}
+}
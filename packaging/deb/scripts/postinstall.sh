@@ -6,3 +6,5 @@ echo " sudo /bin/systemctl daemon-reload"
 echo "### This service is executed using systemd timers"
 echo "### Enable it with the following command"
 echo " sudo /bin/systemctl enable windmaker-home-ip-monitor.timer"
+echo "### Or, to keep a long-running process instead of the timer, enable the daemon unit"
+echo " sudo /bin/systemctl enable windmaker-home-ip-monitor-daemon.service"